@@ -58,6 +58,12 @@ type AppManifest struct {
 			Values      []string `json:"values,omitempty"`
 		} `json:"permissions"`
 		AvailableVersion string `json:"available_version,omitempty"`
+		PreviousVersions []struct {
+			Version  string `json:"version"`
+			Checksum string `json:"checksum,omitempty"`
+			Source   string `json:"source"`
+		} `json:"previous_versions,omitempty"`
+		Pinned bool `json:"pinned,omitempty"`
 
 		Parameters json.RawMessage `json:"parameters,omitempty"`
 
@@ -79,6 +85,10 @@ type AppManifest struct {
 			Debounce       string `json:"debounce"`
 			TriggerOptions string `json:"trigger"`
 			TriggerID      string `json:"trigger_id"`
+			Functions      []struct {
+				Path   string `json:"path"`
+				Public bool   `json:"public,omitempty"`
+			} `json:"functions,omitempty"`
 		} `json:"services"`
 		Notifications map[string]struct {
 			Description     string            `json:"description,omitempty"`
@@ -90,6 +100,7 @@ type AppManifest struct {
 			Templates       map[string]string `json:"templates,omitempty"`
 			MinInterval     time.Duration     `json:"min_interval,omitempty"`
 		} `json:"notifications,omitempty"`
+		CSP map[string]string `json:"csp,omitempty"`
 
 		CreatedAt time.Time `json:"created_at"`
 		UpdatedAt time.Time `json:"updated_at"`
@@ -201,6 +212,51 @@ func (c *Client) UninstallApp(opts *AppOptions) (*AppManifest, error) {
 	return readAppManifest(res)
 }
 
+// RollbackApp is used to switch an application back to one of its previous
+// versions (the most recent if version is empty). It uses the admin API.
+func (c *Client) RollbackApp(domain string, opts *AppOptions, version string) (*AppManifest, error) {
+	res, err := c.Req(&request.Options{
+		Method:  "POST",
+		Path:    makeAdminAppsPath(domain, opts, "rollback"),
+		Queries: url.Values{"Version": {version}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readAdminAppManifest(res)
+}
+
+// PinApp is used to pin or unpin the version of an application, to skip it
+// in the auto-updates. It uses the admin API.
+func (c *Client) PinApp(domain string, opts *AppOptions, pinned bool) (*AppManifest, error) {
+	method := "PUT"
+	if !pinned {
+		method = "DELETE"
+	}
+	res, err := c.Req(&request.Options{
+		Method: method,
+		Path:   makeAdminAppsPath(domain, opts, "pin"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return readAdminAppManifest(res)
+}
+
+func makeAdminAppsPath(domain string, opts *AppOptions, action string) string {
+	return "/instances/" + url.PathEscape(domain) +
+		makeAppsPath(opts.AppType, url.PathEscape(opts.Slug)) + "/" + action
+}
+
+func readAdminAppManifest(res *http.Response) (*AppManifest, error) {
+	defer res.Body.Close()
+	app := &AppManifest{}
+	if err := json.NewDecoder(res.Body).Decode(&app.Attrs); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
 func makeAppsPath(appType, path string) string {
 	switch appType {
 	case consts.Apps:
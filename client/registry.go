@@ -0,0 +1,103 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/request"
+)
+
+// RegistryVersion is a version of an application published on the registry
+// of the stack.
+type RegistryVersion struct {
+	Slug      string    `json:"slug"`
+	Type      string    `json:"type"`
+	Version   string    `json:"version"`
+	Sha256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      string    `json:"size"`
+	TarPrefix string    `json:"tar_prefix"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// PublishOptions is a struct holding the parameters for publishing a version
+// of an application on the registry of the stack.
+type PublishOptions struct {
+	Slug          string
+	Version       string
+	Signature     string
+	Tarball       io.Reader
+	ContentLength int64
+}
+
+// MaintenanceOptions is a struct holding the flags of the maintenance of an
+// application on the registry of the stack.
+type MaintenanceOptions struct {
+	FlagInfraMaintenance   bool `json:"flag_infra_maintenance"`
+	FlagShortMaintenance   bool `json:"flag_short_maintenance"`
+	FlagDisallowManualExec bool `json:"flag_disallow_manual_exec"`
+}
+
+// PublishVersion is used to add a version of an application on the registry
+// of the stack. It uses the admin API.
+func (c *Client) PublishVersion(opts *PublishOptions) (*RegistryVersion, error) {
+	q := url.Values{}
+	if opts.Version != "" {
+		q.Add("Version", opts.Version)
+	}
+	if opts.Signature != "" {
+		q.Add("Signature", opts.Signature)
+	}
+	res, err := c.Req(&request.Options{
+		Method:  "POST",
+		Path:    "/registry/" + url.PathEscape(opts.Slug),
+		Queries: q,
+		Headers: request.Headers{
+			"Content-Type": "application/octet-stream",
+		},
+		Body:          opts.Tarball,
+		ContentLength: opts.ContentLength,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var v RegistryVersion
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ActivateMaintenance is used to put an application of the registry of the
+// stack in maintenance. It uses the admin API.
+func (c *Client) ActivateMaintenance(slug string, opts *MaintenanceOptions) error {
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	_, err = c.Req(&request.Options{
+		Method: "PUT",
+		Path:   "/registry/" + url.PathEscape(slug) + "/maintenance",
+		Headers: request.Headers{
+			"Content-Type": "application/json",
+		},
+		Body:       bytes.NewReader(body),
+		NoResponse: true,
+	})
+	return err
+}
+
+// DeactivateMaintenance is used to end the maintenance of an application of
+// the registry of the stack. It uses the admin API.
+func (c *Client) DeactivateMaintenance(slug string) error {
+	_, err := c.Req(&request.Options{
+		Method:     "DELETE",
+		Path:       "/registry/" + url.PathEscape(slug) + "/maintenance",
+		NoResponse: true,
+	})
+	return err
+}
@@ -81,6 +81,39 @@ var uninstallWebappCmd = &cobra.Command{
 	},
 }
 
+var rollbackWebappCmd = &cobra.Command{
+	Use:   "rollback <slug> [version]",
+	Short: "Switch the application back to a previous version.",
+	Long: `
+cozy-stack apps rollback switches the application back to one of the previous
+versions that have been kept, the most recent by default. The application is
+then pinned: it is skipped by the auto-updates until it is unpinned.
+`,
+	Example: `
+$ cozy-stack apps rollback --domain cozy.tools:8080 drive
+$ cozy-stack apps rollback --domain cozy.tools:8080 drive 1.18.2
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackApp(cmd, args, consts.Apps)
+	},
+}
+
+var pinWebappCmd = &cobra.Command{
+	Use:   "pin <slug>",
+	Short: "Pin the current version of the application, to skip it in the auto-updates.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return pinApp(cmd, args, consts.Apps, true)
+	},
+}
+
+var unpinWebappCmd = &cobra.Command{
+	Use:   "unpin <slug>",
+	Short: "Unpin the application, to update it again with the auto-updates.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return pinApp(cmd, args, consts.Apps, false)
+	},
+}
+
 var lsWebappsCmd = &cobra.Command{
 	Use:   "ls",
 	Short: "List the installed applications.",
@@ -173,6 +206,36 @@ var uninstallKonnectorCmd = &cobra.Command{
 	},
 }
 
+var rollbackKonnectorCmd = &cobra.Command{
+	Use:   "rollback <slug> [version]",
+	Short: "Switch the konnector back to a previous version.",
+	Long: `
+cozy-stack konnectors rollback switches the konnector back to one of the
+previous versions that have been kept, the most recent by default. The
+konnector is then pinned: it is skipped by the auto-updates until it is
+unpinned.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackApp(cmd, args, consts.Konnectors)
+	},
+}
+
+var pinKonnectorCmd = &cobra.Command{
+	Use:   "pin <slug>",
+	Short: "Pin the current version of the konnector, to skip it in the auto-updates.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return pinApp(cmd, args, consts.Konnectors, true)
+	},
+}
+
+var unpinKonnectorCmd = &cobra.Command{
+	Use:   "unpin <slug>",
+	Short: "Unpin the konnector, to update it again with the auto-updates.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return pinApp(cmd, args, consts.Konnectors, false)
+	},
+}
+
 var lsKonnectorsCmd = &cobra.Command{
 	Use:   "ls",
 	Short: "List the installed konnectors.",
@@ -404,6 +467,50 @@ func uninstallApp(cmd *cobra.Command, args []string, appType string) error {
 	return nil
 }
 
+func rollbackApp(cmd *cobra.Command, args []string, appType string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return cmd.Usage()
+	}
+	if flagDomain == "" {
+		errPrintfln("%s", errMissingDomain)
+		return cmd.Usage()
+	}
+	var version string
+	if len(args) > 1 {
+		version = args[1]
+	}
+	ac := newAdminClient()
+	manifest, err := ac.RollbackApp(flagDomain, &client.AppOptions{
+		AppType: appType,
+		Slug:    args[0],
+	}, version)
+	if err != nil {
+		return err
+	}
+	json, err := json.MarshalIndent(manifest.Attrs, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(json))
+	return nil
+}
+
+func pinApp(cmd *cobra.Command, args []string, appType string, pinned bool) error {
+	if len(args) != 1 {
+		return cmd.Usage()
+	}
+	if flagDomain == "" {
+		errPrintfln("%s", errMissingDomain)
+		return cmd.Usage()
+	}
+	ac := newAdminClient()
+	_, err := ac.PinApp(flagDomain, &client.AppOptions{
+		AppType: appType,
+		Slug:    args[0],
+	}, pinned)
+	return err
+}
+
 func showApp(cmd *cobra.Command, args []string, appType string) error {
 	if flagDomain == "" {
 		errPrintfln("%s", errMissingDomain)
@@ -598,6 +705,9 @@ func init() {
 	webappsCmdGroup.AddCommand(installWebappCmd)
 	webappsCmdGroup.AddCommand(updateWebappCmd)
 	webappsCmdGroup.AddCommand(uninstallWebappCmd)
+	webappsCmdGroup.AddCommand(rollbackWebappCmd)
+	webappsCmdGroup.AddCommand(pinWebappCmd)
+	webappsCmdGroup.AddCommand(unpinWebappCmd)
 
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagDomain, "domain", cozyDomain(), "specify the domain name of the instance")
 	konnectorsCmdGroup.PersistentFlags().StringVar(&flagKonnectorsParameters, "parameters", "", "override the parameters of the installed konnector")
@@ -608,6 +718,9 @@ func init() {
 	konnectorsCmdGroup.AddCommand(installKonnectorCmd)
 	konnectorsCmdGroup.AddCommand(updateKonnectorCmd)
 	konnectorsCmdGroup.AddCommand(uninstallKonnectorCmd)
+	konnectorsCmdGroup.AddCommand(rollbackKonnectorCmd)
+	konnectorsCmdGroup.AddCommand(pinKonnectorCmd)
+	konnectorsCmdGroup.AddCommand(unpinKonnectorCmd)
 	konnectorsCmdGroup.AddCommand(runKonnectorsCmd)
 
 	RootCmd.AddCommand(triggersCmdGroup)
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/spf13/cobra"
+)
+
+var flagPermissionID string
+var flagPermissionToken string
+var flagPermissionVerb string
+var flagPermissionDocType string
+var flagPermissionDocID string
+var flagPermissionPath string
+
+var permissionsCmdGroup = &cobra.Command{
+	Use:     "permissions <command>",
+	Aliases: []string{"perms"},
+	Short:   "Inspect the permissions of an instance",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Usage()
+	},
+}
+
+var checkPermissionsCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Explain if a permission gives access to a document",
+	Long: `
+cozy-stack permissions check simulates a permission check for a permission
+document or a token, and explains which rule grants the access, or why every
+rule rejects it. For files, the permissions inherited from the parent
+directories are taken into account.
+`,
+	Example: `$ cozy-stack permissions check --domain cozy.tools:8080 --token $TOKEN --verb PUT --path /Documents/invoice.pdf`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if flagDomain == "" {
+			errPrintfln("%s", errMissingDomain)
+			return cmd.Usage()
+		}
+		if flagPermissionID == "" && flagPermissionToken == "" {
+			return errors.New("A permission identifier or a token is required")
+		}
+		body, err := json.Marshal(map[string]string{
+			"permission_id": flagPermissionID,
+			"token":         flagPermissionToken,
+			"verb":          flagPermissionVerb,
+			"type":          flagPermissionDocType,
+			"id":            flagPermissionDocID,
+			"path":          flagPermissionPath,
+		})
+		if err != nil {
+			return err
+		}
+		c := newAdminClient()
+		res, err := c.Req(&request.Options{
+			Method: "POST",
+			Path:   "/instances/" + flagDomain + "/permissions/check",
+			Headers: request.Headers{
+				"Content-Type": "application/json",
+			},
+			Body: bytes.NewReader(body),
+		})
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if flagJSON {
+			_, err = io.Copy(os.Stdout, res.Body)
+			return err
+		}
+		return printPermissionCheck(res.Body)
+	},
+}
+
+func printPermissionCheck(r io.Reader) error {
+	var result struct {
+		PermissionID   string `json:"permission_id"`
+		PermissionType string `json:"permission_type"`
+		SourceID       string `json:"source_id"`
+		Allowed        bool   `json:"allowed"`
+		Verb           string `json:"verb"`
+		DocType        string `json:"type"`
+		DocID          string `json:"id"`
+		MatchedRule    *int   `json:"matched_rule"`
+		Reason         string `json:"reason"`
+		Rules          []struct {
+			Index   int    `json:"index"`
+			Matched bool   `json:"matched"`
+			Reason  string `json:"reason"`
+			Rule    struct {
+				Type     string   `json:"type"`
+				Verbs    []string `json:"verbs"`
+				Selector string   `json:"selector"`
+				Values   []string `json:"values"`
+			} `json:"rule"`
+		} `json:"rules"`
+	}
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return err
+	}
+
+	fmt.Printf("Permission: %s (%s, %s)\n", result.PermissionID, result.PermissionType, result.SourceID)
+	fmt.Printf("Request: %s %s %s\n", result.Verb, result.DocType, result.DocID)
+	if result.Allowed {
+		fmt.Printf("Access granted by the rule #%d\n", *result.MatchedRule)
+	} else {
+		fmt.Printf("Access rejected: %s\n", result.Reason)
+	}
+	for _, check := range result.Rules {
+		mark := "-"
+		if check.Matched {
+			mark = "+"
+		}
+		fmt.Printf("  %s #%d %s %v", mark, check.Index, check.Rule.Type, check.Rule.Verbs)
+		if check.Rule.Selector != "" {
+			fmt.Printf(" %s", check.Rule.Selector)
+		}
+		if len(check.Rule.Values) > 0 {
+			fmt.Printf(" %v", check.Rule.Values)
+		}
+		fmt.Printf(": %s\n", check.Reason)
+	}
+	return nil
+}
+
+func init() {
+	checkPermissionsCmd.Flags().StringVar(&flagDomain, "domain", cozyDomain(), "Specify the domain name of the instance")
+	checkPermissionsCmd.Flags().StringVar(&flagPermissionID, "permission-id", "", "The identifier of the permission document")
+	checkPermissionsCmd.Flags().StringVar(&flagPermissionToken, "token", "", "The token to check (instead of a permission document)")
+	checkPermissionsCmd.Flags().StringVar(&flagPermissionVerb, "verb", "GET", "The HTTP verb")
+	checkPermissionsCmd.Flags().StringVar(&flagPermissionDocType, "doctype", "", "The doctype of the document")
+	checkPermissionsCmd.Flags().StringVar(&flagPermissionDocID, "id", "", "The identifier of the document")
+	checkPermissionsCmd.Flags().StringVar(&flagPermissionPath, "path", "", "The path of a file or directory (instead of a doctype and an identifier)")
+	checkPermissionsCmd.Flags().BoolVar(&flagJSON, "json", false, "Show the result as JSON")
+
+	permissionsCmdGroup.AddCommand(checkPermissionsCmd)
+	RootCmd.AddCommand(permissionsCmdGroup)
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cozy/cozy-stack/client"
+	"github.com/spf13/cobra"
+)
+
+var flagRegistryVersion string
+var flagRegistrySignature string
+var flagInfraMaintenance bool
+var flagShortMaintenance bool
+var flagDisallowManualExec bool
+
+var registryCmdGroup = &cobra.Command{
+	Use:   "registry <command>",
+	Short: "Manage the applications of the registry of the stack",
+	Long: `
+cozy-stack registry can be used to publish applications on the registry served
+by the stack itself (see local_registry in the configuration file).
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Usage()
+	},
+}
+
+var publishRegistryCmd = &cobra.Command{
+	Use:   "publish <slug> <tarball>",
+	Short: "Publish a new version of an application",
+	Long: `
+Publish a new version of an application on the registry of the stack, from
+its tarball. The version is read from the manifest, and can be overridden with
+the --version flag for the beta and dev versions: it must then start with the
+version of the manifest, like 1.2.3-beta.1 or 1.2.3-dev.abcdef.
+
+The published versions can't be modified.
+`,
+	Example: "$ cozy-stack registry publish drive drive-1.2.3.tar.gz",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		infos, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		ac := newAdminClient()
+		version, err := ac.PublishVersion(&client.PublishOptions{
+			Slug:          args[0],
+			Version:       flagRegistryVersion,
+			Signature:     flagRegistrySignature,
+			Tarball:       f,
+			ContentLength: infos.Size(),
+		})
+		if err != nil {
+			return err
+		}
+		json, err := json.MarshalIndent(version, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(json))
+		return nil
+	},
+}
+
+var maintenanceRegistryCmdGroup = &cobra.Command{
+	Use:   "maintenance <command>",
+	Short: "Manage the maintenance of the applications",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cmd.Usage()
+	},
+}
+
+var activateMaintenanceRegistryCmd = &cobra.Command{
+	Use:     "activate <slug>",
+	Short:   "Put an application in maintenance",
+	Example: "$ cozy-stack registry maintenance activate konnector-foobar --short",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		ac := newAdminClient()
+		return ac.ActivateMaintenance(args[0], &client.MaintenanceOptions{
+			FlagInfraMaintenance:   flagInfraMaintenance,
+			FlagShortMaintenance:   flagShortMaintenance,
+			FlagDisallowManualExec: flagDisallowManualExec,
+		})
+	},
+}
+
+var deactivateMaintenanceRegistryCmd = &cobra.Command{
+	Use:     "deactivate <slug>",
+	Short:   "End the maintenance of an application",
+	Example: "$ cozy-stack registry maintenance deactivate konnector-foobar",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		ac := newAdminClient()
+		return ac.DeactivateMaintenance(args[0])
+	},
+}
+
+func init() {
+	publishRegistryCmd.Flags().StringVar(&flagRegistryVersion, "version", "", "specify the version (by default, the version of the manifest)")
+	publishRegistryCmd.Flags().StringVar(&flagRegistrySignature, "signature", "", "specify the base64 Ed25519 signature of the version")
+	activateMaintenanceRegistryCmd.Flags().BoolVar(&flagInfraMaintenance, "infra", false, "the maintenance is caused by the infrastructure")
+	activateMaintenanceRegistryCmd.Flags().BoolVar(&flagShortMaintenance, "short", false, "the maintenance is expected to be short")
+	activateMaintenanceRegistryCmd.Flags().BoolVar(&flagDisallowManualExec, "no-manual-exec", false, "disallow the manual executions of the konnector")
+
+	maintenanceRegistryCmdGroup.AddCommand(activateMaintenanceRegistryCmd)
+	maintenanceRegistryCmdGroup.AddCommand(deactivateMaintenanceRegistryCmd)
+	registryCmdGroup.AddCommand(publishRegistryCmd)
+	registryCmdGroup.AddCommand(maintenanceRegistryCmdGroup)
+	RootCmd.AddCommand(registryCmdGroup)
+}
@@ -0,0 +1,177 @@
+package account
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+const (
+	// RunDone is the state of a successful execution
+	RunDone = "done"
+	// RunErrored is the state of a failed execution
+	RunErrored = "errored"
+)
+
+// maxRunsPerAccount is the number of executions kept in the history of an
+// account: the older ones are deleted.
+const maxRunsPerAccount = 100
+
+// Run is a record of an execution of a konnector for an account. The retries
+// of a job are part of the same execution.
+type Run struct {
+	DocID      string    `json:"_id,omitempty"`
+	DocRev     string    `json:"_rev,omitempty"`
+	Konnector  string    `json:"konnector"`
+	Account    string    `json:"account"`
+	JobID      string    `json:"job_id"`
+	TriggerID  string    `json:"trigger_id,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Manual     bool      `json:"manual"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Duration is the duration of the execution, in seconds
+	Duration float64 `json:"duration"`
+	State    string  `json:"state"`
+	// ErrorType is the type of the message that has given the error
+	// (critical or error), or timeout, or internal for the errors of the
+	// stack.
+	ErrorType        string `json:"error_type,omitempty"`
+	Error            string `json:"error,omitempty"`
+	FilesCreated     int    `json:"files_created"`
+	DocumentsCreated int    `json:"documents_created"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (r *Run) ID() string { return r.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (r *Run) Rev() string { return r.DocRev }
+
+// SetID is used to implement the couchdb.Doc interface
+func (r *Run) SetID(id string) { r.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (r *Run) SetRev(rev string) { r.DocRev = rev }
+
+// DocType implements couchdb.Doc
+func (r *Run) DocType() string { return consts.KonnectorRuns }
+
+// Clone implements couchdb.Doc
+func (r *Run) Clone() couchdb.Doc {
+	cloned := *r
+	return &cloned
+}
+
+// Included is part of jsonapi.Object interface
+func (r *Run) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (r *Run) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (r *Run) Links() *jsonapi.LinksList { return nil }
+
+var _ jsonapi.Object = (*Run)(nil)
+
+// RunsHealth is the health of a konnector for an account, computed from the
+// history of its executions.
+type RunsHealth struct {
+	Runs                int        `json:"runs"`
+	SuccessRate         float64    `json:"success_rate"`
+	MeanDuration        float64    `json:"mean_duration"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+}
+
+// ComputeHealth aggregates the given executions, sorted from the most recent
+// to the oldest.
+func ComputeHealth(runs []*Run) *RunsHealth {
+	h := &RunsHealth{Runs: len(runs)}
+	if len(runs) == 0 {
+		return h
+	}
+	success := 0
+	var total float64
+	consecutive := true
+	for _, r := range runs {
+		total += r.Duration
+		if r.State == RunDone {
+			success++
+			consecutive = false
+			if h.LastSuccess == nil {
+				at := r.FinishedAt
+				h.LastSuccess = &at
+			}
+			continue
+		}
+		if consecutive {
+			h.ConsecutiveFailures++
+		}
+		if h.LastFailure == nil {
+			at := r.FinishedAt
+			h.LastFailure = &at
+		}
+	}
+	h.SuccessRate = float64(success) / float64(len(runs))
+	h.MeanDuration = total / float64(len(runs))
+	return h
+}
+
+// ListRuns returns the last executions of a konnector for an account, the
+// most recent first.
+func ListRuns(inst *instance.Instance, slug, accountID string, limit int) ([]*Run, error) {
+	if limit <= 0 || limit > maxRunsPerAccount {
+		limit = maxRunsPerAccount
+	}
+	return findRuns(inst, slug, accountID, 0, limit)
+}
+
+func findRuns(inst *instance.Instance, slug, accountID string, skip, limit int) ([]*Run, error) {
+	var runs []*Run
+	req := &couchdb.FindRequest{
+		UseIndex: "by-konnector-and-account",
+		Selector: mango.And(
+			mango.Equal("konnector", slug),
+			mango.Equal("account", accountID),
+			mango.Exists("started_at"),
+		),
+		Sort: mango.SortBy{
+			{Field: "konnector", Direction: mango.Desc},
+			{Field: "account", Direction: mango.Desc},
+			{Field: "started_at", Direction: mango.Desc},
+		},
+		Skip:  skip,
+		Limit: limit,
+	}
+	err := couchdb.FindDocs(inst, consts.KonnectorRuns, req, &runs)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Run{}, nil
+		}
+		return nil, err
+	}
+	return runs, nil
+}
+
+// RecordRun saves an execution in the history of the account, and removes
+// the older executions.
+func RecordRun(inst *instance.Instance, r *Run) error {
+	if err := couchdb.CreateDoc(inst, r); err != nil {
+		return err
+	}
+	old, err := findRuns(inst, r.Konnector, r.Account, maxRunsPerAccount, maxRunsPerAccount)
+	if err != nil || len(old) == 0 {
+		return err
+	}
+	docs := make([]couchdb.Doc, len(old))
+	for i, o := range old {
+		docs[i] = o
+	}
+	return couchdb.BulkDeleteDocs(inst, consts.KonnectorRuns, docs)
+}
@@ -0,0 +1,33 @@
+package account
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeHealth(t *testing.T) {
+	h := ComputeHealth(nil)
+	assert.Equal(t, 0, h.Runs)
+	assert.Nil(t, h.LastSuccess)
+
+	now := time.Now()
+	runs := []*Run{
+		{State: RunErrored, Duration: 10, FinishedAt: now},
+		{State: RunErrored, Duration: 20, FinishedAt: now.Add(-1 * time.Hour)},
+		{State: RunDone, Duration: 30, FinishedAt: now.Add(-2 * time.Hour)},
+		{State: RunErrored, Duration: 40, FinishedAt: now.Add(-3 * time.Hour)},
+	}
+	h = ComputeHealth(runs)
+	assert.Equal(t, 4, h.Runs)
+	assert.Equal(t, 0.25, h.SuccessRate)
+	assert.Equal(t, 25.0, h.MeanDuration)
+	assert.Equal(t, 2, h.ConsecutiveFailures)
+	if assert.NotNil(t, h.LastSuccess) {
+		assert.True(t, h.LastSuccess.Equal(now.Add(-2*time.Hour)))
+	}
+	if assert.NotNil(t, h.LastFailure) {
+		assert.True(t, h.LastFailure.Equal(now))
+	}
+}
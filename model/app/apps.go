@@ -73,6 +73,8 @@ type Manifest interface {
 	Version() string
 	AvailableVersion() string
 	Checksum() string
+	PreviousVersions() []Version
+	Pinned() bool
 	Slug() string
 	State() State
 	LastUpdate() time.Time
@@ -86,6 +88,16 @@ type Manifest interface {
 	SetVersion(version string)
 	SetAvailableVersion(version string)
 	SetChecksum(shasum string)
+	SetPreviousVersions(versions []Version)
+	SetPinned(pinned bool)
+}
+
+// Version is a version of an application that has been replaced by an update,
+// and whose files are kept in the appfs to allow a rollback.
+type Version struct {
+	Version  string `json:"version"`
+	Checksum string `json:"checksum,omitempty"`
+	Source   string `json:"source"`
 }
 
 // GetBySlug returns an app manifest identified by its slug
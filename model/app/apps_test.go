@@ -3,6 +3,7 @@ package app
 import (
 	"testing"
 
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -126,3 +127,63 @@ func TestFindIntent(t *testing.T) {
 	found = man.FindIntent("PICK", "io.cozy.files")
 	assert.Nil(t, found)
 }
+
+func TestFindFunction(t *testing.T) {
+	manifest := &WebappManifest{}
+	manifest.Services = Services{
+		"hooks": &Service{Functions: []*Function{
+			{Path: "/hooks", Public: true},
+		}},
+		"api": &Service{Functions: []*Function{
+			{Path: "/api"},
+			{Path: "/hooks/admin/"},
+		}},
+	}
+
+	name, fn := manifest.FindFunction("/hooks")
+	assert.Equal(t, "hooks", name)
+	assert.True(t, fn.Public)
+
+	name, fn = manifest.FindFunction("/hooks/github")
+	assert.Equal(t, "hooks", name)
+	assert.Equal(t, "/hooks", fn.Path)
+
+	name, fn = manifest.FindFunction("/hooks/admin/reset")
+	assert.Equal(t, "api", name)
+	assert.Equal(t, "/hooks/admin/", fn.Path)
+
+	name, _ = manifest.FindFunction("api/stats")
+	assert.Equal(t, "api", name)
+
+	_, fn = manifest.FindFunction("/apis")
+	assert.Nil(t, fn)
+}
+
+func TestKeepVersions(t *testing.T) {
+	max := config.GetConfig().Fs.Versioning.MaxNumberOfAppVersionsToKeep
+	defer func() { config.GetConfig().Fs.Versioning.MaxNumberOfAppVersionsToKeep = max }()
+	config.GetConfig().Fs.Versioning.MaxNumberOfAppVersionsToKeep = 2
+
+	v1 := Version{Version: "1.0.0", Checksum: "a1"}
+	v2 := Version{Version: "1.1.0", Checksum: "b2"}
+	v3 := Version{Version: "1.2.0", Checksum: "c3"}
+	v4 := Version{Version: "1.3.0", Checksum: "d4"}
+
+	kept, removed := keepVersions(nil, v1, v2)
+	assert.Equal(t, []Version{v1}, kept)
+	assert.Empty(t, removed)
+
+	kept, removed = keepVersions([]Version{v2, v1}, v3, v4)
+	assert.Equal(t, []Version{v3, v2}, kept)
+	assert.Equal(t, []Version{v1}, removed)
+
+	// Rollback from v4 to v3
+	kept, removed = keepVersions([]Version{v3, v2}, v4, v3)
+	assert.Equal(t, []Version{v4, v2}, kept)
+	assert.Empty(t, removed)
+
+	// Same files
+	kept, removed = keepVersions([]Version{v1}, v2, v2)
+	assert.Equal(t, []Version{v1}, kept)
+	assert.Empty(t, removed)
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	build "github.com/cozy/cozy-stack/pkg/config"
+)
+
+// CSP is a map of the extra sources that a webapp needs in its content
+// security policy. The keys are the short names of the directives (like in
+// the csp_whitelist of the config file), and the values are the sources,
+// separated by spaces.
+type CSP map[string]string
+
+// cspDirectives are the directives that the webapps can extend.
+var cspDirectives = map[string]string{
+	"default": "default-src",
+	"script":  "script-src",
+	"connect": "connect-src",
+	"img":     "img-src",
+	"style":   "style-src",
+	"font":    "font-src",
+	"frame":   "frame-src",
+	"media":   "media-src",
+	"worker":  "worker-src",
+}
+
+// Sources returns the valid sources, grouped by directive, that are not
+// denied by the given list of domains. A source must be a URL with a domain
+// (optionally with a *. wildcard), and the https or wss scheme (or their
+// insecure variants in development). The keywords like 'unsafe-eval' are not
+// allowed.
+func (csp CSP) Sources(denied []string) map[string][]string {
+	sources := make(map[string][]string)
+	for key, list := range csp {
+		directive, ok := cspDirectives[key]
+		if !ok {
+			continue
+		}
+		for _, src := range strings.Fields(list) {
+			host, ok := cspSourceHost(src)
+			if !ok || isCSPHostDenied(host, denied) {
+				continue
+			}
+			sources[directive] = append(sources[directive], src)
+		}
+	}
+	return sources
+}
+
+// Equal returns true if the two CSP allow the same sources.
+func (csp CSP) Equal(other CSP) bool {
+	a, b := csp.Sources(nil), other.Sources(nil)
+	if len(a) != len(b) {
+		return false
+	}
+	for directive, list := range a {
+		others := b[directive]
+		if len(list) != len(others) {
+			return false
+		}
+		list = append([]string{}, list...)
+		others = append([]string{}, others...)
+		sort.Strings(list)
+		sort.Strings(others)
+		for i := range list {
+			if list[i] != others[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func cspSourceHost(src string) (string, bool) {
+	if strings.ContainsAny(src, "';,") {
+		return "", false
+	}
+	u, err := url.Parse(src)
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" {
+		return "", false
+	}
+	switch u.Scheme {
+	case "https", "wss":
+	case "http", "ws":
+		if !build.IsDevRelease() {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	base := strings.TrimPrefix(host, "*.")
+	if base == "" || strings.Contains(base, "*") {
+		return "", false
+	}
+	return host, true
+}
+
+// isCSPHostDenied returns true if the host is one of the denied domains, or
+// one of their subdomains. A wildcard host is also denied if it can match a
+// denied domain. The "*" domain denies all the hosts.
+func isCSPHostDenied(host string, denied []string) bool {
+	base := strings.TrimPrefix(host, "*.")
+	wildcard := base != host
+	for _, d := range denied {
+		d = strings.ToLower(strings.TrimPrefix(d, "*."))
+		if d == "*" || d == base || strings.HasSuffix(base, "."+d) {
+			return true
+		}
+		if wildcard && strings.HasSuffix(d, "."+base) {
+			return true
+		}
+	}
+	return false
+}
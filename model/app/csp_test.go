@@ -0,0 +1,34 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSPSources(t *testing.T) {
+	csp := CSP{
+		"img":     "https://*.tile.example.org https://maps.example.com/tiles/ data: 'unsafe-inline'",
+		"connect": "https://api.example.com wss://ws.example.com ftp://files.example.com *",
+		"object":  "https://flash.example.com",
+	}
+	sources := csp.Sources(nil)
+	assert.Equal(t, []string{"https://*.tile.example.org", "https://maps.example.com/tiles/"}, sources["img-src"])
+	assert.Equal(t, []string{"https://api.example.com", "wss://ws.example.com"}, sources["connect-src"])
+	assert.Len(t, sources, 2)
+
+	sources = csp.Sources([]string{"example.com", "example.org"})
+	assert.Len(t, sources, 0)
+	sources = csp.Sources([]string{"ws.example.com", "a.tile.example.org"})
+	assert.Equal(t, []string{"https://maps.example.com/tiles/"}, sources["img-src"])
+	assert.Equal(t, []string{"https://api.example.com"}, sources["connect-src"])
+	sources = csp.Sources([]string{"*"})
+	assert.Len(t, sources, 0)
+
+	assert.True(t, csp.Equal(CSP{
+		"connect": "wss://ws.example.com https://api.example.com",
+		"img":     "https://maps.example.com/tiles/ https://*.tile.example.org",
+	}))
+	assert.False(t, csp.Equal(CSP{"img": "https://*.tile.example.org"}))
+	assert.True(t, CSP(nil).Equal(CSP{}))
+}
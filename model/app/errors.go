@@ -34,4 +34,13 @@ var (
 	ErrBadChecksum = errors.New("Application checksum does not match")
 	// ErrLinkedAppExists is used when an OAuth client is linked to this app
 	ErrLinkedAppExists = errors.New("A linked OAuth client exists for this app")
+	// ErrVersionNotFound is used when trying to rollback an application to a
+	// version that has not been kept.
+	ErrVersionNotFound = errors.New("Application version not found")
+	// ErrMissingSignature is used when the context of the instance requires
+	// signed packages, and the application has no signature.
+	ErrMissingSignature = errors.New("Application package is not signed")
+	// ErrBadSignature is used when the signature of the application package
+	// has not been made with a trusted key.
+	ErrBadSignature = errors.New("Application package signature is invalid")
 )
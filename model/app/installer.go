@@ -13,6 +13,7 @@ import (
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/appfs"
+	build "github.com/cozy/cozy-stack/pkg/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/hooks"
 	"github.com/cozy/cozy-stack/pkg/logger"
@@ -21,6 +22,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/registry"
 	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ed25519"
 )
 
 var slugReg = regexp.MustCompile(`^[a-z0-9\-]+$`)
@@ -146,15 +148,26 @@ func NewInstaller(db prefixer.Prefixer, fs appfs.Copier, opts *InstallerOptions)
 		manFilename = KonnectorManifestName
 	}
 
+	// The git and file sources can't be signed, and new applications can be
+	// installed from them only in development mode. The applications already
+	// installed from them can still be updated and uninstalled.
+	devOnly := opts.Operation == Install && !build.IsDevRelease()
+
 	var fetcher Fetcher
 	switch src.Scheme {
 	case "git", "git+ssh", "ssh+git", "git+https":
+		if devOnly {
+			return nil, ErrNotSupportedSource
+		}
 		fetcher = newGitFetcher(manFilename, log)
 	case "http", "https":
 		fetcher = newHTTPFetcher(manFilename, log)
 	case "registry":
 		fetcher = newRegistryFetcher(opts.Registries, log)
 	case "file":
+		if devOnly {
+			return nil, ErrNotSupportedSource
+		}
 		fetcher = newFileFetcher(manFilename, log)
 	default:
 		return nil, ErrNotSupportedSource
@@ -291,6 +304,9 @@ func (i *Installer) install() error {
 		if err != nil {
 			return err
 		}
+		if err := i.checkSignature(); err != nil {
+			return err
+		}
 		i.man = newManifest
 		i.sendRealtimeEvent()
 		i.notifyChannel()
@@ -327,6 +343,92 @@ func (i *Installer) checkSkipPermissions() (bool, error) {
 	return sp.(bool), nil
 }
 
+// signaturePolicy returns if the context of the instance requires signed
+// packages for the applications, and the keys of the trusted publishers.
+func (i *Installer) signaturePolicy() (bool, []ed25519.PublicKey, error) {
+	domain := i.Domain()
+	if domain == prefixer.UnknownDomainName {
+		return false, nil, nil
+	}
+
+	inst, err := instance.GetFromCouch(domain)
+	if err != nil {
+		return false, nil, err
+	}
+	ctxSettings, ok := inst.SettingsContext()
+	if !ok {
+		return false, nil, nil
+	}
+
+	required, _ := ctxSettings["apps_signatures_required"].(bool)
+	var keys []ed25519.PublicKey
+	trusted, _ := ctxSettings["apps_trusted_keys"].([]interface{})
+	for _, k := range trusted {
+		encoded, _ := k.(string)
+		key, err := registry.ParsePublicKey(encoded)
+		if err != nil {
+			i.log.Warnf("Invalid trusted key %q: %s", encoded, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return required, keys, nil
+}
+
+// checkSignature verifies the signature of the package that will be fetched
+// from the registry. When the context requires signed packages, the unsigned
+// ones are refused.
+func (i *Installer) checkSignature() error {
+	required, keys, err := i.signaturePolicy()
+	if err != nil {
+		return err
+	}
+	var version *registry.Version
+	if f, ok := i.fetcher.(*registryFetcher); ok {
+		version = f.version
+	}
+	return verifySignature(i.slug, i.src.Scheme, version, required, keys, i.log)
+}
+
+// verifySignature applies the signature policy on a version from a registry,
+// or on a package from another source if version is nil. The signatures are
+// checked when they are required, or when some keys are trusted.
+func verifySignature(slug, scheme string, version *registry.Version, required bool, keys []ed25519.PublicKey, log *logrus.Entry) error {
+	if version == nil {
+		if !required {
+			return nil
+		}
+		// The git and file sources are only allowed in development mode,
+		// where they can be used even if the signatures are required
+		switch scheme {
+		case "git", "git+ssh", "ssh+git", "git+https", "file":
+			if build.IsDevRelease() {
+				return nil
+			}
+		}
+		return ErrMissingSignature
+	}
+
+	// The signed message contains the slug: a registry must not serve the
+	// package of another application
+	if version.Slug != slug {
+		log.Warnf("The version %s is for %q, not %q", version.Version, version.Slug, slug)
+		return ErrBadSignature
+	}
+	if !required && (version.Signature == "" || len(keys) == 0) {
+		return nil
+	}
+	switch version.VerifySignature(keys) {
+	case nil:
+		return nil
+	case registry.ErrUnsigned:
+		return ErrMissingSignature
+	default:
+		log.Warnf("Invalid signature for the version %s", version.Version)
+		return ErrBadSignature
+	}
+}
+
 // update will perform the update of an already installed application. It
 // returns the freshly fetched manifest from the source along with a possible
 // error in case the update went wrong.
@@ -360,12 +462,17 @@ func (i *Installer) update() error {
 	// Check the possible permissions changes before updating. If the
 	// verifyPermissions flag is activated (for non manual updates for example),
 	// we cancel out the update and mark the UpdateAvailable field of the
-	// application instead of actually updating.
+	// application instead of actually updating. The extra sources of the CSP
+	// of a webapp are accepted by the user like the permissions.
 	if makeUpdate && !isPlatformApp(oldManifest) {
 		oldPermissions := oldManifest.Permissions()
 		newPermissions := newManifest.Permissions()
 		samePermissions := newPermissions != nil && oldPermissions != nil &&
 			newPermissions.HasSameRules(oldPermissions)
+		if oldWebapp, ok := oldManifest.(*WebappManifest); ok {
+			newWebapp := newManifest.(*WebappManifest)
+			samePermissions = samePermissions && oldWebapp.CSP.Equal(newWebapp.CSP)
+		}
 
 		if !samePermissions && !i.permissionsAcked {
 			// Check if we are going to skip the permissions
@@ -420,13 +527,23 @@ func (i *Installer) update() error {
 		}
 	}
 
+	// The files of the previous version are kept in the appfs, to allow a
+	// rollback if the new version is broken.
+	var removedVersions []Version
 	if makeUpdate {
+		if err := i.checkSignature(); err != nil {
+			return err
+		}
 		i.man = newManifest
 		i.sendRealtimeEvent()
 		i.notifyChannel()
 		if err := i.fetcher.Fetch(i.src, i.fs, i.man); err != nil {
 			return err
 		}
+		var keptVersions []Version
+		keptVersions, removedVersions = keepVersions(oldManifest.PreviousVersions(),
+			currentVersion(oldManifest), currentVersion(i.man))
+		i.man.SetPreviousVersions(keptVersions)
 		i.man.SetAvailableVersion("")
 		i.man.SetState(i.endState)
 	} else {
@@ -441,7 +558,13 @@ func (i *Installer) update() error {
 		i.notifyChannel()
 	}
 
-	return i.man.Update(i.db, extraPerms)
+	if err := i.man.Update(i.db, extraPerms); err != nil {
+		return err
+	}
+	if inst != nil {
+		removeVersions(inst, i.man.AppType(), i.slug, removedVersions)
+	}
+	return nil
 }
 
 func (i *Installer) notifyChannel() {
@@ -543,6 +666,9 @@ func (i *Installer) Poll() (Manifest, bool, error) {
 
 // DoLazyUpdate tries to update an application before using it
 func DoLazyUpdate(db prefixer.Prefixer, man Manifest, copier appfs.Copier, registries []*url.URL) Manifest {
+	if man.Pinned() {
+		return man
+	}
 	src, err := url.Parse(man.Source())
 	if err != nil || src.Scheme != "registry" {
 		return man
@@ -63,6 +63,8 @@ type KonnManifest struct {
 	DocPermissions      permission.Set `json:"permissions"`
 	DocAvailableVersion string         `json:"available_version,omitempty"`
 	DocTerms            Terms          `json:"terms,omitempty"`
+	DocPreviousVersions []Version      `json:"previous_versions,omitempty"`
+	DocPinned           bool           `json:"pinned,omitempty"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -87,6 +89,11 @@ func (m *KonnManifest) Clone() couchdb.Doc {
 	cloned.DocPermissions = make(permission.Set, len(m.DocPermissions))
 	copy(cloned.DocPermissions, m.DocPermissions)
 
+	if m.DocPreviousVersions != nil {
+		cloned.DocPreviousVersions = make([]Version, len(m.DocPreviousVersions))
+		copy(cloned.DocPreviousVersions, m.DocPreviousVersions)
+	}
+
 	cloned.Locales = cloneRawMessage(m.Locales)
 	cloned.Langs = cloneRawMessage(m.Langs)
 	cloned.Platforms = cloneRawMessage(m.Platforms)
@@ -136,6 +143,12 @@ func (m *KonnManifest) AvailableVersion() string { return m.DocAvailableVersion
 // Checksum is part of the Manifest interface
 func (m *KonnManifest) Checksum() string { return m.DocChecksum }
 
+// PreviousVersions is part of the Manifest interface
+func (m *KonnManifest) PreviousVersions() []Version { return m.DocPreviousVersions }
+
+// Pinned is part of the Manifest interface
+func (m *KonnManifest) Pinned() bool { return m.DocPinned }
+
 // Slug is part of the Manifest interface
 func (m *KonnManifest) Slug() string { return m.DocSlug }
 
@@ -154,6 +167,12 @@ func (m *KonnManifest) SetVersion(version string) { m.DocVersion = version }
 // SetAvailableVersion is part of the Manifest interface
 func (m *KonnManifest) SetAvailableVersion(version string) { m.DocAvailableVersion = version }
 
+// SetPreviousVersions is part of the Manifest interface
+func (m *KonnManifest) SetPreviousVersions(versions []Version) { m.DocPreviousVersions = versions }
+
+// SetPinned is part of the Manifest interface
+func (m *KonnManifest) SetPinned(pinned bool) { m.DocPinned = pinned }
+
 // SetChecksum is part of the Manifest interface
 func (m *KonnManifest) SetChecksum(shasum string) { m.DocChecksum = shasum }
 
@@ -202,6 +221,8 @@ func (m *KonnManifest) ReadManifest(r io.Reader, slug, sourceURL string) (Manife
 	newManifest.CreatedAt = m.CreatedAt
 	newManifest.DocSlug = slug
 	newManifest.DocSource = sourceURL
+	newManifest.DocPreviousVersions = m.DocPreviousVersions
+	newManifest.DocPinned = m.DocPinned
 	if newManifest.Parameters == nil {
 		newManifest.Parameters = m.Parameters
 	}
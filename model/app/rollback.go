@@ -0,0 +1,176 @@
+package app
+
+import (
+	"os"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/appfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+	"github.com/spf13/afero"
+)
+
+// currentVersion returns the version of the files currently used by the
+// application.
+func currentVersion(man Manifest) Version {
+	return Version{
+		Version:  man.Version(),
+		Checksum: man.Checksum(),
+		Source:   man.Source(),
+	}
+}
+
+func (v Version) sameFiles(other Version) bool {
+	return v.Version == other.Version && v.Checksum == other.Checksum
+}
+
+// keepVersions returns the list of the previous versions to keep after the
+// application has switched from the previous version to the current one, and
+// the list of the versions whose files can be removed. The list is sorted
+// from the most recent version to the oldest one.
+func keepVersions(versions []Version, previous, current Version) (kept, removed []Version) {
+	max := config.GetConfig().Fs.Versioning.MaxNumberOfAppVersionsToKeep
+	if !previous.sameFiles(current) {
+		kept = append(kept, previous)
+	}
+	for _, v := range versions {
+		if v.sameFiles(previous) || v.sameFiles(current) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	if max < 0 {
+		max = 0
+	}
+	if len(kept) > max {
+		removed = kept[max:]
+		kept = kept[:max]
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	return kept, removed
+}
+
+// removeVersions deletes the files of the given versions of an application.
+// It is only done when the applications are stored on the local file system,
+// as each instance has its own directory: the swift containers are shared by
+// all the instances.
+func removeVersions(inst *instance.Instance, appType consts.AppType, slug string, versions []Version) {
+	if len(versions) == 0 || config.FsURL().Scheme != config.SchemeFile {
+		return
+	}
+	var baseDirName string
+	switch appType {
+	case consts.WebappType:
+		baseDirName = vfs.WebappsDirName
+	case consts.KonnectorType:
+		baseDirName = vfs.KonnectorsDirName
+	}
+	fs := afero.NewBasePathFs(afero.NewOsFs(),
+		path.Join(config.FsURL().Path, inst.DirName(), baseDirName))
+	log := logger.WithDomain(inst.Domain).WithField("nspace", "apps")
+	for _, v := range versions {
+		dir := v.Version
+		if v.Checksum != "" {
+			dir += "-" + v.Checksum
+		}
+		if dir == "" || dir == "." || dir == ".." {
+			continue
+		}
+		if err := fs.RemoveAll(path.Join("/", slug, dir)); err != nil {
+			log.Warnf("Cannot remove the version %s of %s: %s", v.Version, slug, err)
+		}
+	}
+}
+
+// Rollback switches an application back to one of its previous versions. If
+// version is empty, the most recent of them is used. The application is
+// pinned, so that the auto-updates do not reinstall the version that has been
+// left.
+func Rollback(inst *instance.Instance, man Manifest, version string) (Manifest, error) {
+	if man.State() != Ready && man.State() != Installed {
+		return nil, ErrBadState
+	}
+
+	var target *Version
+	for _, v := range man.PreviousVersions() {
+		if version == "" || v.Version == version {
+			v := v
+			target = &v
+			break
+		}
+	}
+	if target == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	var manFilename string
+	var fileServer appfs.FileServer
+	var alteredPerms *permission.Permission
+	var err error
+	switch man.AppType() {
+	case consts.WebappType:
+		manFilename = WebappManifestName
+		fileServer = AppsFileServer(inst)
+		alteredPerms, err = permission.GetForWebapp(inst, man.Slug())
+	case consts.KonnectorType:
+		manFilename = KonnectorManifestName
+		fileServer = KonnectorsFileServer(inst)
+		alteredPerms, err = permission.GetForKonnector(inst, man.Slug())
+	}
+	if err != nil {
+		return nil, err
+	}
+	extraPerms := permission.Set{}
+	if alteredPerms != nil {
+		extraPerms, err = permission.Diff(man.Permissions(), alteredPerms.Permissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := fileServer.Open(man.Slug(), target.Version, target.Checksum, manFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrVersionNotFound
+		}
+		return nil, err
+	}
+	defer r.Close()
+	newManifest, err := man.ReadManifest(r, man.Slug(), target.Source)
+	if err != nil {
+		return nil, err
+	}
+	newManifest.SetVersion(target.Version)
+	newManifest.SetChecksum(target.Checksum)
+	newManifest.SetAvailableVersion("")
+	newManifest.SetPinned(true)
+	kept, removed := keepVersions(man.PreviousVersions(), currentVersion(man), *target)
+	newManifest.SetPreviousVersions(kept)
+
+	if err := newManifest.Update(inst, extraPerms); err != nil {
+		return nil, err
+	}
+	removeVersions(inst, man.AppType(), man.Slug(), removed)
+	realtime.GetHub().Publish(inst, realtime.EventUpdate, newManifest.Clone(), nil)
+	return newManifest, nil
+}
+
+// SetPinned pins or unpins the current version of an application: the
+// auto-updates skip the pinned applications.
+func SetPinned(inst *instance.Instance, man Manifest, pinned bool) error {
+	if man.Pinned() == pinned {
+		return nil
+	}
+	if webapp, ok := man.(*WebappManifest); ok {
+		webapp.oldServices = webapp.Services
+	}
+	man.SetPinned(pinned)
+	return man.Update(inst, nil)
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestVerifySignature(t *testing.T) {
+	log := logger.WithNamespace("apps")
+	key, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keys := []ed25519.PublicKey{key}
+
+	unsigned := &registry.Version{Slug: "mini", Version: "1.0.0", Sha256: "abcdef"}
+	signed := &registry.Version{Slug: "mini", Version: "1.0.0", Sha256: "abcdef"}
+	signed.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signed.SignedMessage()))
+
+	// Optional signatures
+	assert.NoError(t, verifySignature("mini", "registry", unsigned, false, keys, log))
+	assert.NoError(t, verifySignature("mini", "registry", signed, false, nil, log))
+	assert.NoError(t, verifySignature("mini", "registry", signed, false, keys, log))
+	assert.Equal(t, ErrBadSignature, verifySignature("mini", "registry", signed, false, []ed25519.PublicKey{other}, log))
+
+	// Required signatures
+	assert.Equal(t, ErrMissingSignature, verifySignature("mini", "registry", unsigned, true, keys, log))
+	assert.Equal(t, ErrBadSignature, verifySignature("mini", "registry", signed, true, nil, log))
+	assert.Equal(t, ErrBadSignature, verifySignature("mini", "registry", signed, true, []ed25519.PublicKey{other}, log))
+	assert.NoError(t, verifySignature("mini", "registry", signed, true, []ed25519.PublicKey{other, key}, log))
+
+	// A registry must not serve the signed package of another application
+	assert.Equal(t, ErrBadSignature, verifySignature("maxi", "registry", signed, true, keys, log))
+	assert.Equal(t, ErrBadSignature, verifySignature("maxi", "registry", signed, false, keys, log))
+	assert.Equal(t, ErrBadSignature, verifySignature("maxi", "registry", unsigned, false, nil, log))
+
+	// Sources without a registry version
+	assert.NoError(t, verifySignature("mini", "https", nil, false, keys, log))
+	assert.Equal(t, ErrMissingSignature, verifySignature("mini", "https", nil, true, keys, log))
+
+	defer func(mode string) { build.BuildMode = mode }(build.BuildMode)
+	build.BuildMode = build.ModeDev
+	assert.NoError(t, verifySignature("mini", "git", nil, true, keys, log))
+	build.BuildMode = build.ModeProd
+	assert.Equal(t, ErrMissingSignature, verifySignature("mini", "git", nil, true, keys, log))
+}
+
+func TestGitAndFileSourcesOnlyInDev(t *testing.T) {
+	db := prefixer.NewPrefixer("cozy.example.net", "cozy-example-net")
+	opts := func(source string) *InstallerOptions {
+		return &InstallerOptions{
+			Operation: Install,
+			Type:      consts.WebappType,
+			Manifest:  &WebappManifest{DocID: "io.cozy.apps/mini", DocSlug: "mini"},
+			Slug:      "mini",
+			SourceURL: source,
+		}
+	}
+
+	defer func(mode string) { build.BuildMode = mode }(build.BuildMode)
+	build.BuildMode = build.ModeProd
+	for _, source := range []string{"git://github.com/cozy/mini", "git+https://github.com/cozy/mini", "file:///tmp/mini"} {
+		_, err := NewInstaller(db, nil, opts(source))
+		assert.Equal(t, ErrNotSupportedSource, err, source)
+	}
+	_, err := NewInstaller(db, nil, opts("https://github.com/cozy/mini/archive/master.tar.gz"))
+	assert.NoError(t, err)
+
+	// The applications already installed from these sources can still be
+	// updated and uninstalled
+	for _, op := range []Operation{Update, Delete} {
+		for _, source := range []string{"git://github.com/cozy/mini", "file:///tmp/mini"} {
+			o := opts(source)
+			o.Operation = op
+			_, err := NewInstaller(db, nil, o)
+			assert.NoError(t, err, source)
+		}
+	}
+
+	build.BuildMode = build.ModeDev
+	for _, source := range []string{"git://github.com/cozy/mini", "file:///tmp/mini"} {
+		_, err := NewInstaller(db, nil, opts(source))
+		assert.NoError(t, err, source)
+	}
+}
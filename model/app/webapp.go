@@ -46,6 +46,17 @@ type Service struct {
 	Debounce       string `json:"debounce"`
 	TriggerOptions string `json:"trigger"`
 	TriggerID      string `json:"trigger_id"`
+
+	Functions []*Function `json:"functions,omitempty"`
+}
+
+// Function is an HTTP route, under /apps/:slug/functions, that is handled by
+// a service. The requests on the path, and on its sub-paths, are turned into
+// service jobs. A function is public if it can be called without a token of
+// the application (for a webhook for example).
+type Function struct {
+	Path   string `json:"path"`
+	Public bool   `json:"public,omitempty"`
 }
 
 // Services is a map to define services assciated with an application.
@@ -101,11 +112,14 @@ type WebappManifest struct {
 	DocPermissions      permission.Set `json:"permissions"`
 	DocAvailableVersion string         `json:"available_version,omitempty"`
 	DocTerms            Terms          `json:"terms,omitempty"`
+	DocPreviousVersions []Version      `json:"previous_versions,omitempty"`
+	DocPinned           bool           `json:"pinned,omitempty"`
 
 	Intents       []Intent      `json:"intents"`
 	Routes        Routes        `json:"routes"`
 	Services      Services      `json:"services"`
 	Notifications Notifications `json:"notifications"`
+	CSP           CSP           `json:"csp,omitempty"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -162,12 +176,24 @@ func (m *WebappManifest) Clone() couchdb.Doc {
 	cloned.Tags = cloneRawMessage(m.Tags)
 	cloned.Partnership = cloneRawMessage(m.Partnership)
 
+	if m.CSP != nil {
+		cloned.CSP = make(CSP, len(m.CSP))
+		for k, v := range m.CSP {
+			cloned.CSP[k] = v
+		}
+	}
+
 	cloned.Intents = make([]Intent, len(m.Intents))
 	copy(cloned.Intents, m.Intents)
 
 	cloned.DocPermissions = make(permission.Set, len(m.DocPermissions))
 	copy(cloned.DocPermissions, m.DocPermissions)
 
+	if m.DocPreviousVersions != nil {
+		cloned.DocPreviousVersions = make([]Version, len(m.DocPreviousVersions))
+		copy(cloned.DocPreviousVersions, m.DocPreviousVersions)
+	}
+
 	return &cloned
 }
 
@@ -192,6 +218,12 @@ func (m *WebappManifest) AvailableVersion() string { return m.DocAvailableVersio
 // Checksum is part of the Manifest interface
 func (m *WebappManifest) Checksum() string { return m.DocChecksum }
 
+// PreviousVersions is part of the Manifest interface
+func (m *WebappManifest) PreviousVersions() []Version { return m.DocPreviousVersions }
+
+// Pinned is part of the Manifest interface
+func (m *WebappManifest) Pinned() bool { return m.DocPinned }
+
 // Slug is part of the Manifest interface
 func (m *WebappManifest) Slug() string { return m.DocSlug }
 
@@ -210,6 +242,12 @@ func (m *WebappManifest) SetVersion(version string) { m.DocVersion = version }
 // SetAvailableVersion is part of the Manifest interface
 func (m *WebappManifest) SetAvailableVersion(version string) { m.DocAvailableVersion = version }
 
+// SetPreviousVersions is part of the Manifest interface
+func (m *WebappManifest) SetPreviousVersions(versions []Version) { m.DocPreviousVersions = versions }
+
+// SetPinned is part of the Manifest interface
+func (m *WebappManifest) SetPinned(pinned bool) { m.DocPinned = pinned }
+
 // SetChecksum is part of the Manifest interface
 func (m *WebappManifest) SetChecksum(shasum string) { m.DocChecksum = shasum }
 
@@ -274,6 +312,8 @@ func (m *WebappManifest) ReadManifest(r io.Reader, slug, sourceURL string) (Mani
 	newManifest.Instance = m.Instance
 	newManifest.DocSlug = slug
 	newManifest.DocSource = sourceURL
+	newManifest.DocPreviousVersions = m.DocPreviousVersions
+	newManifest.DocPinned = m.DocPinned
 	newManifest.oldServices = m.Services
 	if newManifest.Routes == nil {
 		newManifest.Routes = make(Routes)
@@ -373,7 +413,9 @@ func diffServices(db prefixer.Prefixer, slug string, oldServices, newServices Se
 			deleted = append(deleted, oldService)
 			created = append(created, newService)
 		} else {
+			functions := newService.Functions
 			*newService = *oldService
+			newService.Functions = functions
 		}
 		newService.name = name
 	}
@@ -389,6 +431,10 @@ func diffServices(db prefixer.Prefixer, slug string, oldServices, newServices Se
 	}
 
 	for _, service := range created {
+		// A service can be only used for its functions, without a trigger
+		if service.TriggerOptions == "" && len(service.Functions) > 0 {
+			continue
+		}
 		var triggerType string
 		var triggerArgs string
 		triggerOpts := strings.SplitN(service.TriggerOptions, " ", 2)
@@ -456,6 +502,26 @@ func (m *WebappManifest) FindRoute(vpath string) (Route, string) {
 	return best, rest
 }
 
+// FindFunction returns the name of the service and the function that match
+// the given path, with the longest path if several functions match.
+func (m *WebappManifest) FindFunction(vpath string) (string, *Function) {
+	vpath = path.Clean("/" + vpath)
+	var name string
+	var best *Function
+	for serviceName, service := range m.Services {
+		for _, fn := range service.Functions {
+			fnPath := path.Clean("/" + fn.Path)
+			if vpath != fnPath && !strings.HasPrefix(vpath, strings.TrimSuffix(fnPath, "/")+"/") {
+				continue
+			}
+			if best == nil || len(fnPath) > len(path.Clean("/"+best.Path)) {
+				name, best = serviceName, fn
+			}
+		}
+	}
+	return name, best
+}
+
 // FindIntent returns an intent for the given action and type if the manifest has one
 func (m *WebappManifest) FindIntent(action, typ string) *Intent {
 	for _, intent := range m.Intents {
@@ -184,3 +184,39 @@ func (i *Instance) ValidateMailConfirmationCode(passcode string) bool {
 	}
 	return true
 }
+
+// GenerateSharingGuestCode generates a code that is sent by mail to a guest of
+// a sharing, for checking their identity.
+func (i *Instance) GenerateSharingGuestCode(sharingID, email string) (string, error) {
+	key, err := i.sharingGuestKey(sharingID, email)
+	if err != nil {
+		return "", err
+	}
+	return totp.GenerateCodeCustom(base32.StdEncoding.EncodeToString(key),
+		time.Now().UTC(), twoFactorTOTPOptions)
+}
+
+// ValidateSharingGuestCode returns true if the given passcode is valid for
+// this guest of the sharing.
+func (i *Instance) ValidateSharingGuestCode(sharingID, email, passcode string) bool {
+	key, err := i.sharingGuestKey(sharingID, email)
+	if err != nil {
+		return false
+	}
+	ok, err := totp.ValidateCustom(passcode, base32.StdEncoding.EncodeToString(key),
+		time.Now().UTC(), twoFactorTOTPOptions)
+	if !ok || err != nil {
+		return false
+	}
+	return true
+}
+
+func (i *Instance) sharingGuestKey(sharingID, email string) ([]byte, error) {
+	info := []byte("sharing-guest:" + sharingID + ":" + email)
+	h := hkdf.New(sha256.New, i.SessionSecret(), nil, info)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
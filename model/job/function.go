@@ -0,0 +1,82 @@
+package job
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// FunctionRequest is the HTTP request given to a service that implements a
+// function. The ID is used to route the response to the stack that has
+// received the request. The body is encoded in base64 in JSON, as it can be
+// binary data.
+type FunctionRequest struct {
+	ID      string            `json:"id"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+}
+
+// FunctionResponse is a part of the response of a service to an HTTP
+// request: the first part has the status and the headers, the next ones have
+// only some content for the body, and the last one has the End flag.
+type FunctionResponse struct {
+	RequestID string            `json:"_id"`
+	Status    int               `json:"status,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      []byte            `json:"body,omitempty"`
+	End       bool              `json:"end,omitempty"`
+}
+
+// ID implements the realtime.Doc interface
+func (r *FunctionResponse) ID() string { return r.RequestID }
+
+// DocType implements the realtime.Doc interface
+func (r *FunctionResponse) DocType() string { return consts.JobFunctions }
+
+// SendFunctionResponse sends a part of the response of a service to the stack
+// that waits for it.
+func SendFunctionResponse(db prefixer.Prefixer, res *FunctionResponse) {
+	realtime.GetHub().Publish(db, realtime.EventCreate, res, nil)
+}
+
+// WatchFunctionResponses returns a channel where the parts of the response
+// for the given request are received. The returned function must be called
+// to stop watching.
+func WatchFunctionResponses(db prefixer.Prefixer, requestID string) (<-chan *FunctionResponse, func(), error) {
+	sub := realtime.GetHub().Subscriber(db)
+	if err := sub.Watch(consts.JobFunctions, requestID); err != nil {
+		_ = sub.Close()
+		return nil, nil, err
+	}
+	responses := make(chan *FunctionResponse, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(responses)
+		for e := range sub.Channel {
+			// The document can be a *FunctionResponse or a *realtime.JSONDoc
+			// when the event comes from redis
+			doc, err := json.Marshal(e.Doc)
+			if err != nil {
+				continue
+			}
+			var res FunctionResponse
+			if err := json.Unmarshal(doc, &res); err != nil {
+				continue
+			}
+			select {
+			case responses <- &res:
+			case <-done:
+			}
+		}
+	}()
+	stop := func() {
+		close(done)
+		_ = sub.Close()
+	}
+	return responses, stop, nil
+}
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctionBinaryBody(t *testing.T) {
+	body := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe, '\n'}
+
+	req := &FunctionRequest{ID: "123", Method: "POST", Path: "/upload", Body: body}
+	data, err := json.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"body":"iVBORwD//go="`)
+	var decodedReq FunctionRequest
+	assert.NoError(t, json.Unmarshal(data, &decodedReq))
+	assert.Equal(t, body, decodedReq.Body)
+
+	res := &FunctionResponse{RequestID: "123", Status: 200, Body: body}
+	data, err = json.Marshal(res)
+	assert.NoError(t, err)
+	var decodedRes FunctionResponse
+	assert.NoError(t, json.Unmarshal(data, &decodedRes))
+	assert.Equal(t, body, decodedRes.Body)
+}
@@ -0,0 +1,67 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+// ErrJobNotRunning is used when an input is sent to a job that is not
+// running.
+var ErrJobNotRunning = errors.New("The job is not running")
+
+// Input is an answer of the user for a running job, like the 2FA code asked
+// by a konnector. It is sent to the worker via the realtime hub, as the job
+// may be executed by another stack.
+type Input struct {
+	JobID string          `json:"_id"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// ID implements the realtime.Doc interface
+func (in *Input) ID() string { return in.JobID }
+
+// DocType implements the realtime.Doc interface
+func (in *Input) DocType() string { return consts.JobInputs }
+
+// SendInput sends the input of the user to the running job.
+func SendInput(db prefixer.Prefixer, j *Job, data json.RawMessage) error {
+	if j.State != Running {
+		return ErrJobNotRunning
+	}
+	input := &Input{JobID: j.ID(), Data: data}
+	realtime.GetHub().Publish(db, realtime.EventCreate, input, nil)
+	return nil
+}
+
+// WatchInputs returns a channel where the inputs sent to the given job are
+// received. The returned function must be called to stop watching.
+func WatchInputs(db prefixer.Prefixer, jobID string) (<-chan json.RawMessage, func(), error) {
+	sub := realtime.GetHub().Subscriber(db)
+	if err := sub.Watch(consts.JobInputs, jobID); err != nil {
+		_ = sub.Close()
+		return nil, nil, err
+	}
+	inputs := make(chan json.RawMessage)
+	go func() {
+		defer close(inputs)
+		for e := range sub.Channel {
+			// The document can be an *Input or a *realtime.JSONDoc when the
+			// event comes from redis
+			doc, err := json.Marshal(e.Doc)
+			if err != nil {
+				continue
+			}
+			var input Input
+			if err := json.Unmarshal(doc, &input); err != nil || input.Data == nil {
+				continue
+			}
+			inputs <- input.Data
+		}
+	}()
+	stop := func() { _ = sub.Close() }
+	return inputs, stop, nil
+}
@@ -0,0 +1,35 @@
+package job_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	jobs "github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendInput(t *testing.T) {
+	db := prefixer.NewPrefixer("cozy.tools:8080", "cozy.tools:8080")
+	j := jobs.NewJob(db, &jobs.JobRequest{WorkerType: "konnector"})
+	require.NoError(t, j.Create())
+
+	input := json.RawMessage(`{"code":"123456"}`)
+	err := jobs.SendInput(db, j, input)
+	assert.Equal(t, jobs.ErrJobNotRunning, err)
+
+	inputs, stop, err := jobs.WatchInputs(db, j.ID())
+	require.NoError(t, err)
+	defer stop()
+	require.NoError(t, j.AckConsumed())
+	require.NoError(t, jobs.SendInput(db, j, input))
+
+	select {
+	case data := <-inputs:
+		assert.JSONEq(t, `{"code":"123456"}`, string(data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("The input has not been received")
+	}
+}
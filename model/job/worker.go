@@ -179,6 +179,11 @@ func (c *WorkerContext) ID() string {
 	return c.id
 }
 
+// JobID returns the identifier of the job executed with the worker context.
+func (c *WorkerContext) JobID() string {
+	return c.job.ID()
+}
+
 // Logger return the logger associated with the worker context.
 func (c *WorkerContext) Logger() *logrus.Entry {
 	return c.log
@@ -0,0 +1,536 @@
+package note
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/mail"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/prosemirror-go/transform"
+)
+
+const (
+	// CommentOpen is the state of a thread of comments that is still
+	// discussed.
+	CommentOpen = "open"
+	// CommentResolved is the state of a thread of comments that has been
+	// resolved.
+	CommentResolved = "resolved"
+)
+
+// Anchor is the range of the note that a thread of comments is about. The
+// positions are the prosemirror positions in the content of the note, at the
+// given version.
+type Anchor struct {
+	From    int   `json:"from"`
+	To      int   `json:"to"`
+	Version int64 `json:"version"`
+	// Detached is true when the text of the range has been deleted.
+	Detached bool `json:"detached,omitempty"`
+}
+
+// Comment is a comment on a note. The first comment of a thread has the
+// anchor and the state of the thread, and the replies have the identifier of
+// the first comment in their thread_id.
+type Comment struct {
+	DocID      string     `json:"_id"`
+	DocRev     string     `json:"_rev,omitempty"`
+	NoteID     string     `json:"note_id"`
+	ThreadID   string     `json:"thread_id,omitempty"`
+	Author     string     `json:"author"`
+	Body       string     `json:"body"`
+	Mentions   []string   `json:"mentions,omitempty"`
+	Anchor     *Anchor    `json:"anchor,omitempty"`
+	State      string     `json:"state,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ID returns the comment qualified identifier
+func (c *Comment) ID() string { return c.DocID }
+
+// Rev returns the comment revision
+func (c *Comment) Rev() string { return c.DocRev }
+
+// DocType returns the comment document type
+func (c *Comment) DocType() string { return consts.NotesComments }
+
+// Clone implements couchdb.Doc
+func (c *Comment) Clone() couchdb.Doc {
+	cloned := *c
+	cloned.Mentions = make([]string, len(c.Mentions))
+	copy(cloned.Mentions, c.Mentions)
+	if c.Anchor != nil {
+		anchor := *c.Anchor
+		cloned.Anchor = &anchor
+	}
+	if c.ResolvedAt != nil {
+		resolved := *c.ResolvedAt
+		cloned.ResolvedAt = &resolved
+	}
+	return &cloned
+}
+
+// SetID changes the comment qualified identifier
+func (c *Comment) SetID(id string) { c.DocID = id }
+
+// SetRev changes the comment revision
+func (c *Comment) SetRev(rev string) { c.DocRev = rev }
+
+// Included is part of the jsonapi.Object interface
+func (c *Comment) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (c *Comment) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (c *Comment) Relationships() jsonapi.RelationshipMap { return nil }
+
+// IsThread returns true for the first comment of a thread.
+func (c *Comment) IsThread() bool { return c.ThreadID == "" }
+
+// commentID returns an identifier for a comment that starts with the note
+// identifier, to list the comments of a note with an _all_docs request.
+func commentID(noteID string) string {
+	return fmt.Sprintf("%s-%s", noteID, crypto.GenerateRandomString(16))
+}
+
+// publish sends the comment in the realtime hub, like the telepointers, with
+// the action (created, updated or deleted).
+func (c *Comment) publish(inst *instance.Instance, action string) {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	var event Event
+	if err := json.Unmarshal(buf, &event); err != nil {
+		return
+	}
+	delete(event, "_rev")
+	event["comment_id"] = c.DocID
+	event["doctype"] = consts.NotesComments
+	event["action"] = action
+	event.SetID(c.NoteID)
+	event.publish(inst)
+}
+
+// ListComments returns the comments of a note, sorted by creation date.
+func ListComments(inst *instance.Instance, file *vfs.FileDoc) ([]*Comment, error) {
+	if !IsNote(file) {
+		return nil, ErrInvalidFile
+	}
+	return allComments(inst, file.ID())
+}
+
+func allComments(db prefixer.Prefixer, noteID string) ([]*Comment, error) {
+	var comments []*Comment
+	req := couchdb.AllDocsRequest{
+		Limit:    10000,
+		StartKey: noteID + "-",
+		EndKey:   noteID + "-" + couchdb.MaxString,
+	}
+	if err := couchdb.GetAllDocs(db, consts.NotesComments, &req, &comments); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	return comments, nil
+}
+
+// GetComment returns the comment of the note with the given identifier.
+func GetComment(inst *instance.Instance, file *vfs.FileDoc, commentID string) (*Comment, error) {
+	if !IsNote(file) {
+		return nil, ErrInvalidFile
+	}
+	comment := &Comment{}
+	if err := couchdb.GetDoc(inst, consts.NotesComments, commentID, comment); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	if comment.NoteID != file.ID() {
+		return nil, ErrCommentNotFound
+	}
+	return comment, nil
+}
+
+// CreateThread adds a new thread of comments on a range of the note. If the
+// anchor has been made on an older version of the note, it is mapped through
+// the steps since this version.
+func CreateThread(inst *instance.Instance, file *vfs.FileDoc, comment *Comment) (*Comment, error) {
+	if !IsNote(file) {
+		return nil, ErrInvalidFile
+	}
+	if err := checkComment(comment); err != nil {
+		return nil, err
+	}
+	anchor := comment.Anchor
+	if anchor == nil || anchor.From < 0 || anchor.To < anchor.From {
+		return nil, ErrInvalidAnchor
+	}
+
+	// The lock is kept until the comment is saved, to not miss steps that
+	// could change the anchor.
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	if anchor.Version != doc.Version {
+		if err := mapAnchorSince(inst, doc, anchor); err != nil {
+			return nil, err
+		}
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return nil, err
+	}
+	if anchor.To > content.Content.Size {
+		return nil, ErrInvalidAnchor
+	}
+
+	now := time.Now().UTC()
+	comment.DocID = commentID(file.ID())
+	comment.DocRev = ""
+	comment.NoteID = file.ID()
+	comment.ThreadID = ""
+	comment.State = CommentOpen
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	comment.ResolvedAt = nil
+	if err := saveComment(inst, comment); err != nil {
+		return nil, err
+	}
+	comment.publish(inst, "created")
+	notifyMentions(inst, doc, comment)
+	return comment, nil
+}
+
+// mapAnchorSince maps the positions of an anchor made on an older version of
+// the note through the steps since this version. It must be called with the
+// notes lock already acquired.
+func mapAnchorSince(inst *instance.Instance, doc *Document, anchor *Anchor) error {
+	if anchor.Version > doc.Version {
+		return ErrInvalidAnchor
+	}
+	steps, err := getSteps(inst, doc.ID(), anchor.Version)
+	if err != nil {
+		return err
+	}
+	maps, err := stepMaps(doc, steps)
+	if err != nil {
+		return err
+	}
+	mapAnchor(anchor, maps)
+	anchor.Version = doc.Version
+	return nil
+}
+
+// Reply adds a comment to an existing thread.
+func Reply(inst *instance.Instance, file *vfs.FileDoc, threadID string, comment *Comment) (*Comment, error) {
+	if err := checkComment(comment); err != nil {
+		return nil, err
+	}
+	thread, err := GetComment(inst, file, threadID)
+	if err != nil {
+		return nil, err
+	}
+	if !thread.IsThread() {
+		return nil, ErrCommentNotFound
+	}
+
+	now := time.Now().UTC()
+	comment.DocID = commentID(file.ID())
+	comment.DocRev = ""
+	comment.NoteID = file.ID()
+	comment.ThreadID = thread.ID()
+	comment.Anchor = nil
+	comment.State = ""
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	comment.ResolvedAt = nil
+	if err := saveComment(inst, comment); err != nil {
+		return nil, err
+	}
+	comment.publish(inst, "created")
+	if doc, _, err := lastVersion(inst, file); err == nil {
+		notifyMentions(inst, doc, comment)
+	}
+	return comment, nil
+}
+
+// UpdateComment changes the body and the mentions of a comment.
+func UpdateComment(inst *instance.Instance, file *vfs.FileDoc, commentID string, patch *Comment) (*Comment, error) {
+	if err := checkComment(patch); err != nil {
+		return nil, err
+	}
+	comment, err := GetComment(inst, file, commentID)
+	if err != nil {
+		return nil, err
+	}
+	oldMentions := make(map[string]bool)
+	for _, id := range comment.Mentions {
+		oldMentions[id] = true
+	}
+	comment.Body = patch.Body
+	comment.Mentions = patch.Mentions
+	comment.UpdatedAt = time.Now().UTC()
+	if err := couchdb.UpdateDoc(inst, comment); err != nil {
+		return nil, err
+	}
+	comment.publish(inst, "updated")
+
+	// Only the new mentions are notified
+	var added []string
+	for _, id := range comment.Mentions {
+		if !oldMentions[id] {
+			added = append(added, id)
+		}
+	}
+	if len(added) > 0 {
+		if doc, _, err := lastVersion(inst, file); err == nil {
+			notified := *comment
+			notified.Mentions = added
+			notifyMentions(inst, doc, &notified)
+		}
+	}
+	return comment, nil
+}
+
+// SetThreadState resolves or reopens a thread of comments.
+func SetThreadState(inst *instance.Instance, file *vfs.FileDoc, threadID, state string) (*Comment, error) {
+	if state != CommentOpen && state != CommentResolved {
+		return nil, ErrInvalidCommentState
+	}
+	thread, err := GetComment(inst, file, threadID)
+	if err != nil {
+		return nil, err
+	}
+	if !thread.IsThread() {
+		return nil, ErrCommentNotFound
+	}
+	if thread.State == state {
+		return thread, nil
+	}
+	now := time.Now().UTC()
+	thread.State = state
+	thread.UpdatedAt = now
+	if state == CommentResolved {
+		thread.ResolvedAt = &now
+	} else {
+		thread.ResolvedAt = nil
+	}
+	if err := couchdb.UpdateDoc(inst, thread); err != nil {
+		return nil, err
+	}
+	thread.publish(inst, "updated")
+	return thread, nil
+}
+
+// DeleteComment removes a comment. If it is the first comment of a thread,
+// the whole thread is removed.
+func DeleteComment(inst *instance.Instance, file *vfs.FileDoc, commentID string) error {
+	comment, err := GetComment(inst, file, commentID)
+	if err != nil {
+		return err
+	}
+	docs := []couchdb.Doc{comment}
+	if comment.IsThread() {
+		comments, err := allComments(inst, file.ID())
+		if err != nil {
+			return err
+		}
+		for _, c := range comments {
+			if c.ThreadID == comment.ID() {
+				docs = append(docs, c)
+			}
+		}
+	}
+	if err := couchdb.BulkDeleteDocs(inst, consts.NotesComments, docs); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		doc.(*Comment).publish(inst, "deleted")
+	}
+	return nil
+}
+
+// deleteComments removes all the comments of a note.
+func deleteComments(db prefixer.Prefixer, noteID string) error {
+	comments, err := allComments(db, noteID)
+	if err != nil || len(comments) == 0 {
+		return err
+	}
+	docs := make([]couchdb.Doc, len(comments))
+	for i, c := range comments {
+		docs[i] = c
+	}
+	return couchdb.BulkDeleteDocs(db, consts.NotesComments, docs)
+}
+
+func checkComment(comment *Comment) error {
+	comment.Body = strings.TrimSpace(comment.Body)
+	if comment.Body == "" {
+		return ErrEmptyComment
+	}
+	return nil
+}
+
+func saveComment(inst *instance.Instance, comment *Comment) error {
+	err := couchdb.CreateNamedDoc(inst, comment)
+	if couchdb.IsNoDatabaseError(err) {
+		if err = couchdb.EnsureDBExist(inst, consts.NotesComments); err != nil {
+			return err
+		}
+		err = couchdb.CreateNamedDoc(inst, comment)
+	}
+	return err
+}
+
+// stepMaps returns the step maps for the given steps, that can be used to
+// map the positions in the note through these steps.
+func stepMaps(doc *Document, steps []Step) ([]*transform.StepMap, error) {
+	schema, err := doc.Schema()
+	if err != nil {
+		return nil, err
+	}
+	maps := make([]*transform.StepMap, 0, len(steps))
+	for _, s := range steps {
+		step, err := transform.StepFromJSON(schema, s)
+		if err != nil {
+			return nil, ErrInvalidSteps
+		}
+		maps = append(maps, step.GetMap())
+	}
+	return maps, nil
+}
+
+// mapAnchor maps the positions of an anchor through some step maps. The
+// start of the range sticks to the right, and its end to the left, so that
+// the text inserted at the edges of the range is not included in it.
+func mapAnchor(anchor *Anchor, maps []*transform.StepMap) {
+	for _, m := range maps {
+		anchor.From = m.Map(anchor.From, 1)
+		anchor.To = m.Map(anchor.To, -1)
+	}
+	if anchor.To <= anchor.From {
+		anchor.To = anchor.From
+		anchor.Detached = true
+	}
+}
+
+// mapComments maps the anchors of the threads of a note through the steps
+// that have just been applied on it, and sends the new anchors in the
+// realtime hub. It must be called with the notes lock already acquired.
+func mapComments(inst *instance.Instance, doc *Document, steps []Step) {
+	comments, err := allComments(inst, doc.ID())
+	if err != nil || len(comments) == 0 {
+		return
+	}
+	var maps []*transform.StepMap
+	var changed []*Comment
+	for _, c := range comments {
+		if !c.IsThread() || c.Anchor == nil || c.Anchor.Detached {
+			continue
+		}
+		if maps == nil {
+			if maps, err = stepMaps(doc, steps); err != nil {
+				return
+			}
+		}
+		// When the positions are not changed by the steps, the anchor is
+		// still valid for the new version, and there is no need to save it.
+		before := *c.Anchor
+		mapAnchor(c.Anchor, maps)
+		if before.From != c.Anchor.From || before.To != c.Anchor.To || c.Anchor.Detached {
+			c.Anchor.Version = doc.Version
+			changed = append(changed, c)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	olds := make([]interface{}, len(changed))
+	news := make([]interface{}, len(changed))
+	for i, c := range changed {
+		news[i] = c
+	}
+	if err := couchdb.BulkUpdateDocs(inst, consts.NotesComments, news, olds); err != nil {
+		inst.Logger().WithField("nspace", "notes").
+			Warnf("Cannot update the anchors of the comments for %s: %s", doc.ID(), err)
+		return
+	}
+	for _, c := range changed {
+		c.publish(inst, "updated")
+	}
+}
+
+// notifyMentions sends a mail to the contacts mentioned in a comment. The
+// mentioned contacts are not the owner of the instance, so the notification
+// center cannot be used for them.
+func notifyMentions(inst *instance.Instance, doc *Document, comment *Comment) {
+	if len(comment.Mentions) == 0 {
+		return
+	}
+	author := comment.Author
+	if author == "" {
+		author = inst.Translate("Notes Comment Anonymous Author")
+	}
+	for _, id := range comment.Mentions {
+		c, err := contact.Find(inst, id)
+		if err != nil {
+			continue
+		}
+		addr, err := c.ToMailAddress()
+		if err != nil || addr.Email == "" {
+			continue
+		}
+		msg, err := job.NewMessage(mail.Options{
+			Mode:         mail.ModeFromUser,
+			To:           []*mail.Address{addr},
+			TemplateName: "notifications_note_mention",
+			TemplateValues: map[string]interface{}{
+				"Author":    author,
+				"NoteTitle": doc.Title,
+				"Mentions":  addr.Name,
+				"Body":      comment.Body,
+			},
+			RecipientName: addr.Name,
+			Layout:        mail.CozyCloudLayout,
+		})
+		if err == nil {
+			_, err = job.System().PushJob(inst, &job.JobRequest{
+				WorkerType: "sendmail",
+				Message:    msg,
+			})
+		}
+		if err != nil {
+			inst.Logger().WithField("nspace", "notes").
+				Infof("Cannot notify the mention of %s for %s: %s", id, comment.ID(), err)
+		}
+	}
+}
+
+var _ jsonapi.Object = &Comment{}
@@ -0,0 +1,39 @@
+package note
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapAnchor(t *testing.T) {
+	schema := newDefaultSchema(t)
+	text := func(s string) *model.Slice {
+		return model.NewSlice(model.FragmentFromArray([]*model.Node{schema.Text(s)}), 0, 0)
+	}
+
+	// "Hello world" in a paragraph, with an anchor on "world"
+	anchor := &Anchor{From: 7, To: 12}
+
+	// Insert "big " before "world": it is not included in the anchor
+	maps := []*transform.StepMap{transform.NewReplaceStep(7, 7, text("big ")).GetMap()}
+	mapAnchor(anchor, maps)
+	assert.Equal(t, &Anchor{From: 11, To: 16}, anchor)
+
+	// Insert "!" after "world": it is not included in the anchor
+	maps = []*transform.StepMap{transform.NewReplaceStep(16, 16, text("!")).GetMap()}
+	mapAnchor(anchor, maps)
+	assert.Equal(t, &Anchor{From: 11, To: 16}, anchor)
+
+	// Insert "--" inside "world"
+	maps = []*transform.StepMap{transform.NewReplaceStep(13, 13, text("--")).GetMap()}
+	mapAnchor(anchor, maps)
+	assert.Equal(t, &Anchor{From: 11, To: 18}, anchor)
+
+	// Delete the text of the anchor
+	maps = []*transform.StepMap{transform.NewReplaceStep(9, 20, model.EmptySlice).GetMap()}
+	mapAnchor(anchor, maps)
+	assert.Equal(t, &Anchor{From: 9, To: 9, Detached: true}, anchor)
+}
@@ -20,4 +20,31 @@ var (
 	ErrTooOld = errors.New("The revision is too old")
 	// ErrMissingSessionID is used when a telepointer has no identifier.
 	ErrMissingSessionID = errors.New("The session id is missing")
+	// ErrUnsupportedFormat is used when trying to import a note from a format
+	// that is not Markdown or HTML.
+	ErrUnsupportedFormat = errors.New("The format is not supported for a note")
+	// ErrAlreadyANote is used when trying to convert a file that is already a
+	// note.
+	ErrAlreadyANote = errors.New("The file is already a note")
+	// ErrInvalidContent is used when the content to import can't be
+	// transformed to a note with the schema.
+	ErrInvalidContent = errors.New("The content cannot be imported as a note")
+	// ErrInvalidImage is used when an image uploaded for a note is not a valid
+	// image, or when its name is invalid.
+	ErrInvalidImage = errors.New("Invalid image")
+	// ErrSnapshotNotFound is used when a snapshot of a note cannot be found.
+	ErrSnapshotNotFound = errors.New("Snapshot not found")
+	// ErrMissingSnapshotName is used when trying to create a named snapshot
+	// without a name.
+	ErrMissingSnapshotName = errors.New("The name of the snapshot is missing")
+	// ErrCommentNotFound is used when a comment on a note cannot be found.
+	ErrCommentNotFound = errors.New("Comment not found")
+	// ErrEmptyComment is used when trying to post a comment without a body.
+	ErrEmptyComment = errors.New("The body of the comment is empty")
+	// ErrInvalidAnchor is used when the range of a thread of comments is not
+	// valid for the note.
+	ErrInvalidAnchor = errors.New("Invalid anchor for the comment")
+	// ErrInvalidCommentState is used when the state of a thread of comments
+	// is neither open nor resolved.
+	ErrInvalidCommentState = errors.New("Invalid state for the comment")
 )
@@ -0,0 +1,462 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/assets"
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const (
+	// ExportHTML is the format for exporting a note as a standalone HTML page
+	ExportHTML = "html"
+	// ExportPDF is the format for exporting a note as a PDF document
+	ExportPDF = "pdf"
+	// ExportDOCX is the format for exporting a note as an Office Open XML
+	// document
+	ExportDOCX = "docx"
+	// ExportMarkdown is the format for exporting a note as a zip archive with
+	// the Markdown file and its images
+	ExportMarkdown = "md"
+)
+
+// defaultPrimaryColor is the color used for the titles and links when the
+// theme of the instance doesn't define a --primaryColor.
+const defaultPrimaryColor = "#297EF2"
+
+// ExportMime returns the mime-type for the given export format, or an empty
+// string if the format is not supported.
+func ExportMime(format string) string {
+	switch format {
+	case ExportHTML:
+		return "text/html"
+	case ExportPDF:
+		return "application/pdf"
+	case ExportDOCX:
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case ExportMarkdown:
+		return "application/zip"
+	}
+	return ""
+}
+
+// ExportFilename returns the filename for the export of a note in the given
+// format.
+func ExportFilename(file *vfs.FileDoc, format string) string {
+	name := strings.TrimSuffix(file.DocName, ".cozy-note")
+	if format == ExportMarkdown {
+		return name + ".zip"
+	}
+	return name + "." + format
+}
+
+// IsNote returns true if the file is a note.
+func IsNote(file *vfs.FileDoc) bool {
+	return file.Mime == noteMime
+}
+
+// Export writes the note in the given format (html, pdf, docx or md). The
+// last version of the note is used, even if it has not yet been persisted to
+// the VFS.
+func Export(inst *instance.Instance, file *vfs.FileDoc, format string, w io.Writer) error {
+	if ExportMime(format) == "" {
+		return ErrUnsupportedFormat
+	}
+	if format == ExportMarkdown {
+		return exportMarkdown(inst, file, w)
+	}
+
+	doc, content, err := lastVersion(inst, file)
+	if err != nil {
+		return err
+	}
+	theme := loadExportTheme(inst)
+	images := loadImages(inst, file.ID(), content)
+	switch format {
+	case ExportHTML:
+		return exportHTML(inst, doc.Title, content, theme, images, w)
+	case ExportPDF:
+		return exportPDF(doc.Title, content, theme, images, w)
+	default:
+		return exportDOCX(doc.Title, content, theme, w)
+	}
+}
+
+// lastVersion returns the document and its content for the last version of
+// the note.
+func lastVersion(inst *instance.Instance, file *vfs.FileDoc) (*Document, *model.Node, error) {
+	if !IsNote(file) {
+		return nil, nil, ErrInvalidFile
+	}
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, nil, err
+	}
+	doc, err := get(inst, file)
+	lock.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := doc.Content()
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, content, nil
+}
+
+// MarkdownFiles returns the Markdown for the last version of the note, and
+// the images used in it.
+func MarkdownFiles(inst *instance.Instance, file *vfs.FileDoc) ([]byte, []*vfs.FileDoc, error) {
+	doc, content, err := lastVersion(inst, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	md, err := doc.Markdown()
+	if err != nil {
+		return nil, nil, err
+	}
+	var images []*vfs.FileDoc
+	for name := range usedImages(content) {
+		if img, err := GetImage(inst, file.ID(), name); err == nil {
+			images = append(images, img)
+		}
+	}
+	return md, images, nil
+}
+
+// exportMarkdown writes a zip archive with the Markdown file for the note,
+// and its images in an images/ directory, to match the src of the image
+// nodes.
+func exportMarkdown(inst *instance.Instance, file *vfs.FileDoc, w io.Writer) error {
+	md, images, err := MarkdownFiles(inst, file)
+	if err != nil {
+		return err
+	}
+	z := zip.NewWriter(w)
+	name := strings.TrimSuffix(file.DocName, ".cozy-note") + ".md"
+	if err := writeToZip(z, name, file.UpdatedAt, bytes.NewReader(md)); err != nil {
+		return err
+	}
+	fs := inst.VFS()
+	for _, img := range images {
+		f, err := fs.OpenFile(img)
+		if err != nil {
+			return err
+		}
+		err = writeToZip(z, ImageSrcPrefix+img.DocName, img.UpdatedAt, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func writeToZip(z *zip.Writer, name string, modified time.Time, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	header.SetMode(0640)
+	f, err := z.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// exportImage is an image stored in the cozy and used in a note.
+type exportImage struct {
+	Mime string
+	Data []byte
+}
+
+// loadImages reads the images stored in the cozy that are used in the note,
+// to embed them in the exported document.
+func loadImages(inst *instance.Instance, noteID string, content *model.Node) map[string]*exportImage {
+	images := make(map[string]*exportImage)
+	fs := inst.VFS()
+	for name := range usedImages(content) {
+		img, err := GetImage(inst, noteID, name)
+		if err != nil {
+			continue
+		}
+		f, err := fs.OpenFile(img)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err == nil {
+			images[name] = &exportImage{Mime: img.Mime, Data: data}
+		}
+	}
+	return images
+}
+
+// exportTheme is the part of the theme of the instance used for the exports.
+type exportTheme struct {
+	CSS          string
+	PrimaryColor string
+}
+
+var primaryColorRegexp = regexp.MustCompile(`--primaryColor:\s*(#[0-9a-fA-F]{6})\b`)
+
+func loadExportTheme(inst *instance.Instance) *exportTheme {
+	theme := &exportTheme{PrimaryColor: defaultPrimaryColor}
+	f, err := assets.Open("/styles/theme.css", inst.ContextName)
+	if err != nil {
+		return theme
+	}
+	if css, err := ioutil.ReadAll(f); err == nil {
+		theme.CSS = string(css)
+		if matches := primaryColorRegexp.FindStringSubmatch(theme.CSS); len(matches) == 2 {
+			theme.PrimaryColor = matches[1]
+		}
+	}
+	return theme
+}
+
+// rgb returns the red, green and blue components of the primary color.
+func (t *exportTheme) rgb() (int, int, int) {
+	c, err := strconv.ParseUint(strings.TrimPrefix(t.PrimaryColor, "#"), 16, 32)
+	if err != nil {
+		return 0x29, 0x7E, 0xF2
+	}
+	return int(c >> 16 & 0xFF), int(c >> 8 & 0xFF), int(c & 0xFF)
+}
+
+func nodeName(n *model.Node) string {
+	return normalizedName(n.Type.Name)
+}
+
+func attrInt(n *model.Node, key string, def int) int {
+	switch v := n.Attrs[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case int64:
+		return int(v)
+	}
+	return def
+}
+
+func attrString(n *model.Node, key string) string {
+	s, _ := n.Attrs[key].(string)
+	return s
+}
+
+func markAttr(m *model.Mark, key string) string {
+	s, _ := m.Attrs[key].(string)
+	return s
+}
+
+// linkSchemes and imageSchemes are the schemes of the URLs that are kept in
+// the exports for the links and the images. The other URLs, like the
+// javascript: ones, are removed.
+var (
+	linkSchemes  = []string{"http", "https", "mailto"}
+	imageSchemes = []string{"http", "https"}
+)
+
+// safeURL returns the URL if it is absolute with one of the given schemes,
+// or an empty string.
+func safeURL(raw string, schemes []string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// isTextblock returns true if the node is a block with inline content.
+func isTextblock(n *model.Node) bool {
+	return n.IsBlock() && n.ChildCount() > 0 && n.FirstChild().IsInline()
+}
+
+// children returns the child nodes of n.
+func children(n *model.Node) []*model.Node {
+	var nodes []*model.Node
+	n.ForEach(func(child *model.Node, _ int, _ int) {
+		nodes = append(nodes, child)
+	})
+	return nodes
+}
+
+const exportHTMLTemplate = `<!DOCTYPE html>
+<html lang="{{.Locale}}">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}}</title>
+    <style>
+      body { margin: 0 auto; max-width: 50rem; padding: 2rem; font-family: Lato, sans-serif; color: #32363F; line-height: 1.5; }
+      h1, h2, h3, h4, h5, h6 { color: {{.PrimaryColor}}; }
+      a { color: {{.PrimaryColor}}; }
+      blockquote { margin-left: 0; padding-left: 1rem; border-left: 4px solid #D6D8DA; color: #5D6165; }
+      pre { padding: 1rem; background: #F5F6F7; overflow: auto; }
+      code { font-family: monospace; }
+      table { border-collapse: collapse; }
+      th, td { padding: 0.25rem 0.5rem; border: 1px solid #D6D8DA; }
+      th { background: #F5F6F7; }
+      .panel { padding: 0.5rem 1rem; border-radius: 4px; background: #EEF5FE; }
+      img { max-width: 100%; }
+    </style>
+    <style>{{.ThemeCSS}}</style>
+  </head>
+  <body>
+    <article class="note">
+      <h1 class="note-title">{{.Title}}</h1>
+      {{.Content}}
+    </article>
+  </body>
+</html>
+`
+
+var exportTemplate = template.Must(template.New("note").Parse(exportHTMLTemplate))
+
+func exportHTML(inst *instance.Instance, title string, content *model.Node, theme *exportTheme, images map[string]*exportImage, w io.Writer) error {
+	var b strings.Builder
+	renderHTMLChildren(&b, content, images)
+	return exportTemplate.Execute(w, map[string]interface{}{
+		"Locale":       inst.Locale,
+		"Title":        title,
+		"PrimaryColor": template.CSS(theme.PrimaryColor),
+		"ThemeCSS":     template.CSS(theme.CSS),
+		"Content":      template.HTML(b.String()),
+	})
+}
+
+func renderHTMLChildren(b *strings.Builder, n *model.Node, images map[string]*exportImage) {
+	for _, child := range children(n) {
+		renderHTMLNode(b, child, images)
+	}
+}
+
+func renderHTMLNode(b *strings.Builder, n *model.Node, images map[string]*exportImage) {
+	if n.IsText() {
+		renderHTMLText(b, n)
+		return
+	}
+
+	wrap := func(open, close string) {
+		b.WriteString(open)
+		renderHTMLChildren(b, n, images)
+		b.WriteString(close)
+	}
+	switch nodeName(n) {
+	case "paragraph":
+		wrap("<p>", "</p>\n")
+	case "heading":
+		level := attrInt(n, "level", 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		wrap(fmt.Sprintf("<h%d>", level), fmt.Sprintf("</h%d>\n", level))
+	case "bulletList":
+		wrap("<ul>\n", "</ul>\n")
+	case "orderedList":
+		if order := attrInt(n, "order", 1); order != 1 {
+			wrap(fmt.Sprintf(`<ol start="%d">`+"\n", order), "</ol>\n")
+		} else {
+			wrap("<ol>\n", "</ol>\n")
+		}
+	case "listItem":
+		wrap("<li>", "</li>\n")
+	case "blockquote":
+		wrap("<blockquote>\n", "</blockquote>\n")
+	case "rule":
+		b.WriteString("<hr>\n")
+	case "hardBreak":
+		b.WriteString("<br>")
+	case "image":
+		src := attrString(n, "src")
+		if img, ok := images[ImageNameFromSrc(src)]; ok {
+			src = "data:" + img.Mime + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+		} else {
+			src = safeURL(src, imageSchemes)
+		}
+		alt := template.HTMLEscapeString(attrString(n, "alt"))
+		if src == "" {
+			b.WriteString(alt)
+			break
+		}
+		fmt.Fprintf(b, `<img src="%s" alt="%s">`, template.HTMLEscapeString(src), alt)
+	case "codeBlock":
+		if lang := attrString(n, "language"); lang != "" {
+			fmt.Fprintf(b, `<pre><code class="language-%s">`, template.HTMLEscapeString(lang))
+		} else {
+			b.WriteString("<pre><code>")
+		}
+		b.WriteString(template.HTMLEscapeString(n.TextContent()))
+		b.WriteString("</code></pre>\n")
+	case "panel":
+		typ := attrString(n, "panelType")
+		wrap(fmt.Sprintf(`<div class="panel panel-%s">`+"\n", template.HTMLEscapeString(typ)), "</div>\n")
+	case "table":
+		wrap("<table>\n", "</table>\n")
+	case "tableRow":
+		wrap("<tr>", "</tr>\n")
+	case "tableHeader":
+		wrap("<th>", "</th>")
+	case "tableCell":
+		wrap("<td>", "</td>")
+	default:
+		renderHTMLChildren(b, n, images)
+	}
+}
+
+func renderHTMLText(b *strings.Builder, n *model.Node) {
+	var closing []string
+	for _, m := range n.Marks {
+		switch normalizedName(m.Type.Name) {
+		case "em":
+			b.WriteString("<em>")
+			closing = append(closing, "</em>")
+		case "strong":
+			b.WriteString("<strong>")
+			closing = append(closing, "</strong>")
+		case "strike":
+			b.WriteString("<s>")
+			closing = append(closing, "</s>")
+		case "underline":
+			b.WriteString("<u>")
+			closing = append(closing, "</u>")
+		case "code":
+			b.WriteString("<code>")
+			closing = append(closing, "</code>")
+		case "link":
+			href := safeURL(markAttr(m, "href"), linkSchemes)
+			if href == "" {
+				continue
+			}
+			fmt.Fprintf(b, `<a href="%s">`, template.HTMLEscapeString(href))
+			closing = append(closing, "</a>")
+		}
+	}
+	b.WriteString(template.HTMLEscapeString(*n.Text))
+	for i := len(closing) - 1; i >= 0; i-- {
+		b.WriteString(closing[i])
+	}
+}
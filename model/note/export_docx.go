@@ -0,0 +1,335 @@
+package note
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cozy/prosemirror-go/model"
+)
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+  <Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+  <Default Extension="xml" ContentType="application/xml"/>
+  <Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+  <Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+  <Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>
+  <Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>
+</Types>`
+
+const docxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+  <Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>
+</Relationships>`
+
+const docxCore = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <dc:title>%s</dc:title>
+  <dc:creator>Cozy</dc:creator>
+</cp:coreProperties>`
+
+const docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+  <w:docDefaults>
+    <w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>
+    <w:pPrDefault><w:pPr><w:spacing w:after="120"/></w:pPr></w:pPrDefault>
+  </w:docDefaults>
+  <w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+  <w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="240"/></w:pPr><w:rPr><w:b/><w:color w:val="%[1]s"/><w:sz w:val="44"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:color w:val="%[1]s"/><w:sz w:val="36"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="200"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:color w:val="%[1]s"/><w:sz w:val="30"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="160"/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/><w:color w:val="%[1]s"/><w:sz w:val="26"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:color w:val="%[1]s"/><w:sz w:val="24"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:b/><w:color w:val="%[1]s"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr><w:rPr><w:i/><w:color w:val="%[1]s"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/><w:basedOn w:val="Normal"/><w:pPr><w:pBdr><w:left w:val="single" w:sz="24" w:space="8" w:color="D6D8DA"/></w:pBdr><w:ind w:left="360"/></w:pPr><w:rPr><w:color w:val="5D6165"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="Code"><w:name w:val="Code"/><w:basedOn w:val="Normal"/><w:pPr><w:shd w:val="clear" w:color="auto" w:fill="F5F6F7"/><w:spacing w:after="0"/></w:pPr><w:rPr><w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/><w:sz w:val="20"/></w:rPr></w:style>
+  <w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="40"/></w:pPr></w:style>
+  <w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="%[1]s"/><w:u w:val="single"/></w:rPr></w:style>
+  <w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/><w:tblPr><w:tblBorders><w:top w:val="single" w:sz="4" w:color="D6D8DA"/><w:left w:val="single" w:sz="4" w:color="D6D8DA"/><w:bottom w:val="single" w:sz="4" w:color="D6D8DA"/><w:right w:val="single" w:sz="4" w:color="D6D8DA"/><w:insideH w:val="single" w:sz="4" w:color="D6D8DA"/><w:insideV w:val="single" w:sz="4" w:color="D6D8DA"/></w:tblBorders></w:tblPr></w:style>
+</w:styles>`
+
+// docxBulletNumID and docxOrderedNumID are the identifiers of the abstract
+// numberings for the lists in numbering.xml. The ordered lists get their own
+// numbering instance each, so that the numbers restart for every list.
+const (
+	docxBulletNumID  = 1
+	docxOrderedNumID = 2
+)
+
+const docxMaxListLevel = 8
+
+// docxWriter renders the nodes of a note to the WordprocessingML body of a
+// DOCX document.
+type docxWriter struct {
+	body      strings.Builder
+	links     []string
+	orderNums []int // start values of the ordered lists, by numbering instance
+	listLevel int
+}
+
+func exportDOCX(title string, content *model.Node, theme *exportTheme, w io.Writer) error {
+	dw := &docxWriter{listLevel: -1}
+	dw.paragraph("Title", "", func() { dw.run(title, "") })
+	dw.blocks(content, "")
+
+	color := strings.TrimPrefix(theme.PrimaryColor, "#")
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxRootRels},
+		{"docProps/core.xml", fmt.Sprintf(docxCore, escapeXML(title))},
+		{"word/document.xml", dw.document()},
+		{"word/styles.xml", fmt.Sprintf(docxStyles, color)},
+		{"word/numbering.xml", dw.numbering()},
+		{"word/_rels/document.xml.rels", dw.relationships()},
+	}
+
+	z := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := z.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (dw *docxWriter) document() string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<w:body>` + dw.body.String() + `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr></w:body>
+</w:document>`
+}
+
+func (dw *docxWriter) relationships() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+  <Relationship Id="rIdNumbering" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>
+`)
+	for i, link := range dw.links {
+		fmt.Fprintf(&b, `  <Relationship Id="rIdLink%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="%s" TargetMode="External"/>`+"\n", i+1, escapeXML(link))
+	}
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func (dw *docxWriter) numbering() string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+	for abstract, format := range []string{"bullet", "decimal"} {
+		fmt.Fprintf(&b, `<w:abstractNum w:abstractNumId="%d">`, abstract+1)
+		for lvl := 0; lvl <= docxMaxListLevel; lvl++ {
+			text := "•"
+			if format == "decimal" {
+				text = fmt.Sprintf("%%%d.", lvl+1)
+			}
+			fmt.Fprintf(&b, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`,
+				lvl, format, text, 720*(lvl+1))
+		}
+		b.WriteString(`</w:abstractNum>`)
+	}
+	fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/></w:num>`, docxBulletNumID)
+	for i, start := range dw.orderNums {
+		fmt.Fprintf(&b, `<w:num w:numId="%d"><w:abstractNumId w:val="2"/>`, docxOrderedNumID+i)
+		for lvl := 0; lvl <= docxMaxListLevel; lvl++ {
+			fmt.Fprintf(&b, `<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="%d"/></w:lvlOverride>`, lvl, start)
+		}
+		b.WriteString(`</w:num>`)
+	}
+	b.WriteString(`</w:numbering>`)
+	return b.String()
+}
+
+// paragraph writes a w:p element, with the given style and the properties
+// for the current list item if any.
+func (dw *docxWriter) paragraph(style, extraProps string, content func()) {
+	dw.body.WriteString("<w:p><w:pPr>")
+	if style != "" {
+		fmt.Fprintf(&dw.body, `<w:pStyle w:val="%s"/>`, style)
+	}
+	dw.body.WriteString(extraProps)
+	dw.body.WriteString("</w:pPr>")
+	content()
+	dw.body.WriteString("</w:p>")
+}
+
+func (dw *docxWriter) run(text, props string) {
+	dw.body.WriteString("<w:r>")
+	if props != "" {
+		dw.body.WriteString("<w:rPr>" + props + "</w:rPr>")
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if i > 0 {
+			dw.body.WriteString("<w:br/>")
+		}
+		fmt.Fprintf(&dw.body, `<w:t xml:space="preserve">%s</w:t>`, escapeXML(line))
+	}
+	dw.body.WriteString("</w:r>")
+}
+
+func (dw *docxWriter) blocks(n *model.Node, style string) {
+	for _, child := range children(n) {
+		dw.block(child, style)
+	}
+}
+
+func (dw *docxWriter) block(n *model.Node, style string) {
+	switch nodeName(n) {
+	case "paragraph":
+		dw.paragraph(style, "", func() { dw.inlines(n) })
+	case "heading":
+		level := attrInt(n, "level", 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		dw.paragraph(fmt.Sprintf("Heading%d", level), "", func() { dw.inlines(n) })
+	case "bulletList", "orderedList":
+		dw.list(n)
+	case "blockquote", "panel":
+		dw.blocks(n, "Quote")
+	case "rule":
+		dw.paragraph(style, `<w:pBdr><w:bottom w:val="single" w:sz="6" w:space="1" w:color="D6D8DA"/></w:pBdr>`, func() {})
+	case "codeBlock":
+		dw.paragraph("Code", "", func() { dw.run(n.TextContent(), "") })
+	case "table":
+		dw.table(n)
+	default:
+		if isTextblock(n) {
+			dw.paragraph(style, "", func() { dw.inlines(n) })
+		} else {
+			dw.blocks(n, style)
+		}
+	}
+}
+
+func (dw *docxWriter) list(n *model.Node) {
+	numID := docxBulletNumID
+	if nodeName(n) == "orderedList" {
+		dw.orderNums = append(dw.orderNums, attrInt(n, "order", 1))
+		numID = docxOrderedNumID + len(dw.orderNums) - 1
+	}
+	dw.listLevel++
+	level := dw.listLevel
+	if level > docxMaxListLevel {
+		level = docxMaxListLevel
+	}
+	for _, item := range children(n) {
+		for i, child := range children(item) {
+			if i == 0 && nodeName(child) == "paragraph" {
+				props := fmt.Sprintf(`<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, level, numID)
+				dw.paragraph("ListParagraph", props, func() { dw.inlines(child) })
+			} else if nodeName(child) == "paragraph" {
+				props := fmt.Sprintf(`<w:ind w:left="%d"/>`, 720*(level+1))
+				dw.paragraph("ListParagraph", props, func() { dw.inlines(child) })
+			} else {
+				dw.block(child, "ListParagraph")
+			}
+		}
+	}
+	dw.listLevel--
+}
+
+func (dw *docxWriter) table(n *model.Node) {
+	rows := children(n)
+	cols := 0
+	for _, row := range rows {
+		if c := row.ChildCount(); c > cols {
+			cols = c
+		}
+	}
+	if cols == 0 {
+		return
+	}
+	width := 9026 / cols
+	dw.body.WriteString(`<w:tbl><w:tblPr><w:tblStyle w:val="TableGrid"/><w:tblW w:w="0" w:type="auto"/></w:tblPr><w:tblGrid>`)
+	for i := 0; i < cols; i++ {
+		fmt.Fprintf(&dw.body, `<w:gridCol w:w="%d"/>`, width)
+	}
+	dw.body.WriteString(`</w:tblGrid>`)
+	for _, row := range rows {
+		dw.body.WriteString("<w:tr>")
+		for _, cell := range children(row) {
+			fmt.Fprintf(&dw.body, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>`, width)
+			if span := attrInt(cell, "colspan", 1); span > 1 {
+				fmt.Fprintf(&dw.body, `<w:gridSpan w:val="%d"/>`, span)
+			}
+			if nodeName(cell) == "tableHeader" {
+				dw.body.WriteString(`<w:shd w:val="clear" w:color="auto" w:fill="F5F6F7"/>`)
+			}
+			dw.body.WriteString("</w:tcPr>")
+			if cell.ChildCount() == 0 {
+				dw.paragraph("", "", func() {})
+			}
+			dw.blocks(cell, "")
+			dw.body.WriteString("</w:tc>")
+		}
+		dw.body.WriteString("</w:tr>")
+	}
+	dw.body.WriteString("</w:tbl>")
+	// Word needs a paragraph between two consecutive tables
+	dw.paragraph("", "", func() {})
+}
+
+func (dw *docxWriter) inlines(n *model.Node) {
+	for _, child := range children(n) {
+		switch {
+		case child.IsText():
+			// The order of the properties matters for Word
+			var fonts, bold, italic, strike, underline string
+			link := ""
+			for _, m := range child.Marks {
+				switch normalizedName(m.Type.Name) {
+				case "strong":
+					bold = "<w:b/>"
+				case "em":
+					italic = "<w:i/>"
+				case "strike":
+					strike = "<w:strike/>"
+				case "underline":
+					underline = `<w:u w:val="single"/>`
+				case "code":
+					fonts = `<w:rFonts w:ascii="Courier New" w:hAnsi="Courier New" w:cs="Courier New"/>`
+				case "link":
+					link = safeURL(markAttr(m, "href"), linkSchemes)
+				}
+			}
+			props := fonts + bold + italic + strike + underline
+			if link == "" {
+				dw.run(*child.Text, props)
+				continue
+			}
+			dw.links = append(dw.links, link)
+			fmt.Fprintf(&dw.body, `<w:hyperlink r:id="rIdLink%d">`, len(dw.links))
+			dw.run(*child.Text, `<w:rStyle w:val="Hyperlink"/>`+props)
+			dw.body.WriteString("</w:hyperlink>")
+		case nodeName(child) == "hardBreak":
+			dw.body.WriteString("<w:r><w:br/></w:r>")
+		case nodeName(child) == "image":
+			alt := attrString(child, "alt")
+			if alt == "" {
+				alt = attrString(child, "src")
+			}
+			dw.run("["+alt+"]", "<w:i/>")
+		}
+	}
+}
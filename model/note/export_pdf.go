@@ -0,0 +1,374 @@
+package note
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/assets"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	pdfFontFamily = "DejaVuSans"
+	pdfCodeFamily = "DejaVuSansMono"
+	pdfFontSize   = 11.0
+	pdfLineHeight = 5.5
+	pdfIndent     = 8.0
+)
+
+var pdfHeadingSizes = []float64{22, 18, 15, 13, 12, 11}
+
+// pdfFonts are the TrueType fonts embedded in the PDF documents, by family
+// and style. The core fonts of PDF only have the Latin-1 characters, and the
+// other characters would be lost. Only the glyphs used by the document are
+// embedded. The font files are subsets (Latin, Greek, Cyrillic, punctuation
+// and some symbols) to keep the binary small.
+var pdfFonts = []struct{ family, style, name string }{
+	{pdfFontFamily, "", "/fonts/DejaVuSansCondensed.ttf"},
+	{pdfFontFamily, "B", "/fonts/DejaVuSansCondensed-Bold.ttf"},
+	{pdfFontFamily, "I", "/fonts/DejaVuSansCondensed-Oblique.ttf"},
+	{pdfFontFamily, "BI", "/fonts/DejaVuSansCondensed-BoldOblique.ttf"},
+	{pdfCodeFamily, "", "/fonts/DejaVuSansMono.ttf"},
+	{pdfCodeFamily, "B", "/fonts/DejaVuSansMono-Bold.ttf"},
+}
+
+// openPDFFont returns the content of a font file from the assets.
+var openPDFFont = func(name string) ([]byte, error) {
+	f, err := assets.Open(name, "")
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+// pdfWriter renders the nodes of a note to a PDF document.
+type pdfWriter struct {
+	pdf       *gofpdf.Fpdf
+	theme     *exportTheme
+	margin    float64
+	indent    float64
+	fontSize  float64
+	textColor [3]int
+	images    map[string]*exportImage
+}
+
+// pdfImageTypes are the types of images that can be embedded in a PDF.
+var pdfImageTypes = map[string]string{
+	"image/png":  "PNG",
+	"image/jpeg": "JPG",
+	"image/gif":  "GIF",
+}
+
+func exportPDF(title string, content *model.Node, theme *exportTheme, images map[string]*exportImage, w io.Writer) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("Cozy", true)
+	pdf.SetAutoPageBreak(true, 20)
+	for _, font := range pdfFonts {
+		data, err := openPDFFont(font.name)
+		if err != nil {
+			return err
+		}
+		pdf.AddUTF8FontFromBytes(font.family, font.style, data)
+	}
+	pdf.AddPage()
+	left, _, _, _ := pdf.GetMargins()
+	pw := &pdfWriter{
+		pdf:       pdf,
+		theme:     theme,
+		margin:    left,
+		fontSize:  pdfFontSize,
+		textColor: [3]int{0x32, 0x36, 0x3F},
+		images:    images,
+	}
+
+	r, g, b := theme.rgb()
+	pdf.SetTextColor(r, g, b)
+	pdf.SetFont(pdfFontFamily, "B", pdfHeadingSizes[0])
+	pdf.MultiCell(0, 10, title, "", "L", false)
+	pdf.Ln(4)
+
+	pw.blocks(content)
+	return pdf.Output(w)
+}
+
+func (pw *pdfWriter) setIndent(indent float64) {
+	pw.indent = indent
+	pw.pdf.SetLeftMargin(pw.margin + indent)
+	pw.pdf.SetX(pw.margin + indent)
+}
+
+func (pw *pdfWriter) resetFont() {
+	pw.pdf.SetFont(pdfFontFamily, "", pw.fontSize)
+	pw.pdf.SetTextColor(pw.textColor[0], pw.textColor[1], pw.textColor[2])
+}
+
+func (pw *pdfWriter) blocks(n *model.Node) {
+	for _, child := range children(n) {
+		pw.block(child)
+	}
+}
+
+func (pw *pdfWriter) block(n *model.Node) {
+	pdf := pw.pdf
+	switch nodeName(n) {
+	case "paragraph":
+		pw.inlines(n, pdfLineHeight)
+		pdf.Ln(pdfLineHeight * 1.5)
+	case "heading":
+		level := attrInt(n, "level", 1)
+		if level < 1 || level > len(pdfHeadingSizes) {
+			level = 1
+		}
+		size := pdfHeadingSizes[level-1]
+		pdf.Ln(2)
+		savedSize, savedColor := pw.fontSize, pw.textColor
+		r, g, b := pw.theme.rgb()
+		pw.fontSize, pw.textColor = size, [3]int{r, g, b}
+		pw.inlinesWithStyle(n, size*0.5, "B")
+		pw.fontSize, pw.textColor = savedSize, savedColor
+		pdf.Ln(size * 0.5)
+	case "bulletList", "orderedList":
+		pw.list(n)
+	case "blockquote", "panel":
+		x, y := pdf.GetX(), pdf.GetY()
+		indent := pw.indent
+		pw.setIndent(indent + pdfIndent)
+		pw.blocks(n)
+		pw.setIndent(indent)
+		if pdf.GetY() > y {
+			pdf.SetDrawColor(0xD6, 0xD8, 0xDA)
+			pdf.SetLineWidth(1)
+			pdf.Line(x+1, y, x+1, pdf.GetY()-pdfLineHeight/2)
+			pdf.SetLineWidth(0.2)
+		}
+	case "rule":
+		y := pdf.GetY() + pdfLineHeight/2
+		pageW, _ := pdf.GetPageSize()
+		_, _, right, _ := pdf.GetMargins()
+		pdf.SetDrawColor(0xD6, 0xD8, 0xDA)
+		pdf.Line(pw.margin+pw.indent, y, pageW-right, y)
+		pdf.Ln(pdfLineHeight * 1.5)
+	case "codeBlock":
+		pdf.SetFont(pdfCodeFamily, "", pw.fontSize-1)
+		pdf.SetTextColor(pw.textColor[0], pw.textColor[1], pw.textColor[2])
+		pdf.SetFillColor(0xF5, 0xF6, 0xF7)
+		pdf.MultiCell(0, pdfLineHeight, n.TextContent(), "", "L", true)
+		pdf.Ln(pdfLineHeight)
+		pw.resetFont()
+	case "table":
+		pw.table(n)
+	default:
+		if isTextblock(n) {
+			pw.inlines(n, pdfLineHeight)
+			pdf.Ln(pdfLineHeight * 1.5)
+		} else {
+			pw.blocks(n)
+		}
+	}
+}
+
+func (pw *pdfWriter) list(n *model.Node) {
+	pdf := pw.pdf
+	ordered := nodeName(n) == "orderedList"
+	number := attrInt(n, "order", 1)
+	indent := pw.indent
+	for _, item := range children(n) {
+		pw.resetFont()
+		pdf.SetX(pw.margin + indent)
+		bullet := "•"
+		if ordered {
+			bullet = strconv.Itoa(number) + "."
+			number++
+		}
+		pdf.CellFormat(pdfIndent, pdfLineHeight, bullet, "", 0, "L", false, 0, "")
+		pw.setIndent(indent + pdfIndent)
+		pdf.SetX(pw.margin + indent + pdfIndent)
+		for i, child := range children(item) {
+			if i == 0 && nodeName(child) == "paragraph" {
+				pw.inlines(child, pdfLineHeight)
+				pdf.Ln(pdfLineHeight)
+			} else {
+				pw.block(child)
+			}
+		}
+		pw.setIndent(indent)
+	}
+	if indent == 0 {
+		pdf.Ln(pdfLineHeight / 2)
+	}
+}
+
+func (pw *pdfWriter) table(n *model.Node) {
+	pdf := pw.pdf
+	rows := children(n)
+	cols := 0
+	for _, row := range rows {
+		if c := row.ChildCount(); c > cols {
+			cols = c
+		}
+	}
+	if cols == 0 {
+		return
+	}
+	pageW, _ := pdf.GetPageSize()
+	_, _, right, _ := pdf.GetMargins()
+	width := (pageW - right - pw.margin - pw.indent) / float64(cols)
+	pdf.SetDrawColor(0xD6, 0xD8, 0xDA)
+	pdf.SetFillColor(0xF5, 0xF6, 0xF7)
+	for _, row := range rows {
+		cells := children(row)
+		height := pdfLineHeight
+		texts := make([]string, len(cells))
+		for i, cell := range cells {
+			texts[i] = strings.TrimSpace(cellText(cell))
+			pw.cellFont(cell)
+			lines := pdf.SplitLines([]byte(texts[i]), width-2)
+			if h := float64(len(lines)) * pdfLineHeight; h > height {
+				height = h
+			}
+		}
+		_, pageH := pdf.GetPageSize()
+		if _, _, _, bottom := pdf.GetMargins(); pdf.GetY()+height > pageH-bottom {
+			pdf.AddPage()
+		}
+		y := pdf.GetY()
+		for i, cell := range cells {
+			x := pw.margin + pw.indent + float64(i)*width
+			header := nodeName(cell) == "tableHeader"
+			style := "D"
+			if header {
+				style = "FD"
+			}
+			pdf.Rect(x, y, width, height, style)
+			pdf.SetXY(x, y)
+			pw.cellFont(cell)
+			pdf.MultiCell(width, pdfLineHeight, texts[i], "", "L", false)
+		}
+		pdf.SetXY(pw.margin+pw.indent, y+height)
+	}
+	pw.resetFont()
+	pdf.Ln(pdfLineHeight)
+}
+
+func (pw *pdfWriter) cellFont(cell *model.Node) {
+	style := ""
+	if nodeName(cell) == "tableHeader" {
+		style = "B"
+	}
+	pw.pdf.SetFont(pdfFontFamily, style, pw.fontSize)
+	pw.pdf.SetTextColor(pw.textColor[0], pw.textColor[1], pw.textColor[2])
+}
+
+// cellText returns the text of a table cell, with a line break between its
+// blocks.
+func cellText(cell *model.Node) string {
+	var parts []string
+	for _, child := range children(cell) {
+		parts = append(parts, child.TextContent())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (pw *pdfWriter) inlines(n *model.Node, lineHeight float64) {
+	pw.inlinesWithStyle(n, lineHeight, "")
+}
+
+// inlinesWithStyle writes the inline nodes of a textblock, with the marks
+// translated to font styles.
+func (pw *pdfWriter) inlinesWithStyle(n *model.Node, lineHeight float64, base string) {
+	pdf := pw.pdf
+	for _, child := range children(n) {
+		switch {
+		case child.IsText():
+			style := base
+			family := pdfFontFamily
+			link := ""
+			for _, m := range child.Marks {
+				switch normalizedName(m.Type.Name) {
+				case "strong":
+					if !strings.Contains(style, "B") {
+						style += "B"
+					}
+				case "em":
+					style += "I"
+				case "strike":
+					style += "S"
+				case "underline":
+					style += "U"
+				case "code":
+					family = pdfCodeFamily
+				case "link":
+					link = safeURL(markAttr(m, "href"), linkSchemes)
+				}
+			}
+			if link != "" && !strings.Contains(style, "U") {
+				style += "U"
+			}
+			// There is no oblique style for the monospaced font
+			if family == pdfCodeFamily {
+				style = strings.Replace(style, "I", "", -1)
+			}
+			pdf.SetFont(family, style, pw.fontSize)
+			if link != "" {
+				r, g, b := pw.theme.rgb()
+				pdf.SetTextColor(r, g, b)
+				pdf.WriteLinkString(lineHeight, *child.Text, link)
+			} else {
+				pdf.SetTextColor(pw.textColor[0], pw.textColor[1], pw.textColor[2])
+				pdf.Write(lineHeight, *child.Text)
+			}
+		case nodeName(child) == "hardBreak":
+			pdf.Ln(lineHeight)
+		case nodeName(child) == "image":
+			if pw.image(child, lineHeight) {
+				continue
+			}
+			alt := attrString(child, "alt")
+			if alt == "" {
+				alt = attrString(child, "src")
+			}
+			pdf.SetFont(pdfFontFamily, "I", pw.fontSize)
+			pdf.Write(lineHeight, "["+alt+"]")
+		}
+	}
+	pw.resetFont()
+}
+
+// image embeds an image stored in the cozy in the PDF, and returns false if
+// it is not possible.
+func (pw *pdfWriter) image(n *model.Node, lineHeight float64) bool {
+	pdf := pw.pdf
+	name := ImageNameFromSrc(attrString(n, "src"))
+	img, ok := pw.images[name]
+	if !ok {
+		return false
+	}
+	typ, ok := pdfImageTypes[img.Mime]
+	if !ok {
+		return false
+	}
+	opts := gofpdf.ImageOptions{ImageType: typ}
+	info := pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(img.Data))
+	if pdf.Err() {
+		pdf.ClearError()
+		return false
+	}
+	pageW, _ := pdf.GetPageSize()
+	_, _, right, _ := pdf.GetMargins()
+	left := pw.margin + pw.indent
+	width, height := info.Width(), info.Height()
+	if max := pageW - right - left; width > max {
+		height = height * max / width
+		width = max
+	}
+	if pdf.GetX() > left {
+		pdf.Ln(lineHeight)
+	}
+	pdf.ImageOptions(name, left, -1, width, height, true, opts, 0, "")
+	return true
+}
@@ -0,0 +1,157 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// The assets are not bundled in the tests
+	openPDFFont = func(name string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join("../../assets", name))
+	}
+}
+
+func exportContent(t *testing.T) *model.Node {
+	schema := newDefaultSchema(t)
+	md := `# Title
+
+Some *emphasis*, **strong** and ~~strike~~ text with [a link](https://cozy.io/?a=1&b=2).
+
+- first
+- second
+  1. nested
+
+> A quote
+
+` + "```\nfmt.Println(\"<hello>\")\n```" + `
+
+| Name | Value |
+|------|-------|
+| foo  | 42    |
+
+Æ € ✓
+`
+	doc, err := parseMarkdown(schema, []byte(md))
+	require.NoError(t, err)
+	return doc
+}
+
+func TestExportHTML(t *testing.T) {
+	content := exportContent(t)
+	theme := &exportTheme{CSS: ":root { --primaryColor: #FF0000; }", PrimaryColor: "#FF0000"}
+	inst := &instance.Instance{Locale: "fr"}
+	var buf bytes.Buffer
+	require.NoError(t, exportHTML(inst, "My <note>", content, theme, nil, &buf))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "<!DOCTYPE html>"))
+	assert.Contains(t, out, `<html lang="fr">`)
+	assert.Contains(t, out, "<title>My &lt;note&gt;</title>")
+	assert.Contains(t, out, "--primaryColor: #FF0000;")
+	assert.Contains(t, out, "<h1>Title</h1>")
+	assert.Contains(t, out, "<em>emphasis</em>")
+	assert.Contains(t, out, "<strong>strong</strong>")
+	assert.Contains(t, out, "<s>strike</s>")
+	assert.Contains(t, out, `<a href="https://cozy.io/?a=1&amp;b=2">a link</a>`)
+	assert.Contains(t, out, "<ul>\n<li><p>first</p>\n</li>")
+	assert.Contains(t, out, "<ol>\n<li><p>nested</p>\n</li>")
+	assert.Contains(t, out, "<blockquote>\n<p>A quote</p>\n</blockquote>")
+	assert.Contains(t, out, "<pre><code>fmt.Println(&#34;&lt;hello&gt;&#34;)</code></pre>")
+	assert.Contains(t, out, "<th><p>Name</p>\n</th>")
+	assert.Contains(t, out, "<td><p>42</p>\n</td>")
+}
+
+func TestExportPDF(t *testing.T) {
+	content := exportContent(t)
+	theme := &exportTheme{PrimaryColor: defaultPrimaryColor}
+	var buf bytes.Buffer
+	require.NoError(t, exportPDF("My note", content, theme, nil, &buf))
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+	assert.Contains(t, buf.String(), "https://cozy.io/?a=1&b=2")
+	// The fonts are embedded for the characters outside of Latin-1
+	assert.Contains(t, buf.String(), "/FontFile2")
+	assert.Contains(t, buf.String(), "/BaseFont /utf8dejavusans")
+}
+
+func TestExportDOCX(t *testing.T) {
+	content := exportContent(t)
+	theme := &exportTheme{PrimaryColor: "#FF0000"}
+	var buf bytes.Buffer
+	require.NoError(t, exportDOCX("My note", content, theme, &buf))
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range z.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		files[f.Name] = string(data)
+	}
+	require.Contains(t, files, "[Content_Types].xml")
+	require.Contains(t, files, "word/document.xml")
+	doc := files["word/document.xml"]
+	assert.Contains(t, doc, `<w:pStyle w:val="Title"/></w:pPr><w:r><w:t xml:space="preserve">My note</w:t></w:r>`)
+	assert.Contains(t, doc, `<w:pStyle w:val="Heading1"/>`)
+	assert.Contains(t, doc, `<w:rPr><w:i/></w:rPr><w:t xml:space="preserve">emphasis</w:t>`)
+	assert.Contains(t, doc, `<w:hyperlink r:id="rIdLink1">`)
+	assert.Contains(t, doc, `<w:numId w:val="2"/>`)
+	assert.Contains(t, doc, `fmt.Println(&#34;&lt;hello&gt;&#34;)`)
+	assert.Contains(t, doc, "<w:tbl>")
+	assert.Contains(t, doc, "Æ € ✓")
+	assert.Contains(t, files["word/styles.xml"], `<w:color w:val="FF0000"/>`)
+	assert.Contains(t, files["word/_rels/document.xml.rels"], `Target="https://cozy.io/?a=1&amp;b=2"`)
+}
+
+func TestExportUnsafeURLs(t *testing.T) {
+	schema := newDefaultSchema(t)
+	md := `A [bad link](javascript:alert(1)), a [mail](mailto:me@cozy.io), and ![an image](javascript:alert(2)).
+`
+	content, err := parseMarkdown(schema, []byte(md))
+	require.NoError(t, err)
+	theme := &exportTheme{PrimaryColor: defaultPrimaryColor}
+	inst := &instance.Instance{Locale: "en"}
+
+	var buf bytes.Buffer
+	require.NoError(t, exportHTML(inst, "Unsafe", content, theme, nil, &buf))
+	out := buf.String()
+	assert.NotContains(t, out, "javascript:")
+	assert.Contains(t, out, "A bad link, ")
+	assert.Contains(t, out, `<a href="mailto:me@cozy.io">mail</a>`)
+	assert.Contains(t, out, "and an image.")
+
+	buf.Reset()
+	require.NoError(t, exportPDF("Unsafe", content, theme, nil, &buf))
+	assert.NotContains(t, buf.String(), "javascript:")
+
+	buf.Reset()
+	require.NoError(t, exportDOCX("Unsafe", content, theme, &buf))
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	for _, f := range z.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "javascript:", f.Name)
+	}
+}
+
+func TestPrimaryColor(t *testing.T) {
+	theme := &exportTheme{PrimaryColor: "#297EF2"}
+	r, g, b := theme.rgb()
+	assert.Equal(t, []int{0x29, 0x7E, 0xF2}, []int{r, g, b})
+	matches := primaryColorRegexp.FindStringSubmatch(":root {\n  --primaryColor: #1a2B3c;\n}")
+	require.Len(t, matches, 2)
+	assert.Equal(t, "#1a2B3c", matches[1])
+}
@@ -0,0 +1,558 @@
+package note
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	// AutoSnapshot is the kind of the snapshots taken by the stack when the
+	// note is persisted to the VFS, ie when the users have stopped editing it
+	// for a while.
+	AutoSnapshot = "auto"
+	// NamedSnapshot is the kind of the snapshots created explicitly by a user,
+	// with a name.
+	NamedSnapshot = "named"
+	// CurrentVersion can be used in place of a snapshot identifier for a diff
+	// to compare with the last version of the note.
+	CurrentVersion = "current"
+
+	// maxAutoSnapshots is the number of automatic snapshots kept for a note.
+	// The named snapshots are never purged.
+	maxAutoSnapshots = 50
+
+	// snapshotsPerPage is the number of snapshots fetched by request to
+	// CouchDB.
+	snapshotsPerPage = 1000
+)
+
+// Snapshot is the state of a note at a given version, that can be used to
+// restore it later.
+type Snapshot struct {
+	DocID     string                 `json:"_id"`
+	DocRev    string                 `json:"_rev,omitempty"`
+	NoteID    string                 `json:"note_id"`
+	Kind      string                 `json:"kind"`
+	Name      string                 `json:"name,omitempty"`
+	Title     string                 `json:"title"`
+	Version   int64                  `json:"version"`
+	Content   map[string]interface{} `json:"content,omitempty"`
+	Images    []string               `json:"images,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// snapshotFields are the fields of the snapshots fetched for listing them:
+// everything except the content, that can be large.
+var snapshotFields = []string{
+	"_id", "_rev", "note_id", "kind", "name", "title", "version", "images", "created_at",
+}
+
+// ID returns the snapshot qualified identifier
+func (s *Snapshot) ID() string { return s.DocID }
+
+// Rev returns the snapshot revision
+func (s *Snapshot) Rev() string { return s.DocRev }
+
+// DocType returns the snapshot document type
+func (s *Snapshot) DocType() string { return consts.NotesSnapshots }
+
+// Clone implements couchdb.Doc
+func (s *Snapshot) Clone() couchdb.Doc {
+	cloned := *s
+	// XXX The content is never modified, it is not cloned.
+	cloned.Images = make([]string, len(s.Images))
+	copy(cloned.Images, s.Images)
+	return &cloned
+}
+
+// SetID changes the snapshot qualified identifier
+func (s *Snapshot) SetID(id string) { s.DocID = id }
+
+// SetRev changes the snapshot revision
+func (s *Snapshot) SetRev(rev string) { s.DocRev = rev }
+
+// Included is part of the jsonapi.Object interface
+func (s *Snapshot) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (s *Snapshot) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (s *Snapshot) Relationships() jsonapi.RelationshipMap { return nil }
+
+// snapshotID returns an identifier for a snapshot that starts with the note
+// identifier and the version, to list the snapshots of a note in order with
+// an _all_docs request. A random suffix allows several snapshots of the same
+// version.
+func snapshotID(noteID string, version int64) string {
+	return fmt.Sprintf("%s-%08d-%s", noteID, version, crypto.GenerateRandomString(8))
+}
+
+func snapshotsRange(noteID string) (string, string) {
+	return noteID + "-", noteID + "-" + couchdb.MaxString
+}
+
+// DiffChange is a group of lines in the Markdown of a note that has been
+// kept, inserted, deleted or replaced between two versions.
+type DiffChange struct {
+	Op  string   `json:"op"`
+	Old []string `json:"old,omitempty"`
+	New []string `json:"new,omitempty"`
+}
+
+// Diff is the list of changes between two versions of a note.
+type Diff struct {
+	DocID       string       `json:"_id"`
+	From        string       `json:"from"`
+	FromVersion int64        `json:"from_version"`
+	To          string       `json:"to"`
+	ToVersion   int64        `json:"to_version"`
+	Changes     []DiffChange `json:"changes"`
+}
+
+// ID returns the diff qualified identifier
+func (d *Diff) ID() string { return d.DocID }
+
+// Rev returns the diff revision
+func (d *Diff) Rev() string { return "" }
+
+// DocType returns the diff document type
+func (d *Diff) DocType() string { return consts.NotesDiffs }
+
+// Clone implements couchdb.Doc
+func (d *Diff) Clone() couchdb.Doc {
+	cloned := *d
+	cloned.Changes = make([]DiffChange, len(d.Changes))
+	copy(cloned.Changes, d.Changes)
+	return &cloned
+}
+
+// SetID changes the diff qualified identifier
+func (d *Diff) SetID(id string) { d.DocID = id }
+
+// SetRev changes the diff revision
+func (d *Diff) SetRev(rev string) {}
+
+// Included is part of the jsonapi.Object interface
+func (d *Diff) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (d *Diff) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (d *Diff) Relationships() jsonapi.RelationshipMap { return nil }
+
+// ListSnapshots returns the snapshots of a note, from the most recent to the
+// oldest. The content of the snapshots is not included.
+func ListSnapshots(inst *instance.Instance, file *vfs.FileDoc) ([]*Snapshot, error) {
+	if !IsNote(file) {
+		return nil, ErrInvalidFile
+	}
+	snapshots, err := allSnapshots(inst, file.ID())
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Snapshot, len(snapshots))
+	for i, s := range snapshots {
+		list[len(snapshots)-1-i] = s
+	}
+	return list, nil
+}
+
+// allSnapshots returns the snapshots of a note, without their content, from
+// the oldest to the most recent. It pages through the results with a mango
+// bookmark.
+func allSnapshots(db prefixer.Prefixer, noteID string) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	start, end := snapshotsRange(noteID)
+	bookmark := ""
+	for {
+		var page []*Snapshot
+		req := &couchdb.FindRequest{
+			Selector: mango.And(mango.Gt("_id", start), mango.Lt("_id", end)),
+			Sort:     mango.SortBy{{Field: "_id", Direction: mango.Asc}},
+			Fields:   snapshotFields,
+			Limit:    snapshotsPerPage,
+			Bookmark: bookmark,
+		}
+		res, err := couchdb.FindDocsRaw(db, consts.NotesSnapshots, req, &page)
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		snapshots = append(snapshots, page...)
+		if len(page) < snapshotsPerPage || res.Bookmark == "" {
+			return snapshots, nil
+		}
+		bookmark = res.Bookmark
+	}
+}
+
+// GetSnapshot returns the snapshot of the note with the given identifier.
+func GetSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshotID string) (*Snapshot, error) {
+	if !IsNote(file) {
+		return nil, ErrInvalidFile
+	}
+	snapshot := &Snapshot{}
+	if err := couchdb.GetDoc(inst, consts.NotesSnapshots, snapshotID, snapshot); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, err
+	}
+	if snapshot.NoteID != file.ID() {
+		return nil, ErrSnapshotNotFound
+	}
+	return snapshot, nil
+}
+
+// CreateSnapshot takes a named snapshot of the last version of the note.
+func CreateSnapshot(inst *instance.Instance, file *vfs.FileDoc, name string) (*Snapshot, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrMissingSnapshotName
+	}
+	doc, _, err := lastVersion(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newSnapshot(doc, NamedSnapshot)
+	snapshot.Name = name
+	if err := saveSnapshot(inst, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func newSnapshot(doc *Document, kind string) *Snapshot {
+	return &Snapshot{
+		DocID:     snapshotID(doc.ID(), doc.Version),
+		NoteID:    doc.ID(),
+		Kind:      kind,
+		Title:     doc.Title,
+		Version:   doc.Version,
+		Content:   doc.RawContent,
+		Images:    imagesOf(doc.RawContent),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// imagesOf returns the names of the images used in the content of a note.
+func imagesOf(content map[string]interface{}) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var walk func(raw map[string]interface{})
+	walk = func(raw map[string]interface{}) {
+		if raw["type"] == "image" {
+			if attrs, ok := raw["attrs"].(map[string]interface{}); ok {
+				src, _ := attrs["src"].(string)
+				if name := ImageNameFromSrc(src); name != "" && !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		children, _ := raw["content"].([]interface{})
+		for _, child := range children {
+			if c, ok := child.(map[string]interface{}); ok {
+				walk(c)
+			}
+		}
+	}
+	walk(content)
+	return names
+}
+
+func saveSnapshot(inst *instance.Instance, snapshot *Snapshot) error {
+	err := couchdb.CreateNamedDoc(inst, snapshot)
+	if couchdb.IsNoDatabaseError(err) {
+		if err = couchdb.EnsureDBExist(inst, consts.NotesSnapshots); err != nil {
+			return err
+		}
+		err = couchdb.CreateNamedDoc(inst, snapshot)
+	}
+	return err
+}
+
+// autoSnapshot is called when the note is persisted to the VFS. It takes a
+// snapshot of the note if its version has changed since the last snapshot,
+// and purges the oldest automatic snapshots.
+func autoSnapshot(inst *instance.Instance, doc *Document) {
+	snapshots, err := allSnapshots(inst, doc.ID())
+	if err != nil {
+		inst.Logger().WithField("nspace", "notes").
+			Warnf("Cannot list the snapshots for note %s: %s", doc.ID(), err)
+		return
+	}
+	if n := len(snapshots); n > 0 && snapshots[n-1].Version == doc.Version {
+		return
+	}
+
+	snapshot := newSnapshot(doc, AutoSnapshot)
+	if err := saveSnapshot(inst, snapshot); err != nil {
+		inst.Logger().WithField("nspace", "notes").
+			Warnf("Cannot save a snapshot for note %s: %s", doc.ID(), err)
+		return
+	}
+	snapshots = append(snapshots, snapshot)
+
+	var autos []couchdb.Doc
+	for _, s := range snapshots {
+		if s.Kind == AutoSnapshot {
+			autos = append(autos, s)
+		}
+	}
+	if len(autos) <= maxAutoSnapshots {
+		return
+	}
+	olds := autos[:len(autos)-maxAutoSnapshots]
+	if err := couchdb.BulkDeleteDocs(inst, consts.NotesSnapshots, olds); err != nil {
+		inst.Logger().WithField("nspace", "notes").
+			Warnf("Cannot purge old snapshots for note %s: %s", doc.ID(), err)
+	}
+}
+
+// snapshotsImages returns the names of the images used in the snapshots of a
+// note, as they must be kept for a restore.
+func snapshotsImages(inst *instance.Instance, noteID string) map[string]bool {
+	names := make(map[string]bool)
+	snapshots, err := allSnapshots(inst, noteID)
+	if err != nil {
+		return names
+	}
+	for _, s := range snapshots {
+		for _, name := range s.Images {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// deleteSnapshots removes all the snapshots of a note.
+func deleteSnapshots(db prefixer.Prefixer, noteID string) error {
+	snapshots, err := allSnapshots(db, noteID)
+	if err != nil || len(snapshots) == 0 {
+		return err
+	}
+	docs := make([]couchdb.Doc, len(snapshots))
+	for i, s := range snapshots {
+		docs[i] = s
+	}
+	return couchdb.BulkDeleteDocs(db, consts.NotesSnapshots, docs)
+}
+
+func init() {
+	// The snapshots and the comments of a note are removed when its file is
+	// deleted
+	couchdb.AddHook(consts.Files, couchdb.EventDelete,
+		func(db prefixer.Prefixer, doc couchdb.Doc, old couchdb.Doc) error {
+			var mime string
+			switch v := doc.(type) {
+			case *vfs.FileDoc:
+				mime = v.Mime
+			case *couchdb.JSONDoc:
+				mime, _ = v.M["mime"].(string)
+			}
+			if mime != noteMime {
+				return nil
+			}
+			if err := deleteSnapshots(db, doc.ID()); err != nil {
+				logger.WithDomain(db.DomainName()).WithField("nspace", "notes").
+					Warnf("Cannot delete the snapshots of note %s: %s", doc.ID(), err)
+			}
+			if err := deleteComments(db, doc.ID()); err != nil {
+				logger.WithDomain(db.DomainName()).WithField("nspace", "notes").
+					Warnf("Cannot delete the comments of note %s: %s", doc.ID(), err)
+			}
+			return nil
+		})
+}
+
+// DiffSnapshots compares two versions of a note. The from and to parameters
+// are the identifiers of snapshots, or CurrentVersion for the last version
+// of the note. The comparison is made on the Markdown, line by line.
+func DiffSnapshots(inst *instance.Instance, file *vfs.FileDoc, from, to string) (*Diff, error) {
+	doc, _, err := lastVersion(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := doc.Schema()
+	if err != nil {
+		return nil, err
+	}
+	markdownOf := func(id string) ([]byte, int64, error) {
+		if id == CurrentVersion {
+			md, err := doc.Markdown()
+			return md, doc.Version, err
+		}
+		snapshot, err := GetSnapshot(inst, file, id)
+		if err != nil {
+			return nil, 0, err
+		}
+		content, err := model.NodeFromJSON(schema, snapshot.Content)
+		if err != nil {
+			return nil, 0, ErrInvalidContent
+		}
+		md := markdownSerializer().Serialize(content)
+		return []byte(md), snapshot.Version, nil
+	}
+
+	before, fromVersion, err := markdownOf(from)
+	if err != nil {
+		return nil, err
+	}
+	after, toVersion, err := markdownOf(to)
+	if err != nil {
+		return nil, err
+	}
+	return &Diff{
+		DocID:       file.ID(),
+		From:        from,
+		FromVersion: fromVersion,
+		To:          to,
+		ToVersion:   toVersion,
+		Changes:     diffLines(string(before), string(after)),
+	}, nil
+}
+
+// diffLines returns the changes between two texts, line by line.
+func diffLines(before, after string) []DiffChange {
+	a := splitLines(before)
+	b := splitLines(after)
+	changes := []DiffChange{}
+	for _, op := range difflib.NewMatcher(a, b).GetOpCodes() {
+		change := DiffChange{}
+		switch op.Tag {
+		case 'e':
+			change.Op = "equal"
+			change.Old = a[op.I1:op.I2]
+		case 'i':
+			change.Op = "insert"
+			change.New = b[op.J1:op.J2]
+		case 'd':
+			change.Op = "delete"
+			change.Old = a[op.I1:op.I2]
+		case 'r':
+			change.Op = "replace"
+			change.Old = a[op.I1:op.I2]
+			change.New = b[op.J1:op.J2]
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// RestoreSnapshot replaces the content of the note with the content of the
+// snapshot. It is made with a new step, so that the editors currently opened
+// on the note receive the change in realtime, and the restore can be undone
+// like any other change.
+func RestoreSnapshot(inst *instance.Instance, file *vfs.FileDoc, snapshotID, sessionID string) (*vfs.FileDoc, error) {
+	snapshot, err := GetSnapshot(inst, file, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc, err := get(inst, file)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := doc.Schema()
+	if err != nil {
+		return nil, err
+	}
+	current, err := doc.Content()
+	if err != nil {
+		return nil, err
+	}
+	restored, err := model.NodeFromJSON(schema, snapshot.Content)
+	if err != nil {
+		return nil, ErrInvalidContent
+	}
+
+	if !current.Eq(restored) {
+		slice := model.NewSlice(restored.Content, 0, 0)
+		replace := transform.NewReplaceStep(0, current.Content.Size, slice)
+		steps, err := stepsFromTransform(replace)
+		if err != nil {
+			return nil, err
+		}
+		if sessionID != "" {
+			steps[0]["sessionID"] = sessionID
+		}
+		if err := apply(inst, doc, steps); err != nil {
+			return nil, err
+		}
+		if err := saveSteps(inst, steps); err != nil {
+			return nil, err
+		}
+		publishSteps(inst, file.ID(), steps)
+		mapComments(inst, doc, steps)
+	}
+
+	if doc.Title != snapshot.Title {
+		doc.Title = snapshot.Title
+		publishUpdatedTitle(inst, file.ID(), doc.Title, sessionID)
+	}
+	if err := saveToCache(inst, doc); err != nil {
+		return nil, err
+	}
+	return doc.asFile(inst, file), nil
+}
+
+// stepsFromTransform converts prosemirror steps to the Step type, with the
+// same JSON serialization as the steps sent by the editors.
+func stepsFromTransform(steps ...transform.Step) ([]Step, error) {
+	result := make([]Step, len(steps))
+	for i, s := range steps {
+		buf, err := json.Marshal(s.ToJSON())
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(buf, &result[i]); err != nil {
+			return nil, err
+		}
+		// XXX prosemirror-go uses the type key instead of stepType in ToJSON,
+		// but the editors and StepFromJSON expect stepType.
+		if t, ok := result[i]["type"]; ok {
+			result[i]["stepType"] = t
+			delete(result[i], "type")
+		}
+	}
+	return result, nil
+}
+
+var (
+	_ jsonapi.Object = &Snapshot{}
+	_ jsonapi.Object = &Diff{}
+)
@@ -0,0 +1,70 @@
+package note
+
+import (
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/cozy/prosemirror-go/transform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffLines(t *testing.T) {
+	before := "# Title\n\nFirst paragraph\n\nSecond paragraph\n"
+	after := "# Title\n\nFirst paragraph, edited\n\nSecond paragraph\n\nThird paragraph\n"
+	changes := diffLines(before, after)
+	assert.Equal(t, []DiffChange{
+		{Op: "equal", Old: []string{"# Title", ""}},
+		{Op: "replace", Old: []string{"First paragraph"}, New: []string{"First paragraph, edited"}},
+		{Op: "equal", Old: []string{"", "Second paragraph"}},
+		{Op: "insert", New: []string{"", "Third paragraph"}},
+	}, changes)
+
+	assert.Equal(t, []DiffChange{}, diffLines("", ""))
+	assert.Equal(t, []DiffChange{
+		{Op: "delete", Old: []string{"Gone"}},
+	}, diffLines("Gone\n", ""))
+}
+
+func TestRestoreStep(t *testing.T) {
+	schema := newDefaultSchema(t)
+	current, err := parseMarkdown(schema, []byte("# Current\n\n- one\n- two\n"))
+	require.NoError(t, err)
+	restored, err := parseMarkdown(schema, []byte("Old *content*\n"))
+	require.NoError(t, err)
+
+	slice := model.NewSlice(restored.Content, 0, 0)
+	replace := transform.NewReplaceStep(0, current.Content.Size, slice)
+	steps, err := stepsFromTransform(replace)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "replace", steps[0]["stepType"])
+
+	step, err := transform.StepFromJSON(schema, steps[0])
+	require.NoError(t, err)
+	result := step.Apply(current)
+	require.Empty(t, result.Failed)
+	assert.True(t, result.Doc.Eq(restored))
+}
+
+func TestImagesOf(t *testing.T) {
+	image := func(src string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":  "image",
+			"attrs": map[string]interface{}{"src": src},
+		}
+	}
+	content := map[string]interface{}{
+		"type": "doc",
+		"content": []interface{}{
+			map[string]interface{}{
+				"type":    "paragraph",
+				"content": []interface{}{image("images/one.png"), image("https://example.com/two.png")},
+			},
+			image("images/three.jpg"),
+			image("images/one.png"),
+		},
+	}
+	assert.Equal(t, []string{"one.png", "three.jpg"}, imagesOf(content))
+	assert.Nil(t, imagesOf(map[string]interface{}{"type": "doc"}))
+}
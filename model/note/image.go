@@ -0,0 +1,317 @@
+package note
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	// Packages for decoding the size of the images
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// imagesDirPath is the path of the hidden directory where the images embedded
+// in the notes are stored, with a sub-directory for each note.
+const imagesDirPath = "/.cozy_notes_images"
+
+// ImageSrcPrefix is the prefix of the src attribute of the image nodes for
+// the images stored in the cozy. It is a path relative to the note, so that
+// the Markdown export can put the images in an images/ directory next to it.
+const ImageSrcPrefix = "images/"
+
+// maxImageSize is the maximal size for an image uploaded in a note.
+const maxImageSize = 10 * 1024 * 1024
+
+// orphanImageDelay is the delay before an image that is not used in the
+// content of a note is removed. It gives the time to the client to add the
+// image node after the upload.
+const orphanImageDelay = 1 * time.Hour
+
+// Image is an image embedded in a note.
+type Image struct {
+	DocID  string `json:"_id"`
+	Name   string `json:"name"`
+	Mime   string `json:"mime"`
+	Size   int64  `json:"size"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Src    string `json:"src"`
+}
+
+// ID returns the image qualified identifier
+func (img *Image) ID() string { return img.DocID }
+
+// Rev returns the image revision
+func (img *Image) Rev() string { return "" }
+
+// DocType returns the image document type
+func (img *Image) DocType() string { return consts.NotesImages }
+
+// Clone implements couchdb.Doc
+func (img *Image) Clone() couchdb.Doc {
+	cloned := *img
+	return &cloned
+}
+
+// SetID changes the image qualified identifier
+func (img *Image) SetID(id string) { img.DocID = id }
+
+// SetRev changes the image revision
+func (img *Image) SetRev(rev string) {}
+
+// Included is part of the jsonapi.Object interface
+func (img *Image) Included() []jsonapi.Object { return nil }
+
+// Links is part of the jsonapi.Object interface
+func (img *Image) Links() *jsonapi.LinksList { return nil }
+
+// Relationships is part of the jsonapi.Object interface
+func (img *Image) Relationships() jsonapi.RelationshipMap { return nil }
+
+func imagesDir(noteID string) string {
+	return path.Join(imagesDirPath, noteID)
+}
+
+// UploadImage stores an image for the given note in the VFS. The returned
+// image has a src that can be used for an image node in the note.
+func UploadImage(inst *instance.Instance, file *vfs.FileDoc, filename, contentType string, r io.Reader) (*Image, error) {
+	if !IsNote(file) {
+		return nil, ErrInvalidFile
+	}
+	mimeType, _ := vfs.ExtractMimeAndClass(contentType)
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType, _ = vfs.ExtractMimeAndClassFromFilename(filename)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, ErrInvalidImage
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxImageSize {
+		return nil, vfs.ErrFileTooBig
+	}
+	if len(content) == 0 {
+		return nil, ErrInvalidImage
+	}
+
+	img := &Image{Mime: mimeType}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		img.Width = cfg.Width
+		img.Height = cfg.Height
+	} else if mimeType != "image/svg+xml" && mimeType != "image/webp" {
+		return nil, ErrInvalidImage
+	}
+
+	img.Name = imageName(filename, mimeType)
+	doc, err := SaveImage(inst, file.ID(), img.Name, mimeType, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	img.DocID = doc.ID()
+	img.Mime = doc.Mime
+	img.Size = doc.ByteSize
+	img.Src = ImageSrcPrefix + img.Name
+	return img, nil
+}
+
+// imageName returns a random name for an image, with the extension of the
+// original filename.
+func imageName(filename, mimeType string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if ext == "" {
+		if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return crypto.GenerateRandomString(16) + ext
+}
+
+// SaveImage writes an image with the given name in the images directory of
+// the note. The mime type is checked against the content, as the image can
+// come from another instance for a shared note.
+func SaveImage(inst *instance.Instance, noteID, name, mimeType string, r io.Reader) (*vfs.FileDoc, error) {
+	if !validImageName(name) {
+		return nil, ErrInvalidImage
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxImageSize {
+		return nil, vfs.ErrFileTooBig
+	}
+	mimeType, err = checkImageMime(mimeType, content)
+	if err != nil {
+		return nil, err
+	}
+	r = bytes.NewReader(content)
+
+	fs := inst.VFS()
+	dir, err := vfs.MkdirAll(fs, imagesDir(noteID))
+	if err != nil {
+		return nil, err
+	}
+	_, class := vfs.ExtractMimeAndClass(mimeType)
+	now := time.Now()
+	doc, err := vfs.NewFileDoc(name, dir.ID(), -1, nil, mimeType, class, now, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+	doc.CozyMetadata.CreatedByApp = consts.NotesSlug
+	f, err := fs.CreateFile(doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// GetImage returns the file for the image with the given name in the note.
+func GetImage(inst *instance.Instance, noteID, name string) (*vfs.FileDoc, error) {
+	if !validImageName(name) {
+		return nil, ErrInvalidImage
+	}
+	return inst.VFS().FileByPath(path.Join(imagesDir(noteID), name))
+}
+
+// rasterMimes are the mime types of the images that can be served inline
+// without risk: they can't embed scripts.
+var rasterMimes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/bmp":                true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// IsRasterImage returns true if the mime type is the one of a raster image.
+func IsRasterImage(mimeType string) bool {
+	return rasterMimes[mimeType]
+}
+
+// checkImageMime returns the mime type for the content of an image. For a
+// raster image, it is the sniffed type. A SVG image is accepted only if it was
+// declared as such, and doesn't look like HTML.
+func checkImageMime(mimeType string, content []byte) (string, error) {
+	if len(content) == 0 {
+		return "", ErrInvalidImage
+	}
+	sniffed, _ := vfs.ExtractMimeAndClass(http.DetectContentType(content))
+	if IsRasterImage(sniffed) {
+		return sniffed, nil
+	}
+	mimeType, _ = vfs.ExtractMimeAndClass(mimeType)
+	if mimeType == "image/svg+xml" && sniffed != "text/html" {
+		return mimeType, nil
+	}
+	return "", ErrInvalidImage
+}
+
+func validImageName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\")
+}
+
+// ImageNameFromSrc returns the name of the image for a src attribute of an
+// image node, or an empty string if the image is not stored in the cozy.
+func ImageNameFromSrc(src string) string {
+	if !strings.HasPrefix(src, ImageSrcPrefix) {
+		return ""
+	}
+	name := strings.TrimPrefix(src, ImageSrcPrefix)
+	if !validImageName(name) {
+		return ""
+	}
+	return name
+}
+
+// usedImages returns the names of the images used in the content of a note.
+func usedImages(content *model.Node) map[string]bool {
+	names := make(map[string]bool)
+	var walk func(n *model.Node)
+	walk = func(n *model.Node) {
+		if nodeName(n) == "image" {
+			if name := ImageNameFromSrc(attrString(n, "src")); name != "" {
+				names[name] = true
+			}
+		}
+		for _, child := range children(n) {
+			walk(child)
+		}
+	}
+	walk(content)
+	return names
+}
+
+// listImages returns the files for the images stored for the given note.
+func listImages(inst *instance.Instance, noteID string) ([]*vfs.FileDoc, error) {
+	fs := inst.VFS()
+	dir, err := fs.DirByPath(imagesDir(noteID))
+	if err != nil {
+		return nil, err
+	}
+	var images []*vfs.FileDoc
+	iter := fs.DirIterator(dir, nil)
+	for {
+		_, file, err := iter.Next()
+		if err == vfs.ErrIteratorDone {
+			return images, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			images = append(images, file)
+		}
+	}
+}
+
+// cleanImages removes the images that are no longer used in the content of
+// the note, or in its snapshots. The images uploaded recently are kept, as
+// the client may have not yet added them to the content.
+func cleanImages(inst *instance.Instance, noteID string, content *model.Node) {
+	images, err := listImages(inst, noteID)
+	if err != nil || len(images) == 0 {
+		return
+	}
+	used := usedImages(content)
+	for name := range snapshotsImages(inst, noteID) {
+		used[name] = true
+	}
+	fs := inst.VFS()
+	for _, img := range images {
+		if used[img.DocName] || time.Since(img.CreatedAt) < orphanImageDelay {
+			continue
+		}
+		if err := fs.DestroyFile(img); err != nil {
+			inst.Logger().WithField("nspace", "notes").
+				Infof("Cannot remove orphan image %s: %s", img.ID(), err)
+		}
+	}
+}
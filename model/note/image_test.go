@@ -0,0 +1,98 @@
+package note
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageNameFromSrc(t *testing.T) {
+	assert.Equal(t, "abc.png", ImageNameFromSrc("images/abc.png"))
+	assert.Equal(t, "", ImageNameFromSrc("https://cozy.io/logo.png"))
+	assert.Equal(t, "", ImageNameFromSrc("images/../secret"))
+	assert.Equal(t, "", ImageNameFromSrc("images/.hidden"))
+	assert.Equal(t, "", ImageNameFromSrc("images/"))
+}
+
+func TestUsedImages(t *testing.T) {
+	schema := newDefaultSchema(t)
+	md := `# Title
+
+![first](images/first.png) and ![remote](https://cozy.io/logo.png)
+
+- ![nested](images/nested.jpg)
+`
+	doc, err := parseMarkdown(schema, []byte(md))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"first.png": true, "nested.jpg": true}, usedImages(doc))
+}
+
+func TestExportWithImages(t *testing.T) {
+	schema := newDefaultSchema(t)
+	doc, err := parseMarkdown(schema, []byte("Look: ![a red square](images/square.png)\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	square := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			square.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	require.NoError(t, png.Encode(&buf, square))
+	images := map[string]*exportImage{
+		"square.png": {Mime: "image/png", Data: buf.Bytes()},
+	}
+	theme := &exportTheme{PrimaryColor: defaultPrimaryColor}
+
+	var out bytes.Buffer
+	inst := &instance.Instance{Locale: "en"}
+	require.NoError(t, exportHTML(inst, "Images", doc, theme, images, &out))
+	dataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	assert.Contains(t, out.String(), `<img src="`+dataURI+`" alt="a red square">`)
+
+	out.Reset()
+	require.NoError(t, exportPDF("Images", doc, theme, images, &out))
+	assert.True(t, strings.HasPrefix(out.String(), "%PDF-"))
+	assert.Contains(t, out.String(), "/Subtype /Image")
+	assert.NotContains(t, out.String(), "[a red square]")
+}
+
+func TestCheckImageMime(t *testing.T) {
+	buf := new(bytes.Buffer)
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	require.NoError(t, png.Encode(buf, img))
+	raster := buf.Bytes()
+
+	mime, err := checkImageMime("image/png", raster)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mime)
+	// The sniffed type wins over the declared one
+	mime, err = checkImageMime("text/html", raster)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mime)
+
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="2" height="2"></svg>`)
+	mime, err = checkImageMime("image/svg+xml", svg)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", mime)
+	assert.False(t, IsRasterImage(mime))
+
+	html := []byte(`<html><script>alert(1)</script></html>`)
+	_, err = checkImageMime("text/html", html)
+	assert.Equal(t, ErrInvalidImage, err)
+	_, err = checkImageMime("image/png", html)
+	assert.Equal(t, ErrInvalidImage, err)
+	_, err = checkImageMime("image/svg+xml", html)
+	assert.Equal(t, ErrInvalidImage, err)
+	_, err = checkImageMime("image/png", nil)
+	assert.Equal(t, ErrInvalidImage, err)
+}
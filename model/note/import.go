@@ -0,0 +1,689 @@
+package note
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/russross/blackfriday"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// FormatMarkdown is the format for importing a note from Markdown
+	FormatMarkdown = "markdown"
+	// FormatHTML is the format for importing a note from HTML
+	FormatHTML = "html"
+)
+
+// maxImportSize is the maximal size of a Markdown or HTML file that can be
+// imported as a note.
+const maxImportSize = 10 * 1024 * 1024
+
+const markdownExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_TABLES |
+	blackfriday.EXTENSION_FENCED_CODE |
+	blackfriday.EXTENSION_AUTOLINK |
+	blackfriday.EXTENSION_STRIKETHROUGH |
+	blackfriday.EXTENSION_SPACE_HEADERS
+
+// FormatFromMime returns the import format for the given mime-type or file
+// name, or an empty string if the format is not supported.
+func FormatFromMime(mime, filename string) string {
+	if idx := strings.Index(mime, ";"); idx >= 0 {
+		mime = mime[:idx]
+	}
+	switch strings.TrimSpace(strings.ToLower(mime)) {
+	case "text/markdown", "text/x-markdown":
+		return FormatMarkdown
+	case "text/html", "application/xhtml+xml":
+		return FormatHTML
+	}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return FormatMarkdown
+	case ".html", ".htm", ".xhtml":
+		return FormatHTML
+	}
+	return ""
+}
+
+// Import creates a note from a Markdown or HTML content. If the document has
+// no schema, the default schema of cozy-notes is used.
+func Import(inst *instance.Instance, doc *Document, format string, r io.Reader) (*vfs.FileDoc, error) {
+	content, err := parseContent(doc, format, r)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc.Version = 0
+	doc.SetContent(content)
+	file, err := writeFile(inst, doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupTrigger(inst, file.ID()); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// ImportFile converts a Markdown or HTML file into a note. The file keeps its
+// identifier, its directory and its references, but its content is replaced by
+// the note.
+func ImportFile(inst *instance.Instance, old *vfs.FileDoc, doc *Document) (*vfs.FileDoc, error) {
+	if old.Mime == noteMime {
+		return nil, ErrAlreadyANote
+	}
+	format := FormatFromMime(old.Mime, old.DocName)
+	if format == "" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	fs := inst.VFS()
+	f, err := fs.OpenFile(old)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if doc.Title == "" {
+		doc.Title = strings.TrimSuffix(old.DocName, path.Ext(old.DocName))
+	}
+	content, err := parseContent(doc, format, f)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := inst.NotesLock()
+	if err := lock.Lock(); err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	doc.DocID = old.ID()
+	doc.DirID = old.DirID
+	doc.Version = 0
+	doc.SetContent(content)
+
+	now := time.Now()
+	file := old.Clone().(*vfs.FileDoc)
+	file.DocName = titleToFilename(inst, doc.Title, now)
+	file.ResetFullpath()
+	file.Metadata = doc.Metadata()
+	file.Mime = noteMime
+	file.Class = "text"
+	file.MD5Sum = nil // Let the VFS compute the md5sum
+	file.UpdatedAt = now
+	if file.CozyMetadata == nil {
+		file.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+	}
+	file.CozyMetadata.UpdatedAt = now
+
+	if _, err = saveFile(inst, doc, file, old); err != nil {
+		return nil, err
+	}
+	if err := setupTrigger(inst, file.ID()); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func parseContent(doc *Document, format string, r io.Reader) (*model.Node, error) {
+	if len(doc.SchemaSpec) == 0 {
+		doc.SchemaSpec = DefaultSchemaSpecs()
+	}
+	schema, err := doc.Schema()
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxImportSize {
+		return nil, vfs.ErrFileTooBig
+	}
+
+	switch format {
+	case FormatMarkdown:
+		return parseMarkdown(schema, buf)
+	case FormatHTML:
+		return parseHTML(schema, bytes.NewReader(buf))
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// parseMarkdown transforms a Markdown content to a prosemirror document. The
+// Markdown is first rendered as HTML, and then parsed as HTML.
+func parseMarkdown(schema *model.Schema, md []byte) (*model.Node, error) {
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	out := blackfriday.Markdown(md, renderer, markdownExtensions)
+	return parseHTML(schema, bytes.NewReader(out))
+}
+
+// parseHTML transforms an HTML content to a prosemirror document.
+func parseHTML(schema *model.Schema, r io.Reader) (*model.Node, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, ErrInvalidContent
+	}
+	p := &importParser{schema: schema}
+	top, err := schema.NodeType(schema.Spec.TopNode)
+	if err != nil {
+		return nil, ErrInvalidSchema
+	}
+	blocks := p.blocks(root)
+	if node, err := top.CreateChecked(nil, blocks); err == nil {
+		return node, nil
+	}
+	node, err := top.CreateAndFill(nil, blocks)
+	if err != nil || node == nil {
+		return nil, ErrInvalidContent
+	}
+	return node, nil
+}
+
+// importParser walks the HTML tree and builds the prosemirror nodes. The node
+// and mark types are looked up in the schema with the names used by
+// cozy-notes, and their names in the prosemirror basic schema.
+// When a type is missing from the schema, the content is kept as paragraphs
+// or plain text.
+type importParser struct {
+	schema *model.Schema
+}
+
+func (p *importParser) nodeType(name string) *model.NodeType {
+	for _, name := range schemaNames(name) {
+		if typ, err := p.schema.NodeType(name); err == nil {
+			return typ
+		}
+	}
+	return nil
+}
+
+func (p *importParser) markType(name string) *model.MarkType {
+	for _, name := range schemaNames(name) {
+		if typ, err := p.schema.MarkType(name); err == nil {
+			return typ
+		}
+	}
+	return nil
+}
+
+// attrsFor keeps only the attributes known by the type, and gives a value to
+// the attributes without a default value.
+func attrsFor(known map[string]*model.Attribute, given map[string]interface{}) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for name, attr := range known {
+		if v, ok := given[name]; ok {
+			attrs[name] = v
+		} else if !attr.HasDefault {
+			attrs[name] = ""
+		}
+	}
+	return attrs
+}
+
+// create makes a node of the given type. If the content doesn't match the
+// schema, it tries to fill the missing nodes, and returns nil if it fails.
+func (p *importParser) create(typ *model.NodeType, attrs map[string]interface{}, content []*model.Node) *model.Node {
+	attrs = attrsFor(typ.Attrs, attrs)
+	if node, err := typ.CreateChecked(attrs, content); err == nil {
+		return node
+	}
+	if node, err := typ.CreateAndFill(attrs, content); err == nil && node != nil {
+		return node
+	}
+	return nil
+}
+
+func (p *importParser) paragraph(inlines []*model.Node) *model.Node {
+	typ := p.nodeType("paragraph")
+	if typ == nil {
+		return nil
+	}
+	return p.create(typ, nil, inlines)
+}
+
+// blocks returns the block nodes for the children of the given HTML node.
+func (p *importParser) blocks(n *html.Node) []*model.Node {
+	var blocks []*model.Node
+	var inlines []*model.Node
+	flush := func() {
+		if len(inlines) > 0 && !onlyWhitespaces(inlines) {
+			if para := p.paragraph(trimInlines(inlines)); para != nil {
+				blocks = append(blocks, para)
+			}
+		}
+		inlines = nil
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if isInlineNode(child) {
+			inlines = append(inlines, p.inlines(child, nil)...)
+			continue
+		}
+		flush()
+		blocks = append(blocks, p.block(child)...)
+	}
+	flush()
+	return blocks
+}
+
+func (p *importParser) block(n *html.Node) []*model.Node {
+	if n.Type != html.ElementNode {
+		if n.Type == html.DocumentNode {
+			return p.blocks(n)
+		}
+		return nil
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Template:
+		return nil
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		if typ := p.nodeType("heading"); typ != nil {
+			if node := p.create(typ, map[string]interface{}{"level": level}, p.inlineChildren(n)); node != nil {
+				return []*model.Node{node}
+			}
+		}
+		return p.asParagraph(n)
+	case atom.P:
+		return p.asParagraph(n)
+	case atom.Ul, atom.Ol:
+		return p.list(n)
+	case atom.Blockquote:
+		if typ := p.nodeType("blockquote"); typ != nil {
+			if node := p.create(typ, nil, p.blocks(n)); node != nil {
+				return []*model.Node{node}
+			}
+		}
+		return p.blocks(n)
+	case atom.Pre:
+		return p.codeBlock(n)
+	case atom.Hr:
+		if typ := p.nodeType("rule"); typ != nil {
+			if node := p.create(typ, nil, nil); node != nil {
+				return []*model.Node{node}
+			}
+		}
+		return nil
+	case atom.Table:
+		return p.table(n)
+	}
+	return p.blocks(n)
+}
+
+func (p *importParser) asParagraph(n *html.Node) []*model.Node {
+	inlines := trimInlines(p.inlineChildren(n))
+	if len(inlines) == 0 {
+		return nil
+	}
+	if para := p.paragraph(inlines); para != nil {
+		return []*model.Node{para}
+	}
+	return nil
+}
+
+func (p *importParser) list(n *html.Node) []*model.Node {
+	var typ *model.NodeType
+	attrs := map[string]interface{}{}
+	if n.DataAtom == atom.Ol {
+		typ = p.nodeType("orderedList")
+		if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+			attrs["order"] = start
+		}
+	} else {
+		typ = p.nodeType("bulletList")
+	}
+	itemType := p.nodeType("listItem")
+
+	var items []*model.Node
+	var fallback []*model.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+			continue
+		}
+		content := p.blocks(child)
+		fallback = append(fallback, content...)
+		if itemType == nil {
+			continue
+		}
+		if len(content) == 0 || content[0].Type.Name != "paragraph" {
+			if para := p.paragraph(nil); para != nil {
+				content = append([]*model.Node{para}, content...)
+			}
+		}
+		if item := p.create(itemType, nil, content); item != nil {
+			items = append(items, item)
+		}
+	}
+
+	if typ != nil && itemType != nil && len(items) > 0 {
+		if node := p.create(typ, attrs, items); node != nil {
+			return []*model.Node{node}
+		}
+	}
+	return fallback
+}
+
+func (p *importParser) codeBlock(n *html.Node) []*model.Node {
+	text := strings.TrimSuffix(textContent(n), "\n")
+	attrs := map[string]interface{}{}
+	if code := firstElement(n, atom.Code); code != nil {
+		for _, class := range strings.Fields(getAttr(code, "class")) {
+			if strings.HasPrefix(class, "language-") {
+				attrs["language"] = strings.TrimPrefix(class, "language-")
+			}
+		}
+	}
+
+	var content []*model.Node
+	if text != "" {
+		content = append(content, p.schema.Text(text))
+	}
+	if typ := p.nodeType("codeBlock"); typ != nil {
+		if node := p.create(typ, attrs, content); node != nil {
+			return []*model.Node{node}
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	var marks []*model.Mark
+	if code := p.markType("code"); code != nil {
+		marks = code.Create(nil).AddToSet(marks)
+	}
+	if para := p.paragraph([]*model.Node{p.schema.Text(text, marks)}); para != nil {
+		return []*model.Node{para}
+	}
+	return nil
+}
+
+func (p *importParser) table(n *html.Node) []*model.Node {
+	tableType := p.nodeType("table")
+	rowType := p.nodeType("tableRow")
+	cellType := p.nodeType("tableCell")
+	headerType := p.nodeType("tableHeader")
+	if headerType == nil {
+		headerType = cellType
+	}
+
+	var rows []*model.Node
+	var fallback []*model.Node
+	for _, tr := range findElements(n, atom.Tr) {
+		var cells []*model.Node
+		var texts []*model.Node
+		for child := tr.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || (child.DataAtom != atom.Td && child.DataAtom != atom.Th) {
+				continue
+			}
+			if len(texts) > 0 {
+				texts = append(texts, p.schema.Text(" | "))
+			}
+			texts = append(texts, trimInlines(p.inlineChildren(child))...)
+			typ := cellType
+			if child.DataAtom == atom.Th {
+				typ = headerType
+			}
+			if typ == nil {
+				continue
+			}
+			content := p.blocks(child)
+			if len(content) == 0 {
+				if para := p.paragraph(nil); para != nil {
+					content = []*model.Node{para}
+				}
+			}
+			if cell := p.create(typ, nil, content); cell != nil {
+				cells = append(cells, cell)
+			}
+		}
+		if len(texts) > 0 {
+			if para := p.paragraph(texts); para != nil {
+				fallback = append(fallback, para)
+			}
+		}
+		if rowType != nil && len(cells) > 0 {
+			if row := p.create(rowType, nil, cells); row != nil {
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	if tableType != nil && len(rows) > 0 {
+		if node := p.create(tableType, nil, rows); node != nil {
+			return []*model.Node{node}
+		}
+	}
+	return fallback
+}
+
+func (p *importParser) inlineChildren(n *html.Node) []*model.Node {
+	var inlines []*model.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		inlines = append(inlines, p.inlines(child, nil)...)
+	}
+	return inlines
+}
+
+// inlines returns the inline nodes (text, images and hard breaks) for the
+// given HTML node, with the marks for emphasis, links, etc.
+func (p *importParser) inlines(n *html.Node, marks []*model.Mark) []*model.Node {
+	switch n.Type {
+	case html.TextNode:
+		text := collapseWhitespaces(n.Data)
+		if text == "" {
+			return nil
+		}
+		return []*model.Node{p.schema.Text(text, marks)}
+	case html.ElementNode:
+	default:
+		return nil
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		if typ := p.nodeType("hardBreak"); typ != nil {
+			if node := p.create(typ, nil, nil); node != nil {
+				return []*model.Node{node}
+			}
+		}
+		return []*model.Node{p.schema.Text(" ", marks)}
+	case atom.Img:
+		attrs := map[string]interface{}{
+			"src":   getAttr(n, "src"),
+			"alt":   getAttr(n, "alt"),
+			"title": getAttr(n, "title"),
+		}
+		if typ := p.nodeType("image"); typ != nil && typ.IsInline() {
+			if node := p.create(typ, attrs, nil); node != nil {
+				return []*model.Node{node.Mark(marks)}
+			}
+		}
+		alt := getAttr(n, "alt")
+		if alt == "" {
+			return nil
+		}
+		return []*model.Node{p.schema.Text(alt, marks)}
+	case atom.Em, atom.I:
+		marks = p.addMark(marks, nil, "em")
+	case atom.Strong, atom.B:
+		marks = p.addMark(marks, nil, "strong")
+	case atom.S, atom.Del, atom.Strike:
+		marks = p.addMark(marks, nil, "strike")
+	case atom.Code:
+		marks = p.addMark(marks, nil, "code")
+	case atom.A:
+		href := getAttr(n, "href")
+		if href != "" {
+			attrs := map[string]interface{}{"href": href}
+			if title := getAttr(n, "title"); title != "" {
+				attrs["title"] = title
+			}
+			marks = p.addMark(marks, attrs, "link")
+		}
+	case atom.Script, atom.Style:
+		return nil
+	}
+
+	var inlines []*model.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		inlines = append(inlines, p.inlines(child, marks)...)
+	}
+	return inlines
+}
+
+func (p *importParser) addMark(marks []*model.Mark, attrs map[string]interface{}, name string) []*model.Mark {
+	typ := p.markType(name)
+	if typ == nil {
+		return marks
+	}
+	return typ.Create(attrsFor(typ.Attrs, attrs)).AddToSet(marks)
+}
+
+var inlineAtoms = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Br: true,
+	atom.Cite: true, atom.Code: true, atom.Del: true, atom.Em: true,
+	atom.I: true, atom.Img: true, atom.Ins: true, atom.Kbd: true,
+	atom.Mark: true, atom.Q: true, atom.S: true, atom.Samp: true,
+	atom.Small: true, atom.Span: true, atom.Strike: true, atom.Strong: true,
+	atom.Sub: true, atom.Sup: true, atom.Time: true, atom.U: true,
+	atom.Var: true,
+}
+
+func isInlineNode(n *html.Node) bool {
+	switch n.Type {
+	case html.TextNode:
+		return true
+	case html.ElementNode:
+		return inlineAtoms[n.DataAtom]
+	}
+	return false
+}
+
+func collapseWhitespaces(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range text {
+		switch r {
+		case ' ', '\t', '\n', '\r', '\f':
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+func onlyWhitespaces(inlines []*model.Node) bool {
+	for _, node := range inlines {
+		if !node.IsText() || strings.TrimSpace(*node.Text) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// trimInlines removes the leading and trailing whitespaces of a list of inline
+// nodes.
+func trimInlines(inlines []*model.Node) []*model.Node {
+	for len(inlines) > 0 {
+		first := inlines[0]
+		if !first.IsText() {
+			break
+		}
+		text := strings.TrimLeft(*first.Text, " ")
+		if text != "" {
+			inlines[0] = first.WithText(text)
+			break
+		}
+		inlines = inlines[1:]
+	}
+	for len(inlines) > 0 {
+		last := inlines[len(inlines)-1]
+		if !last.IsText() {
+			break
+		}
+		text := strings.TrimRight(*last.Text, " ")
+		if text != "" {
+			inlines[len(inlines)-1] = last.WithText(text)
+			break
+		}
+		inlines = inlines[:len(inlines)-1]
+	}
+	return inlines
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
+
+func firstElement(n *html.Node, a atom.Atom) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == a {
+			return child
+		}
+		if found := firstElement(child, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findElements returns the descendants of n with the given tag, but without
+// looking inside them (for nested tables).
+func findElements(n *html.Node, a atom.Atom) []*html.Node {
+	var found []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		if child.DataAtom == a {
+			found = append(found, child)
+		} else if child.DataAtom != atom.Table {
+			found = append(found, findElements(child, a)...)
+		}
+	}
+	return found
+}
@@ -0,0 +1,122 @@
+package note
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cozy/prosemirror-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDefaultSchema(t *testing.T) *model.Schema {
+	spec := model.SchemaSpecFromJSON(DefaultSchemaSpecs())
+	schema, err := model.NewSchema(&spec)
+	require.NoError(t, err)
+	return schema
+}
+
+func TestParseMarkdown(t *testing.T) {
+	schema := newDefaultSchema(t)
+	md := `# Title
+
+Some *emphasis*, **strong** and ~~strike~~ text with [a link](https://cozy.io/).
+
+- first
+- second
+  1. nested
+
+> A quote
+
+` + "```go\nfmt.Println(\"hello\")\n```" + `
+
+| Name | Value |
+|------|-------|
+| foo  | 42    |
+
+![alt text](https://cozy.io/logo.png)
+`
+	doc, err := parseMarkdown(schema, []byte(md))
+	require.NoError(t, err)
+
+	var types []string
+	doc.ForEach(func(node *model.Node, _ int, _ int) {
+		types = append(types, node.Type.Name)
+	})
+	assert.Equal(t, []string{"heading", "paragraph", "bulletList", "blockquote", "codeBlock", "table", "paragraph"}, types)
+
+	heading := doc.FirstChild()
+	assert.EqualValues(t, 1, heading.Attrs["level"])
+	assert.Equal(t, "Title", heading.TextContent())
+
+	code, err := doc.Child(4)
+	require.NoError(t, err)
+	assert.Equal(t, "go", code.Attrs["language"])
+	assert.Equal(t, `fmt.Println("hello")`, code.TextContent())
+
+	out := markdownSerializer().Serialize(doc)
+	assert.Contains(t, out, "# Title")
+	assert.Contains(t, out, "*emphasis*")
+	assert.Contains(t, out, "**strong**")
+	assert.Contains(t, out, "~~strike~~")
+	assert.Contains(t, out, "[a link](https://cozy.io/)")
+	assert.Contains(t, out, "![alt text](https://cozy.io/logo.png)")
+}
+
+func TestParseHTML(t *testing.T) {
+	schema := newDefaultSchema(t)
+	body := `<html><head><title>ignored</title></head><body>
+<h2>Subtitle</h2>
+<div><p>First<br/>line</p>Loose text</div>
+<ol start="3"><li>three</li><li>four</li></ol>
+<script>alert("no")</script>
+</body></html>`
+	doc, err := parseHTML(schema, strings.NewReader(body))
+	require.NoError(t, err)
+
+	var types []string
+	doc.ForEach(func(node *model.Node, _ int, _ int) {
+		types = append(types, node.Type.Name)
+	})
+	assert.Equal(t, []string{"heading", "paragraph", "paragraph", "orderedList"}, types)
+	assert.EqualValues(t, 2, doc.FirstChild().Attrs["level"])
+	assert.Equal(t, 3, doc.LastChild().Attrs["order"])
+	assert.NotContains(t, doc.TextContent(), "alert")
+}
+
+func TestParseWithoutTables(t *testing.T) {
+	specs := DefaultSchemaSpecs()
+	var nodes []interface{}
+	for _, node := range specs["nodes"].([]interface{}) {
+		name := node.([]interface{})[0].(string)
+		if !strings.HasPrefix(name, "table") {
+			nodes = append(nodes, node)
+		}
+	}
+	specs["nodes"] = nodes
+	spec := model.SchemaSpecFromJSON(specs)
+	schema, err := model.NewSchema(&spec)
+	require.NoError(t, err)
+
+	doc, err := parseMarkdown(schema, []byte("| a | b |\n|---|---|\n| c | d |\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, doc.ChildCount())
+	assert.Equal(t, "a | b", doc.FirstChild().TextContent())
+	assert.Equal(t, "c | d", doc.LastChild().TextContent())
+}
+
+func TestSchemaNames(t *testing.T) {
+	assert.Equal(t, "bulletList", normalizedName("bullet_list"))
+	assert.Equal(t, "bulletList", normalizedName("bulletList"))
+	assert.Equal(t, "strike", normalizedName("strikethrough"))
+	assert.Equal(t, []string{"codeBlock", "code_block"}, schemaNames("codeBlock"))
+	assert.Equal(t, []string{"paragraph"}, schemaNames("paragraph"))
+}
+
+func TestFormatFromMime(t *testing.T) {
+	assert.Equal(t, FormatMarkdown, FormatFromMime("text/markdown; charset=utf-8", ""))
+	assert.Equal(t, FormatMarkdown, FormatFromMime("text/plain", "README.md"))
+	assert.Equal(t, FormatHTML, FormatFromMime("text/html", ""))
+	assert.Equal(t, FormatHTML, FormatFromMime("", "page.htm"))
+	assert.Equal(t, "", FormatFromMime("text/plain", "notes.txt"))
+}
@@ -334,11 +334,6 @@ func setupTrigger(inst *instance.Instance, fileID string) error {
 }
 
 func writeFile(inst *instance.Instance, doc *Document, oldDoc *vfs.FileDoc) (fileDoc *vfs.FileDoc, err error) {
-	md, err := doc.Markdown()
-	if err != nil {
-		return nil, err
-	}
-
 	if oldDoc == nil {
 		fileDoc, err = newFileDoc(inst, doc)
 		if err != nil {
@@ -347,6 +342,16 @@ func writeFile(inst *instance.Instance, doc *Document, oldDoc *vfs.FileDoc) (fil
 	} else {
 		fileDoc = doc.asFile(inst, oldDoc)
 	}
+	return saveFile(inst, doc, fileDoc, oldDoc)
+}
+
+// saveFile writes the markdown of the note in the given file. If a file with
+// the same name already exists, the file is renamed with a suffix.
+func saveFile(inst *instance.Instance, doc *Document, fileDoc, oldDoc *vfs.FileDoc) (_ *vfs.FileDoc, err error) {
+	md, err := doc.Markdown()
+	if err != nil {
+		return nil, err
+	}
 	fileDoc.ByteSize = int64(len(md))
 
 	fs := inst.VFS()
@@ -360,7 +365,7 @@ func writeFile(inst *instance.Instance, doc *Document, oldDoc *vfs.FileDoc) (fil
 		file, err = fs.CreateFile(fileDoc, oldDoc)
 	}
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer func() {
 		if cerr := file.Close(); cerr != nil && err == nil {
@@ -371,7 +376,7 @@ func writeFile(inst *instance.Instance, doc *Document, oldDoc *vfs.FileDoc) (fil
 		}
 	}()
 	_, err = file.Write(md)
-	return
+	return fileDoc, err
 }
 
 // List returns a list of notes sorted by descending updated_at. It uses
@@ -591,6 +596,10 @@ func Update(inst *instance.Instance, fileID string) error {
 		return err
 	}
 	purgeOldSteps(inst, fileID)
+	autoSnapshot(inst, doc)
+	if content, err := doc.Content(); err == nil {
+		cleanImages(inst, fileID, content)
+	}
 	return nil
 }
 
@@ -0,0 +1,109 @@
+package note
+
+import "encoding/json"
+
+// defaultSchema is the prosemirror schema used by cozy-notes. It is used when
+// a note is imported from a Markdown or HTML file, and the client has not
+// given a schema.
+const defaultSchema = `{
+  "nodes": [
+    ["doc", { "content": "(block)+" }],
+    ["paragraph", { "content": "inline*", "group": "block" }],
+    ["text", { "group": "inline" }],
+    ["bulletList", { "content": "listItem+", "group": "block" }],
+    ["orderedList", {
+      "content": "listItem+",
+      "group": "block",
+      "attrs": { "order": { "default": 1 } }
+    }],
+    ["listItem", { "content": "paragraph (paragraph | bulletList | orderedList | codeBlock)*" }],
+    ["heading", {
+      "content": "inline*",
+      "group": "block",
+      "attrs": { "level": { "default": 1 } }
+    }],
+    ["blockquote", { "content": "paragraph+", "group": "block" }],
+    ["rule", { "group": "block" }],
+    ["hardBreak", { "group": "inline", "inline": true }],
+    ["image", {
+      "group": "inline",
+      "inline": true,
+      "attrs": { "src": {}, "alt": { "default": "" }, "title": { "default": "" } }
+    }],
+    ["codeBlock", {
+      "content": "text*",
+      "marks": "",
+      "group": "block",
+      "attrs": { "language": { "default": null } }
+    }],
+    ["panel", {
+      "content": "(paragraph | heading | bulletList | orderedList)+",
+      "group": "block",
+      "attrs": { "panelType": { "default": "info" } }
+    }],
+    ["table", { "content": "tableRow+", "group": "block" }],
+    ["tableRow", { "content": "(tableCell | tableHeader)+" }],
+    ["tableCell", {
+      "content": "(paragraph | heading | bulletList | orderedList | codeBlock)+",
+      "attrs": { "colspan": { "default": 1 }, "rowspan": { "default": 1 }, "colwidth": { "default": null }, "background": { "default": null } }
+    }],
+    ["tableHeader", {
+      "content": "(paragraph | heading | bulletList | orderedList | codeBlock)+",
+      "attrs": { "colspan": { "default": 1 }, "rowspan": { "default": 1 }, "colwidth": { "default": null }, "background": { "default": null } }
+    }]
+  ],
+  "marks": [
+    ["link", { "attrs": { "href": {}, "title": { "default": null } }, "inclusive": false }],
+    ["em", {}],
+    ["strong", {}],
+    ["strike", {}],
+    ["code", { "excludes": "em strong strike" }]
+  ],
+  "topNode": "doc"
+}`
+
+// basicNames maps the names of the node and mark types in the prosemirror
+// basic schema to the names used by cozy-notes. The notes can use both, and
+// this mapping is used by the imports and the exports.
+var basicNames = map[string]string{
+	"bullet_list":     "bulletList",
+	"ordered_list":    "orderedList",
+	"list_item":       "listItem",
+	"horizontal_rule": "rule",
+	"hard_break":      "hardBreak",
+	"code_block":      "codeBlock",
+	"table_row":       "tableRow",
+	"table_cell":      "tableCell",
+	"table_header":    "tableHeader",
+	"strikethrough":   "strike",
+}
+
+// normalizedName returns the cozy-notes name of a node or mark type.
+func normalizedName(name string) string {
+	if normalized, ok := basicNames[name]; ok {
+		return normalized
+	}
+	return name
+}
+
+// schemaNames returns the names that a node or mark type of cozy-notes can
+// have in a schema: its own name, and its name in the basic schema.
+func schemaNames(name string) []string {
+	names := []string{name}
+	for basic, normalized := range basicNames {
+		if normalized == name {
+			names = append(names, basic)
+		}
+	}
+	return names
+}
+
+// DefaultSchemaSpecs returns the specification of the default prosemirror
+// schema for the notes.
+func DefaultSchemaSpecs() map[string]interface{} {
+	var specs map[string]interface{}
+	if err := json.Unmarshal([]byte(defaultSchema), &specs); err != nil {
+		panic(err)
+	}
+	return specs
+}
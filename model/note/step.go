@@ -147,6 +147,7 @@ func ApplySteps(inst *instance.Instance, file *vfs.FileDoc, lastVersion string,
 		return nil, err
 	}
 	publishSteps(inst, file.ID(), steps)
+	mapComments(inst, doc, steps)
 
 	if err := saveToCache(inst, doc); err != nil {
 		return nil, err
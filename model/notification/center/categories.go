@@ -0,0 +1,82 @@
+package center
+
+import (
+	"sort"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/notification"
+)
+
+// Category is a category of notifications that can be sent to the user, with
+// the preferences of the user for it.
+type Category struct {
+	Key         string                            `json:"key"`
+	Slug        string                            `json:"slug"`
+	Name        string                            `json:"category"`
+	Description string                            `json:"description,omitempty"`
+	Preferences *notification.CategoryPreferences `json:"preferences,omitempty"`
+}
+
+// ListCategories returns the categories of notifications declared by the
+// stack and by the installed webapps and konnectors.
+func ListCategories(inst *instance.Instance) ([]*Category, error) {
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return nil, err
+	}
+	var categories []*Category
+	add := func(slug string, notifs map[string]notification.Properties) {
+		for name, p := range notifs {
+			key := notification.CategoryKey(slug, name)
+			categories = append(categories, &Category{
+				Key:         key,
+				Slug:        slug,
+				Name:        name,
+				Description: p.Description,
+				Preferences: prefs.Categories[key],
+			})
+		}
+	}
+
+	stack := make(map[string]notification.Properties, len(stackNotifications))
+	for name, p := range stackNotifications {
+		stack[name] = *p
+	}
+	add("stack", stack)
+
+	next := ""
+	for {
+		webapps, startKey, err := app.ListWebappsWithPagination(inst, 0, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range webapps {
+			add(m.Slug(), m.Notifications)
+		}
+		if startKey == "" {
+			break
+		}
+		next = startKey
+	}
+
+	next = ""
+	for {
+		konnectors, startKey, err := app.ListKonnectorsWithPagination(inst, 0, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range konnectors {
+			add(m.Slug(), m.Notifications)
+		}
+		if startKey == "" {
+			break
+		}
+		next = startKey
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Key < categories[j].Key
+	})
+	return categories, nil
+}
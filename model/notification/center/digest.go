@@ -0,0 +1,144 @@
+package center
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/mail"
+)
+
+// DigestWorker is the type of the worker that sends the digest of the
+// notifications.
+const DigestWorker = "notifications-digest"
+
+// digestSchedules are the @cron specs used for the trigger of the digest,
+// by frequency.
+var digestSchedules = map[string]string{
+	notification.DigestDaily:  "0 0 8 * * *",
+	notification.DigestWeekly: "0 0 8 * * 1",
+}
+
+// UpdateDigest adds, replaces or removes the trigger for the digest of the
+// notifications, depending of the preferences of the user. When the digest is
+// disabled, the notifications waiting for it are sent immediately.
+func UpdateDigest(inst *instance.Instance, prefs *notification.Preferences) error {
+	schedule := digestSchedules[prefs.Digest]
+	sched := job.System()
+	triggers, err := sched.GetAllTriggers(inst)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, t := range triggers {
+		infos := t.Infos()
+		if infos.WorkerType != DigestWorker {
+			continue
+		}
+		if !found && schedule != "" && infos.Arguments == schedule {
+			found = true
+			continue
+		}
+		if err := sched.DeleteTrigger(inst, infos.TID); err != nil {
+			return err
+		}
+	}
+	if schedule == "" {
+		return SendDigest(inst)
+	}
+	if found {
+		return nil
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@cron",
+		WorkerType: DigestWorker,
+		Arguments:  schedule,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return sched.AddTrigger(t)
+}
+
+// SendDigest sends a mail with the notifications waiting for the digest,
+// grouped by application and category. Nothing is sent if there are no such
+// notifications.
+func SendDigest(inst *instance.Instance) error {
+	var entries []*notification.DigestEntry
+	err := couchdb.ForeachDocs(inst, consts.NotificationsDigest, func(_ string, doc json.RawMessage) error {
+		var entry notification.DigestEntry
+		if err := json.Unmarshal(doc, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, &entry)
+		return nil
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	apps := notification.GroupDigest(entries)
+	for _, a := range apps {
+		a.Name = appName(inst, a.Slug)
+	}
+	email := mail.Options{
+		Mode:         mail.ModeFromStack,
+		TemplateName: "notifications_digest",
+		TemplateValues: map[string]interface{}{
+			"Count": len(entries),
+			"Apps":  apps,
+		},
+	}
+	msg, err := job.NewMessage(&email)
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "sendmail",
+		Message:    msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	docs := make([]couchdb.Doc, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+	return couchdb.BulkDeleteDocs(inst, consts.NotificationsDigest, docs)
+}
+
+// queueForDigest keeps the notification for the next digest, instead of
+// sending a mail for it now. The notifications that would not have been sent
+// by mail are ignored.
+func queueForDigest(inst *instance.Instance, p *notification.Properties, n *notification.Notification) error {
+	if buildMailMessage(p, n) == nil {
+		return nil
+	}
+	return couchdb.CreateDoc(inst, notification.NewDigestEntry(n))
+}
+
+// appName returns the name of the application to display in the digest, or
+// its slug if the application is not installed.
+func appName(inst *instance.Instance, slug string) string {
+	if slug == "stack" {
+		return inst.TemplateTitle()
+	}
+	if m, err := app.GetWebappBySlug(inst, slug); err == nil {
+		return m.NameLocalized(inst.Locale)
+	}
+	if m, err := app.GetKonnectorBySlug(inst, slug); err == nil && m.Name != "" {
+		return m.Name
+	}
+	return slug
+}
@@ -25,6 +25,12 @@ const (
 	// NotificationDiskQuota category for sending alert when reaching 90% of disk
 	// usage quota.
 	NotificationDiskQuota = "disk-quota"
+	// NotificationSharingActivity category for sending a daily digest of the
+	// changes made by the members of a sharing.
+	NotificationSharingActivity = "sharing-activity"
+	// NotificationKonnectorInput category for asking the user an input needed
+	// by a running konnector, like a 2FA code.
+	NotificationKonnectorInput = "konnector-input"
 )
 
 var (
@@ -36,6 +42,14 @@ var (
 			MailTemplate: "notifications_diskquota",
 			MinInterval:  7 * 24 * time.Hour,
 		},
+		NotificationSharingActivity: {
+			Description:  "Summarize the changes made by the members of a sharing",
+			MailTemplate: "notifications_sharing_activity",
+		},
+		NotificationKonnectorInput: {
+			Description: "Ask for an input needed by a konnector, like a 2FA code",
+			Collapsible: true,
+		},
 	}
 )
 
@@ -51,17 +65,20 @@ func init() {
 		}
 		cozyDriveLink := i.SubDomain(consts.DriveSlug)
 		n := &notification.Notification{
+			Title: i.Translate("Notifications Disk Quota Subject"),
 			State: exceeded,
 			Data: map[string]interface{}{
 				"OffersLink":    offersLink,
 				"CozyDriveLink": cozyDriveLink.String(),
 			},
 		}
-		_ = pushStack(domain, NotificationDiskQuota, n)
+		_ = PushStack(domain, NotificationDiskQuota, n)
 	})
 }
 
-func pushStack(domain string, category string, n *notification.Notification) error {
+// PushStack creates and sends a new notification for one of the categories
+// of the stack.
+func PushStack(domain string, category string, n *notification.Notification) error {
 	inst, err := lifecycle.GetInstance(domain)
 	if err != nil {
 		return err
@@ -175,8 +192,25 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 		}
 	}
 
-	preferredChannels := ensureMailFallback(n.PreferredChannels)
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	catPrefs := prefs.ForCategory(n)
+	var preferredChannels []string
+	if catPrefs != nil && len(catPrefs.Channels) > 0 {
+		// The choice of the user takes precedence, without mail fallback
+		preferredChannels = catPrefs.Channels
+	} else {
+		preferredChannels = ensureMailFallback(n.PreferredChannels)
+	}
+	// The push worker cannot keep the mail fallback for the digest
+	mailFallback := hasChannel(preferredChannels, notification.ChannelMail) &&
+		prefs.Digest == ""
 	at := n.At
+	if catPrefs == nil || !catPrefs.IgnoreDoNotDisturb {
+		at = deferAt(inst, prefs, at)
+	}
 
 	n.NID = ""
 	n.NRev = ""
@@ -192,23 +226,33 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 	if skipNotification {
 		return nil
 	}
+	if catPrefs != nil && catPrefs.Muted {
+		inst.Logger().WithField("nspace", "notifications").
+			Debugf("Notification %s was not sent (muted by the user)", n.PreferencesKey())
+		return nil
+	}
 
 	var errm error
 	for _, channel := range preferredChannels {
 		switch channel {
-		case "mobile":
+		case notification.ChannelMobile:
 			if p != nil {
 				log := inst.Logger().WithField("nspace", "notifications")
 				log.Infof("Sending push %#v: %v", p, n.State)
-				err := sendPush(inst, p, n, at)
+				err := sendPush(inst, p, n, at, mailFallback)
 				if err == nil {
 					return nil
 				}
 				log.Errorf("Error while sending push %#v: %v", p, n.State)
 				errm = multierror.Append(errm, err)
 			}
-		case "mail":
-			err := sendMail(inst, p, n, at)
+		case notification.ChannelMail:
+			var err error
+			if prefs.Digest != "" {
+				err = queueForDigest(inst, p, n)
+			} else {
+				err = sendMail(inst, p, n, at)
+			}
 			if err == nil {
 				return nil
 			}
@@ -246,11 +290,15 @@ func sendPush(inst *instance.Instance,
 	p *notification.Properties,
 	n *notification.Notification,
 	at string,
+	mailFallback bool,
 ) error {
 	if !hasNotifiableDevice(inst) {
 		return errors.New("No device with push notification")
 	}
-	email := buildMailMessage(p, n)
+	var email *mail.Options
+	if mailFallback {
+		email = buildMailMessage(p, n)
+	}
 	push := PushMessage{
 		NotificationID: n.ID(),
 		Source:         n.Source(),
@@ -330,15 +378,67 @@ func pushJobOrTrigger(inst *instance.Instance, msg job.Message, worker, at strin
 }
 
 func ensureMailFallback(channels []string) []string {
+	if hasChannel(channels, notification.ChannelMail) {
+		return channels
+	}
+	return append(channels, notification.ChannelMail)
+}
+
+// instanceLocation returns the timezone from the settings of the instance, or
+// UTC if it has not been set.
+func instanceLocation(inst *instance.Instance) *time.Location {
+	doc, err := inst.SettingsDocument()
+	if err != nil {
+		return time.UTC
+	}
+	tz, _ := doc.M["tz"].(string)
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func hasChannel(channels []string, channel string) bool {
 	for _, c := range channels {
-		if c == "mail" {
-			return channels
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// deferAt returns the date for sending the notification, after the end of the
+// do-not-disturb hours of the user if needed. The at parameter is the date
+// asked by the application, in RFC3339 format (or empty for now).
+func deferAt(inst *instance.Instance, prefs *notification.Preferences, at string) string {
+	if prefs.DoNotDisturb == nil {
+		return at
+	}
+	now := time.Now().In(instanceLocation(inst))
+	if at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return at
+		}
+		if t.After(now) {
+			now = t.In(now.Location())
 		}
 	}
-	return append(channels, "mail")
+	if until, ok := prefs.DeferredUntil(now); ok {
+		return until.UTC().Format(time.RFC3339)
+	}
+	return at
 }
 
 func hasNotifiableDevice(inst *instance.Instance) bool {
 	cs, err := oauth.GetNotifiables(inst)
-	return err == nil && len(cs) > 0
+	if err == nil && len(cs) > 0 {
+		return true
+	}
+	subs, err := notification.ListWebPushSubscriptions(inst)
+	return err == nil && len(subs) > 0
 }
@@ -0,0 +1,123 @@
+package notification
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// DigestEntry is a notification waiting to be sent by mail in the next
+// digest.
+type DigestEntry struct {
+	DocID          string    `json:"_id,omitempty"`
+	DocRev         string    `json:"_rev,omitempty"`
+	NotificationID string    `json:"notification_id"`
+	Slug           string    `json:"slug"`
+	Category       string    `json:"category"`
+	Title          string    `json:"title,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// NewDigestEntry returns a digest entry for the given notification.
+func NewDigestEntry(n *Notification) *DigestEntry {
+	slug := n.Slug
+	if slug == "" {
+		slug = n.Originator
+	}
+	return &DigestEntry{
+		NotificationID: n.ID(),
+		Slug:           slug,
+		Category:       n.Category,
+		Title:          n.Title,
+		Message:        n.Message,
+		CreatedAt:      n.CreatedAt,
+	}
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (e *DigestEntry) ID() string { return e.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (e *DigestEntry) Rev() string { return e.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (e *DigestEntry) DocType() string { return consts.NotificationsDigest }
+
+// Clone implements couchdb.Doc
+func (e *DigestEntry) Clone() couchdb.Doc {
+	cloned := *e
+	return &cloned
+}
+
+// SetID is used to implement the couchdb.Doc interface
+func (e *DigestEntry) SetID(id string) { e.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (e *DigestEntry) SetRev(rev string) { e.DocRev = rev }
+
+// DigestItem is a notification in the digest mail. The JSON keys are the names
+// used in the mail templates.
+type DigestItem struct {
+	Title   string `json:"Title"`
+	Message string `json:"Message"`
+}
+
+// DigestCategory is the list of notifications of a category in the digest
+// mail.
+type DigestCategory struct {
+	Name  string        `json:"Name"`
+	Items []*DigestItem `json:"Items"`
+}
+
+// DigestApp is the list of notifications sent by an application (or by the
+// stack), grouped by category, in the digest mail.
+type DigestApp struct {
+	Slug       string            `json:"Slug"`
+	Name       string            `json:"Name"`
+	Categories []*DigestCategory `json:"Categories"`
+}
+
+// GroupDigest groups the entries by application and by category. The
+// applications and the categories are sorted by their names, and the
+// notifications of a category by their creation date. The Name of the apps is
+// initialized with their slug.
+func GroupDigest(entries []*DigestEntry) []*DigestApp {
+	sorted := make([]*DigestEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Slug != b.Slug {
+			return a.Slug < b.Slug
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+
+	var apps []*DigestApp
+	var app *DigestApp
+	var cat *DigestCategory
+	for _, e := range sorted {
+		if app == nil || app.Slug != e.Slug {
+			app = &DigestApp{Slug: e.Slug, Name: e.Slug}
+			apps = append(apps, app)
+			cat = nil
+		}
+		if cat == nil || cat.Name != e.Category {
+			cat = &DigestCategory{Name: e.Category}
+			app.Categories = append(app.Categories, cat)
+		}
+		title := e.Title
+		if title == "" {
+			title = e.Category
+		}
+		cat.Items = append(cat.Items, &DigestItem{Title: title, Message: e.Message})
+	}
+	return apps
+}
+
+var _ couchdb.Doc = &DigestEntry{}
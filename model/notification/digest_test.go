@@ -0,0 +1,38 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupDigest(t *testing.T) {
+	now := time.Now()
+	entries := []*DigestEntry{
+		{Slug: "stack", Category: "disk-quota", Title: "Disk quota", CreatedAt: now},
+		{Slug: "banks", Category: "balance", Title: "Low balance", Message: "-12€", CreatedAt: now},
+		{Slug: "banks", Category: "balance", Title: "Lower balance", CreatedAt: now.Add(-time.Hour)},
+		{Slug: "banks", Category: "alert", CreatedAt: now},
+	}
+	apps := GroupDigest(entries)
+	if assert.Len(t, apps, 2) {
+		assert.Equal(t, "banks", apps[0].Slug)
+		assert.Equal(t, "banks", apps[0].Name)
+		if assert.Len(t, apps[0].Categories, 2) {
+			assert.Equal(t, "alert", apps[0].Categories[0].Name)
+			assert.Equal(t, []*DigestItem{{Title: "alert"}}, apps[0].Categories[0].Items)
+			assert.Equal(t, "balance", apps[0].Categories[1].Name)
+			assert.Equal(t, []*DigestItem{
+				{Title: "Lower balance"},
+				{Title: "Low balance", Message: "-12€"},
+			}, apps[0].Categories[1].Items)
+		}
+		assert.Equal(t, "stack", apps[1].Slug)
+		if assert.Len(t, apps[1].Categories, 1) {
+			assert.Equal(t, []*DigestItem{{Title: "Disk quota"}}, apps[1].Categories[0].Items)
+		}
+	}
+	assert.Equal(t, "stack", entries[0].Slug)
+	assert.Empty(t, GroupDigest(nil))
+}
@@ -0,0 +1,260 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// The channels that can be used to deliver a notification
+const (
+	ChannelMobile = "mobile"
+	ChannelMail   = "mail"
+)
+
+// The frequencies for the digest of the notifications sent by mail
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+var (
+	// ErrInvalidChannel is used when the preferences have an unknown channel.
+	ErrInvalidChannel = errors.New("Invalid channel for the notifications")
+	// ErrInvalidDoNotDisturb is used when the hours or the timezone of the
+	// do-not-disturb schedule are not valid.
+	ErrInvalidDoNotDisturb = errors.New("Invalid do-not-disturb schedule")
+	// ErrInvalidDigest is used when the frequency of the digest is unknown.
+	ErrInvalidDigest = errors.New("Invalid frequency for the digest")
+)
+
+// CategoryPreferences are the choices of the user for a category of
+// notifications.
+type CategoryPreferences struct {
+	// Muted is true if the notifications of this category should not be
+	// delivered (they are still visible in the notification center).
+	Muted bool `json:"muted,omitempty"`
+	// Channels is the list of channels that can be used to deliver the
+	// notifications, in order of preference. When it is empty, the channels
+	// asked by the application are used, with a fallback on mail.
+	Channels []string `json:"channels,omitempty"`
+	// IgnoreDoNotDisturb is true if the notifications of this category should
+	// be delivered even during the do-not-disturb hours.
+	IgnoreDoNotDisturb bool `json:"ignore_do_not_disturb,omitempty"`
+}
+
+// DoNotDisturb is a daily schedule during which the notifications are not
+// delivered: they are deferred to the end of the time window.
+type DoNotDisturb struct {
+	// Hours is a time window, like "22:00-07:00". It can span over midnight.
+	Hours string `json:"hours"`
+	// Timezone is the location for the hours, like "Europe/Paris". When it is
+	// empty, the timezone of the instance is used.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Preferences is the settings document with the preferences of the user for
+// the notifications. The categories are indexed by the slug of the app (or
+// "stack" for the notifications sent by the stack) and the category name,
+// separated by a slash, like "stack/disk-quota" or "banks/balance-lower".
+type Preferences struct {
+	DocRev       string                          `json:"_rev,omitempty"`
+	Categories   map[string]*CategoryPreferences `json:"categories,omitempty"`
+	DoNotDisturb *DoNotDisturb                   `json:"do_not_disturb,omitempty"`
+	// Digest is the frequency of the mail that groups the notifications
+	// (daily or weekly). When it is empty, a mail is sent for each
+	// notification.
+	Digest string `json:"digest,omitempty"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (p *Preferences) ID() string { return consts.NotificationsSettingsID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (p *Preferences) Rev() string { return p.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (p *Preferences) DocType() string { return consts.Settings }
+
+// Clone implements couchdb.Doc
+func (p *Preferences) Clone() couchdb.Doc {
+	cloned := *p
+	if p.Categories != nil {
+		cloned.Categories = make(map[string]*CategoryPreferences, len(p.Categories))
+		for k, v := range p.Categories {
+			c := *v
+			c.Channels = make([]string, len(v.Channels))
+			copy(c.Channels, v.Channels)
+			cloned.Categories[k] = &c
+		}
+	}
+	if p.DoNotDisturb != nil {
+		dnd := *p.DoNotDisturb
+		cloned.DoNotDisturb = &dnd
+	}
+	return &cloned
+}
+
+// SetID is used to implement the couchdb.Doc interface
+func (p *Preferences) SetID(id string) {}
+
+// SetRev is used to implement the couchdb.Doc interface
+func (p *Preferences) SetRev(rev string) { p.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (p *Preferences) Fetch(field string) []string { return nil }
+
+// CategoryKey returns the key used in the preferences for the category of
+// notifications of an app.
+func CategoryKey(slug, category string) string {
+	return slug + "/" + category
+}
+
+// PreferencesKey returns the key used in the preferences for the category of
+// this notification.
+func (n *Notification) PreferencesKey() string {
+	slug := n.Slug
+	if slug == "" {
+		slug = n.Originator
+	}
+	return CategoryKey(slug, n.Category)
+}
+
+// GetPreferences returns the preferences of the user for the notifications.
+// Empty preferences are returned if the user has not set them.
+func GetPreferences(db prefixer.Prefixer) (*Preferences, error) {
+	prefs := &Preferences{}
+	err := couchdb.GetDoc(db, consts.Settings, consts.NotificationsSettingsID, prefs)
+	if err != nil && !couchdb.IsNotFoundError(err) {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// Save checks and persists the preferences in CouchDB.
+func (p *Preferences) Save(db prefixer.Prefixer) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(db, p)
+	}
+	return couchdb.UpdateDoc(db, p)
+}
+
+// Validate checks the channels, the do-not-disturb schedule, and the
+// frequency of the digest.
+func (p *Preferences) Validate() error {
+	if p.Digest != "" && p.Digest != DigestDaily && p.Digest != DigestWeekly {
+		return ErrInvalidDigest
+	}
+	for _, c := range p.Categories {
+		if c == nil {
+			continue
+		}
+		for _, channel := range c.Channels {
+			if channel != ChannelMobile && channel != ChannelMail {
+				return ErrInvalidChannel
+			}
+		}
+	}
+	if dnd := p.DoNotDisturb; dnd != nil {
+		if _, _, err := parseHours(dnd.Hours); err != nil {
+			return err
+		}
+		if _, err := dnd.location(); err != nil {
+			return ErrInvalidDoNotDisturb
+		}
+	}
+	return nil
+}
+
+// ForCategory returns the preferences for the category of the notification,
+// or nil if the user has not set them.
+func (p *Preferences) ForCategory(n *Notification) *CategoryPreferences {
+	if p == nil || p.Categories == nil {
+		return nil
+	}
+	return p.Categories[n.PreferencesKey()]
+}
+
+// DeferredUntil returns the end of the do-not-disturb time window if the
+// given time is inside it. The boolean is false if the notifications can be
+// delivered now. The location of now is used if the schedule has no timezone.
+func (p *Preferences) DeferredUntil(now time.Time) (time.Time, bool) {
+	if p == nil || p.DoNotDisturb == nil {
+		return time.Time{}, false
+	}
+	dnd := p.DoNotDisturb
+	start, end, err := parseHours(dnd.Hours)
+	if err != nil || start == end {
+		return time.Time{}, false
+	}
+	loc, err := dnd.location()
+	if err != nil {
+		return time.Time{}, false
+	}
+	if loc == nil {
+		loc = now.Location()
+	}
+	now = now.In(loc)
+	minutes := now.Hour()*60 + now.Minute()
+	var inside bool
+	if start < end {
+		inside = start <= minutes && minutes < end
+	} else {
+		inside = minutes >= start || minutes < end
+	}
+	if !inside {
+		return time.Time{}, false
+	}
+	y, m, d := now.Date()
+	until := time.Date(y, m, d, end/60, end%60, 0, 0, loc)
+	if !until.After(now) {
+		until = time.Date(y, m, d+1, end/60, end%60, 0, 0, loc)
+	}
+	return until, true
+}
+
+func (dnd *DoNotDisturb) location() (*time.Location, error) {
+	if dnd.Timezone == "" {
+		return nil, nil
+	}
+	return time.LoadLocation(dnd.Timezone)
+}
+
+// parseHours parses a time window like "22:00-07:00", and returns the start
+// and the end as a number of minutes since midnight.
+func parseHours(hours string) (int, int, error) {
+	parts := strings.SplitN(hours, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidDoNotDisturb
+	}
+	start, err := parseHour(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseHour(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseHour(hour string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(hour), "%d:%d", &h, &m); err != nil {
+		return 0, ErrInvalidDoNotDisturb
+	}
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, ErrInvalidDoNotDisturb
+	}
+	return h*60 + m, nil
+}
+
+var _ couchdb.Doc = &Preferences{}
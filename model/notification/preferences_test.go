@@ -0,0 +1,83 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferencesValidate(t *testing.T) {
+	prefs := &Preferences{
+		Categories: map[string]*CategoryPreferences{
+			"stack/disk-quota": {Channels: []string{ChannelMobile, ChannelMail}},
+			"banks/balance":    {Muted: true},
+		},
+		DoNotDisturb: &DoNotDisturb{Hours: "22:00-07:30", Timezone: "Europe/Paris"},
+	}
+	assert.NoError(t, prefs.Validate())
+
+	prefs.Categories["banks/balance"].Channels = []string{"sms"}
+	assert.Equal(t, ErrInvalidChannel, prefs.Validate())
+	prefs.Categories["banks/balance"].Channels = nil
+
+	prefs.DoNotDisturb.Hours = "22:00"
+	assert.Equal(t, ErrInvalidDoNotDisturb, prefs.Validate())
+	prefs.DoNotDisturb.Hours = "25:00-07:00"
+	assert.Equal(t, ErrInvalidDoNotDisturb, prefs.Validate())
+	prefs.DoNotDisturb.Hours = "22:00-07:00"
+	prefs.DoNotDisturb.Timezone = "Mars/Olympus"
+	assert.Equal(t, ErrInvalidDoNotDisturb, prefs.Validate())
+	prefs.DoNotDisturb.Timezone = ""
+
+	prefs.Digest = DigestWeekly
+	assert.NoError(t, prefs.Validate())
+	prefs.Digest = "hourly"
+	assert.Equal(t, ErrInvalidDigest, prefs.Validate())
+}
+
+func TestPreferencesForCategory(t *testing.T) {
+	prefs := &Preferences{
+		Categories: map[string]*CategoryPreferences{
+			"stack/disk-quota": {Muted: true},
+			"banks/balance":    {Channels: []string{ChannelMail}},
+		},
+	}
+	n := &Notification{Originator: "stack", Category: "disk-quota"}
+	assert.True(t, prefs.ForCategory(n).Muted)
+	n = &Notification{Originator: "konnector", Slug: "banks", Category: "balance"}
+	assert.Equal(t, []string{ChannelMail}, prefs.ForCategory(n).Channels)
+	n = &Notification{Originator: "app", Slug: "drive", Category: "balance"}
+	assert.Nil(t, prefs.ForCategory(n))
+}
+
+func TestDeferredUntil(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	assert.NoError(t, err)
+
+	var prefs *Preferences
+	_, ok := prefs.DeferredUntil(time.Now())
+	assert.False(t, ok)
+
+	prefs = &Preferences{
+		DoNotDisturb: &DoNotDisturb{Hours: "22:00-07:30", Timezone: "Europe/Paris"},
+	}
+	_, ok = prefs.DeferredUntil(time.Date(2020, 3, 10, 12, 0, 0, 0, paris))
+	assert.False(t, ok)
+
+	until, ok := prefs.DeferredUntil(time.Date(2020, 3, 10, 23, 15, 0, 0, paris))
+	assert.True(t, ok)
+	assert.True(t, until.Equal(time.Date(2020, 3, 11, 7, 30, 0, 0, paris)))
+
+	until, ok = prefs.DeferredUntil(time.Date(2020, 3, 11, 5, 0, 0, 0, paris))
+	assert.True(t, ok)
+	assert.True(t, until.Equal(time.Date(2020, 3, 11, 7, 30, 0, 0, paris)))
+
+	// The location of the given time is used when there is no timezone
+	prefs.DoNotDisturb = &DoNotDisturb{Hours: "12:00-14:00"}
+	until, ok = prefs.DeferredUntil(time.Date(2020, 3, 10, 13, 0, 0, 0, paris))
+	assert.True(t, ok)
+	assert.True(t, until.Equal(time.Date(2020, 3, 10, 14, 0, 0, 0, paris)))
+	_, ok = prefs.DeferredUntil(time.Date(2020, 3, 10, 11, 30, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
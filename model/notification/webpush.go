@@ -0,0 +1,205 @@
+package notification
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+)
+
+// maxWebPushSubscriptions is the maximal number of browsers that can be
+// subscribed to the web push notifications for an instance.
+const maxWebPushSubscriptions = 20
+
+var (
+	// ErrInvalidEndpoint is used when the endpoint of a web push subscription
+	// is not a valid https URL of a known push service.
+	ErrInvalidEndpoint = errors.New("Invalid endpoint for the web push subscription")
+	// ErrTooManySubscriptions is used when too many browsers have been
+	// subscribed to the web push notifications.
+	ErrTooManySubscriptions = errors.New("Too many web push subscriptions")
+)
+
+// WebPushKeys are the keys of a web push subscription, encoded in base64url.
+type WebPushKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// WebPushSubscription is a browser that has subscribed to the notifications
+// via Web Push. The endpoint and the keys are the ones returned by the
+// PushSubscription API of the browser.
+type WebPushSubscription struct {
+	DocID     string      `json:"_id,omitempty"`
+	DocRev    string      `json:"_rev,omitempty"`
+	Endpoint  string      `json:"endpoint"`
+	Keys      WebPushKeys `json:"keys"`
+	Name      string      `json:"name,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) ID() string { return s.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) Rev() string { return s.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) DocType() string { return consts.WebPushSubscriptions }
+
+// Clone implements couchdb.Doc
+func (s *WebPushSubscription) Clone() couchdb.Doc {
+	cloned := *s
+	return &cloned
+}
+
+// SetID is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) SetID(id string) { s.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) SetRev(rev string) { s.DocRev = rev }
+
+// Fetch implements permission.Fetcher
+func (s *WebPushSubscription) Fetch(field string) []string { return nil }
+
+// Subscription returns the subscription for the webpush package.
+func (s *WebPushSubscription) Subscription() *webpush.Subscription {
+	return &webpush.Subscription{
+		Endpoint: s.Endpoint,
+		P256dh:   s.Keys.P256dh,
+		Auth:     s.Keys.Auth,
+	}
+}
+
+// Validate checks the endpoint and the keys of the subscription. The
+// endpoint must be an https URL of a known push service, except for the
+// development releases, where a local push service can be used.
+func (s *WebPushSubscription) Validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Host == "" || u.User != nil {
+		return ErrInvalidEndpoint
+	}
+	if !build.IsDevRelease() {
+		if u.Scheme != "https" || !webpush.IsPushService(u.Hostname()) {
+			return ErrInvalidEndpoint
+		}
+	} else if u.Scheme != "https" && u.Scheme != "http" {
+		return ErrInvalidEndpoint
+	}
+	return webpush.ValidateSubscription(s.Subscription())
+}
+
+// ListWebPushSubscriptions returns the browsers subscribed to the web push
+// notifications.
+func ListWebPushSubscriptions(db prefixer.Prefixer) ([]*WebPushSubscription, error) {
+	var subs []*WebPushSubscription
+	req := &couchdb.AllDocsRequest{Limit: maxWebPushSubscriptions}
+	err := couchdb.GetAllDocs(db, consts.WebPushSubscriptions, req, &subs)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// FindWebPushSubscription returns the web push subscription with the given
+// identifier.
+func FindWebPushSubscription(db prefixer.Prefixer, id string) (*WebPushSubscription, error) {
+	sub := &WebPushSubscription{}
+	if err := couchdb.GetDoc(db, consts.WebPushSubscriptions, id, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Subscribe checks and saves the subscription. If the browser was already
+// subscribed with the same endpoint, its subscription is updated.
+func (s *WebPushSubscription) Subscribe(db prefixer.Prefixer) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	subs, err := ListWebPushSubscriptions(db)
+	if err != nil {
+		return err
+	}
+	s.CreatedAt = time.Now()
+	for _, sub := range subs {
+		if sub.Endpoint == s.Endpoint {
+			s.SetID(sub.ID())
+			s.SetRev(sub.Rev())
+			return couchdb.UpdateDoc(db, s)
+		}
+	}
+	if len(subs) >= maxWebPushSubscriptions {
+		return ErrTooManySubscriptions
+	}
+	s.SetID("")
+	s.SetRev("")
+	return couchdb.CreateDoc(db, s)
+}
+
+// Unsubscribe removes the subscription.
+func (s *WebPushSubscription) Unsubscribe(db prefixer.Prefixer) error {
+	return couchdb.DeleteDoc(db, s)
+}
+
+// vapidDoc is the document in the global database with the VAPID keys of
+// the stack.
+type vapidDoc struct {
+	DocRev string `json:"_rev,omitempty"`
+	webpush.VAPIDKeys
+}
+
+func (v *vapidDoc) ID() string      { return consts.VAPIDSettingsID }
+func (v *vapidDoc) Rev() string     { return v.DocRev }
+func (v *vapidDoc) DocType() string { return consts.Settings }
+func (v *vapidDoc) Clone() couchdb.Doc {
+	cloned := *v
+	return &cloned
+}
+func (v *vapidDoc) SetID(id string)   {}
+func (v *vapidDoc) SetRev(rev string) { v.DocRev = rev }
+
+var (
+	vapidMu   sync.Mutex
+	vapidKeys *webpush.VAPIDKeys
+)
+
+// GetVAPIDKeys returns the VAPID keys of the stack. They are generated the
+// first time, and saved in the global database, to be shared by all the
+// stacks.
+func GetVAPIDKeys() (*webpush.VAPIDKeys, error) {
+	vapidMu.Lock()
+	defer vapidMu.Unlock()
+	if vapidKeys != nil {
+		return vapidKeys, nil
+	}
+
+	doc := &vapidDoc{}
+	err := couchdb.GetDoc(couchdb.GlobalDB, consts.Settings, consts.VAPIDSettingsID, doc)
+	if couchdb.IsNotFoundError(err) {
+		keys, errg := webpush.GenerateVAPIDKeys()
+		if errg != nil {
+			return nil, errg
+		}
+		doc = &vapidDoc{VAPIDKeys: *keys}
+		err = couchdb.CreateNamedDocWithDB(couchdb.GlobalDB, doc)
+		if couchdb.IsConflictError(err) {
+			// Another stack has generated the keys at the same time
+			doc = &vapidDoc{}
+			err = couchdb.GetDoc(couchdb.GlobalDB, consts.Settings, consts.VAPIDSettingsID, doc)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	vapidKeys = &doc.VAPIDKeys
+	return vapidKeys, nil
+}
+
+var _ couchdb.Doc = &WebPushSubscription{}
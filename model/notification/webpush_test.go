@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"testing"
+
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebPushSubscriptionValidate(t *testing.T) {
+	defer func(mode string) { build.BuildMode = mode }(build.BuildMode)
+	build.BuildMode = build.ModeProd
+
+	sub := &WebPushSubscription{
+		Endpoint: "https://fcm.googleapis.com/fcm/send/abcdef",
+		Keys: WebPushKeys{
+			P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+			Auth:   "BTBZMqHH6r4Tts7J_aSIgg",
+		},
+	}
+	assert.NoError(t, sub.Validate())
+
+	for _, endpoint := range []string{
+		"http://fcm.googleapis.com/fcm/send/abcdef",
+		"https://user@fcm.googleapis.com/fcm/send/abcdef",
+		"https://push.example.net/abcdef",
+		"https://127.0.0.1/abcdef",
+		"https://169.254.169.254/latest/meta-data/",
+		"ftp://fcm.googleapis.com/abcdef",
+	} {
+		sub.Endpoint = endpoint
+		assert.Equal(t, ErrInvalidEndpoint, sub.Validate(), endpoint)
+	}
+
+	// A local push service can be used for the development releases
+	build.BuildMode = build.ModeDev
+	sub.Endpoint = "http://localhost:8080/push/abcdef"
+	assert.NoError(t, sub.Validate())
+	sub.Endpoint = "ftp://localhost/abcdef"
+	assert.Equal(t, ErrInvalidEndpoint, sub.Validate())
+}
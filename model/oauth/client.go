@@ -78,6 +78,8 @@ type Client struct {
 	// XXX omitempty does not work for time.Time, thus the interface{} type
 	SynchronizedAt interface{} `json:"synchronized_at,omitempty"` // Date of the last synchronization, updated by /settings/synchronized
 
+	ConsumedScopes map[string]time.Time `json:"consumed_scopes,omitempty"` // The read-once rules of the scope that have already been read, with the date of the read
+
 	OnboardingSecret      string `json:"onboarding_secret,omitempty"`
 	OnboardingApp         string `json:"onboarding_app,omitempty"`
 	OnboardingPermissions string `json:"onboarding_permissions,omitempty"`
@@ -107,6 +109,13 @@ func (c *Client) Clone() couchdb.Doc {
 	cloned.ResponseTypes = make([]string, len(c.ResponseTypes))
 	copy(cloned.ResponseTypes, c.ResponseTypes)
 
+	if c.ConsumedScopes != nil {
+		cloned.ConsumedScopes = make(map[string]time.Time, len(c.ConsumedScopes))
+		for k, v := range c.ConsumedScopes {
+			cloned.ConsumedScopes[k] = v
+		}
+	}
+
 	cloned.Notifications = make(map[string]notification.Properties)
 	for k, v := range c.Notifications {
 		props := (&v).Clone()
@@ -486,6 +495,25 @@ func (c *Client) Delete(i *instance.Instance) *ClientRegistrationError {
 	return nil
 }
 
+// ConsumedScopeAt returns the date of the read if the given rule of the scope
+// has the read-once condition and has already been read.
+func (c *Client) ConsumedScopeAt(rule string) (time.Time, bool) {
+	at, ok := c.ConsumedScopes[rule]
+	return at, ok
+}
+
+// ConsumeScope marks a read-once rule of the scope as read.
+func (c *Client) ConsumeScope(i *instance.Instance, rule string) error {
+	if _, ok := c.ConsumedScopeAt(rule); ok {
+		return nil
+	}
+	if c.ConsumedScopes == nil {
+		c.ConsumedScopes = make(map[string]time.Time)
+	}
+	c.ConsumedScopes[rule] = time.Now().UTC()
+	return couchdb.UpdateDoc(i, c)
+}
+
 // AcceptRedirectURI returns true if the given URI matches the registered
 // redirect_uris
 func (c *Client) AcceptRedirectURI(u string) bool {
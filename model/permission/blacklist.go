@@ -36,6 +36,8 @@ var blackList = map[string]bool{
 	consts.RemoteRequests: readable,
 	consts.SessionsLogins: readable,
 	consts.NotesSteps:     readable,
+	consts.NotesSnapshots: readable,
+	consts.NotesComments:  readable,
 }
 
 // CheckReadable will abort the context and returns false if the doctype
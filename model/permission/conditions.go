@@ -0,0 +1,315 @@
+package permission
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const condSep = ";"
+const condKeySep = "="
+
+// Conditions are some restrictions on a rule that are evaluated on every
+// request: the rule can be used only during a time window, only from some IP
+// ranges, or only until the first read.
+type Conditions struct {
+	// Hours is a time window, like "08:00-18:00". It can span over midnight,
+	// like "22:00-06:00".
+	Hours string `json:"hours,omitempty"`
+
+	// Timezone is the location for the hours (UTC by default), like
+	// "Europe/Paris".
+	Timezone string `json:"timezone,omitempty"`
+
+	// IPRanges is a list of CIDR, like "192.168.1.0/24", for the IP addresses
+	// allowed to use the rule.
+	IPRanges []string `json:"ip_ranges,omitempty"`
+
+	// ReadOnce is true if the rule can only be used until the first read
+	ReadOnce bool `json:"read_once,omitempty"`
+
+	// ReadAt is the date of the first read for a read-once rule
+	ReadAt *time.Time `json:"read_at,omitempty"`
+
+	// reader marks the read-once rule as read when it grants a request
+	reader *onceReader
+}
+
+// onceReader calls a function to mark a read-once rule as read, the first time
+// that the rule is used for a request.
+type onceReader struct {
+	mu   sync.Mutex
+	fn   func() error
+	done bool
+}
+
+// Clone returns a copy of the conditions
+func (c *Conditions) Clone() *Conditions {
+	if c == nil {
+		return nil
+	}
+	cloned := *c
+	if c.IPRanges != nil {
+		cloned.IPRanges = make([]string, len(c.IPRanges))
+		copy(cloned.IPRanges, c.IPRanges)
+	}
+	if c.ReadAt != nil {
+		readAt := *c.ReadAt
+		cloned.ReadAt = &readAt
+	}
+	return &cloned
+}
+
+// Validate checks that the hours, timezone and IP ranges are well formed.
+func (c *Conditions) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Hours != "" {
+		if _, _, err := parseHours(c.Hours); err != nil {
+			return err
+		}
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return ErrBadConditions
+		}
+	}
+	for _, cidr := range c.IPRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return ErrBadConditions
+		}
+	}
+	return nil
+}
+
+// Consumed returns true for a read-once rule that has already been read.
+func (c *Conditions) Consumed() bool {
+	return c != nil && c.ReadOnce && c.ReadAt != nil
+}
+
+// OnRead sets the function called to mark the read-once rule as read, the
+// first time that it grants the current request. A read-once rule without this
+// function can't grant anything.
+func (c *Conditions) OnRead(fn func() error) {
+	c.reader = &onceReader{fn: fn}
+}
+
+// markRead returns true if the read-once rule can grant the current request,
+// and marks it as read.
+func (c *Conditions) markRead() bool {
+	if c.Consumed() || c.reader == nil {
+		return false
+	}
+	c.reader.mu.Lock()
+	defer c.reader.mu.Unlock()
+	if !c.reader.done {
+		if err := c.reader.fn(); err != nil {
+			return false
+		}
+		c.reader.done = true
+	}
+	return true
+}
+
+// Fulfilled returns true if a request made at the given time and from the
+// given IP address can use the rule. The read-once condition is not checked
+// here, see Consumed.
+func (c *Conditions) Fulfilled(now time.Time, ip net.IP) bool {
+	if c == nil {
+		return true
+	}
+	if c.Hours != "" && !c.inTimeWindow(now) {
+		return false
+	}
+	if len(c.IPRanges) > 0 && !c.inIPRanges(ip) {
+		return false
+	}
+	return true
+}
+
+func (c *Conditions) inTimeWindow(now time.Time) bool {
+	start, end, err := parseHours(c.Hours)
+	if err != nil {
+		return false
+	}
+	loc := time.UTC
+	if c.Timezone != "" {
+		if loc, err = time.LoadLocation(c.Timezone); err != nil {
+			return false
+		}
+	}
+	now = now.In(loc)
+	minutes := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return start <= minutes && minutes < end
+	}
+	return minutes >= start || minutes < end
+}
+
+func (c *Conditions) inIPRanges(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range c.IPRanges {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err == nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Includes returns true if the other conditions are at least as restrictive
+// as these conditions.
+func (c *Conditions) Includes(other *Conditions) bool {
+	if c == nil {
+		return true
+	}
+	if other == nil {
+		return false
+	}
+	if c.Hours != "" && (c.Hours != other.Hours || c.Timezone != other.Timezone) {
+		return false
+	}
+	if len(c.IPRanges) > 0 {
+		if len(other.IPRanges) == 0 {
+			return false
+		}
+		for _, cidr := range other.IPRanges {
+			if !contains(c.IPRanges, cidr) {
+				return false
+			}
+		}
+	}
+	if c.ReadOnce && !other.ReadOnce {
+		return false
+	}
+	return true
+}
+
+// parseHours parses a time window like "08:00-18:00", and returns the start
+// and the end as a number of minutes since midnight.
+func parseHours(hours string) (int, int, error) {
+	parts := strings.SplitN(hours, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, ErrBadConditions
+	}
+	start, err := parseHour(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseHour(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseHour(hour string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(hour), "%d:%d", &h, &m); err != nil {
+		return 0, ErrBadConditions
+	}
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, ErrBadConditions
+	}
+	return h*60 + m, nil
+}
+
+// marshalScopeString transforms the conditions into the suffix of a
+// scope string, like ";hours=08:00-18:00;tz=Europe/Paris;ip=10.0.0.0/8;once"
+func (c *Conditions) marshalScopeString() string {
+	if c == nil {
+		return ""
+	}
+	out := ""
+	if c.Hours != "" {
+		out += condSep + "hours" + condKeySep + c.Hours
+	}
+	if c.Timezone != "" {
+		out += condSep + "tz" + condKeySep + c.Timezone
+	}
+	if len(c.IPRanges) > 0 {
+		out += condSep + "ip" + condKeySep + strings.Join(c.IPRanges, valueSep)
+	}
+	if c.ReadOnce {
+		out += condSep + "once"
+	}
+	return out
+}
+
+// unmarshalConditionsString parses the conditions from the parts of a scope
+// string after the rule itself.
+func unmarshalConditionsString(parts []string) (*Conditions, error) {
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	c := &Conditions{}
+	for _, part := range parts {
+		kv := strings.SplitN(part, condKeySep, 2)
+		switch {
+		case kv[0] == "once" && len(kv) == 1:
+			c.ReadOnce = true
+		case kv[0] == "hours" && len(kv) == 2:
+			c.Hours = kv[1]
+		case kv[0] == "tz" && len(kv) == 2:
+			c.Timezone = kv[1]
+		case kv[0] == "ip" && len(kv) == 2:
+			c.IPRanges = strings.Split(kv[1], valueSep)
+		default:
+			return nil, ErrBadScope
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, ErrBadScope
+	}
+	return c, nil
+}
+
+// HasConditions returns true if at least one rule of the set has conditions.
+func (ps Set) HasConditions() bool {
+	return ps.Some(func(r Rule) bool {
+		return r.Conditions != nil
+	})
+}
+
+// ValidateConditions checks the conditions of all the rules of the set.
+func (ps Set) ValidateConditions() error {
+	for _, r := range ps {
+		if err := r.Conditions.Validate(); err != nil {
+			return err
+		}
+		if err := r.validateReadOnce(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConsumeReadOnce marks the read-once rule with the given title as read, and
+// saves the permission document.
+func ConsumeReadOnce(db prefixer.Prefixer, id, title string) error {
+	doc := &Permission{}
+	if err := couchdb.GetDoc(db, consts.Permissions, id, doc); err != nil {
+		return err
+	}
+	for i, r := range doc.Permissions {
+		if r.Title != title || r.Conditions == nil || !r.Conditions.ReadOnce {
+			continue
+		}
+		if r.Conditions.ReadAt != nil {
+			return nil
+		}
+		now := time.Now().UTC()
+		doc.Permissions[i].Conditions.ReadAt = &now
+		return couchdb.UpdateDoc(db, doc)
+	}
+	return nil
+}
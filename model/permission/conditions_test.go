@@ -0,0 +1,138 @@
+package permission
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionsHours(t *testing.T) {
+	c := &Conditions{Hours: "08:00-18:00"}
+	assert.NoError(t, c.Validate())
+	assert.True(t, c.Fulfilled(time.Date(2020, 1, 1, 8, 0, 0, 0, time.UTC), nil))
+	assert.True(t, c.Fulfilled(time.Date(2020, 1, 1, 17, 59, 0, 0, time.UTC), nil))
+	assert.False(t, c.Fulfilled(time.Date(2020, 1, 1, 18, 0, 0, 0, time.UTC), nil))
+	assert.False(t, c.Fulfilled(time.Date(2020, 1, 1, 7, 30, 0, 0, time.UTC), nil))
+
+	night := &Conditions{Hours: "22:00-06:00"}
+	assert.True(t, night.Fulfilled(time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC), nil))
+	assert.True(t, night.Fulfilled(time.Date(2020, 1, 1, 5, 0, 0, 0, time.UTC), nil))
+	assert.False(t, night.Fulfilled(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), nil))
+
+	paris := &Conditions{Hours: "08:00-18:00", Timezone: "Europe/Paris"}
+	assert.NoError(t, paris.Validate())
+	assert.True(t, paris.Fulfilled(time.Date(2020, 1, 1, 7, 30, 0, 0, time.UTC), nil))
+	assert.False(t, paris.Fulfilled(time.Date(2020, 1, 1, 17, 30, 0, 0, time.UTC), nil))
+
+	assert.Error(t, (&Conditions{Hours: "8h-18h"}).Validate())
+	assert.Error(t, (&Conditions{Hours: "08:00-25:00"}).Validate())
+	assert.Error(t, (&Conditions{Timezone: "Nowhere/Land"}).Validate())
+}
+
+func TestConditionsIPRanges(t *testing.T) {
+	c := &Conditions{IPRanges: []string{"192.168.1.0/24", "fd00::/8"}}
+	assert.NoError(t, c.Validate())
+	now := time.Now()
+	assert.True(t, c.Fulfilled(now, net.ParseIP("192.168.1.42")))
+	assert.True(t, c.Fulfilled(now, net.ParseIP("fd12::1")))
+	assert.False(t, c.Fulfilled(now, net.ParseIP("192.168.2.42")))
+	assert.False(t, c.Fulfilled(now, nil))
+
+	assert.Error(t, (&Conditions{IPRanges: []string{"192.168.1.0"}}).Validate())
+}
+
+func TestConditionsReadOnce(t *testing.T) {
+	c := &Conditions{ReadOnce: true}
+	assert.False(t, c.Consumed())
+	now := time.Now()
+	c.ReadAt = &now
+	assert.True(t, c.Consumed())
+}
+
+func TestAllowReadOnce(t *testing.T) {
+	once := Rule{
+		Title:      "once",
+		Type:       "io.cozy.events",
+		Verbs:      Verbs(GET),
+		Conditions: &Conditions{ReadOnce: true},
+	}
+	other := Rule{
+		Title:  "other",
+		Type:   "io.cozy.contacts",
+		Verbs:  Verbs(GET),
+		Values: []string{"contact-1"},
+	}
+
+	// A read-once rule can't be used outside of a request
+	set := Set{once, other}
+	assert.False(t, set.AllowWholeType(GET, "io.cozy.events"))
+
+	// It is marked as read only once per request
+	reads := 0
+	set[0].Conditions = once.Conditions.Clone()
+	set[0].Conditions.OnRead(func() error { reads++; return nil })
+	assert.True(t, set.AllowID(GET, "io.cozy.contacts", "contact-1"))
+	assert.Equal(t, 0, reads)
+	assert.False(t, set.AllowID(GET, "io.cozy.contacts", "contact-2"))
+	assert.Equal(t, 0, reads)
+	assert.True(t, set.AllowWholeType(GET, "io.cozy.events"))
+	assert.True(t, set.AllowID(GET, "io.cozy.events", "event-1"))
+	assert.Equal(t, 1, reads)
+
+	// The rule can't be used if it can't be marked as read
+	set[0].Conditions = once.Conditions.Clone()
+	set[0].Conditions.OnRead(func() error { return ErrInvalidToken })
+	assert.False(t, set.AllowWholeType(GET, "io.cozy.events"))
+
+	// And it can't be used after it has been read
+	now := time.Now()
+	set[0].Conditions = &Conditions{ReadOnce: true, ReadAt: &now}
+	set[0].Conditions.OnRead(func() error { reads++; return nil })
+	assert.False(t, set.AllowWholeType(GET, "io.cozy.events"))
+	assert.Equal(t, 1, reads)
+
+	e := set.ExplainID(GET, "io.cozy.events", "")
+	assert.False(t, e.Allowed)
+	assert.Equal(t, "the read-once rule has already been read", e.Rules[0].Reason)
+}
+
+func TestReadOnceForWrite(t *testing.T) {
+	write := Rule{
+		Type:       "io.cozy.files",
+		Verbs:      Verbs(GET, POST),
+		Conditions: &Conditions{ReadOnce: true},
+	}
+	_, err := write.MarshalScopeString()
+	assert.Equal(t, ErrBadConditions, err)
+	assert.Equal(t, ErrBadConditions, Set{write}.ValidateConditions())
+
+	all := Rule{Type: "io.cozy.files", Conditions: &Conditions{ReadOnce: true}}
+	assert.Equal(t, ErrBadConditions, Set{all}.ValidateConditions())
+
+	_, err = UnmarshalRuleString("io.cozy.files:PUT;once")
+	assert.Equal(t, ErrBadScope, err)
+	_, err = UnmarshalRuleString("io.cozy.files;once")
+	assert.Equal(t, ErrBadScope, err)
+	r, err := UnmarshalRuleString("io.cozy.files:GET;once")
+	assert.NoError(t, err)
+	assert.True(t, r.Conditions.ReadOnce)
+	assert.NoError(t, Set{r}.ValidateConditions())
+}
+
+func TestConditionsInSubset(t *testing.T) {
+	parent := Set{Rule{
+		Type:       "io.cozy.files",
+		Verbs:      Verbs(GET),
+		Conditions: &Conditions{IPRanges: []string{"10.0.0.0/8"}},
+	}}
+	withoutConditions := Set{Rule{Type: "io.cozy.files", Verbs: Verbs(GET)}}
+	assert.False(t, withoutConditions.IsSubSetOf(parent))
+	sameConditions := Set{Rule{
+		Type:       "io.cozy.files",
+		Verbs:      Verbs(GET),
+		Conditions: &Conditions{IPRanges: []string{"10.0.0.0/8"}, ReadOnce: true},
+	}}
+	assert.True(t, sameConditions.IsSubSetOf(parent))
+}
@@ -25,6 +25,10 @@ var (
 	ErrBadScope = echo.NewHTTPError(http.StatusBadRequest,
 		"Permission scope is empty or malformed")
 
+	// ErrBadConditions is used when the conditions of a rule are malformed
+	ErrBadConditions = echo.NewHTTPError(http.StatusBadRequest,
+		"Permission conditions are malformed")
+
 	// ErrNotSubset is returned on requests attempting to create a Set of
 	// permissions which is not a subset of the request's own token.
 	ErrNotSubset = echo.NewHTTPError(http.StatusForbidden,
@@ -0,0 +1,138 @@
+package permission
+
+import "fmt"
+
+// RuleCheck is the result of checking a rule of a permission set against a
+// verb and a document. It is used to explain why an access is granted or
+// rejected.
+type RuleCheck struct {
+	Index   int    `json:"index"`
+	Title   string `json:"title,omitempty"`
+	Rule    Rule   `json:"rule"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// Explanation is the detailed result of a permission check: the access is
+// granted if at least one rule has matched.
+type Explanation struct {
+	Allowed     bool        `json:"allowed"`
+	Verb        Verb        `json:"verb"`
+	DocType     string      `json:"type"`
+	DocID       string      `json:"id,omitempty"`
+	MatchedRule *int        `json:"matched_rule,omitempty"`
+	Reason      string      `json:"reason,omitempty"`
+	Rules       []RuleCheck `json:"rules"`
+}
+
+// NewExplanation returns an explanation where no rule has been checked yet.
+func NewExplanation(v Verb, doctype, id string) *Explanation {
+	return &Explanation{
+		Verb:    v,
+		DocType: doctype,
+		DocID:   id,
+		Rules:   []RuleCheck{},
+	}
+}
+
+// Add appends the result of the check of a rule to the explanation. The first
+// rule that has matched is the one that grants the access.
+func (e *Explanation) Add(index int, r Rule, matched bool, reason string) {
+	e.Rules = append(e.Rules, RuleCheck{
+		Index:   index,
+		Title:   r.Title,
+		Rule:    r,
+		Matched: matched,
+		Reason:  reason,
+	})
+	if matched && !e.Allowed {
+		e.Allowed = true
+		idx := index
+		e.MatchedRule = &idx
+		e.Reason = ""
+	}
+}
+
+// Finish sets the global reason if the access has been rejected.
+func (e *Explanation) Finish() *Explanation {
+	if !e.Allowed && e.Reason == "" {
+		if len(e.Rules) == 0 {
+			e.Reason = "the permission set has no rule"
+		} else {
+			e.Reason = "no rule of the permission set has matched"
+		}
+	}
+	return e
+}
+
+// CheckVerbAndType returns a reason if the rule can't be used for this verb
+// and doctype (or if it is a read-once rule already read), or an empty string
+// if it can.
+func CheckVerbAndType(r Rule, v Verb, doctype string) string {
+	if r.Conditions.Consumed() {
+		return "the read-once rule has already been read"
+	}
+	if r.Type != doctype {
+		return fmt.Sprintf("the rule is for the doctype %s", r.Type)
+	}
+	if !r.Verbs.Contains(v) {
+		return fmt.Sprintf("the rule doesn't allow the verb %s (only %s)", v, r.Verbs)
+	}
+	return ""
+}
+
+// Explain is like Allow, but it returns the details of the check of every
+// rule.
+func (s Set) Explain(v Verb, o Fetcher) *Explanation {
+	e := NewExplanation(v, o.DocType(), o.ID())
+	for i, r := range s {
+		if reason := CheckVerbAndType(r, v, o.DocType()); reason != "" {
+			e.Add(i, r, false, reason)
+			continue
+		}
+		switch {
+		case len(r.Values) == 0:
+			e.Add(i, r, true, "the rule gives access to the whole doctype")
+		case r.Selector == "":
+			if r.ValuesContain(o.ID()) {
+				e.Add(i, r, true, "the identifier of the document is in the values of the rule")
+			} else {
+				e.Add(i, r, false, "the identifier of the document is not in the values of the rule")
+			}
+		default:
+			if r.ValuesMatch(o) {
+				e.Add(i, r, true, fmt.Sprintf("the field %s of the document matches a value of the rule", r.Selector))
+			} else {
+				e.Add(i, r, false, fmt.Sprintf("the field %s of the document doesn't match any value of the rule", r.Selector))
+			}
+		}
+	}
+	return e.Finish()
+}
+
+// ExplainID is like AllowID (or AllowWholeType if id is empty), but it
+// returns the details of the check of every rule.
+func (s Set) ExplainID(v Verb, doctype, id string) *Explanation {
+	e := NewExplanation(v, doctype, id)
+	for i, r := range s {
+		if reason := CheckVerbAndType(r, v, doctype); reason != "" {
+			e.Add(i, r, false, reason)
+			continue
+		}
+		switch {
+		case len(r.Values) == 0:
+			e.Add(i, r, true, "the rule gives access to the whole doctype")
+		case id == "":
+			e.Add(i, r, false, "the rule is restricted to some documents, not the whole doctype")
+		case r.Selector == "":
+			if r.ValuesContain(id) {
+				e.Add(i, r, true, "the identifier of the document is in the values of the rule")
+			} else {
+				e.Add(i, r, false, "the identifier of the document is not in the values of the rule")
+			}
+		default:
+			e.Add(i, r, false, fmt.Sprintf("the rule has a selector on %s, and the document is not available to check it", r.Selector))
+		}
+	}
+	return e.Finish()
+}
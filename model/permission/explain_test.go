@@ -0,0 +1,59 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	set := Set{
+		Rule{Type: "io.cozy.contacts", Verbs: ALL},
+		Rule{Type: "io.cozy.events", Verbs: Verbs(GET), Values: []string{"abc"}},
+		Rule{Type: "io.cozy.events", Verbs: ALL, Selector: "calendar", Values: []string{"work"}},
+	}
+
+	doc := &validable{id: "abc", doctype: "io.cozy.events", values: map[string]string{"calendar": "perso"}}
+	e := set.Explain(GET, doc)
+	assert.True(t, e.Allowed)
+	assert.Equal(t, 1, *e.MatchedRule)
+	assert.Len(t, e.Rules, 3)
+	assert.False(t, e.Rules[0].Matched)
+	assert.Contains(t, e.Rules[0].Reason, "io.cozy.contacts")
+	assert.True(t, e.Rules[1].Matched)
+	assert.False(t, e.Rules[2].Matched)
+
+	e = set.Explain(PUT, doc)
+	assert.False(t, e.Allowed)
+	assert.Nil(t, e.MatchedRule)
+	assert.NotEmpty(t, e.Reason)
+	assert.Contains(t, e.Rules[1].Reason, "PUT")
+	assert.Contains(t, e.Rules[2].Reason, "calendar")
+
+	doc.values["calendar"] = "work"
+	e = set.Explain(PUT, doc)
+	assert.True(t, e.Allowed)
+	assert.Equal(t, 2, *e.MatchedRule)
+}
+
+func TestExplainID(t *testing.T) {
+	set := Set{
+		Rule{Type: "io.cozy.contacts", Verbs: ALL},
+		Rule{Type: "io.cozy.events", Verbs: Verbs(GET), Values: []string{"abc"}},
+	}
+
+	e := set.ExplainID(GET, "io.cozy.contacts", "")
+	assert.True(t, e.Allowed)
+	assert.Equal(t, 0, *e.MatchedRule)
+
+	e = set.ExplainID(GET, "io.cozy.events", "")
+	assert.False(t, e.Allowed)
+
+	e = set.ExplainID(GET, "io.cozy.events", "abc")
+	assert.True(t, e.Allowed)
+	assert.Equal(t, 1, *e.MatchedRule)
+
+	e = Set{}.ExplainID(GET, "io.cozy.events", "abc")
+	assert.False(t, e.Allowed)
+	assert.Equal(t, "the permission set has no rule", e.Reason)
+}
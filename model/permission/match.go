@@ -49,32 +49,64 @@ func matchID(r Rule, id string) bool {
 	return r.Selector == "" && r.ValuesContain(id)
 }
 
+// AllowsWith returns true if the check function allows the access with the
+// rules of the set. The rules without the read-once condition are tried
+// first, then the read-once rules one by one, and the read-once rule that
+// allows the access is marked as read.
+func (s Set) AllowsWith(check func(set Set) bool) bool {
+	var others, once Set
+	for _, r := range s {
+		if r.Conditions != nil && r.Conditions.ReadOnce {
+			once = append(once, r)
+		} else {
+			others = append(others, r)
+		}
+	}
+	if len(others) > 0 && check(others) {
+		return true
+	}
+	for _, r := range once {
+		if check(Set{r}) && r.Conditions.markRead() {
+			return true
+		}
+	}
+	return false
+}
+
 // AllowWholeType returns true if the set allows to apply verb to every
 // document from the given doctypes (ie. r.values == 0)
 func (s Set) AllowWholeType(v Verb, doctype string) bool {
-	return s.Some(func(r Rule) bool {
-		return matchVerbAndType(r, v, doctype) && matchWholeType(r)
+	return s.AllowsWith(func(set Set) bool {
+		return set.Some(func(r Rule) bool {
+			return matchVerbAndType(r, v, doctype) && matchWholeType(r)
+		})
 	})
 }
 
 // AllowID returns true if the set allows to apply verb to given type & id
 func (s Set) AllowID(v Verb, doctype, id string) bool {
-	return s.Some(func(r Rule) bool {
-		return matchVerbAndType(r, v, doctype) && (matchWholeType(r) || matchID(r, id))
+	return s.AllowsWith(func(set Set) bool {
+		return set.Some(func(r Rule) bool {
+			return matchVerbAndType(r, v, doctype) && (matchWholeType(r) || matchID(r, id))
+		})
 	})
 }
 
 // Allow returns true if the set allows to apply verb to given doc
 func (s Set) Allow(v Verb, o Fetcher) bool {
-	return s.Some(func(r Rule) bool {
-		return matchVerbAndType(r, v, o.DocType()) && matchValues(r, o)
+	return s.AllowsWith(func(set Set) bool {
+		return set.Some(func(r Rule) bool {
+			return matchVerbAndType(r, v, o.DocType()) && matchValues(r, o)
+		})
 	})
 }
 
 // AllowOnFields returns true if the set allows to apply verb to given doc on
 // the specified fields.
 func (s Set) AllowOnFields(v Verb, o Fetcher, fields ...string) bool {
-	return s.Some(func(r Rule) bool {
-		return matchVerbAndType(r, v, o.DocType()) && matchOnFields(r, o, fields...)
+	return s.AllowsWith(func(set Set) bool {
+		return set.Some(func(r Rule) bool {
+			return matchVerbAndType(r, v, o.DocType()) && matchOnFields(r, o, fields...)
+		})
 	})
 }
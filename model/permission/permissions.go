@@ -57,6 +57,10 @@ const (
 	// TypeSharePreview is the value of Permission.Type to preview a
 	// cozy-to-cozy sharing
 	TypeSharePreview = "share-preview"
+
+	// TypeShareGuest is the value of Permission.Type for the guests of a
+	// cozy-to-cozy sharing, ie the members that don't have a cozy
+	TypeShareGuest = "share-guest"
 )
 
 // ID implements jsonapi.Doc
@@ -87,6 +91,7 @@ func (p *Permission) Clone() couchdb.Doc {
 		vals := r.Values
 		r.Values = make([]string, len(r.Values))
 		copy(r.Values, vals)
+		r.Conditions = r.Conditions.Clone()
 		cloned.Permissions[i] = r
 	}
 	return &cloned
@@ -209,6 +214,11 @@ func GetForSharePreview(db prefixer.Prefixer, sharingID string) (*Permission, er
 	return getFromSource(db, TypeSharePreview, consts.Sharings, sharingID)
 }
 
+// GetForShareGuest retrieves the Permission doc for the guests of a sharing
+func GetForShareGuest(db prefixer.Prefixer, sharingID string) (*Permission, error) {
+	return getFromSource(db, TypeShareGuest, consts.Sharings, sharingID)
+}
+
 func getFromSource(db prefixer.Prefixer, permType, docType, slug string) (*Permission, error) {
 	var res []Permission
 	req := couchdb.FindRequest{
@@ -447,6 +457,10 @@ func CreateShareSet(db prefixer.Prefixer, parent *Permission, sourceID string, c
 		return nil, ErrOnlyAppCanCreateSubSet
 	}
 
+	if err := set.ValidateConditions(); err != nil {
+		return nil, err
+	}
+
 	if !set.IsSubSetOf(parent.Permissions) {
 		return nil, ErrNotSubset
 	}
@@ -496,6 +510,22 @@ func CreateSharePreviewSet(db prefixer.Prefixer, sharingID string, codes map[str
 	return doc, nil
 }
 
+// CreateShareGuestSet creates a Permission doc for the guests of a sharing
+func CreateShareGuestSet(db prefixer.Prefixer, sharingID string, codes map[string]string, subdoc Permission) (*Permission, error) {
+	doc := &Permission{
+		Type:        TypeShareGuest,
+		Permissions: subdoc.Permissions,
+		Codes:       codes,
+		SourceID:    consts.Sharings + "/" + sharingID,
+		Metadata:    subdoc.Metadata,
+	}
+	err := couchdb.CreateDoc(db, doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
 // ForceWebapp creates or updates a Permission doc for a given webapp
 func ForceWebapp(db prefixer.Prefixer, slug string, set Set) error {
 	existing, _ := GetForWebapp(db, slug)
@@ -237,6 +237,44 @@ func TestStringToSet(t *testing.T) {
 	assert.Equal(t, "calendar-id", rule.Selector)
 }
 
+func TestScopeStringWithConditions(t *testing.T) {
+	s := Set{
+		Rule{
+			Type:   "io.cozy.files",
+			Verbs:  Verbs(GET),
+			Values: []string{"io.cozy.files.music-dir"},
+			Conditions: &Conditions{
+				Hours:    "08:00-18:00",
+				Timezone: "Europe/Paris",
+				IPRanges: []string{"192.168.1.0/24", "fd00::/8"},
+			},
+		},
+		Rule{
+			Type:       "io.cozy.contacts",
+			Verbs:      Verbs(GET),
+			Conditions: &Conditions{ReadOnce: true},
+		},
+	}
+
+	out, err := s.MarshalScopeString()
+	assert.NoError(t, err)
+	assert.Equal(t, "io.cozy.files:GET:io.cozy.files.music-dir;hours=08:00-18:00;tz=Europe/Paris;ip=192.168.1.0/24,fd00::/8 io.cozy.contacts:GET;once", out)
+
+	set, err := UnmarshalScopeString(out)
+	assert.NoError(t, err)
+	assert.Len(t, set, 2)
+	assert.Equal(t, Verbs(GET), set[0].Verbs)
+	assert.Equal(t, []string{"io.cozy.files.music-dir"}, set[0].Values)
+	assert.Equal(t, s[0].Conditions, set[0].Conditions)
+	assert.Equal(t, "io.cozy.contacts", set[1].Type)
+	assert.True(t, set[1].Conditions.ReadOnce)
+
+	_, err = UnmarshalRuleString("io.cozy.files:GET;hours=8h-18h")
+	assert.Error(t, err)
+	_, err = UnmarshalRuleString("io.cozy.files:GET;unknown")
+	assert.Error(t, err)
+}
+
 func TestAllowType(t *testing.T) {
 	s := Set{Rule{Type: "io.cozy.contacts"}}
 	assert.True(t, s.Allow(GET, &validable{doctype: "io.cozy.contacts"}))
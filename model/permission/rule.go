@@ -33,11 +33,18 @@ type Rule struct {
 	// Selector is the field which must be one of Values.
 	Selector string   `json:"selector,omitempty"`
 	Values   []string `json:"values,omitempty"`
+
+	// Conditions are optional restrictions evaluated on every request.
+	Conditions *Conditions `json:"conditions,omitempty"`
 }
 
 // MarshalScopeString transform a Rule into a string of the shape
-// io.cozy.files:GET:io.cozy.files.music-dir
+// io.cozy.files:GET:io.cozy.files.music-dir, optionally followed by the
+// conditions, like io.cozy.files:GET:io.cozy.files.music-dir;hours=08:00-18:00
 func (r Rule) MarshalScopeString() (string, error) {
+	if err := r.validateReadOnce(); err != nil {
+		return "", err
+	}
 	out := r.Type
 	hasVerbs := len(r.Verbs) != 0
 	hasValues := len(r.Values) != 0
@@ -55,13 +62,20 @@ func (r Rule) MarshalScopeString() (string, error) {
 		out += partSep + r.Selector
 	}
 
+	out += r.Conditions.marshalScopeString()
 	return out, nil
 }
 
 // UnmarshalRuleString parse a scope formated rule
 func UnmarshalRuleString(in string) (Rule, error) {
 	var out Rule
-	parts := strings.Split(in, partSep)
+	conds := strings.Split(in, condSep)
+	conditions, err := unmarshalConditionsString(conds[1:])
+	if err != nil {
+		return out, err
+	}
+	out.Conditions = conditions
+	parts := strings.Split(conds[0], partSep)
 	switch len(parts) {
 	case 4:
 		out.Selector = parts[3]
@@ -80,9 +94,21 @@ func UnmarshalRuleString(in string) (Rule, error) {
 	default:
 		return out, ErrBadScope
 	}
+	if err := out.validateReadOnce(); err != nil {
+		return out, ErrBadScope
+	}
 	return out, nil
 }
 
+// validateReadOnce checks that a read-once rule can only be used for reading:
+// it is consumed by the first request, and a write can't be undone.
+func (r Rule) validateReadOnce() error {
+	if r.Conditions != nil && r.Conditions.ReadOnce && !r.Verbs.ReadOnly() {
+		return ErrBadConditions
+	}
+	return nil
+}
+
 // SomeValue returns true if any value statisfy the predicate
 func (r Rule) SomeValue(predicate func(v string) bool) bool {
 	for _, v := range r.Values {
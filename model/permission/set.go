@@ -157,6 +157,10 @@ func (ps *Set) RuleInSubset(r2 Rule) bool {
 			continue
 		}
 
+		if !r.Conditions.Includes(r2.Conditions) {
+			continue
+		}
+
 		if r.Selector == "" && len(r.Values) == 0 {
 			return true
 		}
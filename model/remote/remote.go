@@ -1,6 +1,7 @@
 package remote
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"path"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -95,6 +97,8 @@ type Request struct {
 	ResponseCode  int               `json:"response_code"`
 	ContentType   string            `json:"content_type"`
 	Variables     map[string]string `json:"variables"`
+	Secrets       []string          `json:"secrets,omitempty"`
+	Cached        bool              `json:"cached,omitempty"`
 	CreatedAt     time.Time         `json:"created_at"`
 }
 
@@ -120,6 +124,10 @@ func (r *Request) Clone() couchdb.Doc {
 	for k, v := range r.Variables {
 		cloned.Variables[k] = v
 	}
+	if r.Secrets != nil {
+		cloned.Secrets = make([]string, len(r.Secrets))
+		copy(cloned.Secrets, r.Secrets)
+	}
 	return &cloned
 }
 
@@ -129,6 +137,19 @@ type Remote struct {
 	URL     *url.URL
 	Headers map[string]string
 	Body    string
+
+	// CacheTTL is the duration for which the responses are kept in cache (0
+	// means no cache)
+	CacheTTL time.Duration
+	// CacheKey is a template for the key of the cache (by default, the key is
+	// computed from the request)
+	CacheKey string
+	// Extract is the path of the value to extract from the JSON response
+	Extract string
+	// Mapping is used to build a new object from the fields of the (extracted)
+	// JSON response: the keys are the names of the new fields, and the values
+	// are their paths in the response
+	Mapping map[string]string
 }
 
 var log = logger.WithNamespace("remote")
@@ -174,7 +195,15 @@ func ParseRawRequest(doctype, raw string) (*Remote, error) {
 			log.Infof("Invalid header for remote doctype %s: %s", doctype, line)
 			return nil, ErrInvalidRequest
 		}
-		remote.Headers[parts[0]] = strings.TrimSpace(parts[1])
+		name, value := parts[0], strings.TrimSpace(parts[1])
+		isDirective, err := remote.parseDirective(name, value)
+		if err != nil {
+			log.Infof("Invalid %s for remote doctype %s: %s", name, doctype, value)
+			return nil, ErrInvalidRequest
+		}
+		if !isDirective {
+			remote.Headers[name] = value
+		}
 	}
 	return &remote, nil
 }
@@ -250,6 +279,22 @@ func extractVariables(verb string, in *http.Request) (map[string]string, error)
 	return vars, nil
 }
 
+// ExtractVariables returns the variables given by the client for the
+// request. The variables reserved for the secrets are rejected.
+func (remote *Remote) ExtractVariables(in *http.Request) (map[string]string, error) {
+	vars, err := extractVariables(remote.Verb, in)
+	if err != nil {
+		log.Infof("Error on extracting variables: %s", err)
+		return nil, ErrInvalidVariables
+	}
+	for name := range vars {
+		if isSecretVariable(name) {
+			return nil, ErrReservedVariable
+		}
+	}
+	return vars, nil
+}
+
 var injectionRegexp = regexp.MustCompile(`{{[0-9A-Za-z_ ]+}}`)
 
 func injectVar(src string, vars map[string]string, defautFunc string) (string, error) {
@@ -334,14 +379,34 @@ func injectVariables(remote *Remote, vars map[string]string) error {
 	return err
 }
 
-// ProxyTo calls the external website and proxy the response
-func (remote *Remote) ProxyTo(doctype string, ins *instance.Instance, rw http.ResponseWriter, in *http.Request) error {
-	vars, err := extractVariables(remote.Verb, in)
+// ProxyTo calls the external website and proxy the response. The secrets
+// used by the template are injected with the variables, but they are not
+// logged.
+func (remote *Remote) ProxyTo(doctype string, ins *instance.Instance, rw http.ResponseWriter, vars map[string]string) error {
+	secrets, err := remote.loadSecrets(ins, vars)
 	if err != nil {
-		log.Infof("Error on extracting variables: %s", err)
-		return ErrInvalidVariables
+		return err
+	}
+	all := make(map[string]string, len(vars)+len(secrets))
+	redacted := make(map[string]string, len(vars)+len(secrets))
+	for k, v := range vars {
+		all[k] = v
+		redacted[k] = v
+	}
+	secretNames := make([]string, 0, len(secrets))
+	for k, v := range secrets {
+		all[k] = v
+		redacted[k] = redactedSecret
+		secretNames = append(secretNames, k)
 	}
-	if err = injectVariables(remote, vars); err != nil {
+	sort.Strings(secretNames)
+
+	// Keep a copy of the URL template to log the URL without the secrets
+	loggedURL := *remote.URL
+	if err = injectVariables(remote, all); err != nil {
+		return err
+	}
+	if err = injectVariables(&Remote{URL: &loggedURL}, redacted); err != nil {
 		return err
 	}
 
@@ -352,6 +417,33 @@ func (remote *Remote) ProxyTo(doctype string, ins *instance.Instance, rw http.Re
 	}
 	remote.URL.User = nil
 	remote.URL.Fragment = ""
+	loggedURL.User = nil
+	loggedURL.Fragment = ""
+
+	logged := &Request{
+		RemoteDoctype: doctype,
+		Verb:          remote.Verb,
+		URL:           loggedURL.String(),
+		Variables:     vars,
+		Secrets:       secretNames,
+	}
+
+	cacheKey, err := remote.cacheKeyFor(doctype, ins, vars, secrets)
+	if err != nil {
+		return err
+	}
+	if cacheKey != "" {
+		if cached, ok := getCachedResponse(cacheKey); ok {
+			logged.ResponseCode = cached.StatusCode
+			logged.ContentType, _, _ = mime.ParseMediaType(cached.ContentType)
+			logged.Cached = true
+			logRequest(ins, logged)
+			if err = cached.writeTo(rw); err != nil {
+				log.Infof("Error on copying cached response from %s: %s", loggedURL.String(), err)
+			}
+			return nil
+		}
+	}
 
 	var body io.Reader
 	if remote.Verb != "GET" && remote.Verb != "DELETE" {
@@ -369,14 +461,14 @@ func (remote *Remote) ProxyTo(doctype string, ins *instance.Instance, rw http.Re
 
 	res, err := remoteClient.Do(req)
 	if err != nil {
-		log.Infof("Error on request %s: %s", remote.URL.String(), err)
+		log.Infof("Error on request %s: %s", loggedURL.String(), err)
 		return ErrRequestFailed
 	}
 	defer res.Body.Close()
 
 	ctype, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
 	if err != nil {
-		log.Infof("request %s has an invalid content-type", remote.URL.String())
+		log.Infof("request %s has an invalid content-type", loggedURL.String())
 		return ErrInvalidContentType
 	}
 	if ctype != "application/json" &&
@@ -388,35 +480,91 @@ func (remote *Remote) ProxyTo(doctype string, ins *instance.Instance, rw http.Re
 		class := strings.SplitN(ctype, "/", 2)[0]
 		if class != "image" && class != "audio" && class != "video" {
 			log.Infof("request %s has a content-type that is not allowed: %s",
-				remote.URL.String(), ctype)
+				loggedURL.String(), ctype)
 			return ErrInvalidContentType
 		}
 	}
 
-	logged := &Request{
-		RemoteDoctype: doctype,
-		Verb:          remote.Verb,
-		URL:           remote.URL.String(),
-		ResponseCode:  res.StatusCode,
-		ContentType:   ctype,
-		Variables:     vars,
-		CreatedAt:     time.Now(),
+	logged.ResponseCode = res.StatusCode
+	logged.ContentType = ctype
+	logRequest(ins, logged)
+
+	// Only the successful responses are cached and transformed
+	success := res.StatusCode >= 200 && res.StatusCode < 300
+	encoding := res.Header.Get("Content-Encoding")
+	encoded := encoding != "" && encoding != "identity"
+	if success && encoded && remote.transforms() {
+		log.Infof("request %s has a content-encoding that cannot be transformed: %s",
+			loggedURL.String(), encoding)
+		return ErrInvalidResponse
 	}
-	err = couchdb.CreateDoc(ins, logged)
+	if !success || encoded || (cacheKey == "" && !remote.transforms()) {
+		copyHeader(rw.Header(), res.Header)
+		rw.WriteHeader(res.StatusCode)
+		_, err = io.Copy(rw, res.Body)
+		if err != nil {
+			log.Infof("Error on copying response from %s: %s", loggedURL.String(), err)
+		}
+		return nil
+	}
+
+	if remote.transforms() && !isJSONContentType(ctype) {
+		log.Infof("request %s has a content-type that cannot be transformed: %s",
+			loggedURL.String(), ctype)
+		return ErrInvalidResponse
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(res.Body, maxProcessedBodySize+1))
 	if err != nil {
-		log.Errorf("Can't save remote request: %s", err)
+		log.Infof("Error on reading response from %s: %s", loggedURL.String(), err)
+		return ErrRequestFailed
+	}
+	if len(buf) > maxProcessedBodySize {
+		if remote.transforms() {
+			log.Infof("response from %s is too large to be transformed", loggedURL.String())
+			return ErrInvalidResponse
+		}
+		// The response is too large to be cached, so it is just proxied
+		copyHeader(rw.Header(), res.Header)
+		rw.WriteHeader(res.StatusCode)
+		_, err = io.Copy(rw, io.MultiReader(bytes.NewReader(buf), res.Body))
+		if err != nil {
+			log.Infof("Error on copying response from %s: %s", loggedURL.String(), err)
+		}
+		return nil
+	}
+
+	response := &cachedResponse{
+		StatusCode:  res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+		Body:        buf,
+	}
+	if remote.transforms() {
+		if response.Body, err = remote.transform(buf); err != nil {
+			log.Infof("response from %s cannot be transformed", loggedURL.String())
+			return err
+		}
+		response.ContentType = "application/json"
+	}
+	if cacheKey != "" {
+		setCachedResponse(cacheKey, response, remote.CacheTTL)
 	}
-	log.Debugf("Remote request: %#v\n", logged)
 
 	copyHeader(rw.Header(), res.Header)
-	rw.WriteHeader(res.StatusCode)
-	_, err = io.Copy(rw, res.Body)
-	if err != nil {
-		log.Infof("Error on copying response from %s: %s", remote.URL.String(), err)
+	if err = response.writeTo(rw); err != nil {
+		log.Infof("Error on copying response from %s: %s", loggedURL.String(), err)
 	}
 	return nil
 }
 
+// logRequest saves the request in the logs of the remote requests.
+func logRequest(ins *instance.Instance, logged *Request) {
+	logged.CreatedAt = time.Now()
+	if err := couchdb.CreateDoc(ins, logged); err != nil {
+		log.Errorf("Can't save remote request: %s", err)
+	}
+	log.Debugf("Remote request: %#v\n", logged)
+}
+
 // ProxyRemoteAsset proxy the given http request to fetch an asset from our
 // list of available asset list.
 func ProxyRemoteAsset(name string, w http.ResponseWriter) error {
@@ -5,8 +5,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -132,3 +136,195 @@ Accept-Language: {{lang}},en
 	err = injectVariables(r, vars)
 	assert.Equal(t, ErrMissingVar, err)
 }
+
+func TestSecretNames(t *testing.T) {
+	raw := `POST https://example.org/{{secret_path}}?key={{secret_api_key}}&q={{q}}
+Authorization: Bearer {{account_access_token}}
+
+{ "key": "{{json secret_api_key}}", "account": "{{json account}}" }`
+	r, err := ParseRawRequest(doctype, raw)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"account_access_token", "secret_api_key", "secret_path"}, r.secretNames())
+	assert.True(t, r.NeedsAccount())
+
+	r, err = ParseRawRequest(doctype, `GET https://example.org/?key={{secret_api_key}}`)
+	assert.NoError(t, err)
+	assert.False(t, r.NeedsAccount())
+}
+
+func TestExtractVariablesReserved(t *testing.T) {
+	r, err := ParseRawRequest(doctype, `GET https://example.org/?key={{secret_api_key}}&q={{q}}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	u, err := url.Parse("https://example.org/foo?q=un&account=123")
+	assert.NoError(t, err)
+	vars, err := r.ExtractVariables(&http.Request{URL: u})
+	assert.NoError(t, err)
+	assert.Equal(t, "123", vars["account"])
+
+	u, err = url.Parse("https://example.org/foo?q=un&secret_api_key=foo")
+	assert.NoError(t, err)
+	_, err = r.ExtractVariables(&http.Request{URL: u})
+	assert.Equal(t, ErrReservedVariable, err)
+
+	u, err = url.Parse("https://example.org/foo?account_access_token=foo")
+	assert.NoError(t, err)
+	_, err = r.ExtractVariables(&http.Request{URL: u})
+	assert.Equal(t, ErrReservedVariable, err)
+}
+
+func TestContextSecret(t *testing.T) {
+	conf := config.GetConfig()
+	backup := conf.RemoteSecrets
+	conf.RemoteSecrets = map[string]map[string]string{
+		"default": {"api_key": "default-key", "other": "other-key"},
+		"foo":     {"api_key": "foo-key"},
+	}
+	defer func() { conf.RemoteSecrets = backup }()
+
+	inst := &instance.Instance{ContextName: "foo"}
+	value, ok := contextSecret(inst, "api_key")
+	assert.True(t, ok)
+	assert.Equal(t, "foo-key", value)
+	value, ok = contextSecret(inst, "OTHER")
+	assert.True(t, ok)
+	assert.Equal(t, "other-key", value)
+	_, ok = contextSecret(inst, "missing")
+	assert.False(t, ok)
+
+	value, ok = contextSecret(&instance.Instance{}, "api_key")
+	assert.True(t, ok)
+	assert.Equal(t, "default-key", value)
+
+	r, err := ParseRawRequest(doctype, `GET https://example.org/?key={{secret_api_key}}&x={{secret_missing}}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = r.loadSecrets(inst, map[string]string{})
+	assert.Equal(t, ErrMissingSecret, err)
+}
+
+func TestMain(m *testing.M) {
+	config.UseTestFile()
+	os.Exit(m.Run())
+}
+
+func TestParseDirectives(t *testing.T) {
+	raw := `GET https://example.org/search?q={{q}}
+Accept: application/json
+Cozy-Cache-TTL: 10m
+Cozy-Cache-Key: search-{{q}}
+Cozy-Extract: data.items
+Cozy-Map: id=id, title=info.title, author=info.authors.0`
+	r, err := ParseRawRequest(doctype, raw)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]string{"Accept": "application/json"}, r.Headers)
+	assert.Equal(t, 10*time.Minute, r.CacheTTL)
+	assert.Equal(t, "search-{{q}}", r.CacheKey)
+	assert.Equal(t, "data.items", r.Extract)
+	assert.Equal(t, map[string]string{
+		"id":     "id",
+		"title":  "info.title",
+		"author": "info.authors.0",
+	}, r.Mapping)
+
+	for _, directive := range []string{
+		"Cozy-Cache-TTL: forever",
+		"Cozy-Cache-TTL: -1s",
+		"Cozy-Extract: data..items",
+		"Cozy-Map: title",
+		"Cozy-Map: =info.title",
+	} {
+		_, err = ParseRawRequest(doctype, "GET https://example.org/\n"+directive)
+		assert.Equal(t, ErrInvalidRequest, err, directive)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	body := []byte(`{"data": {"total": 2, "items": [
+		{"id": "1", "info": {"title": "Foo", "authors": ["Alice", "Bob"]}},
+		{"id": "2", "info": {"title": "Bar"}}
+	]}}`)
+
+	r := &Remote{Extract: "data.total"}
+	out, err := r.transform(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `2`, string(out))
+
+	r = &Remote{Extract: "data.items.1.info"}
+	out, err = r.transform(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"title":"Bar"}`, string(out))
+
+	r = &Remote{Extract: "data.missing"}
+	out, err = r.transform(body)
+	assert.NoError(t, err)
+	assert.Equal(t, `null`, string(out))
+
+	r = &Remote{
+		Extract: "data.items",
+		Mapping: map[string]string{"id": "id", "title": "info.title", "author": "info.authors.0"},
+	}
+	out, err = r.transform(body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"id": "1", "title": "Foo", "author": "Alice"},
+		{"id": "2", "title": "Bar", "author": null}
+	]`, string(out))
+
+	r = &Remote{Mapping: map[string]string{"count": "data.total"}}
+	out, err = r.transform(body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"count": 2}`, string(out))
+
+	_, err = r.transform([]byte(`<xml/>`))
+	assert.Equal(t, ErrInvalidResponse, err)
+}
+
+func TestCacheKey(t *testing.T) {
+	inst := &instance.Instance{Domain: "alice.example.net", ContextName: "foo"}
+	r, err := ParseRawRequest(doctype, `GET https://example.org/?q={{q}}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	key, err := r.cacheKeyFor(doctype, inst, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, key)
+
+	raw := `GET https://example.org/?q={{q}}
+Cozy-Cache-TTL: 1h`
+	r1, _ := ParseRawRequest(doctype, raw)
+	assert.NoError(t, injectVariables(r1, map[string]string{"q": "one"}))
+	k1, err := r1.cacheKeyFor(doctype, inst, nil, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, k1, "remote:"+doctype+":")
+	r2, _ := ParseRawRequest(doctype, raw)
+	assert.NoError(t, injectVariables(r2, map[string]string{"q": "two"}))
+	k2, err := r2.cacheKeyFor(doctype, inst, nil, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, k1, k2)
+
+	raw = `GET https://example.org/?q={{q}}
+Authorization: Bearer {{account_access_token}}
+Cozy-Cache-TTL: 1h
+Cozy-Cache-Key: {{q}}`
+	r3, _ := ParseRawRequest(doctype, raw)
+	vars := map[string]string{"q": "one", "account": "123"}
+	k3, err := r3.cacheKeyFor(doctype, inst, vars, nil)
+	assert.NoError(t, err)
+	secrets := map[string]string{"account_access_token": "token"}
+	k4, err := r3.cacheKeyFor(doctype, inst, vars, secrets)
+	assert.NoError(t, err)
+	assert.NotEqual(t, k3, k4)
+	vars["account"] = "456"
+	k5, err := r3.cacheKeyFor(doctype, inst, vars, secrets)
+	assert.NoError(t, err)
+	assert.NotEqual(t, k4, k5)
+	_, err = r3.cacheKeyFor(doctype, inst, map[string]string{}, nil)
+	assert.Equal(t, ErrMissingVar, err)
+}
@@ -0,0 +1,217 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+)
+
+// ErrInvalidResponse is used when the response of the remote website can't
+// be transformed as declared in the request
+var ErrInvalidResponse = errors.New("the response cannot be transformed")
+
+// The directives are written like headers in the request, but they are not
+// sent to the remote website.
+const (
+	directiveCacheTTL = "Cozy-Cache-Ttl"
+	directiveCacheKey = "Cozy-Cache-Key"
+	directiveExtract  = "Cozy-Extract"
+	directiveMap      = "Cozy-Map"
+)
+
+// maxProcessedBodySize is the maximal size of a response that can be cached
+// or transformed.
+const maxProcessedBodySize = 5 * 1024 * 1024
+
+// parseDirective reads the directives for the cache and the transformation
+// of the response. It returns false if the header is not a directive.
+func (remote *Remote) parseDirective(name, value string) (bool, error) {
+	switch http.CanonicalHeaderKey(name) {
+	case directiveCacheTTL:
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return true, ErrInvalidRequest
+		}
+		remote.CacheTTL = ttl
+	case directiveCacheKey:
+		if value == "" {
+			return true, ErrInvalidRequest
+		}
+		remote.CacheKey = value
+	case directiveExtract:
+		if !isValidPath(value) {
+			return true, ErrInvalidRequest
+		}
+		remote.Extract = value
+	case directiveMap:
+		mapping := make(map[string]string)
+		for _, field := range strings.Split(value, ",") {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return true, ErrInvalidRequest
+			}
+			key, path := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			if key == "" || !isValidPath(path) {
+				return true, ErrInvalidRequest
+			}
+			mapping[key] = path
+		}
+		remote.Mapping = mapping
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func isValidPath(path string) bool {
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// transforms returns true if the response must be transformed.
+func (remote *Remote) transforms() bool {
+	return remote.Extract != "" || len(remote.Mapping) > 0
+}
+
+// cacheKeyFor returns the key used to cache the response, or an empty string
+// if the response is not cached. The variables must have been injected in
+// the request.
+func (remote *Remote) cacheKeyFor(doctype string, ins *instance.Instance, vars, secrets map[string]string) (string, error) {
+	if remote.CacheTTL == 0 {
+		return "", nil
+	}
+	h := sha256.New()
+	if remote.CacheKey != "" {
+		key, err := injectVar(remote.CacheKey, vars, "")
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(key))
+		// The responses for the requests authenticated with secrets are not
+		// shared with the instances that use other secrets.
+		if len(secrets) > 0 {
+			h.Write([]byte{0})
+			h.Write([]byte(ins.ContextName))
+		}
+		if _, ok := secrets[accountTokenVariable]; ok {
+			h.Write([]byte{0})
+			h.Write([]byte(ins.Domain + "/" + vars[AccountVariable]))
+		}
+	} else {
+		h.Write([]byte(remote.Verb + " " + remote.URL.String()))
+		names := make([]string, 0, len(remote.Headers))
+		for name := range remote.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			h.Write([]byte("\n" + name + ": " + remote.Headers[name]))
+		}
+		h.Write([]byte("\n\n" + remote.Body))
+	}
+	return "remote:" + doctype + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// transform applies the extraction and the mapping on the JSON body.
+func (remote *Remote) transform(body []byte) ([]byte, error) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	if remote.Extract != "" {
+		data = valueAt(data, remote.Extract)
+	}
+	if len(remote.Mapping) > 0 {
+		if list, ok := data.([]interface{}); ok {
+			mapped := make([]interface{}, len(list))
+			for i, item := range list {
+				mapped[i] = mapFields(item, remote.Mapping)
+			}
+			data = mapped
+		} else {
+			data = mapFields(data, remote.Mapping)
+		}
+	}
+	return json.Marshal(data)
+}
+
+// valueAt returns the value at the given path, with the keys for the objects
+// and the indexes for the arrays separated by dots, or nil if there is no
+// value at this path.
+func valueAt(data interface{}, path string) interface{} {
+	for _, part := range strings.Split(path, ".") {
+		switch v := data.(type) {
+		case map[string]interface{}:
+			data = v[part]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			data = v[i]
+		default:
+			return nil
+		}
+	}
+	return data
+}
+
+func mapFields(data interface{}, mapping map[string]string) map[string]interface{} {
+	mapped := make(map[string]interface{}, len(mapping))
+	for key, path := range mapping {
+		mapped[key] = valueAt(data, path)
+	}
+	return mapped
+}
+
+// cachedResponse is a response of a remote website kept in cache.
+type cachedResponse struct {
+	StatusCode  int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+func getCachedResponse(key string) (*cachedResponse, bool) {
+	data, ok := config.GetConfig().CacheStorage.Get(key)
+	if !ok {
+		return nil, false
+	}
+	cached := &cachedResponse{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, false
+	}
+	return cached, true
+}
+
+func setCachedResponse(key string, cached *cachedResponse, ttl time.Duration) {
+	if data, err := json.Marshal(cached); err == nil {
+		config.GetConfig().CacheStorage.Set(key, data, ttl)
+	}
+}
+
+func (cached *cachedResponse) writeTo(rw http.ResponseWriter) error {
+	rw.Header().Set("Content-Type", cached.ContentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(cached.Body)))
+	rw.WriteHeader(cached.StatusCode)
+	_, err := rw.Write(cached.Body)
+	return err
+}
+
+func isJSONContentType(ctype string) bool {
+	return ctype == "application/json" ||
+		ctype == "application/vnd.api+json" ||
+		ctype == "application/sparql-results+json"
+}
@@ -0,0 +1,161 @@
+package remote
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+var (
+	// ErrMissingSecret is used when a secret is used in the template, but is
+	// not defined in the configuration
+	ErrMissingSecret = errors.New("a secret is used in the template, but it is not configured")
+	// ErrInvalidAccount is used when the account given for a request can't be
+	// used to authenticate it
+	ErrInvalidAccount = errors.New("the account cannot be used for this request")
+	// ErrAccountRefresh is used when the token of the account has expired and
+	// can't be refreshed
+	ErrAccountRefresh = errors.New("the token of the account cannot be refreshed")
+	// ErrReservedVariable is used when the client gives a value for a
+	// variable reserved for the secrets
+	ErrReservedVariable = errors.New("the variable is reserved for the secrets")
+)
+
+const (
+	// AccountVariable is the name of the variable used by the client to give
+	// the identifier of the account to use for the request.
+	AccountVariable = "account"
+	// accountTokenVariable is replaced by the OAuth access token of the
+	// account.
+	accountTokenVariable = "account_access_token"
+	// secretPrefix is the prefix of the variables replaced by the secrets of
+	// the context of the instance.
+	secretPrefix = "secret_"
+	// redactedSecret is the value used for the secrets in the logs.
+	redactedSecret = "***"
+)
+
+// refreshMargin is the delay before the expiration of an access token where
+// it is refreshed before being used.
+const refreshMargin = 1 * time.Minute
+
+func isSecretVariable(name string) bool {
+	return name == accountTokenVariable || strings.HasPrefix(name, secretPrefix)
+}
+
+// secretNames returns the names of the variables used in the template that
+// are replaced by secrets.
+func (remote *Remote) secretNames() []string {
+	templates := []string{remote.URL.Path, remote.URL.RawQuery, remote.Body}
+	for _, v := range remote.Headers {
+		templates = append(templates, v)
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, tmpl := range templates {
+		for _, m := range injectionRegexp.FindAllString(tmpl, -1) {
+			fields := strings.Fields(m[2 : len(m)-2])
+			if len(fields) == 0 {
+				continue
+			}
+			name := fields[len(fields)-1]
+			if isSecretVariable(name) && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NeedsAccount returns true if the request is authenticated with the token of
+// an account, given by the client in the account variable.
+func (remote *Remote) NeedsAccount() bool {
+	for _, name := range remote.secretNames() {
+		if name == accountTokenVariable {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSecrets returns the values of the secrets used in the template.
+func (remote *Remote) loadSecrets(ins *instance.Instance, vars map[string]string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, name := range remote.secretNames() {
+		if name == accountTokenVariable {
+			token, err := accountToken(ins, vars[AccountVariable])
+			if err != nil {
+				return nil, err
+			}
+			secrets[name] = token
+			continue
+		}
+		value, ok := contextSecret(ins, strings.TrimPrefix(name, secretPrefix))
+		if !ok {
+			log.Infof("Secret %s is not configured for the context %q", name, ins.ContextName)
+			return nil, ErrMissingSecret
+		}
+		secrets[name] = value
+	}
+	return secrets, nil
+}
+
+// contextSecret returns the secret with the given name for the context of the
+// instance, or for the default context.
+func contextSecret(ins *instance.Instance, name string) (string, bool) {
+	// The keys are lowercased by viper when the configuration is read
+	name = strings.ToLower(name)
+	contexts := config.GetConfig().RemoteSecrets
+	if ins.ContextName != "" {
+		if value, ok := contexts[ins.ContextName][name]; ok {
+			return value, true
+		}
+	}
+	value, ok := contexts[config.DefaultInstanceContext][name]
+	return value, ok
+}
+
+// accountToken returns the OAuth access token of the account, and refreshes
+// it if it has expired.
+func accountToken(ins *instance.Instance, accountID string) (string, error) {
+	if accountID == "" {
+		return "", ErrMissingVar
+	}
+	var acc account.Account
+	if err := couchdb.GetDoc(ins, consts.Accounts, accountID, &acc); err != nil {
+		log.Infof("Cannot fetch the account %s: %s", accountID, err)
+		return "", ErrInvalidAccount
+	}
+	if acc.Oauth == nil || acc.Oauth.AccessToken == "" {
+		return "", ErrInvalidAccount
+	}
+
+	expiresAt := acc.Oauth.ExpiresAt
+	if !expiresAt.IsZero() && expiresAt.Before(time.Now().Add(refreshMargin)) {
+		if acc.Oauth.RefreshToken == "" {
+			return "", ErrAccountRefresh
+		}
+		accountType, err := account.TypeInfo(acc.AccountType)
+		if err != nil {
+			log.Infof("Cannot find the account type %s: %s", acc.AccountType, err)
+			return "", ErrAccountRefresh
+		}
+		if err = accountType.RefreshAccount(acc); err != nil {
+			log.Infof("Cannot refresh the account %s: %s", accountID, err)
+			return "", ErrAccountRefresh
+		}
+		if err = couchdb.UpdateDoc(ins, &acc); err != nil {
+			log.Errorf("Cannot save the refreshed account %s: %s", accountID, err)
+		}
+	}
+	return acc.Oauth.AccessToken, nil
+}
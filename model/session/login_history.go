@@ -4,7 +4,6 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -99,13 +98,7 @@ func lookupIP(ip, locale string) (city, country string) {
 // StoreNewLoginEntry creates a new login entry in the database associated with
 // the given instance.
 func StoreNewLoginEntry(i *instance.Instance, sessionID, clientID string, req *http.Request, notifEnabled bool) error {
-	var ip string
-	if forwardedFor := req.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		ip = strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0])
-	}
-	if ip == "" {
-		ip = strings.Split(req.RemoteAddr, ":")[0]
-	}
+	ip := config.ClientIP(req)
 
 	city, country := lookupIP(ip, i.Locale)
 	ua := user_agent.New(req.UserAgent())
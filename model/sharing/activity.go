@@ -0,0 +1,473 @@
+package sharing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+const (
+	// ActivityCreated is used when a member has created a shared document
+	ActivityCreated = "created"
+	// ActivityUpdated is used when a member has modified a shared document
+	ActivityUpdated = "updated"
+	// ActivityDeleted is used when a member has deleted a shared document
+	ActivityDeleted = "deleted"
+	// ActivityConflict is used when a change made by a member was in conflict
+	// with another change, and a copy of the file has been made
+	ActivityConflict = "conflict"
+)
+
+// ActivityDigestSchedule is the @cron spec used for the trigger of the daily
+// digest of the activity on a sharing.
+const ActivityDigestSchedule = "0 0 8 * * *"
+
+// activityPerPage is the number of activities returned in a page of results
+const activityPerPage = 100
+
+// activityRetention is how long the activity of a sharing is kept
+const activityRetention = 30 * 24 * time.Hour
+
+// activityPurgeInterval is the minimal delay between two purges of the old
+// activity of a sharing by the same stack process.
+const activityPurgeInterval = time.Hour
+
+// lastActivityPurges keeps, by sharing ID, when the activity was purged for
+// the last time.
+var lastActivityPurges = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// Activity is a record of a change made by a member on a shared document. The
+// activity of a sharing is kept on the cozy of the owner, as it is the only
+// one that knows which member has sent a change.
+type Activity struct {
+	DocID       string    `json:"_id,omitempty"`
+	DocRev      string    `json:"_rev,omitempty"`
+	SharingID   string    `json:"sharing_id"`
+	MemberIndex int       `json:"member_index"`
+	PublicName  string    `json:"public_name,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Action      string    `json:"action"`
+	Type        string    `json:"doctype"`
+	TargetID    string    `json:"doc_id"`
+	Name        string    `json:"name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ID returns the activity identifier
+func (a *Activity) ID() string { return a.DocID }
+
+// Rev returns the activity revision
+func (a *Activity) Rev() string { return a.DocRev }
+
+// DocType returns the activity document type
+func (a *Activity) DocType() string { return consts.SharingsActivity }
+
+// SetID changes the activity identifier
+func (a *Activity) SetID(id string) { a.DocID = id }
+
+// SetRev changes the activity revision
+func (a *Activity) SetRev(rev string) { a.DocRev = rev }
+
+// Clone implements couchdb.Doc
+func (a *Activity) Clone() couchdb.Doc {
+	cloned := *a
+	return &cloned
+}
+
+// Included is part of jsonapi.Object interface
+func (a *Activity) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (a *Activity) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (a *Activity) Links() *jsonapi.LinksList { return nil }
+
+var _ jsonapi.Object = (*Activity)(nil)
+
+// memberIndex returns the index of the given member in the members list of
+// the sharing, or -1 if it is not a member.
+func (s *Sharing) memberIndex(m *Member) int {
+	for i := range s.Members {
+		if &s.Members[i] == m {
+			return i
+		}
+	}
+	return -1
+}
+
+// activityID returns the identifier of the activity for a revision of a
+// shared document. The same change can be seen by several paths (the
+// replicator and the upload of a file for example), and using the same
+// identifier ensures that it is recorded only once.
+func activityID(sharingID, doctype, id, rev string) string {
+	sum := sha256.Sum256([]byte(sharingID + "/" + doctype + "/" + id + "/" + rev))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordActivity saves in CouchDB that a member has changed a shared
+// document. It does nothing if the member is unknown, or if the current cozy
+// is not the owner of the sharing, or if this revision of the document has
+// already been recorded. The errors are only logged, as the activity is not
+// needed for the synchronization.
+func (s *Sharing) recordActivity(inst *instance.Instance, m *Member, action, doctype, id, rev, name string) {
+	if m == nil || !s.Owner {
+		return
+	}
+	index := s.memberIndex(m)
+	if index < 0 {
+		return
+	}
+	a := &Activity{
+		SharingID:   s.SID,
+		MemberIndex: index,
+		PublicName:  m.PublicName,
+		Email:       m.Email,
+		Action:      action,
+		Type:        doctype,
+		TargetID:    id,
+		Name:        name,
+		CreatedAt:   time.Now().UTC(),
+	}
+	var err error
+	if rev == "" {
+		err = couchdb.CreateDoc(inst, a)
+	} else {
+		a.DocID = activityID(s.SID, doctype, id, rev)
+		err = couchdb.CreateNamedDocWithDB(inst, a)
+	}
+	if couchdb.IsConflictError(err) {
+		return
+	}
+	if err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Cannot record the activity for %s: %s", s.SID, err)
+		return
+	}
+	if shouldPurgeActivity(s.SID, a.CreatedAt) {
+		if err := purgeActivity(inst, s.SID, a.CreatedAt.Add(-activityRetention)); err != nil {
+			inst.Logger().WithField("nspace", "sharing").
+				Warnf("Cannot purge the activity for %s: %s", s.SID, err)
+		}
+	}
+}
+
+// shouldPurgeActivity returns true if the old activity of the sharing has not
+// been purged recently by this process.
+func shouldPurgeActivity(sharingID string, now time.Time) bool {
+	lastActivityPurges.Lock()
+	defer lastActivityPurges.Unlock()
+	if last, ok := lastActivityPurges.at[sharingID]; ok && now.Sub(last) < activityPurgeInterval {
+		return false
+	}
+	lastActivityPurges.at[sharingID] = now
+	return true
+}
+
+// purgeActivity deletes the activity of the sharing that has been recorded
+// before the given date.
+func purgeActivity(db prefixer.Prefixer, sharingID string, before time.Time) error {
+	for {
+		var activities []*Activity
+		req := &couchdb.FindRequest{
+			UseIndex: "by-sharing-id",
+			Selector: mango.And(
+				mango.Equal("sharing_id", sharingID),
+				mango.Lt("created_at", before.UTC()),
+			),
+			Sort: mango.SortBy{
+				{Field: "sharing_id", Direction: mango.Asc},
+				{Field: "created_at", Direction: mango.Asc},
+			},
+			Limit: activityPerPage,
+		}
+		if err := couchdb.FindDocs(db, consts.SharingsActivity, req, &activities); err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return nil
+			}
+			return err
+		}
+		if len(activities) == 0 {
+			return nil
+		}
+		docs := make([]couchdb.Doc, len(activities))
+		for i, a := range activities {
+			docs[i] = a
+		}
+		if err := couchdb.BulkDeleteDocs(db, consts.SharingsActivity, docs); err != nil {
+			return err
+		}
+		if len(activities) < activityPerPage {
+			return nil
+		}
+	}
+}
+
+// ListActivity returns the changes made by the members on the shared
+// documents, the most recent first. It uses pagination via a mango bookmark.
+// On the owner, m is the member that has made the request, or nil if it is
+// the owner itself, and the identifiers of the files are transformed for this
+// member. On a recipient, the activity is asked to the owner.
+func (s *Sharing) ListActivity(inst *instance.Instance, m *Member, bookmark string) ([]*Activity, string, error) {
+	if !s.Owner {
+		if m != nil {
+			return nil, "", ErrInvalidSharing
+		}
+		return s.fetchActivityFromOwner(inst, bookmark)
+	}
+
+	var creds *Credentials
+	if m != nil {
+		if creds = s.FindCredentials(m); creds == nil {
+			return nil, "", ErrInvalidSharing
+		}
+	}
+
+	var activities []*Activity
+	req := &couchdb.FindRequest{
+		UseIndex: "by-sharing-id",
+		Selector: mango.And(
+			mango.Equal("sharing_id", s.SID),
+			mango.Exists("created_at"),
+		),
+		Sort: mango.SortBy{
+			{Field: "sharing_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit:    activityPerPage,
+		Bookmark: bookmark,
+	}
+	res, err := couchdb.FindDocsRaw(inst, consts.SharingsActivity, req, &activities)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []*Activity{}, "", nil
+		}
+		return nil, "", err
+	}
+	if creds != nil && len(creds.XorKey) > 0 {
+		for _, a := range activities {
+			if a.Type == consts.Files {
+				a.TargetID = XorID(a.TargetID, creds.XorKey)
+			}
+		}
+	}
+	next := res.Bookmark
+	if len(activities) < activityPerPage {
+		next = ""
+	}
+	return activities, next, nil
+}
+
+// activityList is used to decode the JSON-API response of the owner for the
+// activity of a sharing.
+type activityList struct {
+	Data []struct {
+		ID         string   `json:"id"`
+		Attributes Activity `json:"attributes"`
+	} `json:"data"`
+	Links jsonapi.LinksList `json:"links"`
+}
+
+// fetchActivityFromOwner asks the cozy of the owner the activity of the
+// sharing.
+func (s *Sharing) fetchActivityFromOwner(inst *instance.Instance, bookmark string) ([]*Activity, string, error) {
+	if len(s.Credentials) == 0 || s.Credentials[0].AccessToken == nil {
+		return nil, "", ErrInvalidSharing
+	}
+	owner := &s.Members[0]
+	creds := &s.Credentials[0]
+	u, err := url.Parse(owner.Instance)
+	if err != nil {
+		return nil, "", ErrInvalidURL
+	}
+	var query url.Values
+	if bookmark != "" {
+		query = url.Values{"page[cursor]": {bookmark}}
+	}
+	opts := &request.Options{
+		Method:  http.MethodGet,
+		Scheme:  u.Scheme,
+		Domain:  u.Host,
+		Path:    "/sharings/" + s.SID + "/activity",
+		Queries: query,
+		Headers: request.Headers{
+			"Accept":        "application/vnd.api+json",
+			"Authorization": "Bearer " + creds.AccessToken.AccessToken,
+		},
+	}
+	res, err := request.Req(opts)
+	if res != nil && res.StatusCode/100 == 4 {
+		res, err = RefreshToken(inst, s, owner, creds, opts, nil)
+	}
+	if err != nil {
+		if res != nil && res.StatusCode/100 == 5 {
+			return nil, "", ErrInternalServerError
+		}
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	var list activityList
+	if err = json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, "", err
+	}
+	activities := make([]*Activity, len(list.Data))
+	for i := range list.Data {
+		a := list.Data[i].Attributes
+		a.DocID = list.Data[i].ID
+		activities[i] = &a
+	}
+	next := ""
+	if list.Links.Next != "" {
+		if n, err := url.Parse(list.Links.Next); err == nil {
+			next = n.Query().Get("page[cursor]")
+		}
+	}
+	return activities, next, nil
+}
+
+// ActivitySummary counts the changes made by a member of a sharing.
+type ActivitySummary struct {
+	Name     string `json:"Name"`
+	Created  int    `json:"Created"`
+	Updated  int    `json:"Updated"`
+	Deleted  int    `json:"Deleted"`
+	Conflict int    `json:"Conflict"`
+}
+
+// summarizeActivity groups the activities by member, ordered by their index.
+func summarizeActivity(activities []*Activity) []*ActivitySummary {
+	byIndex := make(map[int]*ActivitySummary)
+	var indexes []int
+	for _, a := range activities {
+		sum, ok := byIndex[a.MemberIndex]
+		if !ok {
+			name := a.PublicName
+			if name == "" {
+				name = a.Email
+			}
+			sum = &ActivitySummary{Name: name}
+			byIndex[a.MemberIndex] = sum
+			indexes = append(indexes, a.MemberIndex)
+		}
+		switch a.Action {
+		case ActivityCreated:
+			sum.Created++
+		case ActivityUpdated:
+			sum.Updated++
+		case ActivityDeleted:
+			sum.Deleted++
+		case ActivityConflict:
+			sum.Conflict++
+		}
+	}
+	sort.Ints(indexes)
+	summaries := make([]*ActivitySummary, len(indexes))
+	for i, idx := range indexes {
+		summaries[i] = byIndex[idx]
+	}
+	return summaries
+}
+
+// SendActivityDigest sends a notification that summarizes the changes made
+// on the shared documents since the given date. Nothing is sent if there
+// were no changes.
+func (s *Sharing) SendActivityDigest(inst *instance.Instance, since time.Time) error {
+	var recent []*Activity
+	bookmark := ""
+	for {
+		activities, next, err := s.ListActivity(inst, nil, bookmark)
+		if err != nil {
+			return err
+		}
+		done := next == ""
+		for _, a := range activities {
+			if a.CreatedAt.Before(since) {
+				done = true
+				break
+			}
+			recent = append(recent, a)
+		}
+		if done {
+			break
+		}
+		bookmark = next
+	}
+	if len(recent) == 0 {
+		return nil
+	}
+
+	drive := inst.SubDomain(consts.DriveSlug)
+	n := &notification.Notification{
+		CategoryID: s.SID,
+		Title:      inst.Translate("Notifications Sharing Activity Subject", s.Description),
+		Data: map[string]interface{}{
+			"Description":   s.Description,
+			"Members":       summarizeActivity(recent),
+			"CozyDriveLink": drive.String(),
+		},
+	}
+	return center.PushStack(inst.Domain, center.NotificationSharingActivity, n)
+}
+
+// ActivityDigestMsg is used for jobs on the share-activity-digest worker.
+type ActivityDigestMsg struct {
+	SharingID string `json:"sharing_id"`
+}
+
+// EnableActivityDigest adds a trigger for sending every day a digest of the
+// activity on this sharing.
+func (s *Sharing) EnableActivityDigest(inst *instance.Instance) error {
+	if !s.Active {
+		return ErrInvalidSharing
+	}
+	if s.Triggers.DigestID != "" {
+		return nil
+	}
+	msg := &ActivityDigestMsg{SharingID: s.SID}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Domain:     inst.ContextualDomain(),
+		Type:       "@cron",
+		WorkerType: "share-activity-digest",
+		Arguments:  ActivityDigestSchedule,
+	}, msg)
+	if err != nil {
+		return err
+	}
+	if err = job.System().AddTrigger(t); err != nil {
+		return err
+	}
+	s.Triggers.DigestID = t.ID()
+	return couchdb.UpdateDoc(inst, s)
+}
+
+// DisableActivityDigest removes the trigger for the daily digest of the
+// activity on this sharing.
+func (s *Sharing) DisableActivityDigest(inst *instance.Instance) error {
+	if s.Triggers.DigestID == "" {
+		return nil
+	}
+	if err := removeSharingTrigger(inst, s.Triggers.DigestID); err != nil {
+		return err
+	}
+	s.Triggers.DigestID = ""
+	return couchdb.UpdateDoc(inst, s)
+}
@@ -0,0 +1,131 @@
+package sharing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeActivity(t *testing.T) {
+	activities := []*Activity{
+		{MemberIndex: 2, PublicName: "Dave", Action: ActivityCreated},
+		{MemberIndex: 0, PublicName: "Alice", Action: ActivityUpdated},
+		{MemberIndex: 2, PublicName: "Dave", Action: ActivityCreated},
+		{MemberIndex: 1, Email: "bob@example.net", Action: ActivityDeleted},
+		{MemberIndex: 2, PublicName: "Dave", Action: ActivityConflict},
+		{MemberIndex: 0, PublicName: "Alice", Action: ActivityUpdated},
+	}
+	summaries := summarizeActivity(activities)
+	assert.Len(t, summaries, 3)
+	assert.Equal(t, &ActivitySummary{Name: "Alice", Updated: 2}, summaries[0])
+	assert.Equal(t, &ActivitySummary{Name: "bob@example.net", Deleted: 1}, summaries[1])
+	assert.Equal(t, &ActivitySummary{Name: "Dave", Created: 2, Conflict: 1}, summaries[2])
+
+	assert.Empty(t, summarizeActivity(nil))
+}
+
+func TestMemberIndex(t *testing.T) {
+	s := &Sharing{
+		Members: []Member{
+			{Status: MemberStatusOwner, Email: "alice@example.net"},
+			{Status: MemberStatusReady, Email: "bob@example.net"},
+		},
+	}
+	assert.Equal(t, 0, s.memberIndex(&s.Members[0]))
+	assert.Equal(t, 1, s.memberIndex(&s.Members[1]))
+	other := s.Members[1]
+	assert.Equal(t, -1, s.memberIndex(&other))
+}
+
+func activitySharing() *Sharing {
+	return &Sharing{
+		SID:   utils.RandomString(32),
+		Owner: true,
+		Members: []Member{
+			{Status: MemberStatusOwner, PublicName: "Alice", Email: "alice@example.net"},
+			{Status: MemberStatusReady, Email: "bob@example.net"},
+		},
+	}
+}
+
+func TestRecordActivityOnlyOnce(t *testing.T) {
+	s := activitySharing()
+	bob := &s.Members[1]
+	s.recordActivity(inst, bob, ActivityUpdated, consts.Files, "file1", "2-aaa", "foo.txt")
+	s.recordActivity(inst, bob, ActivityUpdated, consts.Files, "file1", "2-aaa", "foo.txt")
+	s.recordActivity(inst, bob, ActivityUpdated, consts.Files, "file1", "3-bbb", "foo.txt")
+	other := *bob
+	s.recordActivity(inst, &other, ActivityUpdated, consts.Files, "file1", "4-ccc", "foo.txt")
+
+	activities, next, err := s.ListActivity(inst, nil, "")
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, activities, 2)
+	for _, a := range activities {
+		assert.Equal(t, s.SID, a.SharingID)
+		assert.Equal(t, 1, a.MemberIndex)
+		assert.Equal(t, "file1", a.TargetID)
+	}
+	assert.NotEqual(t, activities[0].ID(), activities[1].ID())
+
+	s.Owner = false
+	s.recordActivity(inst, bob, ActivityUpdated, consts.Files, "file1", "5-ddd", "foo.txt")
+	s.Owner = true
+	activities, _, err = s.ListActivity(inst, nil, "")
+	require.NoError(t, err)
+	assert.Len(t, activities, 2)
+}
+
+func TestListActivityPagination(t *testing.T) {
+	s := activitySharing()
+	bob := &s.Members[1]
+	for i := 0; i < activityPerPage+5; i++ {
+		rev := fmt.Sprintf("1-%d", i)
+		s.recordActivity(inst, bob, ActivityCreated, consts.Files, "file"+rev, rev, "")
+	}
+
+	activities, next, err := s.ListActivity(inst, nil, "")
+	require.NoError(t, err)
+	assert.Len(t, activities, activityPerPage)
+	require.NotEmpty(t, next)
+	activities, next, err = s.ListActivity(inst, nil, next)
+	require.NoError(t, err)
+	assert.Len(t, activities, 5)
+	assert.Empty(t, next)
+}
+
+func TestPurgeActivity(t *testing.T) {
+	s := activitySharing()
+	now := time.Now().UTC()
+	for i, age := range []time.Duration{40 * 24 * time.Hour, 31 * 24 * time.Hour, time.Hour} {
+		a := &Activity{
+			SharingID: s.SID,
+			Action:    ActivityUpdated,
+			Type:      consts.Files,
+			TargetID:  fmt.Sprintf("file%d", i),
+			CreatedAt: now.Add(-age),
+		}
+		require.NoError(t, couchdb.CreateDoc(inst, a))
+	}
+
+	require.NoError(t, purgeActivity(inst, s.SID, now.Add(-activityRetention)))
+	activities, _, err := s.ListActivity(inst, nil, "")
+	require.NoError(t, err)
+	require.Len(t, activities, 1)
+	assert.Equal(t, "file2", activities[0].TargetID)
+}
+
+func TestShouldPurgeActivity(t *testing.T) {
+	sid := utils.RandomString(32)
+	now := time.Now()
+	assert.True(t, shouldPurgeActivity(sid, now))
+	assert.False(t, shouldPurgeActivity(sid, now.Add(time.Minute)))
+	assert.True(t, shouldPurgeActivity(sid, now.Add(activityPurgeInterval)))
+	assert.True(t, shouldPurgeActivity(utils.RandomString(32), now))
+}
@@ -41,9 +41,15 @@ var (
 	// ErrFolderNotFound is used when informations about a folder is asked,
 	// but this folder was not found
 	ErrFolderNotFound = errors.New("This folder was not found")
+	// ErrImageNotFound is used when an image of a shared note is asked, but
+	// it was not found
+	ErrImageNotFound = errors.New("This image was not found")
 	// ErrSafety is used when an operation is aborted due to the safery principal
 	ErrSafety = errors.New("Operation aborted")
 	// ErrAlreadyAccepted is used when someone tries to accept twice a sharing
 	// on the same cozy instance
 	ErrAlreadyAccepted = errors.New("Sharing already accepted by this recipient")
+	// ErrInvalidGuestCode is used when a guest of a sharing gives a code that
+	// is not valid, or has made too many attempts
+	ErrInvalidGuestCode = errors.New("The code is invalid or has expired")
 )
@@ -423,7 +423,7 @@ func (s *Sharing) GetFolder(inst *instance.Instance, m *Member, xoredID string)
 
 // ApplyBulkFiles takes a list of documents for the io.cozy.files doctype and
 // will apply changes to the VFS according to those documents.
-func (s *Sharing) ApplyBulkFiles(inst *instance.Instance, docs DocsList) error {
+func (s *Sharing) ApplyBulkFiles(inst *instance.Instance, docs DocsList, m *Member) error {
 	var errm error
 	fs := inst.VFS()
 
@@ -459,6 +459,8 @@ func (s *Sharing) ApplyBulkFiles(inst *instance.Instance, docs DocsList) error {
 			errm = multierror.Append(errm, err)
 			continue
 		}
+		var action string
+		name, _ := target["name"].(string)
 		if _, ok := target["_deleted"]; ok {
 			if ref == nil || infos.Removed {
 				continue
@@ -466,9 +468,12 @@ func (s *Sharing) ApplyBulkFiles(inst *instance.Instance, docs DocsList) error {
 			if dir == nil && file == nil {
 				continue
 			}
+			action = ActivityDeleted
 			if dir != nil {
+				name = dir.DocName
 				err = s.TrashDir(inst, dir)
 			} else {
+				name = file.DocName
 				err = s.TrashFile(inst, file, &s.Rules[infos.Rule])
 			}
 		} else if file != nil {
@@ -476,16 +481,21 @@ func (s *Sharing) ApplyBulkFiles(inst *instance.Instance, docs DocsList) error {
 		} else if ref != nil && infos.Removed {
 			continue
 		} else if dir == nil {
+			action = ActivityCreated
 			err = s.CreateDir(inst, target)
 		} else if ref == nil {
 			err = multierror.Append(errm, ErrSafety)
 		} else {
+			action = ActivityUpdated
 			err = s.UpdateDir(inst, target, dir, ref)
 		}
 		if err != nil {
 			inst.Logger().WithField("nspace", "replicator").
 				Debugf("Error on apply bulk file: %s (%#v - %#v)", err, target, ref)
 			errm = multierror.Append(errm, err)
+		} else {
+			rev, _ := target["_rev"].(string)
+			s.recordActivity(inst, m, action, consts.Files, id, rev, name)
 		}
 	}
 
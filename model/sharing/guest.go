@@ -0,0 +1,262 @@
+package sharing
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/mail"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// AddGuests adds a list of contacts that don't have a cozy on the sharer
+// cozy. They will receive a link by mail to access the shared documents from
+// a page served by this cozy.
+func (s *Sharing) AddGuests(inst *instance.Instance, contactIDs map[string]bool) error {
+	if !s.Owner {
+		return ErrInvalidSharing
+	}
+	for id, ro := range contactIDs {
+		if err := s.AddGuest(inst, id, ro); err != nil {
+			return err
+		}
+	}
+	if err := s.SendMails(inst, nil); err != nil {
+		return err
+	}
+	cloned := s.Clone().(*Sharing)
+	go cloned.NotifyRecipients(inst, nil)
+	return nil
+}
+
+// AddGuest adds the contact with the given identifier as a guest of the
+// sharing. The contact must have an email address, as it is used to send the
+// link and the codes to access the shared documents.
+func (s *Sharing) AddGuest(inst *instance.Instance, contactID string, readOnly bool) error {
+	c, err := contact.Find(inst, contactID)
+	if err != nil {
+		return err
+	}
+	addr, err := c.ToMailAddress()
+	if err != nil {
+		return err
+	}
+	m := Member{
+		Status:   MemberStatusMailNotSent,
+		Name:     addr.Name,
+		Email:    addr.Email,
+		ReadOnly: readOnly,
+		Guest:    true,
+	}
+	s.addMember(m)
+	return nil
+}
+
+// FindGuestByState returns the guest that is linked to the sharing by the
+// given state
+func (s *Sharing) FindGuestByState(state string) (*Member, error) {
+	m, err := s.FindMemberByState(state)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Guest || m.Status == MemberStatusRevoked {
+		return nil, ErrMemberNotFound
+	}
+	return m, nil
+}
+
+// FindGuestBySharecode returns the guest that has been given the sharecode
+// after checking their identity.
+func (s *Sharing) FindGuestBySharecode(db prefixer.Prefixer, sharecode string) (*Member, error) {
+	if !s.Owner {
+		return nil, ErrInvalidSharing
+	}
+	perms, err := permission.GetForShareGuest(db, s.SID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.findMemberByCode(perms, sharecode)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Guest || m.Status == MemberStatusRevoked {
+		return nil, ErrMemberNotFound
+	}
+	return m, nil
+}
+
+// HasGuests returns true if at least one member of the sharing is a guest
+func (s *Sharing) HasGuests() bool {
+	for _, m := range s.Members {
+		if m.Guest {
+			return true
+		}
+	}
+	return false
+}
+
+// guestKey returns the key used for the rate-limiting of the codes sent to a
+// guest.
+func (s *Sharing) guestKey(inst *instance.Instance, m *Member) string {
+	return inst.Domain + ":" + s.SID + ":" + m.Email
+}
+
+// SendGuestCode sends by mail a code to the guest, that they can use to prove
+// that they have access to this email address.
+func (s *Sharing) SendGuestCode(inst *instance.Instance, m *Member) error {
+	if !s.Owner || !m.Guest || m.Email == "" {
+		return ErrInvalidSharing
+	}
+	key := s.guestKey(inst, m)
+	err := limits.CheckRateLimitKey(key, limits.SharingGuestCodeGenerationType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		return ErrMailNotSent
+	}
+	code, err := inst.GenerateSharingGuestCode(s.SID, m.Email)
+	if err != nil {
+		return err
+	}
+	sharer, desc := s.getSharerAndDescription(inst)
+	addr := &mail.Address{
+		Email: m.Email,
+		Name:  m.PrimaryName(),
+	}
+	msg, err := job.NewMessage(mail.Options{
+		Mode:         "from",
+		To:           []*mail.Address{addr},
+		TemplateName: "sharing_guest_code",
+		TemplateValues: map[string]interface{}{
+			"RecipientName":    addr.Name,
+			"SharerPublicName": sharer,
+			"Description":      desc,
+			"Code":             code,
+		},
+		RecipientName: addr.Name,
+		Layout:        mail.CozyCloudLayout,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "sendmail",
+		Message:    msg,
+	})
+	return err
+}
+
+// ValidateGuestCode checks the code given by a guest, and returns a sharecode
+// that they can use to access the shared documents.
+func (s *Sharing) ValidateGuestCode(inst *instance.Instance, m *Member, code string) (string, error) {
+	if !s.Owner || !m.Guest || m.Email == "" {
+		return "", ErrInvalidSharing
+	}
+	key := s.guestKey(inst, m)
+	err := limits.CheckRateLimitKey(key, limits.SharingGuestCodeType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		return "", ErrInvalidGuestCode
+	}
+	if !inst.ValidateSharingGuestCode(s.SID, m.Email, code) {
+		return "", ErrInvalidGuestCode
+	}
+	return s.createGuestSharecode(inst, m)
+}
+
+// guestPermissionSet returns the permissions given to the guests: they can
+// read the files of the sharing, and upload new files or new versions of the
+// files for the rules that are synchronized. The local rules and the rules
+// for other doctypes are not for the guests. The guests with the read-only
+// flag are restricted to GET when their token is checked.
+func (s *Sharing) guestPermissionSet() permission.Set {
+	var set permission.Set
+	for _, rule := range s.Rules {
+		if rule.Local || rule.DocType != consts.Files {
+			continue
+		}
+		verbs := permission.Verbs(permission.GET)
+		if rule.HasSync() {
+			verbs = permission.Verbs(permission.GET, permission.POST, permission.PUT)
+		}
+		set = append(set, permission.Rule{
+			Type:     rule.DocType,
+			Title:    rule.Title,
+			Verbs:    verbs,
+			Selector: rule.Selector,
+			Values:   rule.Values,
+		})
+	}
+	return set
+}
+
+// createGuestSharecode creates the permissions doc for the guests of this
+// sharing if needed, and adds a sharecode for the given guest in it.
+func (s *Sharing) createGuestSharecode(inst *instance.Instance, m *Member) (string, error) {
+	doc, _ := permission.GetForShareGuest(inst, s.SID)
+	if doc != nil {
+		if code, ok := doc.Codes[m.Email]; ok {
+			return code, nil
+		}
+	}
+
+	code, err := inst.CreateShareCode(m.Email)
+	if err != nil {
+		return "", err
+	}
+
+	if doc != nil {
+		if doc.Codes == nil {
+			doc.Codes = make(map[string]string)
+		}
+		doc.Codes[m.Email] = code
+		doc.Permissions = s.guestPermissionSet()
+		if err := couchdb.UpdateDoc(inst, doc); err != nil {
+			return "", err
+		}
+		return code, nil
+	}
+
+	md := metadata.New()
+	md.CreatedByApp = s.AppSlug
+	subdoc := permission.Permission{
+		Permissions: s.guestPermissionSet(),
+		Metadata:    md,
+	}
+	codes := map[string]string{m.Email: code}
+	if _, err := permission.CreateShareGuestSet(inst, s.SID, codes, subdoc); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// RemoveGuestCode removes the sharecode of a guest, when they are revoked or
+// when they have connected their cozy to the sharing.
+func (s *Sharing) RemoveGuestCode(inst *instance.Instance, m *Member) error {
+	doc, err := permission.GetForShareGuest(inst, s.SID)
+	if err != nil {
+		// No guest has checked their identity yet
+		return nil
+	}
+	if _, ok := doc.Codes[m.Email]; !ok {
+		return nil
+	}
+	delete(doc.Codes, m.Email)
+	return couchdb.UpdateDoc(inst, doc)
+}
+
+// RevokeGuestPermissions ensures that the permissions for the guests are no
+// longer valid.
+func (s *Sharing) RevokeGuestPermissions(inst *instance.Instance) error {
+	perms, err := permission.GetForShareGuest(inst, s.SID)
+	if err != nil {
+		// No guest has checked their identity yet
+		return nil
+	}
+	now := time.Now()
+	perms.ExpiresAt = &now
+	return couchdb.UpdateDoc(inst, perms)
+}
@@ -0,0 +1,86 @@
+package sharing
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindMemberByCode(t *testing.T) {
+	s := &Sharing{
+		Owner: true,
+		Members: []Member{
+			{Status: MemberStatusOwner, Email: "alice@example.net"},
+			{Status: MemberStatusReady, Instance: "https://bob.example.net"},
+			{Status: MemberStatusPendingInvitation, Email: "dave@example.net", Guest: true},
+		},
+	}
+	perms := &permission.Permission{
+		Codes: map[string]string{
+			"https://bob.example.net": "bobcode",
+			"dave@example.net":        "davecode",
+		},
+	}
+	m, err := s.findMemberByCode(perms, "davecode")
+	assert.NoError(t, err)
+	assert.Equal(t, &s.Members[2], m)
+	m, err = s.findMemberByCode(perms, "bobcode")
+	assert.NoError(t, err)
+	assert.Equal(t, &s.Members[1], m)
+	_, err = s.findMemberByCode(perms, "unknown")
+	assert.Equal(t, ErrMemberNotFound, err)
+}
+
+func TestGuestPermissionSet(t *testing.T) {
+	s := &Sharing{
+		Rules: []Rule{
+			{
+				Title:   "photos",
+				DocType: "io.cozy.files",
+				Values:  []string{"foo"},
+				Add:     ActionRuleSync,
+				Update:  ActionRuleSync,
+				Remove:  ActionRuleSync,
+			},
+			{
+				Title:   "albums",
+				DocType: "io.cozy.photos.albums",
+				Values:  []string{"bar"},
+				Add:     ActionRuleSync,
+				Update:  ActionRuleSync,
+				Remove:  ActionRuleSync,
+			},
+			{
+				Title:   "local",
+				DocType: "io.cozy.files",
+				Values:  []string{"baz"},
+				Local:   true,
+			},
+			{
+				Title:   "pushed",
+				DocType: "io.cozy.files",
+				Values:  []string{"qux"},
+				Add:     ActionRulePush,
+			},
+		},
+	}
+	set := s.guestPermissionSet()
+	assert.Len(t, set, 2)
+	assert.Equal(t, "photos", set[0].Title)
+	assert.True(t, set[0].Verbs.Contains(permission.GET))
+	assert.True(t, set[0].Verbs.Contains(permission.POST))
+	assert.True(t, set[0].Verbs.Contains(permission.PUT))
+	assert.False(t, set[0].Verbs.Contains(permission.PATCH))
+	assert.False(t, set[0].Verbs.Contains(permission.DELETE))
+	assert.Equal(t, "pushed", set[1].Title)
+	assert.True(t, set[1].Verbs.Contains(permission.GET))
+	assert.False(t, set[1].Verbs.Contains(permission.POST))
+
+	s.Rules[0].Add = ActionRuleNone
+	s.Rules[0].Update = ActionRuleNone
+	s.Rules[0].Remove = ActionRuleNone
+	set = s.guestPermissionSet()
+	assert.True(t, set[0].Verbs.Contains(permission.GET))
+	assert.False(t, set[0].Verbs.Contains(permission.POST))
+}
@@ -117,6 +117,12 @@ func (s *Sharing) getSharerAndDescription(inst *instance.Instance) (string, stri
 // MailLink generates an HTTP link where the recipient can start the process of
 // accepting the sharing
 func (m *Member) MailLink(inst *instance.Instance, s *Sharing, state string, codes map[string]string) string {
+	if s.Owner && m.Guest {
+		query := url.Values{"state": {state}}
+		path := fmt.Sprintf("/sharings/%s/guest", s.SID)
+		return inst.PageURL(path, query)
+	}
+
 	if s.Owner && s.PreviewPath != "" && codes != nil {
 		if code, ok := codes[m.Email]; ok {
 			u := inst.SubDomain(s.AppSlug)
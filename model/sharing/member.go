@@ -44,6 +44,9 @@ type Member struct {
 	Email      string `json:"email,omitempty"`
 	Instance   string `json:"instance,omitempty"`
 	ReadOnly   bool   `json:"read_only,omitempty"`
+	// Guest is true for a member that doesn't have a cozy: they access the
+	// shared documents from a page served by the cozy of the owner.
+	Guest bool `json:"guest,omitempty"`
 }
 
 // PrimaryName returns the main name of this member
@@ -122,6 +125,14 @@ func (s *Sharing) AddContact(inst *instance.Instance, contactID string, readOnly
 		Instance: cozyURL,
 		ReadOnly: readOnly,
 	}
+	s.addMember(m)
+	return nil
+}
+
+// addMember adds the member to the sharing, or updates it if it is already a
+// member that has not accepted the sharing. New credentials are generated for
+// this member.
+func (s *Sharing) addMember(m Member) {
 	idx := -1
 	for i, member := range s.Members {
 		if i == 0 {
@@ -139,6 +150,7 @@ func (s *Sharing) AddContact(inst *instance.Instance, contactID string, readOnly
 			s.Members[i].Name = m.Name
 			s.Members[i].Instance = m.Instance
 			s.Members[i].ReadOnly = m.ReadOnly
+			s.Members[i].Guest = m.Guest
 		}
 	}
 	if idx < 1 {
@@ -154,7 +166,6 @@ func (s *Sharing) AddContact(inst *instance.Instance, contactID string, readOnly
 	} else {
 		s.Credentials[idx-1] = creds
 	}
-	return nil
 }
 
 // APIDelegateAddContacts is used to serialize a request to add contacts to
@@ -420,15 +431,30 @@ func (s *Sharing) FindMemberByState(state string) (*Member, error) {
 }
 
 // FindMemberBySharecode returns the member that is linked to the sharing by
-// the given sharecode
+// the given sharecode. The sharecode can be one for the preview, or one given
+// to a guest.
 func (s *Sharing) FindMemberBySharecode(db prefixer.Prefixer, sharecode string) (*Member, error) {
 	if !s.Owner {
 		return nil, ErrInvalidSharing
 	}
 	perms, err := permission.GetForSharePreview(db, s.SID)
+	if err == nil {
+		if m, errm := s.findMemberByCode(perms, sharecode); errm == nil {
+			return m, nil
+		}
+	}
+	if s.HasGuests() {
+		return s.FindGuestBySharecode(db, sharecode)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return nil, ErrMemberNotFound
+}
+
+// findMemberByCode returns the member that has been given the sharecode in the
+// permissions doc
+func (s *Sharing) findMemberByCode(perms *permission.Permission, sharecode string) (*Member, error) {
 	var emailOrInstance string
 	for e, code := range perms.Codes {
 		if code == sharecode {
@@ -436,6 +462,9 @@ func (s *Sharing) FindMemberBySharecode(db prefixer.Prefixer, sharecode string)
 			break
 		}
 	}
+	if emailOrInstance == "" {
+		return nil, ErrMemberNotFound
+	}
 	for i, m := range s.Members {
 		if m.Email == emailOrInstance {
 			return &s.Members[i], nil
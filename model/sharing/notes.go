@@ -0,0 +1,125 @@
+package sharing
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// GetNoteImage returns an image of a shared note for a member of the sharing.
+// The identifier of the note is XORed. If the image is not on this instance,
+// and it is the instance of the owner, the other members are asked for it.
+func (s *Sharing) GetNoteImage(inst *instance.Instance, m *Member, xoredID, name string) (*vfs.FileDoc, error) {
+	creds := s.FindCredentials(m)
+	if creds == nil {
+		return nil, ErrInvalidSharing
+	}
+	noteID := XorID(xoredID, creds.XorKey)
+	if !s.isSharedFile(inst, noteID) {
+		return nil, ErrImageNotFound
+	}
+	img, err := note.GetImage(inst, noteID, name)
+	if err == nil {
+		return img, nil
+	}
+	if err != os.ErrNotExist || !s.Owner {
+		return nil, ErrImageNotFound
+	}
+	for i := range s.Credentials {
+		if &s.Credentials[i] == creds {
+			continue
+		}
+		img, err = s.fetchNoteImageFromInstance(inst, &s.Members[i+1], &s.Credentials[i], noteID, name)
+		if err == nil {
+			return img, nil
+		}
+	}
+	return nil, ErrImageNotFound
+}
+
+// FetchNoteImage is used when an image of a note is not on this instance. If
+// the note is shared, the image is fetched from the other members of the
+// sharing and saved locally.
+func FetchNoteImage(inst *instance.Instance, noteID, name string) (*vfs.FileDoc, error) {
+	ref := &SharedRef{}
+	if err := couchdb.GetDoc(inst, consts.Shared, consts.Files+"/"+noteID, ref); err != nil {
+		return nil, ErrImageNotFound
+	}
+	for sid, info := range ref.Infos {
+		if info.Removed {
+			continue
+		}
+		s, err := FindSharing(inst, sid)
+		if err != nil || !s.Active {
+			continue
+		}
+		if img, err := s.fetchNoteImageFromNetwork(inst, noteID, name); err == nil {
+			return img, nil
+		}
+	}
+	return nil, ErrImageNotFound
+}
+
+func (s *Sharing) isSharedFile(inst *instance.Instance, fileID string) bool {
+	ref := &SharedRef{}
+	if err := couchdb.GetDoc(inst, consts.Shared, consts.Files+"/"+fileID, ref); err != nil {
+		return false
+	}
+	info, ok := ref.Infos[s.SID]
+	return ok && !info.Removed
+}
+
+// fetchNoteImageFromNetwork asks the other cozy instances of this sharing for
+// an image of a note.
+func (s *Sharing) fetchNoteImageFromNetwork(inst *instance.Instance, noteID, name string) (*vfs.FileDoc, error) {
+	if !s.Owner {
+		return s.fetchNoteImageFromInstance(inst, &s.Members[0], &s.Credentials[0], noteID, name)
+	}
+	for i := range s.Credentials {
+		img, err := s.fetchNoteImageFromInstance(inst, &s.Members[i+1], &s.Credentials[i], noteID, name)
+		if err == nil {
+			return img, nil
+		}
+	}
+	return nil, ErrImageNotFound
+}
+
+// fetchNoteImageFromInstance downloads an image of a note from the given
+// member of the sharing, and saves it on this instance.
+func (s *Sharing) fetchNoteImageFromInstance(inst *instance.Instance, m *Member, creds *Credentials, noteID, name string) (*vfs.FileDoc, error) {
+	if creds == nil || creds.AccessToken == nil || m.Instance == "" {
+		return nil, ErrInvalidSharing
+	}
+	u, err := url.Parse(m.Instance)
+	if err != nil {
+		return nil, ErrInvalidSharing
+	}
+	opts := &request.Options{
+		Method: http.MethodGet,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   "/sharings/" + s.SID + "/notes/" + noteID + "/images/" + name,
+		Headers: request.Headers{
+			"Authorization": "Bearer " + creds.AccessToken.AccessToken,
+		},
+	}
+	res, err := request.Req(opts)
+	if res != nil && res.StatusCode/100 == 4 && res.StatusCode != http.StatusNotFound {
+		res, err = RefreshToken(inst, s, m, creds, opts, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	mime, _ := vfs.ExtractMimeAndClass(res.Header.Get("Content-Type"))
+	inst.Logger().WithField("nspace", "replicator").
+		Debugf("Fetched image %s of note %s from %s", name, noteID, m.Instance)
+	return note.SaveImage(inst, noteID, name, mime, res.Body)
+}
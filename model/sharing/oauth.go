@@ -387,6 +387,13 @@ func (s *Sharing) ProcessAnswer(inst *instance.Instance, creds *APICredentials)
 	}
 	for i, c := range s.Credentials {
 		if c.State == creds.State {
+			// A guest that has connected their cozy is now a normal member
+			if s.Members[i+1].Guest {
+				if err := s.RemoveGuestCode(inst, &s.Members[i+1]); err != nil {
+					return nil, err
+				}
+				s.Members[i+1].Guest = false
+			}
 			s.Members[i+1].Status = MemberStatusReady
 			s.Members[i+1].PublicName = creds.PublicName
 			s.Credentials[i].Client = creds.Client
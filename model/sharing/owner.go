@@ -0,0 +1,516 @@
+package sharing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+)
+
+// APIOwnership is used to serialize the new list of members, and the
+// credentials that go with it, when the ownership of a sharing is transferred
+// to another member.
+type APIOwnership struct {
+	SID         string        `json:"_id,omitempty"`
+	Members     []Member      `json:"members"`
+	Credentials []Credentials `json:"credentials"`
+}
+
+// ID returns the sharing qualified identifier
+func (o *APIOwnership) ID() string { return o.SID }
+
+// Rev returns the sharing revision
+func (o *APIOwnership) Rev() string { return "" }
+
+// DocType returns the sharing document type
+func (o *APIOwnership) DocType() string { return consts.SharingsOwnership }
+
+// SetID changes the sharing qualified identifier
+func (o *APIOwnership) SetID(id string) { o.SID = id }
+
+// SetRev changes the sharing revision
+func (o *APIOwnership) SetRev(rev string) {}
+
+// Clone is part of jsonapi.Object interface
+func (o *APIOwnership) Clone() couchdb.Doc {
+	panic("APIOwnership must not be cloned")
+}
+
+// Included is part of jsonapi.Object interface
+func (o *APIOwnership) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (o *APIOwnership) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (o *APIOwnership) Links() *jsonapi.LinksList { return nil }
+
+var _ jsonapi.Object = (*APIOwnership)(nil)
+
+// CombineXorKeys returns the key that transforms the identifiers of a file
+// XORed with a first key to the identifiers XORed with a second key. It is
+// used when the ownership of a sharing is transferred: the identifiers on the
+// cozy of the recipients are kept, and only the keys are changed.
+func CombineXorKeys(a, b []byte) []byte {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+	l := len(a)
+	if len(b) > l {
+		l = len(b)
+	}
+	key := make([]byte, l)
+	for i := range key {
+		key[i] = a[i%len(a)] ^ b[i%len(b)]
+	}
+	return key
+}
+
+// swapOwner returns a copy of the members list where the member at the given
+// index is the new owner, and the old owner takes its place.
+func swapOwner(members []Member, index int) []Member {
+	swapped := make([]Member, len(members))
+	copy(swapped, members)
+	swapped[0], swapped[index] = members[index], members[0]
+	swapped[0].Status = MemberStatusOwner
+	swapped[0].ReadOnly = false
+	swapped[index].Status = MemberStatusReady
+	return swapped
+}
+
+// membersForRecipient returns the list of members that can be sent to the
+// member at the given index: the instance URL is private, except for the
+// owner and for the recipient itself.
+func membersForRecipient(members []Member, index int) []Member {
+	list := make([]Member, len(members))
+	for i, m := range members {
+		list[i] = Member{
+			Status:     m.Status,
+			PublicName: m.PublicName,
+			Email:      m.Email,
+			ReadOnly:   m.ReadOnly,
+		}
+		if i == 0 || i == index {
+			list[i].Instance = m.Instance
+		}
+	}
+	return list
+}
+
+// TransferOwnership gives the ownership of the sharing to the recipient at
+// the given index. It is called on the cozy of the owner, which sends to the
+// new owner the full list of members and its credentials toward them. The
+// old owner becomes a normal recipient, or leaves the sharing if leave is
+// true.
+func (s *Sharing) TransferOwnership(inst *instance.Instance, index int, leave bool) error {
+	if !s.Owner || !s.Active || len(s.Members) != len(s.Credentials)+1 {
+		return ErrInvalidSharing
+	}
+	if index <= 0 || index >= len(s.Members) {
+		return ErrMemberNotFound
+	}
+	m := &s.Members[index]
+	c := &s.Credentials[index-1]
+	if m.Status != MemberStatusReady || m.ReadOnly || m.Instance == "" ||
+		c.AccessToken == nil || c.InboundClientID == "" {
+		return ErrInvalidSharing
+	}
+	u, err := url.Parse(m.Instance)
+	if err != nil {
+		return ErrInvalidSharing
+	}
+
+	// The new owner will push its changes to this cozy, so it needs an access
+	// token with all the verbs, even if the rules are read-only.
+	cli, err := oauth.FindClient(inst, c.InboundClientID)
+	if err != nil {
+		return err
+	}
+	token, err := CreateAccessToken(inst, cli, s.SID, permission.ALL)
+	if err != nil {
+		return err
+	}
+
+	members := swapOwner(s.Members, index)
+	creds := make([]Credentials, len(s.Credentials))
+	for i := range creds {
+		if i+1 == index {
+			creds[i] = Credentials{
+				AccessToken: token,
+				XorKey:      c.XorKey,
+			}
+			continue
+		}
+		old := s.Credentials[i]
+		creds[i] = Credentials{State: old.State}
+		if s.Members[i+1].Status == MemberStatusReady {
+			creds[i].Client = old.Client
+			creds[i].AccessToken = old.AccessToken
+			creds[i].XorKey = CombineXorKeys(c.XorKey, old.XorKey)
+		}
+	}
+	ownership := APIOwnership{
+		SID:         s.SID,
+		Members:     members,
+		Credentials: creds,
+	}
+	data, err := jsonapi.MarshalObject(&ownership)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(jsonapi.Document{Data: &data})
+	if err != nil {
+		return err
+	}
+
+	// The sharing is saved as a recipient before asking the new owner to take
+	// the ownership, and it is restored if the new owner refuses it. The
+	// OAuth clients and the triggers are only changed after the transfer has
+	// been accepted, as it can't be undone.
+	oldMembers := make([]Member, len(s.Members))
+	copy(oldMembers, s.Members)
+	oldCreds := make([]Credentials, len(s.Credentials))
+	copy(oldCreds, s.Credentials)
+	s.Owner = false
+	s.Members = membersForRecipient(members, index)
+	s.Credentials = []Credentials{{
+		Client:          c.Client,
+		AccessToken:     c.AccessToken,
+		XorKey:          c.XorKey,
+		InboundClientID: c.InboundClientID,
+	}}
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		s.Owner = true
+		s.Members = oldMembers
+		s.Credentials = oldCreds
+		return err
+	}
+
+	opts := &request.Options{
+		Method: http.MethodPost,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   "/sharings/" + s.SID + "/recipients/self/owner",
+		Headers: request.Headers{
+			"Accept":        "application/vnd.api+json",
+			"Content-Type":  "application/vnd.api+json",
+			"Authorization": "Bearer " + s.Credentials[0].AccessToken.AccessToken,
+		},
+		Body: bytes.NewReader(body),
+	}
+	res, err := request.Req(opts)
+	if res != nil && res.StatusCode/100 == 4 {
+		res, err = RefreshToken(inst, s, &s.Members[0], &s.Credentials[0], opts, body)
+	}
+	if err != nil {
+		inst.Logger().WithField("nspace", "sharing").
+			Warnf("Error on ownership transfer for %s: %s", s.SID, err)
+		// The access token may have been refreshed, and it is shared with
+		// the old credentials.
+		s.Owner = true
+		s.Members = oldMembers
+		s.Credentials = oldCreds
+		if errr := couchdb.UpdateDoc(inst, s); errr != nil {
+			inst.Logger().WithField("nspace", "sharing").
+				Errorf("Cannot restore the sharing %s as owner: %s", s.SID, errr)
+		}
+		return ErrRequestFailed
+	}
+	res.Body.Close()
+
+	s.becomeRecipient(inst, oldMembers, oldCreds, index)
+	if leave {
+		return s.RevokeRecipientBySelf(inst)
+	}
+	return nil
+}
+
+// becomeRecipient is called on the old owner, after the new owner has
+// accepted the ownership of the sharing. The sharing has already been saved
+// as a recipient, and the errors are only logged, as the transfer can no
+// longer be rolled back.
+func (s *Sharing) becomeRecipient(inst *instance.Instance, oldMembers []Member, oldCreds []Credentials, index int) {
+	log := inst.Logger().WithField("nspace", "sharing")
+	for i := range oldCreds {
+		if i+1 == index {
+			continue
+		}
+		// The other members now use the credentials given by the new owner
+		if err := DeleteOAuthClient(inst, &oldMembers[i+1], &oldCreds[i]); err != nil {
+			log.Infof("Cannot delete the OAuth client for %s: %s", s.SID, err)
+		}
+	}
+	for i := range s.Members {
+		if err := s.ClearLastSequenceNumbers(inst, &s.Members[i]); err != nil {
+			log.Warnf("Cannot clear the sequence numbers for %s: %s", s.SID, err)
+		}
+	}
+	if err := s.RemoveTriggers(inst); err != nil {
+		log.Warnf("Cannot remove the triggers for %s: %s", s.SID, err)
+	}
+	if s.PreviewPath != "" {
+		if err := s.RevokePreviewPermissions(inst); err != nil && !couchdb.IsNotFoundError(err) {
+			log.Warnf("Cannot revoke the preview permissions for %s: %s", s.SID, err)
+		}
+	}
+	if err := s.SetupReceiver(inst); err != nil {
+		log.Warnf("Cannot setup the receiver for %s: %s", s.SID, err)
+	}
+	s.pushJob(inst, "share-replicate")
+	if s.FirstFilesRule() != nil {
+		s.pushJob(inst, "share-upload")
+	}
+}
+
+// TakeOwnership is called on the cozy of the recipient that becomes the new
+// owner of the sharing. It takes over the credentials of the old owner toward
+// the other members, gives them new credentials to contact this cozy, and
+// re-creates the triggers of the sharing. An error is returned only if the
+// sharing has been left unchanged, as the old owner will then restore it.
+func (s *Sharing) TakeOwnership(inst *instance.Instance, ownership *APIOwnership) error {
+	if s.Owner || !s.Active || len(s.Credentials) != 1 {
+		return ErrInvalidSharing
+	}
+	if len(ownership.Members) != len(ownership.Credentials)+1 {
+		return ErrInvalidSharing
+	}
+	previous, err := s.checkOwnerSwap(ownership.Members)
+	if err != nil {
+		return err
+	}
+
+	old := s.Credentials[0]
+	members := make([]Member, len(ownership.Members))
+	copy(members, ownership.Members)
+	members[0].Instance = inst.PageURL("", nil)
+	creds := make([]Credentials, len(ownership.Credentials))
+	copy(creds, ownership.Credentials)
+	creds[previous-1].Client = old.Client
+	creds[previous-1].InboundClientID = old.InboundClientID
+	creds[previous-1].XorKey = old.XorKey
+
+	// The OAuth clients for the other members are deleted if the sharing
+	// can't be saved, to leave this cozy unchanged.
+	var clients []*oauth.Client
+	rollback := func() {
+		for _, cli := range clients {
+			if cerr := cli.Delete(inst); cerr != nil {
+				inst.Logger().WithField("nspace", "sharing").
+					Infof("Cannot delete the OAuth client for %s: %s", s.SID, cerr.Error)
+			}
+		}
+	}
+	readOnly := s.ReadOnlyRules()
+	tokens := make(map[int]*APICredentials)
+	for i := range creds {
+		m := &members[i+1]
+		if i+1 == previous {
+			continue
+		}
+		if m.Status != MemberStatusReady {
+			// The invitation was sent by the old owner, and the new owner
+			// will have to send it again
+			if len(creds[i].XorKey) == 0 {
+				creds[i].XorKey = MakeXorKey()
+			}
+			continue
+		}
+		cli, err := CreateOAuthClient(inst, m)
+		if err != nil {
+			rollback()
+			return err
+		}
+		clients = append(clients, cli)
+		verb := permission.ALL
+		if readOnly || m.ReadOnly {
+			verb = permission.Verbs(permission.GET)
+		}
+		token, err := CreateAccessToken(inst, cli, s.SID, verb)
+		if err != nil {
+			rollback()
+			return err
+		}
+		creds[i].InboundClientID = cli.ClientID
+		tokens[i+1] = &APICredentials{
+			CID: s.SID,
+			Credentials: &Credentials{
+				Client:      ConvertOAuthClient(cli),
+				AccessToken: token,
+				XorKey:      creds[i].XorKey,
+			},
+		}
+	}
+
+	oldMembers := s.Members
+	s.Owner = true
+	s.Members = members
+	s.Credentials = creds
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		s.Owner = false
+		s.Members = oldMembers
+		s.Credentials = []Credentials{old}
+		rollback()
+		return err
+	}
+
+	// The ownership has been taken, the errors are only logged from here
+	log := inst.Logger().WithField("nspace", "sharing")
+	if err := s.ClearLastSequenceNumbers(inst, &s.Members[0]); err != nil {
+		log.Warnf("Cannot clear the sequence numbers for %s: %s", s.SID, err)
+	}
+	if err := s.RemoveTriggers(inst); err != nil {
+		log.Warnf("Cannot remove the triggers for %s: %s", s.SID, err)
+	}
+	if err := s.AddTrackTriggers(inst); err != nil {
+		log.Warnf("Cannot add the track triggers for %s: %s", s.SID, err)
+	}
+	if err := s.AddReplicateTrigger(inst); err != nil {
+		log.Warnf("Cannot add the replicate trigger for %s: %s", s.SID, err)
+	}
+	if s.FirstFilesRule() != nil {
+		if err := s.AddUploadTrigger(inst); err != nil {
+			log.Warnf("Cannot add the upload trigger for %s: %s", s.SID, err)
+		}
+	}
+
+	for index, creds := range tokens {
+		if err := s.NotifyNewOwner(inst, index, creds); err != nil {
+			log.Warnf("Can't notify %#v about the new owner: %s", s.Members[index], err)
+		}
+	}
+
+	s.pushJob(inst, "share-replicate")
+	if s.FirstFilesRule() != nil {
+		s.pushJob(inst, "share-upload")
+	}
+	return nil
+}
+
+// checkOwnerSwap checks that the list of members sent for an ownership
+// transfer is the list of members of the sharing, where the old owner and the
+// new owner have swapped their places. It returns the index of the old owner
+// in the new list.
+func (s *Sharing) checkOwnerSwap(members []Member) (int, error) {
+	if len(members) < 2 || len(members) != len(s.Members) {
+		return -1, ErrInvalidSharing
+	}
+	if members[0].Status != MemberStatusOwner || members[0].Instance == "" {
+		return -1, ErrInvalidSharing
+	}
+	previous := -1
+	for i := 1; i < len(members); i++ {
+		if sameMember(&members[i], &s.Members[i]) {
+			continue
+		}
+		if previous > 0 ||
+			!sameMember(&members[i], &s.Members[0]) ||
+			!sameMember(&members[0], &s.Members[i]) {
+			return -1, ErrInvalidSharing
+		}
+		previous = i
+	}
+	if previous < 0 {
+		return -1, ErrMemberNotFound
+	}
+	return previous, nil
+}
+
+// sameMember returns true if the two members have the same email, and the
+// same instance when it is known for both of them (the instance URL of a
+// member is only sent to the owner).
+func sameMember(a, b *Member) bool {
+	if a.Email != b.Email {
+		return false
+	}
+	return a.Instance == "" || b.Instance == "" || a.Instance == b.Instance
+}
+
+// NotifyNewOwner is called on the new owner to send to the member at the
+// given index the new list of members and the credentials for contacting
+// the new owner. The request is made with the credentials taken over from the
+// old owner.
+func (s *Sharing) NotifyNewOwner(inst *instance.Instance, index int, creds *APICredentials) error {
+	m := &s.Members[index]
+	c := &s.Credentials[index-1]
+	u, err := url.Parse(m.Instance)
+	if m.Instance == "" || err != nil || c.AccessToken == nil {
+		return ErrInvalidSharing
+	}
+	ownership := APIOwnership{
+		SID:         s.SID,
+		Members:     membersForRecipient(s.Members, index),
+		Credentials: []Credentials{*creds.Credentials},
+	}
+	data, err := jsonapi.MarshalObject(&ownership)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(jsonapi.Document{Data: &data})
+	if err != nil {
+		return err
+	}
+	opts := &request.Options{
+		Method: http.MethodPut,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   "/sharings/" + s.SID + "/owner",
+		Headers: request.Headers{
+			"Accept":        "application/vnd.api+json",
+			"Content-Type":  "application/vnd.api+json",
+			"Authorization": "Bearer " + c.AccessToken.AccessToken,
+		},
+		Body: bytes.NewReader(body),
+	}
+	res, err := request.Req(opts)
+	if res != nil && res.StatusCode/100 == 4 {
+		res, err = RefreshToken(inst, s, m, c, opts, body)
+	}
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// ChangeOwner is called on the cozy of a recipient when the ownership of the
+// sharing has been transferred to another member. The recipient keeps its
+// documents and triggers, and starts to synchronize them with the new owner.
+func (s *Sharing) ChangeOwner(inst *instance.Instance, ownership *APIOwnership) error {
+	if s.Owner || !s.Active || len(s.Credentials) != 1 {
+		return ErrInvalidSharing
+	}
+	if len(ownership.Credentials) != 1 {
+		return ErrInvalidSharing
+	}
+	if _, err := s.checkOwnerSwap(ownership.Members); err != nil {
+		return err
+	}
+	if err := s.ClearLastSequenceNumbers(inst, &s.Members[0]); err != nil {
+		return err
+	}
+
+	creds := ownership.Credentials[0]
+	s.Members = ownership.Members
+	s.Credentials[0].Client = creds.Client
+	s.Credentials[0].AccessToken = creds.AccessToken
+	s.Credentials[0].XorKey = creds.XorKey
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+
+	if !s.ReadOnly() {
+		s.pushJob(inst, "share-replicate")
+		if s.FirstFilesRule() != nil {
+			s.pushJob(inst, "share-upload")
+		}
+	}
+	return nil
+}
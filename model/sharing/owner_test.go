@@ -0,0 +1,106 @@
+package sharing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCombineXorKeys(t *testing.T) {
+	id := "12345678-abcd-90ef-1337-cafebee54321"
+	oldOwner := MakeXorKey()
+	newOwner := MakeXorKey()
+	recipient := MakeXorKey()
+
+	// The identifiers on the cozy of the recipient are kept
+	onNewOwner := XorID(id, newOwner)
+	onRecipient := XorID(id, recipient)
+	key := CombineXorKeys(newOwner, recipient)
+	assert.Len(t, key, 16)
+	assert.Equal(t, onRecipient, XorID(onNewOwner, key))
+	assert.Equal(t, onNewOwner, XorID(onRecipient, key))
+	for _, k := range key {
+		assert.True(t, k < 16)
+	}
+
+	assert.Nil(t, CombineXorKeys(nil, oldOwner))
+	assert.Equal(t, make([]byte, 16), CombineXorKeys(oldOwner, oldOwner))
+}
+
+func TestSwapOwner(t *testing.T) {
+	members := []Member{
+		{Status: MemberStatusOwner, Email: "alice@example.net", Instance: "https://alice.example.net"},
+		{Status: MemberStatusReady, Email: "bob@example.net", Instance: "https://bob.example.net"},
+		{Status: MemberStatusReady, Email: "dave@example.net", Instance: "https://dave.example.net", ReadOnly: true},
+	}
+	swapped := swapOwner(members, 2)
+	assert.Len(t, swapped, 3)
+	assert.Equal(t, "dave@example.net", swapped[0].Email)
+	assert.Equal(t, MemberStatusOwner, swapped[0].Status)
+	assert.False(t, swapped[0].ReadOnly)
+	assert.Equal(t, "bob@example.net", swapped[1].Email)
+	assert.Equal(t, "alice@example.net", swapped[2].Email)
+	assert.Equal(t, MemberStatusReady, swapped[2].Status)
+	// The original list is not modified
+	assert.Equal(t, "alice@example.net", members[0].Email)
+
+	list := membersForRecipient(swapped, 1)
+	assert.Equal(t, "https://dave.example.net", list[0].Instance)
+	assert.Equal(t, "https://bob.example.net", list[1].Instance)
+	assert.Empty(t, list[2].Instance)
+}
+
+func TestCheckOwnerSwap(t *testing.T) {
+	// The sharing, as seen by Bob, a recipient
+	s := &Sharing{Members: []Member{
+		{Status: MemberStatusOwner, Email: "alice@example.net", Instance: "https://alice.example.net"},
+		{Status: MemberStatusReady, Email: "bob@example.net", Instance: "https://bob.example.net"},
+		{Status: MemberStatusReady, Email: "dave@example.net"},
+	}}
+
+	// Dave becomes the owner
+	members := []Member{
+		{Status: MemberStatusOwner, Email: "dave@example.net", Instance: "https://dave.example.net"},
+		{Status: MemberStatusReady, Email: "bob@example.net", Instance: "https://bob.example.net"},
+		{Status: MemberStatusReady, Email: "alice@example.net"},
+	}
+	previous, err := s.checkOwnerSwap(members)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, previous)
+
+	// A member can't be added or removed
+	_, err = s.checkOwnerSwap(members[:2])
+	assert.Equal(t, ErrInvalidSharing, err)
+	extra := append(members, Member{Status: MemberStatusReady, Email: "eve@example.net"})
+	_, err = s.checkOwnerSwap(extra)
+	assert.Equal(t, ErrInvalidSharing, err)
+
+	// A member can't be replaced
+	replaced := make([]Member, len(members))
+	copy(replaced, members)
+	replaced[1].Email = "eve@example.net"
+	_, err = s.checkOwnerSwap(replaced)
+	assert.Equal(t, ErrInvalidSharing, err)
+
+	// The new owner must be a member
+	copy(replaced, members)
+	replaced[0].Email = "eve@example.net"
+	_, err = s.checkOwnerSwap(replaced)
+	assert.Equal(t, ErrInvalidSharing, err)
+
+	// The instance of a known member can't be changed
+	copy(replaced, members)
+	replaced[1].Instance = "https://eve.example.net"
+	_, err = s.checkOwnerSwap(replaced)
+	assert.Equal(t, ErrInvalidSharing, err)
+
+	// The list must have an owner
+	copy(replaced, members)
+	replaced[0].Status = MemberStatusReady
+	_, err = s.checkOwnerSwap(replaced)
+	assert.Equal(t, ErrInvalidSharing, err)
+
+	// Without a swap, there is no new owner
+	_, err = s.checkOwnerSwap(s.Members)
+	assert.Equal(t, ErrMemberNotFound, err)
+}
@@ -576,15 +576,17 @@ func (s *Sharing) sendBulkDocs(inst *instance.Instance, m *Member, creds *Creden
 	return nil
 }
 
-// ApplyBulkDocs is a multi-doctypes version of the POST _bulk_docs endpoint of CouchDB
-func (s *Sharing) ApplyBulkDocs(inst *instance.Instance, payload DocsByDoctype) error {
+// ApplyBulkDocs is a multi-doctypes version of the POST _bulk_docs endpoint of
+// CouchDB. The member is the one who has sent the documents, and it is used
+// for the activity of the sharing (it can be nil).
+func (s *Sharing) ApplyBulkDocs(inst *instance.Instance, payload DocsByDoctype, m *Member) error {
 	var refs []*SharedRef
 
 	for doctype, docs := range payload {
 		inst.Logger().WithField("nspace", "replicator").
 			Debugf("Apply bulk docs %s: %#v", doctype, docs)
 		if doctype == consts.Files {
-			err := s.ApplyBulkFiles(inst, docs)
+			err := s.ApplyBulkFiles(inst, docs, m)
 			if err != nil {
 				return err
 			}
@@ -614,6 +616,18 @@ func (s *Sharing) ApplyBulkDocs(inst *instance.Instance, payload DocsByDoctype)
 			}
 			refs = append(refs, newRefs...)
 			refs = append(refs, existingRefs...)
+			nbNew := len(newRefs)
+			for i, doc := range okDocs {
+				action := ActivityUpdated
+				if _, ok := doc["_deleted"]; ok {
+					action = ActivityDeleted
+				} else if i < nbNew {
+					action = ActivityCreated
+				}
+				id, _ := doc["_id"].(string)
+				rev, _ := doc["_rev"].(string)
+				s.recordActivity(inst, m, action, doctype, id, rev, "")
+			}
 		}
 	}
 
@@ -336,9 +336,20 @@ func UpdateShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent) err
 		}
 	}
 
+	action := ActivityUpdated
+	if ref.Infos[msg.SharingID].Removed {
+		action = ActivityDeleted
+	} else if ref.Rev() == "" {
+		action = ActivityCreated
+	}
+
 	if ref.Rev() == "" {
 		ref.Revisions = &RevsTree{Rev: rev}
-		return couchdb.CreateNamedDoc(inst, &ref)
+		if err := couchdb.CreateNamedDoc(inst, &ref); err != nil {
+			return err
+		}
+		recordLocalActivity(inst, msg.SharingID, action, evt)
+		return nil
 	}
 	if evt.OldDoc == nil {
 		inst.Logger().WithField("nspace", "sharing").
@@ -351,6 +362,7 @@ func UpdateShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent) err
 	if err := couchdb.UpdateDoc(inst, &ref); err != nil {
 		return err
 	}
+	recordLocalActivity(inst, msg.SharingID, action, evt)
 
 	// For a directory, we have to update the Removed flag for the files inside
 	// it, as we won't have any events for them.
@@ -366,6 +378,17 @@ func UpdateShared(inst *instance.Instance, msg TrackMessage, evt TrackEvent) err
 	return nil
 }
 
+// recordLocalActivity adds to the activity of the sharing a change made on the
+// cozy of the owner.
+func recordLocalActivity(inst *instance.Instance, sharingID, action string, evt TrackEvent) {
+	s, err := FindSharing(inst, sharingID)
+	if err != nil || !s.Owner || !s.Active {
+		return
+	}
+	name, _ := evt.Doc.Get("name").(string)
+	s.recordActivity(inst, &s.Members[0], action, evt.Doc.Type, evt.Doc.ID(), evt.Doc.Rev(), name)
+}
+
 // UpdateFileShared creates or updates the io.cozy.shared for a file with
 // possibly multiple revisions.
 func UpdateFileShared(db couchdb.Database, ref *SharedRef, revs RevsStruct) error {
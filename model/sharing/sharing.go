@@ -29,6 +29,7 @@ type Triggers struct {
 	TrackID     string `json:"track_id,omitempty"`
 	ReplicateID string `json:"replicate_id,omitempty"`
 	UploadID    string `json:"upload_id,omitempty"`
+	DigestID    string `json:"digest_id,omitempty"`
 }
 
 // Sharing contains all the information about a sharing.
@@ -170,7 +171,7 @@ func (s *Sharing) CreatePreviewPermissions(inst *instance.Instance) (map[string]
 	codes := make(map[string]string, len(s.Members)-1)
 
 	for i, m := range s.Members {
-		if i == 0 {
+		if i == 0 || m.Guest {
 			continue
 		}
 		var err error
@@ -318,6 +319,11 @@ func (s *Sharing) Revoke(inst *instance.Instance) error {
 			return err
 		}
 	}
+	if s.HasGuests() {
+		if err := s.RevokeGuestPermissions(inst); err != nil {
+			return err
+		}
+	}
 	s.Active = false
 	if err := couchdb.UpdateDoc(inst, s); err != nil {
 		return err
@@ -347,6 +353,11 @@ func (s *Sharing) RevokeRecipient(inst *instance.Instance, index int) error {
 	if err := s.RevokeMember(inst, &s.Members[index], &s.Credentials[index-1]); err != nil {
 		return err
 	}
+	if s.Members[index].Guest {
+		if err := s.RemoveGuestCode(inst, &s.Members[index]); err != nil {
+			return err
+		}
+	}
 	if err := s.ClearLastSequenceNumbers(inst, &s.Members[index]); err != nil {
 		return err
 	}
@@ -398,7 +409,9 @@ func (s *Sharing) RemoveTriggers(inst *instance.Instance) error {
 	if err := removeSharingTrigger(inst, s.Triggers.UploadID); err != nil {
 		return err
 	}
-	s.Triggers = Triggers{}
+	// The trigger for the digest of the activity is kept, it will be removed
+	// by the worker if the sharing is no longer active.
+	s.Triggers = Triggers{DigestID: s.Triggers.DigestID}
 	return nil
 }
 
@@ -332,8 +332,9 @@ func (s *Sharing) createUploadKey(inst *instance.Instance, target *FileDocWithRe
 }
 
 // SyncFile tries to synchronize a file with just the metadata. If it can't,
-// it will return a key to upload the content.
-func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions) (*KeyToUpload, error) {
+// it will return a key to upload the content. The member is the one who has
+// sent the metadata, and it is used for the activity of the sharing.
+func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions, m *Member) (*KeyToUpload, error) {
 	inst.Logger().WithField("nspace", "upload").Debugf("SyncFile %#v", target)
 	mu := lock.ReadWrite(inst, "shared")
 	if err := mu.Lock(); err != nil {
@@ -372,7 +373,11 @@ func (s *Sharing) SyncFile(inst *instance.Instance, target *FileDocWithRevisions
 	if !bytes.Equal(target.MD5Sum, current.MD5Sum) {
 		return s.createUploadKey(inst, target)
 	}
-	return nil, s.updateFileMetadata(inst, target, current, &ref)
+	if err = s.updateFileMetadata(inst, target, current, &ref); err != nil {
+		return nil, err
+	}
+	s.recordActivity(inst, m, ActivityUpdated, consts.Files, current.DocID, target.DocRev, current.DocName)
+	return nil, nil
 }
 
 // prepareFileWithAncestors find the parent directory for file, and recreates it
@@ -455,8 +460,9 @@ func (s *Sharing) updateFileMetadata(inst *instance.Instance, target *FileDocWit
 }
 
 // HandleFileUpload is used to receive a file upload when synchronizing just
-// the metadata was not enough.
-func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.ReadCloser) error {
+// the metadata was not enough. The member is the one who has sent the file,
+// and it is used for the activity of the sharing.
+func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.ReadCloser, m *Member) error {
 	defer body.Close()
 	target, err := getStore().Get(inst, key)
 	inst.Logger().WithField("nspace", "upload").Debugf("HandleFileUpload %#v %#v", target.FileDoc, target.Revisions)
@@ -480,13 +486,13 @@ func (s *Sharing) HandleFileUpload(inst *instance.Instance, key string, body io.
 	}
 
 	if current == nil {
-		return s.UploadNewFile(inst, target, body)
+		return s.UploadNewFile(inst, target, body, m)
 	}
-	return s.UploadExistingFile(inst, target, current, body)
+	return s.UploadExistingFile(inst, target, current, body, m)
 }
 
 // UploadNewFile is used to receive a new file.
-func (s *Sharing) UploadNewFile(inst *instance.Instance, target *FileDocWithRevisions, body io.ReadCloser) error {
+func (s *Sharing) UploadNewFile(inst *instance.Instance, target *FileDocWithRevisions, body io.ReadCloser, m *Member) error {
 	inst.Logger().WithField("nspace", "upload").Debugf("UploadNewFile")
 	ref := SharedRef{
 		Infos: make(map[string]SharedInfo),
@@ -559,7 +565,11 @@ func (s *Sharing) UploadNewFile(inst *instance.Instance, target *FileDocWithRevi
 	if s.NbFiles > 0 {
 		defer s.countReceivedFiles(inst)
 	}
-	return copyFileContent(inst, file, body)
+	if err = copyFileContent(inst, file, body); err != nil {
+		return err
+	}
+	s.recordActivity(inst, m, ActivityCreated, consts.Files, newdoc.DocID, newdoc.DocRev, newdoc.DocName)
+	return nil
 }
 
 // countReceivedFiles counts the number of files received during the initial
@@ -612,7 +622,7 @@ func (s *Sharing) countReceivedFiles(inst *instance.Instance) {
 // than on content: a conflict on different content is resolved by a copy of
 // the file (which is not what we want), a conflict of name+dir_id, the higher
 // revision wins and it should be the good one in our case.
-func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWithRevisions, newdoc *vfs.FileDoc, body io.ReadCloser) error {
+func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWithRevisions, newdoc *vfs.FileDoc, body io.ReadCloser, m *Member) error {
 	inst.Logger().WithField("nspace", "upload").Debugf("UploadExistingFile")
 	var ref SharedRef
 	err := couchdb.GetDoc(inst, consts.Shared, consts.Files+"/"+target.DocID, &ref)
@@ -647,9 +657,9 @@ func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWit
 	conflict := detectConflict(newdoc.DocRev, chain)
 	switch conflict {
 	case LostConflict:
-		return s.uploadLostConflict(inst, target, newdoc, body)
+		return s.uploadLostConflict(inst, target, newdoc, body, m)
 	case WonConflict:
-		if err = s.uploadWonConflict(inst, olddoc); err != nil {
+		if err = s.uploadWonConflict(inst, olddoc, m); err != nil {
 			return err
 		}
 	case NoConflict:
@@ -663,7 +673,11 @@ func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWit
 		if errf != nil {
 			return errf
 		}
-		return copyFileContent(inst, file, body)
+		if err = copyFileContent(inst, file, body); err != nil {
+			return err
+		}
+		s.recordActivity(inst, m, ActivityUpdated, consts.Files, newdoc.DocID, newdoc.DocRev, newdoc.DocName)
+		return nil
 	}
 
 	stash := indexer.StashRevision(false)
@@ -698,12 +712,16 @@ func (s *Sharing) UploadExistingFile(inst *instance.Instance, target *FileDocWit
 		}
 		err = fs.UpdateFileDoc(tmpdoc, newdoc)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	s.recordActivity(inst, m, ActivityUpdated, consts.Files, newdoc.DocID, newdoc.DocRev, newdoc.DocName)
+	return nil
 }
 
 // uploadLostConflict manages an upload where a file is in conflict, and the
 // uploaded file version goes to a new file.
-func (s *Sharing) uploadLostConflict(inst *instance.Instance, target *FileDocWithRevisions, newdoc *vfs.FileDoc, body io.ReadCloser) error {
+func (s *Sharing) uploadLostConflict(inst *instance.Instance, target *FileDocWithRevisions, newdoc *vfs.FileDoc, body io.ReadCloser, m *Member) error {
 	rev := target.Rev()
 	inst.Logger().WithField("nspace", "upload").Debugf("uploadLostConflict %s", rev)
 	indexer := newSharingIndexer(inst, &bulkRevs{
@@ -727,12 +745,16 @@ func (s *Sharing) uploadLostConflict(inst *instance.Instance, target *FileDocWit
 		return err
 	}
 	inst.Logger().WithField("nspace", "upload").Debugf("1. loser = %#v", newdoc)
-	return copyFileContent(inst, file, body)
+	if err = copyFileContent(inst, file, body); err != nil {
+		return err
+	}
+	s.recordActivity(inst, m, ActivityConflict, consts.Files, newdoc.DocID, newdoc.DocRev, newdoc.DocName)
+	return nil
 }
 
 // uploadWonConflict manages an upload where a file is in conflict, and the
 // existing file is copied to a new file to let the upload succeed.
-func (s *Sharing) uploadWonConflict(inst *instance.Instance, src *vfs.FileDoc) error {
+func (s *Sharing) uploadWonConflict(inst *instance.Instance, src *vfs.FileDoc, m *Member) error {
 	rev := src.Rev()
 	inst.Logger().WithField("nspace", "upload").Debugf("uploadWonConflict %s", rev)
 	indexer := newSharingIndexer(inst, &bulkRevs{
@@ -757,7 +779,11 @@ func (s *Sharing) uploadWonConflict(inst *instance.Instance, src *vfs.FileDoc) e
 		return err
 	}
 	inst.Logger().WithField("nspace", "upload").Debugf("2. loser = %#v", dst)
-	return copyFileContent(inst, file, content)
+	if err = copyFileContent(inst, file, content); err != nil {
+		return err
+	}
+	s.recordActivity(inst, m, ActivityConflict, consts.Files, dst.DocID, dst.DocRev, dst.DocName)
+	return nil
 }
 
 // copyFileContent will copy the body of the HTTP request to the file, and
@@ -23,6 +23,20 @@ var _ Fetcher = (*DirDoc)(nil)
 
 // Allows check if a permSet allows verb on given file
 func Allows(fs VFS, pset permission.Set, v permission.Verb, fd Fetcher) error {
+	var err error
+	if pset.AllowsWith(func(set permission.Set) bool {
+		err = allows(fs, set, v, fd)
+		return err == nil
+	}) {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("no permission")
+	}
+	return err
+}
+
+func allows(fs VFS, pset permission.Set, v permission.Verb, fd Fetcher) error {
 	allowedIDs := []string{}
 	otherRules := []permission.Rule{}
 
@@ -111,6 +125,92 @@ func Allows(fs VFS, pset permission.Set, v permission.Verb, fd Fetcher) error {
 	return errors.New("no permission")
 }
 
+// ExplainAllows is like Allows, but it returns the details of the check of
+// every rule, including the permissions inherited from the parent
+// directories.
+func ExplainAllows(fs VFS, pset permission.Set, v permission.Verb, fd Fetcher) (*permission.Explanation, error) {
+	e := permission.NewExplanation(v, consts.Files, fd.ID())
+	selfPath, err := fd.Path(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range pset {
+		if reason := permission.CheckVerbAndType(r, v, consts.Files); reason != "" {
+			e.Add(i, r, false, reason)
+			continue
+		}
+
+		// permission on whole io.cozy.files doctype
+		if len(r.Values) == 0 {
+			e.Add(i, r, true, "the rule gives access to the whole doctype")
+			continue
+		}
+
+		// permission by ID on self or an ancestor
+		if r.Selector == "" {
+			matched, reason := explainByID(fs, r, fd, selfPath)
+			e.Add(i, r, matched, reason)
+			continue
+		}
+
+		// permission by attributes values (tags, mime ...) on self
+		if r.ValuesMatch(fd) {
+			e.Add(i, r, true, fmt.Sprintf("the field %s of the file matches a value of the rule", r.Selector))
+			continue
+		}
+		if r.Selector == "mime" || r.Selector == "class" {
+			e.Add(i, r, false, fmt.Sprintf("the field %s of the file doesn't match any value of the rule", r.Selector))
+			continue
+		}
+
+		// permission by attributes values on an ancestor
+		matched, reason, err := explainByAncestor(fs, r, fd)
+		if err != nil {
+			return nil, err
+		}
+		e.Add(i, r, matched, reason)
+	}
+	return e.Finish(), nil
+}
+
+func explainByID(fs VFS, r permission.Rule, fd Fetcher, selfPath string) (bool, string) {
+	for _, v := range r.Values {
+		switch v {
+		case fd.ID():
+			return true, "the identifier of the file is in the values of the rule"
+		case fd.parentID():
+			return true, "the identifier of the parent directory is in the values of the rule"
+		case consts.RootDirID:
+			return true, "the root directory is in the values of the rule"
+		}
+	}
+	for _, v := range r.Values {
+		allowedPath, err := pathFromID(fs, v)
+		if err == nil && strings.HasPrefix(selfPath, allowedPath+"/") {
+			return true, fmt.Sprintf("the directory %s is an ancestor of the file", allowedPath)
+		}
+	}
+	return false, fmt.Sprintf("neither the file nor its ancestors are in the values of the rule (path: %s)", selfPath)
+}
+
+func explainByAncestor(fs VFS, r permission.Rule, fd Fetcher) (bool, string, error) {
+	cur, err := fd.Parent(fs)
+	if err != nil {
+		return false, "", err
+	}
+	for cur.ID() != consts.RootDirID {
+		if r.ValuesMatch(cur) {
+			return true, fmt.Sprintf("the field %s of the ancestor %s matches a value of the rule", r.Selector, cur.Fullpath), nil
+		}
+		cur, err = cur.Parent(fs)
+		if err != nil {
+			return false, "", err
+		}
+	}
+	return false, fmt.Sprintf("the field %s of the file and its ancestors doesn't match any value of the rule", r.Selector), nil
+}
+
 func pathFromID(fs VFS, id string) (string, error) {
 	if id == consts.RootDirID {
 		return "", nil
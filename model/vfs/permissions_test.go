@@ -222,4 +222,17 @@ func TestPermissions(t *testing.T) {
 		},
 	}
 	assert.Error(t, vfs.Allows(fs, psetUnclePrefixID, permission.GET, f))
+
+	explanation, err := vfs.ExplainAllows(fs, psetUnclePrefixID, permission.GET, f)
+	assert.NoError(t, err)
+	assert.False(t, explanation.Allowed)
+	assert.Nil(t, explanation.MatchedRule)
+	assert.Len(t, explanation.Rules, 1)
+
+	explanation, err = vfs.ExplainAllows(fs, psetParentID, permission.GET, f)
+	assert.NoError(t, err)
+	assert.True(t, explanation.Allowed)
+	if assert.NotNil(t, explanation.MatchedRule) {
+		assert.Equal(t, 0, *explanation.MatchedRule)
+	}
 }
@@ -107,6 +107,13 @@ type Config struct {
 	CredentialsDecryptorKey string
 
 	RemoteAssets map[string]string
+	// RemoteSecrets are the secrets, by context, that can be injected in the
+	// requests of the remote doctypes.
+	RemoteSecrets map[string]map[string]string
+
+	// TrustedProxies are the networks of the reverse proxies that can be
+	// trusted for the X-Forwarded-For header.
+	TrustedProxies []*net.IPNet
 
 	Fs            Fs
 	CouchDB       CouchDB
@@ -131,6 +138,8 @@ type Config struct {
 	Registries     map[string][]*url.URL
 	Clouderies     map[string]interface{}
 
+	LocalRegistry LocalRegistry
+
 	CSPDisabled  bool
 	CSPWhitelist map[string]string
 
@@ -170,6 +179,9 @@ type Fs struct {
 type FsVersioning struct {
 	MaxNumberToKeep            int
 	MinDelayBetweenTwoVersions time.Duration
+	// MaxNumberOfAppVersionsToKeep is the number of previous versions of a
+	// webapp or konnector that are kept for a rollback.
+	MaxNumberOfAppVersionsToKeep int
 }
 
 // CouchDB contains the configuration values of the database
@@ -195,6 +207,11 @@ type Jobs struct {
 // Konnectors contains the configuration values for the konnectors
 type Konnectors struct {
 	Cmd string
+
+	// WasmMemoryLimit and WasmTimeLimit are the limits for the konnectors
+	// and services executed with the WebAssembly runtime.
+	WasmMemoryLimit int64
+	WasmTimeLimit   time.Duration
 }
 
 // Matomo contains the configuration for the JS tracking
@@ -218,6 +235,17 @@ type Notifications struct {
 	IOSTeamID              string
 }
 
+// LocalRegistry contains the configuration for the apps registry served by the
+// stack itself.
+type LocalRegistry struct {
+	// Host is the domain name on which the registry is served (the registry
+	// is disabled if it is empty).
+	Host string
+	// Path is the directory where the tarballs and versions are stored. If it
+	// is empty, they are stored with the file system of the stack (fs.url).
+	Path string
+}
+
 // Worker contains the configuration fields for a specific worker type.
 type Worker struct {
 	WorkerType   string
@@ -400,9 +428,13 @@ func applyDefaults(v *viper.Viper) {
 	v.SetDefault("jobs.imagemagick_convert_cmd", "convert")
 	v.SetDefault("jobs.defaultDurationToKeep", "2W")
 	v.SetDefault("assets_polling_disabled", false)
+	v.SetDefault("trusted_proxies", []string{"127.0.0.0/8", "::1/128"})
 	v.SetDefault("assets_polling_interval", 2*time.Minute)
 	v.SetDefault("fs.versioning.max_number_of_versions_to_keep", 20)
 	v.SetDefault("fs.versioning.min_delay_between_two_versions", 15*time.Minute)
+	v.SetDefault("fs.versioning.max_number_of_app_versions_to_keep", 2)
+	v.SetDefault("konnectors.wasm.memory_limit", 128*1024*1024)
+	v.SetDefault("konnectors.wasm.time_limit", 2*time.Minute)
 }
 
 func envMap() map[string]string {
@@ -479,6 +511,11 @@ func UseViper(v *viper.Viper) error {
 		return err
 	}
 
+	trustedProxies, err := makeTrustedProxies(v)
+	if err != nil {
+		return err
+	}
+
 	var subdomains SubdomainType
 	if subs := v.GetString("subdomains"); subs != "" {
 		switch subs {
@@ -661,7 +698,10 @@ func UseViper(v *viper.Viper) error {
 		GeoDB:                 v.GetString("geodb"),
 		PasswordResetInterval: v.GetDuration("password_reset_interval"),
 
-		RemoteAssets: v.GetStringMapString("remote_assets"),
+		RemoteAssets:  v.GetStringMapString("remote_assets"),
+		RemoteSecrets: makeRemoteSecrets(v),
+
+		TrustedProxies: trustedProxies,
 
 		CredentialsEncryptorKey: v.GetString("vault.credentials_encryptor_key"),
 		CredentialsDecryptorKey: v.GetString("vault.credentials_decryptor_key"),
@@ -671,8 +711,9 @@ func UseViper(v *viper.Viper) error {
 			Transport:     fsClient.Transport,
 			DefaultLayout: defaultLayout,
 			Versioning: FsVersioning{
-				MaxNumberToKeep:            v.GetInt("fs.versioning.max_number_of_versions_to_keep"),
-				MinDelayBetweenTwoVersions: v.GetDuration("fs.versioning.min_delay_between_two_versions"),
+				MaxNumberToKeep:              v.GetInt("fs.versioning.max_number_of_versions_to_keep"),
+				MinDelayBetweenTwoVersions:   v.GetDuration("fs.versioning.min_delay_between_two_versions"),
+				MaxNumberOfAppVersionsToKeep: v.GetInt("fs.versioning.max_number_of_app_versions_to_keep"),
 			},
 		},
 		CouchDB: CouchDB{
@@ -682,7 +723,9 @@ func UseViper(v *viper.Viper) error {
 		},
 		Jobs: jobs,
 		Konnectors: Konnectors{
-			Cmd: v.GetString("konnectors.cmd"),
+			Cmd:             v.GetString("konnectors.cmd"),
+			WasmMemoryLimit: v.GetInt64("konnectors.wasm.memory_limit"),
+			WasmTimeLimit:   v.GetDuration("konnectors.wasm.time_limit"),
 		},
 		Matomo: Matomo{
 			URL:             v.GetString("matomo.url"),
@@ -725,6 +768,11 @@ func UseViper(v *viper.Viper) error {
 		Registries:     regs,
 		Clouderies:     v.GetStringMap("clouderies"),
 
+		LocalRegistry: LocalRegistry{
+			Host: v.GetString("local_registry.host"),
+			Path: v.GetString("local_registry.path"),
+		},
+
 		CSPWhitelist: v.GetStringMapString("csp_whitelist"),
 
 		AssetsPollingDisabled: v.GetBool("assets_polling_disabled"),
@@ -799,6 +847,43 @@ func MakeVault(c *Config) error {
 	return nil
 }
 
+func makeTrustedProxies(v *viper.Viper) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range v.GetStringSlice("trusted_proxies") {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid network in trusted_proxies: %s", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIP returns the IP address of the client that has made the request,
+// with the X-Forwarded-For header if the request comes from a trusted proxy.
+func ClientIP(req *http.Request) string {
+	var trusted []*net.IPNet
+	if config != nil {
+		trusted = config.TrustedProxies
+	}
+	return utils.ClientIP(req, trusted)
+}
+
+func makeRemoteSecrets(v *viper.Viper) map[string]map[string]string {
+	secrets := make(map[string]map[string]string)
+	for ctx := range v.GetStringMap("remote_secrets") {
+		secrets[ctx] = v.GetStringMapString("remote_secrets." + ctx)
+	}
+	return secrets
+}
+
 func makeRegistries(v *viper.Viper) (map[string][]*url.URL, error) {
 	regs := make(map[string][]*url.URL)
 
@@ -70,6 +70,12 @@ const (
 	// DefaultFlagsSettingsID is the id of the settings documents with the
 	// default feature flags.
 	DefaultFlagsSettingsID = "io.cozy.settings.flags.default"
+	// NotificationsSettingsID is the id of the settings document with the
+	// preferences of the user for the notifications.
+	NotificationsSettingsID = "io.cozy.settings.notifications"
+	// VAPIDSettingsID is the id of the settings document, in the global
+	// database, with the VAPID keys used for Web Push.
+	VAPIDSettingsID = "io.cozy.settings.vapid"
 )
 
 const (
@@ -17,6 +17,9 @@ const (
 	Versions = "io.cozy.registry.versions"
 	// KonnectorLogs doc type for konnector last execution logs.
 	KonnectorLogs = "io.cozy.konnectors.logs"
+	// KonnectorRuns doc type for the history of the executions of the
+	// konnectors for an account.
+	KonnectorRuns = "io.cozy.konnectors.runs"
 	// Archives doc type for zip archives with files and directories
 	Archives = "io.cozy.files.archives"
 	// Exports doc type for global exports archives
@@ -40,8 +43,23 @@ const (
 	Jobs = "io.cozy.jobs"
 	// JobEvents doc type for real time events sent by jobs
 	JobEvents = "io.cozy.jobs.events"
+	// JobInputs doc type for the inputs of the user sent to the running jobs.
+	// It is only used internally by the realtime hub.
+	JobInputs = "io.cozy.jobs.inputs"
+	// JobFunctions doc type for the responses of the services to the HTTP
+	// requests. It is only used internally by the realtime hub.
+	JobFunctions = "io.cozy.jobs.functions"
 	// Notifications doc type for notifications
 	Notifications = "io.cozy.notifications"
+	// NotificationsCategories doc type is used for listing the categories of
+	// notifications that can be sent to the user.
+	NotificationsCategories = "io.cozy.notifications.categories"
+	// NotificationsDigest doc type is used for the notifications waiting to be
+	// sent in the next digest mail.
+	NotificationsDigest = "io.cozy.notifications.digest"
+	// WebPushSubscriptions doc type is used for the browsers that have
+	// subscribed to the notifications via Web Push.
+	WebPushSubscriptions = "io.cozy.notifications.webpush_subscriptions"
 	// OAuthAccessCodes doc type for OAuth2 access codes
 	OAuthAccessCodes = "io.cozy.oauth.access_codes"
 	// OAuthClients doc type for OAuth2 clients
@@ -67,6 +85,11 @@ const (
 	// SharingsInitialSync doc type for real-time events for initial sync of a
 	// sharing
 	SharingsInitialSync = "io.cozy.sharings.initial-sync"
+	// SharingsOwnership doc type for transferring the ownership of a sharing
+	SharingsOwnership = "io.cozy.sharings.ownership"
+	// SharingsActivity doc type for the changes made by the members of a
+	// sharing on the shared documents
+	SharingsActivity = "io.cozy.sharings.activity"
 	// Triggers doc type for triggers, jobs launchers
 	Triggers = "io.cozy.triggers"
 	// TriggersState doc type for triggers current state, jobs launchers
@@ -95,4 +118,13 @@ const (
 	// NotesEvents doc type is used for realtime events related to a note, like
 	// a change of title.
 	NotesEvents = "io.cozy.notes.events"
+	// NotesImages doc type is used for the images embedded in a note.
+	NotesImages = "io.cozy.notes.images"
+	// NotesSnapshots doc type is used for the revision history of a note.
+	NotesSnapshots = "io.cozy.notes.snapshots"
+	// NotesDiffs doc type is used for the differences between two versions of
+	// a note.
+	NotesDiffs = "io.cozy.notes.diffs"
+	// NotesComments doc type is used for the comments on a note.
+	NotesComments = "io.cozy.notes.comments"
 )
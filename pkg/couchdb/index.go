@@ -7,7 +7,7 @@ import (
 
 // IndexViewsVersion is the version of current definition of views & indexes.
 // This number should be incremented when this file changes.
-const IndexViewsVersion int = 27
+const IndexViewsVersion int = 29
 
 // Indexes is the index list required by an instance to run properly.
 var Indexes = []*mango.Index{
@@ -37,6 +37,13 @@ var Indexes = []*mango.Index{
 	// date
 	mango.IndexOnFields(consts.Notifications, "by-source-id", []string{"source_id", "created_at"}),
 
+	// Used to lookup the activity of a sharing, ordered by date
+	mango.IndexOnFields(consts.SharingsActivity, "by-sharing-id", []string{"sharing_id", "created_at"}),
+
+	// Used to lookup the executions of a konnector for an account, ordered by
+	// date
+	mango.IndexOnFields(consts.KonnectorRuns, "by-konnector-and-account", []string{"konnector", "account", "started_at"}),
+
 	// Used to find the myself document
 	mango.IndexOnFields(consts.Contacts, "by-me", []string{"me"}),
 
@@ -59,6 +59,12 @@ const (
 	SendHintByMail
 	// JobNotesPersistType is used for saving notes to the VFS
 	JobNotesPersistType
+	// SharingGuestCodeGenerationType is used for counting the number of codes
+	// sent by mail to the guests of a sharing
+	SharingGuestCodeGenerationType
+	// SharingGuestCodeType is used for counting the number of attempts of a
+	// guest to give the code received by mail
+	SharingGuestCodeType
 )
 
 type counterConfig struct {
@@ -170,6 +176,18 @@ var configs = []counterConfig{
 		Limit:  100,
 		Period: 1 * time.Hour,
 	},
+	// SharingGuestCodeGenerationType
+	{
+		Prefix: "sharing-guest-code-generation",
+		Limit:  10,
+		Period: 1 * time.Hour,
+	},
+	// SharingGuestCodeType
+	{
+		Prefix: "sharing-guest-code",
+		Limit:  10,
+		Period: 5 * time.Minute,
+	},
 }
 
 // Counter is an interface for counting number of attempts that can be used to
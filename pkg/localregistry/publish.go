@@ -0,0 +1,238 @@
+package localregistry
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/registry"
+)
+
+const (
+	// manifestMaxSize is the maximal size of the manifest in the tarball.
+	manifestMaxSize = 2 << (2 * 10) // 2MB
+
+	webappManifestName    = "manifest.webapp"
+	konnectorManifestName = "manifest.konnector"
+)
+
+var slugReg = regexp.MustCompile(`^[a-z0-9\-]+$`)
+
+var (
+	// ErrInvalidSlug is used when the slug of the application is invalid.
+	ErrInvalidSlug = errors.New("Invalid slug")
+	// ErrInvalidTarball is used when the tarball can't be read.
+	ErrInvalidTarball = errors.New("Invalid tarball")
+	// ErrNoManifest is used when the tarball has no manifest.
+	ErrNoManifest = errors.New("The tarball has no manifest")
+	// ErrBadManifest is used when the manifest can't be parsed, or does not
+	// match the published application.
+	ErrBadManifest = errors.New("The manifest is invalid or does not match the application")
+)
+
+// PublishOptions are the optional parameters for publishing a version.
+type PublishOptions struct {
+	// Version is the version string, the version of the manifest is used if
+	// it is empty. For the beta and dev versions, it must start with the
+	// version of the manifest.
+	Version string
+	// Signature is the signature of the publisher (see registry.Version).
+	Signature string
+}
+
+type manifestInfos struct {
+	Slug    string `json:"slug"`
+	Type    string `json:"type"`
+	Editor  string `json:"editor"`
+	Version string `json:"version"`
+}
+
+// Publish adds a version of an application to the registry, from its tarball
+// (a tar archive, optionally compressed with gzip).
+func Publish(slug string, tarball io.Reader, opts *PublishOptions) (*Version, error) {
+	if !Enabled() {
+		return nil, ErrDisabled
+	}
+	if !slugReg.MatchString(slug) {
+		return nil, ErrInvalidSlug
+	}
+	if opts == nil {
+		opts = &PublishOptions{}
+	}
+
+	tmp, err := ioutil.TempFile("", "registry-"+slug)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), tarball); err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	manifest, infos, prefix, size, err := readTarball(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	if infos.Slug != "" && infos.Slug != slug {
+		return nil, ErrBadManifest
+	}
+	version := opts.Version
+	if version == "" {
+		version = infos.Version
+	} else if version != infos.Version && !strings.HasPrefix(version, infos.Version+"-") {
+		return nil, ErrBadManifest
+	}
+	channel, err := channelOf(version)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Version{
+		Version: registry.Version{
+			Slug:      slug,
+			Version:   version,
+			Sha256:    hex.EncodeToString(h.Sum(nil)),
+			CreatedAt: time.Now().UTC(),
+			Size:      strconv.FormatInt(size, 10),
+			Manifest:  manifest,
+			TarPrefix: prefix,
+			Signature: opts.Signature,
+		},
+		Type: infos.Type,
+	}
+	versionDoc, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updateApplication(slug, func(s storage, app *appEntry) (*appEntry, error) {
+		if app == nil {
+			app = &appEntry{Slug: slug, Type: infos.Type}
+		}
+		if app.Type != infos.Type {
+			return nil, ErrBadManifest
+		}
+		if app.hasVersion(version) {
+			return nil, ErrVersionExists
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := s.Put(tarballName(slug, version), tmp, "application/octet-stream"); err != nil {
+			return nil, err
+		}
+		if err := s.Put(versionName(slug, version), strings.NewReader(string(versionDoc)), "application/json"); err != nil {
+			return nil, err
+		}
+
+		if infos.Editor != "" {
+			app.Editor = infos.Editor
+		}
+		app.Versions = append(app.Versions, versionRef{
+			Version:   version,
+			Channel:   channel,
+			CreatedAt: v.CreatedAt,
+		})
+		latest := app.latest("stable")
+		if latest == "" {
+			latest = app.latest("dev")
+		}
+		if latest == version {
+			app.LatestVersion = v
+		}
+		return app, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// readTarball returns the manifest of the application in the tarball, the
+// directory where it is, and the total size of the files.
+func readTarball(f io.Reader) (json.RawMessage, *manifestInfos, string, int64, error) {
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, "", 0, ErrInvalidTarball
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	var manifest json.RawMessage
+	var appType, prefix string
+	depth := -1
+	var size int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", 0, ErrInvalidTarball
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		size += hdr.Size
+		name := path.Clean("/" + hdr.Name)
+		base := path.Base(name)
+		if base != webappManifestName && base != konnectorManifestName {
+			continue
+		}
+		d := strings.Count(name, "/")
+		if depth >= 0 && d >= depth {
+			continue
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(tr, manifestMaxSize))
+		if err != nil {
+			return nil, nil, "", 0, ErrInvalidTarball
+		}
+		manifest = content
+		depth = d
+		// The prefix is stripped from the raw names of the files by the stack.
+		if dir := path.Dir(hdr.Name); dir != "." {
+			prefix = dir + "/"
+		} else {
+			prefix = ""
+		}
+		if base == webappManifestName {
+			appType = "webapp"
+		} else {
+			appType = "konnector"
+		}
+	}
+	if manifest == nil {
+		return nil, nil, "", 0, ErrNoManifest
+	}
+
+	var infos manifestInfos
+	if err := json.Unmarshal(manifest, &infos); err != nil || infos.Version == "" {
+		return nil, nil, "", 0, ErrBadManifest
+	}
+	infos.Type = appType
+	return manifest, &infos, prefix, size, nil
+}
@@ -0,0 +1,377 @@
+// Package localregistry is an apps registry served by the stack itself, for
+// the deployments that can't reach the registries on internet. It implements
+// the part of the registry API used by the stack, and the applications are
+// published by the administrators.
+package localregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/lock"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/registry"
+)
+
+// indexName is the name of the file with the list of the applications and
+// their versions.
+const indexName = "apps.json"
+
+var (
+	// ErrDisabled is used when the local registry is not configured.
+	ErrDisabled = errors.New("The local registry is disabled")
+	// ErrAppNotFound is used when the application is not in the registry.
+	ErrAppNotFound = errors.New("Application not found")
+	// ErrVersionNotFound is used when the version is not in the registry.
+	ErrVersionNotFound = errors.New("Version not found")
+	// ErrVersionExists is used when publishing a version that already exists:
+	// the versions are immutable.
+	ErrVersionExists = errors.New("Version already exists")
+	// ErrInvalidVersion is used when the version string does not follow the
+	// rules of the channels.
+	ErrInvalidVersion = errors.New("Invalid version")
+	// ErrInvalidChannel is used when the channel is not stable, beta or dev.
+	ErrInvalidChannel = errors.New("Invalid channel")
+)
+
+var (
+	stableReg = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+	betaReg   = regexp.MustCompile(`^\d+\.\d+\.\d+-beta\.\d+$`)
+	devReg    = regexp.MustCompile(`^\d+\.\d+\.\d+-dev\.[0-9A-Za-z]+$`)
+)
+
+// channels are the channels, from the most stable to the least stable. The
+// latest version of a channel is searched in this channel and in the more
+// stable ones.
+var channels = []string{"stable", "beta", "dev"}
+
+// Version is a version of an application, as served by the registry API.
+type Version struct {
+	registry.Version
+	Type string `json:"type"`
+}
+
+type versionRef struct {
+	Version   string    `json:"version"`
+	Channel   string    `json:"channel"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Application is an application of the registry, with the format of the
+// registry API: the versions are grouped by channel.
+type Application struct {
+	Slug                 string              `json:"slug"`
+	Type                 string              `json:"type"`
+	Editor               string              `json:"editor"`
+	MaintenanceActivated bool                `json:"maintenance_activated"`
+	MaintenanceOptions   *json.RawMessage    `json:"maintenance_options,omitempty"`
+	Versions             map[string][]string `json:"versions"`
+	LatestVersion        *Version            `json:"latest_version,omitempty"`
+}
+
+// appEntry is how an application is stored in the index.
+type appEntry struct {
+	Slug                 string           `json:"slug"`
+	Type                 string           `json:"type"`
+	Editor               string           `json:"editor"`
+	MaintenanceActivated bool             `json:"maintenance_activated,omitempty"`
+	MaintenanceOptions   *json.RawMessage `json:"maintenance_options,omitempty"`
+	Versions             []versionRef     `json:"versions"`
+	LatestVersion        *Version         `json:"latest_version,omitempty"`
+}
+
+func (a *appEntry) toApplication() *Application {
+	versions := make(map[string][]string)
+	for _, c := range channels {
+		versions[c] = []string{}
+	}
+	for _, v := range a.Versions {
+		versions[v.Channel] = append(versions[v.Channel], v.Version)
+	}
+	return &Application{
+		Slug:                 a.Slug,
+		Type:                 a.Type,
+		Editor:               a.Editor,
+		MaintenanceActivated: a.MaintenanceActivated,
+		MaintenanceOptions:   a.MaintenanceOptions,
+		Versions:             versions,
+		LatestVersion:        a.LatestVersion,
+	}
+}
+
+func (a *appEntry) hasVersion(version string) bool {
+	for _, v := range a.Versions {
+		if v.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// latest returns the most recent version for the channel, or an empty string
+// if there is none.
+func (a *appEntry) latest(channel string) string {
+	var latest *versionRef
+	for i, v := range a.Versions {
+		if channelRank(v.Channel) > channelRank(channel) {
+			continue
+		}
+		if latest == nil || isMoreRecent(*latest, v) {
+			latest = &a.Versions[i]
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Version
+}
+
+func channelRank(channel string) int {
+	for i, c := range channels {
+		if c == channel {
+			return i
+		}
+	}
+	return len(channels)
+}
+
+// channelOf returns the channel of a version, from the format of the version
+// string.
+func channelOf(version string) (string, error) {
+	switch {
+	case stableReg.MatchString(version):
+		return "stable", nil
+	case betaReg.MatchString(version):
+		return "beta", nil
+	case devReg.MatchString(version):
+		return "dev", nil
+	}
+	return "", ErrInvalidVersion
+}
+
+// isMoreRecent returns true if b is more recent than a: a stable version is
+// more recent than the beta and dev versions with the same number, and the
+// beta and dev versions are sorted by their creation date.
+func isMoreRecent(a, b versionRef) bool {
+	baseA, errA := semver.NewVersion(strings.SplitN(a.Version, "-", 2)[0])
+	baseB, errB := semver.NewVersion(strings.SplitN(b.Version, "-", 2)[0])
+	if errA == nil && errB == nil && !baseA.Equal(baseB) {
+		return baseB.GreaterThan(baseA)
+	}
+	if a.Channel == "stable" || b.Channel == "stable" {
+		return b.Channel == "stable" && a.Channel != "stable"
+	}
+	return b.CreatedAt.After(a.CreatedAt)
+}
+
+// Enabled returns true if the local registry is configured.
+func Enabled() bool {
+	return config.GetConfig().LocalRegistry.Host != ""
+}
+
+func versionName(slug, version string) string {
+	return path.Join(slug, version+".json")
+}
+
+func tarballName(slug, version string) string {
+	return path.Join(slug, version+".tar.gz")
+}
+
+func readIndex(s storage) (map[string]*appEntry, error) {
+	apps := make(map[string]*appEntry)
+	f, err := s.Open(indexName)
+	if os.IsNotExist(err) {
+		return apps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&apps); err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+func writeIndex(s storage, apps map[string]*appEntry) error {
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(json.NewEncoder(w).Encode(apps))
+	}()
+	err := s.Put(indexName, r, "application/json")
+	_ = r.Close()
+	return err
+}
+
+func indexLock() lock.ErrorRWLocker {
+	return lock.ReadWrite(prefixer.GlobalPrefixer, "registry")
+}
+
+func readApplications() (map[string]*appEntry, error) {
+	if !Enabled() {
+		return nil, ErrDisabled
+	}
+	s, err := newStorage()
+	if err != nil {
+		return nil, err
+	}
+	mu := indexLock()
+	if err := mu.RLock(); err != nil {
+		return nil, err
+	}
+	defer mu.RUnlock()
+	return readIndex(s)
+}
+
+// updateApplication calls the given function on the application with the
+// given slug (nil if it is not in the registry), and saves the index.
+func updateApplication(slug string, fn func(s storage, app *appEntry) (*appEntry, error)) error {
+	if !Enabled() {
+		return ErrDisabled
+	}
+	s, err := newStorage()
+	if err != nil {
+		return err
+	}
+	mu := indexLock()
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+	apps, err := readIndex(s)
+	if err != nil {
+		return err
+	}
+	app, err := fn(s, apps[slug])
+	if err != nil {
+		return err
+	}
+	apps[slug] = app
+	return writeIndex(s, apps)
+}
+
+func getEntry(slug string) (*appEntry, error) {
+	apps, err := readApplications()
+	if err != nil {
+		return nil, err
+	}
+	app, ok := apps[slug]
+	if !ok {
+		return nil, ErrAppNotFound
+	}
+	return app, nil
+}
+
+// GetApplication returns the application with the given slug.
+func GetApplication(slug string) (*Application, error) {
+	app, err := getEntry(slug)
+	if err != nil {
+		return nil, err
+	}
+	return app.toApplication(), nil
+}
+
+// ListApplications returns the applications of the registry, sorted by slug.
+func ListApplications() ([]*Application, error) {
+	apps, err := readApplications()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Application, 0, len(apps))
+	for _, app := range apps {
+		list = append(list, app.toApplication())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Slug < list[j].Slug })
+	return list, nil
+}
+
+// GetVersion returns the given version of an application.
+func GetVersion(slug, version string) (*Version, error) {
+	if !Enabled() {
+		return nil, ErrDisabled
+	}
+	s, err := newStorage()
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.Open(versionName(slug, version))
+	if os.IsNotExist(err) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var v Version
+	if err := json.NewDecoder(f).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetLatestVersion returns the latest version of an application for the
+// given channel.
+func GetLatestVersion(slug, channel string) (*Version, error) {
+	if channelRank(channel) == len(channels) {
+		return nil, ErrInvalidChannel
+	}
+	app, err := getEntry(slug)
+	if err != nil {
+		return nil, err
+	}
+	version := app.latest(channel)
+	if version == "" {
+		return nil, ErrVersionNotFound
+	}
+	return GetVersion(slug, version)
+}
+
+// OpenTarball returns the tarball of the given version of an application.
+func OpenTarball(slug, version string) (io.ReadCloser, error) {
+	if !Enabled() {
+		return nil, ErrDisabled
+	}
+	s, err := newStorage()
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.Open(tarballName(slug, version))
+	if os.IsNotExist(err) {
+		return nil, ErrVersionNotFound
+	}
+	return f, err
+}
+
+// ActivateMaintenance puts an application in maintenance, with the given
+// options (see registry.MaintenanceOptions).
+func ActivateMaintenance(slug string, options *json.RawMessage) error {
+	return updateApplication(slug, func(s storage, app *appEntry) (*appEntry, error) {
+		if app == nil {
+			return nil, ErrAppNotFound
+		}
+		app.MaintenanceActivated = true
+		app.MaintenanceOptions = options
+		return app, nil
+	})
+}
+
+// DeactivateMaintenance ends the maintenance of an application.
+func DeactivateMaintenance(slug string) error {
+	return updateApplication(slug, func(s storage, app *appEntry) (*appEntry, error) {
+		if app == nil {
+			return nil, ErrAppNotFound
+		}
+		app.MaintenanceActivated = false
+		app.MaintenanceOptions = nil
+		return app, nil
+	})
+}
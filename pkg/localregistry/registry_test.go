@@ -0,0 +1,128 @@
+package localregistry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTarball(t *testing.T, prefix, manifest string) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	files := map[string]string{
+		prefix + "manifest.webapp": manifest,
+		prefix + "index.html":      "<html></html>",
+	}
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf
+}
+
+func TestChannelOf(t *testing.T) {
+	c, err := channelOf("1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, "stable", c)
+	c, err = channelOf("1.2.3-beta.4")
+	assert.NoError(t, err)
+	assert.Equal(t, "beta", c)
+	c, err = channelOf("1.2.3-dev.7a8354f74b50d7beead7719252a18ed45f55d070")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", c)
+	_, err = channelOf("1.2")
+	assert.Equal(t, ErrInvalidVersion, err)
+	_, err = channelOf("1.2.3-alpha.1")
+	assert.Equal(t, ErrInvalidVersion, err)
+}
+
+func TestIsMoreRecent(t *testing.T) {
+	now := time.Now()
+	stable := versionRef{Version: "1.0.0", Channel: "stable", CreatedAt: now}
+	beta := versionRef{Version: "1.0.0-beta.1", Channel: "beta", CreatedAt: now.Add(-time.Hour)}
+	next := versionRef{Version: "1.0.1-beta.1", Channel: "beta", CreatedAt: now.Add(-2 * time.Hour)}
+	dev := versionRef{Version: "1.0.0-dev.abc", Channel: "dev", CreatedAt: now.Add(time.Hour)}
+	assert.True(t, isMoreRecent(beta, stable))
+	assert.False(t, isMoreRecent(stable, beta))
+	assert.True(t, isMoreRecent(stable, next))
+	assert.True(t, isMoreRecent(beta, dev))
+	assert.False(t, isMoreRecent(dev, beta))
+}
+
+func TestPublish(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cozy-registry")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+	conf := config.GetConfig()
+	backup := conf.LocalRegistry
+	conf.LocalRegistry = config.LocalRegistry{Host: "registry.cozy.localhost", Path: tmp}
+	defer func() { conf.LocalRegistry = backup }()
+
+	manifest := `{"slug": "mini", "editor": "cozy", "version": "1.0.0"}`
+	v, err := Publish("mini", makeTarball(t, "mini/", manifest), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", v.Version.Version)
+	assert.Equal(t, "webapp", v.Type)
+	assert.Equal(t, "mini/", v.TarPrefix)
+	assert.Len(t, v.Sha256, 64)
+
+	_, err = Publish("mini", makeTarball(t, "mini/", manifest), nil)
+	assert.Equal(t, ErrVersionExists, err)
+	_, err = Publish("other", makeTarball(t, "", manifest), nil)
+	assert.Equal(t, ErrBadManifest, err)
+	_, err = Publish("mini", makeTarball(t, "", manifest), &PublishOptions{Version: "1.1.0-beta.1"})
+	assert.Equal(t, ErrBadManifest, err)
+
+	manifest = `{"slug": "mini", "editor": "cozy", "version": "1.1.0"}`
+	_, err = Publish("mini", makeTarball(t, "", manifest), &PublishOptions{Version: "1.1.0-beta.1"})
+	require.NoError(t, err)
+
+	latest, err := GetLatestVersion("mini", "stable")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", latest.Version.Version)
+	latest, err = GetLatestVersion("mini", "beta")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1.0-beta.1", latest.Version.Version)
+	assert.Equal(t, "", latest.TarPrefix)
+	_, err = GetLatestVersion("mini", "nightly")
+	assert.Equal(t, ErrInvalidChannel, err)
+
+	app, err := GetApplication("mini")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, app.Versions["stable"])
+	assert.Equal(t, []string{"1.1.0-beta.1"}, app.Versions["beta"])
+	assert.Equal(t, "1.0.0", app.LatestVersion.Version.Version)
+
+	assert.NoError(t, ActivateMaintenance("mini", nil))
+	apps, err := ListApplications()
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+	assert.True(t, apps[0].MaintenanceActivated)
+	assert.Equal(t, ErrAppNotFound, DeactivateMaintenance("unknown"))
+
+	f, err := OpenTarball("mini", "1.0.0")
+	require.NoError(t, err)
+	f.Close()
+}
+
+func TestMain(m *testing.M) {
+	config.UseTestFile()
+	os.Exit(m.Run())
+}
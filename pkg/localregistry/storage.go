@@ -0,0 +1,120 @@
+package localregistry
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/ncw/swift"
+	"github.com/spf13/afero"
+)
+
+// containerName is the name of the swift container, and of the directory in
+// the file system of the stack, used for the registry.
+const containerName = "registry"
+
+// storage is where the files of the registry are stored.
+type storage interface {
+	Open(name string) (io.ReadCloser, error)
+	Put(name string, r io.Reader, contentType string) error
+}
+
+var memFS struct {
+	sync.Mutex
+	fs afero.Fs
+}
+
+func newStorage() (storage, error) {
+	conf := config.GetConfig().LocalRegistry
+	if conf.Path != "" {
+		fs := afero.NewBasePathFs(afero.NewOsFs(), conf.Path)
+		return &aferoStorage{fs: fs}, nil
+	}
+	fsURL := config.FsURL()
+	switch fsURL.Scheme {
+	case config.SchemeFile:
+		fs := afero.NewBasePathFs(afero.NewOsFs(), path.Join(fsURL.Path, containerName))
+		return &aferoStorage{fs: fs}, nil
+	case config.SchemeMem:
+		memFS.Lock()
+		defer memFS.Unlock()
+		if memFS.fs == nil {
+			memFS.fs = afero.NewMemMapFs()
+		}
+		return &aferoStorage{fs: memFS.fs}, nil
+	case config.SchemeSwift, config.SchemeSwiftSecure:
+		s := &swiftStorage{c: config.GetSwiftConnection()}
+		if err := s.ensureContainer(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("registry: unknown storage provider %s", fsURL.Scheme)
+	}
+}
+
+type aferoStorage struct {
+	fs afero.Fs
+}
+
+func (s *aferoStorage) Open(name string) (io.ReadCloser, error) {
+	return s.fs.Open(path.Join("/", name))
+}
+
+// Put writes the file in a temporary file first, and then renames it, to
+// never serve a partial file.
+func (s *aferoStorage) Put(name string, r io.Reader, contentType string) (err error) {
+	name = path.Join("/", name)
+	dir := path.Dir(name)
+	if err = s.fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := afero.TempFile(s.fs, dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = s.fs.Remove(tmp.Name())
+		}
+	}()
+	if _, err = io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return s.fs.Rename(tmp.Name(), name)
+}
+
+type swiftStorage struct {
+	c *swift.Connection
+}
+
+func (s *swiftStorage) ensureContainer() error {
+	_, _, err := s.c.Container(containerName)
+	if err == swift.ContainerNotFound {
+		err = s.c.ContainerCreate(containerName, nil)
+	}
+	return err
+}
+
+func (s *swiftStorage) Open(name string) (io.ReadCloser, error) {
+	f, _, err := s.c.ObjectOpen(containerName, name, false, nil)
+	if err == swift.ObjectNotFound || err == swift.ContainerNotFound {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *swiftStorage) Put(name string, r io.Reader, contentType string) error {
+	_, err := s.c.ObjectPut(containerName, name, r, true, "", contentType, nil)
+	return err
+}
@@ -106,6 +106,53 @@ a label by slug.`,
 	[]string{"slug", "result"},
 )
 
+// WorkersKonnectorsRuns is a counter number of the executions of the
+// konnectors for an account, labelled by konnector slug, result and error
+// type.
+var WorkersKonnectorsRuns = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "workers",
+		Subsystem: "konnectors",
+		Name:      "runs",
+
+		Help: `Number of executions of the konnectors for an account, with their retries,
+labelled by konnector slug, result, error type and manual execution.`,
+	},
+	[]string{"slug", "result", "error_type", "manual"},
+)
+
+// WorkersKonnectorsCreated is a counter number of the files and documents
+// created by the konnectors, labelled by konnector slug and kind.
+var WorkersKonnectorsCreated = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "workers",
+		Subsystem: "konnectors",
+		Name:      "created",
+
+		Help: `Number of files and documents created by the konnectors, labelled by konnector
+slug and kind (file or document).`,
+	},
+	[]string{"slug", "kind"},
+)
+
+// WorkersKonnectorsConsecutiveFailures is a histogram metric of the number
+// of consecutive failures of an account after each execution, labelled by
+// konnector slug. A konnector that is broken for everybody has its
+// observations moving to the high buckets.
+var WorkersKonnectorsConsecutiveFailures = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "workers",
+		Subsystem: "konnectors",
+		Name:      "consecutive_failures",
+
+		Help: `Number of consecutive failures of the account after each execution of a
+konnector, labelled by konnector slug.`,
+
+		Buckets: []float64{0, 1, 2, 3, 5, 10},
+	},
+	[]string{"slug"},
+)
+
 func init() {
 	prometheus.MustRegister(
 		WorkerExecDurations,
@@ -115,5 +162,8 @@ func init() {
 		WorkerKonnectorExecDeleteCounter,
 
 		WorkersKonnectorsExecDurations,
+		WorkersKonnectorsRuns,
+		WorkersKonnectorsCreated,
+		WorkersKonnectorsConsecutiveFailures,
 	)
 }
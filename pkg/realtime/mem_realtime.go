@@ -10,16 +10,34 @@ var globalPrefixer = prefixer.NewPrefixer("", "*")
 
 type memHub struct {
 	sync.RWMutex
-	topics map[string]*topic
+	topics  map[string]*topic
+	buffers *memBuffers
 }
 
 func newMemHub() *memHub {
-	return &memHub{topics: make(map[string]*topic)}
+	return &memHub{
+		topics:  make(map[string]*topic),
+		buffers: newMemBuffers(),
+	}
 }
 
 func (h *memHub) Publish(db prefixer.Prefixer, verb string, doc, oldDoc Doc) {
 	e := newEvent(db, verb, doc, oldDoc)
-	topic := h.get(e, doc.DocType())
+	if IsInternal(doc.DocType()) {
+		h.broadcast(e)
+		return
+	}
+	// The sequence number is given and the event is broadcasted while holding
+	// the lock of the buffer, to keep the events in order.
+	h.buffers.push(e, h.broadcast)
+}
+
+func (h *memHub) Replay(db prefixer.Prefixer, since Position) ([]*Event, Position, error) {
+	return h.buffers.replay(db, since)
+}
+
+func (h *memHub) broadcast(e *Event) {
+	topic := h.get(e, e.Doc.DocType())
 	if topic != nil {
 		topic.broadcast <- e
 	}
@@ -36,7 +54,7 @@ func (h *memHub) Subscriber(db prefixer.Prefixer) *DynamicSubscriber {
 func (h *memHub) SubscribeLocalAll() *DynamicSubscriber {
 	ds := newDynamicSubscriber(nil, globalPrefixer)
 	t := h.GetTopic(globalPrefixer, "*")
-	ds.addTopic(t, "")
+	ds.addTopic(t, &toWatch{sub: &ds.Channel, doctype: "*"})
 	return ds
 }
 
@@ -63,13 +81,38 @@ func (h *memHub) topicKey(db prefixer.Prefixer, doctype string) string {
 }
 
 type filter struct {
-	whole bool // true if the events for the whole doctype should be sent
-	ids   []string
+	whole     bool // true if the events for the whole doctype should be sent
+	ids       []string
+	selectors []*Selector
+}
+
+func (f *filter) add(w *toWatch) {
+	if w.selector != nil {
+		f.selectors = append(f.selectors, w.selector)
+	} else if w.id == "" {
+		f.whole = true
+	} else {
+		f.ids = append(f.ids, w.id)
+	}
+}
+
+func (f *filter) match(e *Event, docs *eventDocs) bool {
+	if f.whole {
+		return true
+	}
+	for _, id := range f.ids {
+		if e.Doc.ID() == id {
+			return true
+		}
+	}
+	return len(f.selectors) > 0 && docs.matchAny(f.selectors)
 }
 
 type toWatch struct {
-	sub *MemSub
-	id  string
+	sub      *MemSub
+	doctype  string
+	id       string
+	selector *Selector
 }
 
 type topic struct {
@@ -104,29 +147,42 @@ func (t *topic) loop() {
 			delete(t.subs, s)
 		case w := <-t.subscribe:
 			f := t.subs[w.sub]
-			if w.id == "" {
-				f.whole = true
-			} else {
-				f.ids = append(f.ids, w.id)
-			}
+			f.add(w)
 			t.subs[w.sub] = f
 		case e := <-t.broadcast:
+			docs := &eventDocs{event: e}
 			for s, f := range t.subs {
-				ok := false
-				if f.whole {
-					ok = true
-				} else {
-					for _, id := range f.ids {
-						if e.Doc.ID() == id {
-							ok = true
-							break
-						}
-					}
-				}
-				if ok {
+				if f.match(e, docs) {
 					*s <- e
 				}
 			}
 		}
 	}
 }
+
+// eventDocs are the new and old documents of an event, as maps, for
+// evaluating the selectors. The documents are converted to maps only once,
+// and only if there is a subscriber with a selector.
+type eventDocs struct {
+	event     *Event
+	converted bool
+	doc       map[string]interface{}
+	old       map[string]interface{}
+}
+
+func (d *eventDocs) matchAny(selectors []*Selector) bool {
+	if !d.converted {
+		d.doc = docToMap(d.event.Doc)
+		d.old = docToMap(d.event.OldDoc)
+		d.converted = true
+	}
+	for _, sel := range selectors {
+		if d.doc != nil && sel.Match(d.doc) {
+			return true
+		}
+		if d.old != nil && sel.Match(d.old) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 )
 
@@ -23,6 +24,13 @@ type Doc interface {
 	DocType() string
 }
 
+// IsInternal returns true for the doctypes of the events that are only used
+// for the communication between the stacks. The clients cannot subscribe to
+// them, and they are not kept in the replay buffers.
+func IsInternal(doctype string) bool {
+	return doctype == consts.JobInputs || doctype == consts.JobFunctions
+}
+
 // Event is the basic message structure manipulated by the realtime package
 type Event struct {
 	Domain string `json:"domain"`
@@ -30,6 +38,20 @@ type Event struct {
 	Verb   string `json:"verb"`
 	Doc    Doc    `json:"doc"`
 	OldDoc Doc    `json:"old,omitempty"`
+	// Epoch and Seq are the position of the event in the stream of the
+	// events of its instance. It is used by the clients to ask for the events
+	// they have missed.
+	Epoch string `json:"epoch,omitempty"`
+	Seq   uint64 `json:"seq,omitempty"`
+}
+
+// Position returns the position of the event in the stream of the events of
+// its instance, or the zero position if the event has no sequence number.
+func (e *Event) Position() Position {
+	if e.Seq == 0 {
+		return Position{}
+	}
+	return Position{Epoch: e.Epoch, Seq: e.Seq}
 }
 
 func newEvent(db prefixer.Prefixer, verb string, doc Doc, oldDoc Doc) *Event {
@@ -73,6 +95,13 @@ type Hub interface {
 	// GetTopic returns the topic for the given domain+doctype.
 	// It creates the topic if it does not exist.
 	GetTopic(db prefixer.Prefixer, doctype string) *topic
+
+	// Replay returns the events published for the given instance after the
+	// since position, and the position of the last event. If some of these
+	// events are no longer in the replay buffer, or if the position is for
+	// another epoch, ErrTooOld is returned, and the client should
+	// resynchronize its data.
+	Replay(db prefixer.Prefixer, since Position) ([]*Event, Position, error)
 }
 
 // MemSub is a chan of events
@@ -84,6 +113,8 @@ type DynamicSubscriber struct {
 	Channel MemSub
 	hub     Hub
 	topics  []*topic
+	mu      sync.Mutex // protects watches, as Replay can be called from another goroutine
+	watches []*toWatch
 	c       uint32 // mark whether or not the sub is closed
 }
 
@@ -101,7 +132,19 @@ func (ds *DynamicSubscriber) Subscribe(doctype string) error {
 		return errors.New("Can't subscribe")
 	}
 	t := ds.hub.GetTopic(ds, doctype)
-	ds.addTopic(t, "")
+	ds.addTopic(t, &toWatch{sub: &ds.Channel, doctype: doctype})
+	return nil
+}
+
+// SubscribeSelector adds a listener for the events on the documents of a
+// doctype that are matched by the selector. For an update or a deletion, the
+// event is sent if the new or the old version of the document is matched.
+func (ds *DynamicSubscriber) SubscribeSelector(doctype string, selector *Selector) error {
+	if ds.Closed() || ds.hub == nil {
+		return errors.New("Can't subscribe")
+	}
+	t := ds.hub.GetTopic(ds, doctype)
+	ds.addTopic(t, &toWatch{sub: &ds.Channel, doctype: doctype, selector: selector})
 	return nil
 }
 
@@ -111,11 +154,11 @@ func (ds *DynamicSubscriber) Watch(doctype, id string) error {
 		return errors.New("Can't subscribe")
 	}
 	t := ds.hub.GetTopic(ds, doctype)
-	ds.addTopic(t, id)
+	ds.addTopic(t, &toWatch{sub: &ds.Channel, doctype: doctype, id: id})
 	return nil
 }
 
-func (ds *DynamicSubscriber) addTopic(t *topic, id string) {
+func (ds *DynamicSubscriber) addTopic(t *topic, w *toWatch) {
 	found := false
 	for _, topic := range ds.topics {
 		if t == topic {
@@ -126,7 +169,42 @@ func (ds *DynamicSubscriber) addTopic(t *topic, id string) {
 	if !found {
 		ds.topics = append(ds.topics, t)
 	}
-	t.subscribe <- &toWatch{&ds.Channel, id}
+	ds.mu.Lock()
+	ds.watches = append(ds.watches, w)
+	ds.mu.Unlock()
+	t.subscribe <- w
+}
+
+// Replay returns the events published after the since position that match
+// the subscriptions, and the position of the last event for the instance. It
+// returns ErrTooOld if some events have been missed and can no longer be
+// replayed.
+func (ds *DynamicSubscriber) Replay(since Position) ([]*Event, Position, error) {
+	if ds.Closed() || ds.hub == nil {
+		return nil, Position{}, errors.New("Can't replay")
+	}
+	events, last, err := ds.hub.Replay(ds, since)
+	if err != nil {
+		return nil, last, err
+	}
+	filters := make(map[string]*filter)
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, w := range ds.watches {
+		f, ok := filters[w.doctype]
+		if !ok {
+			f = &filter{}
+			filters[w.doctype] = f
+		}
+		f.add(w)
+	}
+	var matched []*Event
+	for _, e := range events {
+		if f, ok := filters[e.Doc.DocType()]; ok && f.match(e, &eventDocs{event: e}) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, last, nil
 }
 
 // Closed returns true if it will no longer send events in its channel
@@ -1,10 +1,12 @@
 package realtime
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/go-redis/redis/v7"
 	"github.com/stretchr/testify/assert"
@@ -24,6 +26,14 @@ func (t *testDoc) MarshalJSON() ([]byte, error) {
 	return []byte(j), nil
 }
 
+type testOperation struct {
+	DocID   string `json:"_id"`
+	Account string `json:"account"`
+}
+
+func (t *testOperation) ID() string      { return t.DocID }
+func (t *testOperation) DocType() string { return "io.cozy.bank.operations" }
+
 func TestMemRealtime(t *testing.T) {
 	h := newMemHub()
 	c1 := h.Subscriber(testingDB)
@@ -316,3 +326,174 @@ func TestRedisRealtime(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestMemRealtimeSelector(t *testing.T) {
+	h := newMemHub()
+	c := h.Subscriber(testingDB)
+	sel, err := NewSelector(map[string]interface{}{"account": "123"})
+	assert.NoError(t, err)
+	err = c.SubscribeSelector("io.cozy.bank.operations", sel)
+	assert.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		var ids []string
+		for e := range c.Channel {
+			ids = append(ids, e.Doc.ID())
+			if len(ids) == 2 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"op-1", "op-3"}, ids)
+		wg.Done()
+	}()
+
+	time.AfterFunc(10*time.Millisecond, func() {
+		h.Publish(testingDB, EventCreate, &testOperation{DocID: "op-1", Account: "123"}, nil)
+		h.Publish(testingDB, EventCreate, &testOperation{DocID: "op-2", Account: "456"}, nil)
+		// The old version of the document is matched by the selector
+		h.Publish(testingDB, EventUpdate, &testOperation{DocID: "op-3", Account: "456"},
+			&testOperation{DocID: "op-3", Account: "123"})
+	})
+
+	wg.Wait()
+	assert.NoError(t, c.Close())
+}
+
+func TestMemReplay(t *testing.T) {
+	h := newMemHub()
+	other := prefixer.NewPrefixer("other", "other")
+	for i := 1; i <= replaySize+10; i++ {
+		id := fmt.Sprintf("foo-%d", i)
+		h.Publish(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: id}, nil)
+	}
+	h.Publish(other, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "other"}, nil)
+	_, last, err := h.Replay(testingDB, Position{})
+	assert.Equal(t, ErrTooOld, err)
+	assert.NotEmpty(t, last.Epoch)
+	assert.Equal(t, uint64(replaySize+10), last.Seq)
+	at := func(seq uint64) Position { return Position{Epoch: last.Epoch, Seq: seq} }
+
+	events, pos, err := h.Replay(testingDB, at(last.Seq-2))
+	assert.NoError(t, err)
+	assert.Equal(t, last, pos)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, at(last.Seq-1), events[0].Position())
+		assert.Equal(t, fmt.Sprintf("foo-%d", last.Seq), events[1].Doc.ID())
+	}
+
+	events, pos, err = h.Replay(testingDB, last)
+	assert.NoError(t, err)
+	assert.Equal(t, last, pos)
+	assert.Len(t, events, 0)
+
+	_, pos, err = h.Replay(testingDB, at(5))
+	assert.Equal(t, ErrTooOld, err)
+	assert.Equal(t, last, pos)
+
+	_, _, err = h.Replay(testingDB, at(last.Seq+1))
+	assert.Equal(t, ErrTooOld, err)
+
+	// The sequence numbers of another epoch can't be used
+	_, pos, err = h.Replay(testingDB, Position{Epoch: "another", Seq: last.Seq - 2})
+	assert.Equal(t, ErrTooOld, err)
+	assert.Equal(t, last, pos)
+
+	_, pos, err = h.Replay(other, Position{})
+	assert.Equal(t, ErrTooOld, err)
+	assert.NotEqual(t, last.Epoch, pos.Epoch)
+	events, pos, err = h.Replay(other, Position{Epoch: pos.Epoch})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), pos.Seq)
+	assert.Len(t, events, 1)
+
+	c := h.Subscriber(testingDB)
+	assert.NoError(t, c.Watch("io.cozy.testobject", fmt.Sprintf("foo-%d", last.Seq-5)))
+	events, pos, err = c.Replay(at(last.Seq - 10))
+	assert.NoError(t, err)
+	assert.Equal(t, last, pos)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, last.Seq-5, events[0].Seq)
+	}
+	assert.NoError(t, c.Close())
+
+	// A new stream has a new epoch
+	h2 := newMemHub()
+	h2.Publish(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "foo-1"}, nil)
+	_, pos, err = h2.Replay(testingDB, at(0))
+	assert.Equal(t, ErrTooOld, err)
+	assert.NotEqual(t, last.Epoch, pos.Epoch)
+	assert.Equal(t, uint64(1), pos.Seq)
+}
+
+func TestParsePosition(t *testing.T) {
+	pos, err := ParsePosition("aBcDeFgH-42")
+	assert.NoError(t, err)
+	assert.Equal(t, Position{Epoch: "aBcDeFgH", Seq: 42}, pos)
+	assert.Equal(t, "aBcDeFgH-42", pos.String())
+	assert.Equal(t, "", Position{}.String())
+
+	for _, s := range []string{"", "42", "-42", "aBcDeFgH-", "aBcDeFgH-x"} {
+		_, err = ParsePosition(s)
+		assert.Equal(t, ErrInvalidPosition, err, s)
+	}
+}
+
+func TestMemInternalEvents(t *testing.T) {
+	h := newMemHub()
+	c := h.Subscriber(testingDB)
+	assert.NoError(t, c.Watch(consts.JobInputs, "job-1"))
+	h.Publish(testingDB, EventCreate, &testDoc{doctype: consts.JobInputs, id: "job-1"}, nil)
+	e := <-c.Channel
+	assert.Equal(t, "job-1", e.Doc.ID())
+	assert.Equal(t, Position{}, e.Position())
+
+	_, last, err := h.Replay(testingDB, Position{})
+	assert.Equal(t, ErrTooOld, err)
+	events, pos, err := h.Replay(testingDB, last)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), pos.Seq)
+	assert.Len(t, events, 0)
+	assert.NoError(t, c.Close())
+}
+
+func TestRedisReplay(t *testing.T) {
+	opt, err := redis.ParseURL("redis://localhost:6379/6")
+	assert.NoError(t, err)
+	client := redis.NewClient(opt)
+	client.Del(streamRedisKeys(testingDB)...)
+	h := newRedisHub(client)
+
+	_, start, err := h.Replay(testingDB, Position{})
+	assert.Equal(t, ErrTooOld, err)
+	assert.NotEmpty(t, start.Epoch)
+	assert.Equal(t, uint64(0), start.Seq)
+
+	h.Publish(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "foo"}, nil)
+	h.Publish(testingDB, EventUpdate, &testDoc{doctype: "io.cozy.testobject", id: "foo"},
+		&testDoc{doctype: "io.cozy.testobject", id: "foo"})
+	h.Publish(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject2", id: "bar"}, nil)
+
+	events, pos, err := h.Replay(testingDB, Position{Epoch: start.Epoch, Seq: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, Position{Epoch: start.Epoch, Seq: 3}, pos)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, Position{Epoch: start.Epoch, Seq: 2}, events[0].Position())
+		assert.Equal(t, EventUpdate, events[0].Verb)
+		assert.Equal(t, "io.cozy.testobject", events[0].Doc.DocType())
+		assert.NotNil(t, events[0].OldDoc)
+		assert.Equal(t, "bar", events[1].Doc.ID())
+	}
+
+	_, _, err = h.Replay(testingDB, Position{Epoch: start.Epoch, Seq: 42})
+	assert.Equal(t, ErrTooOld, err)
+
+	// When the stream expires, the sequence restarts with a new epoch
+	client.Del(seqRedisKey(testingDB))
+	h.Publish(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "baz"}, nil)
+	_, pos, err = h.Replay(testingDB, Position{Epoch: start.Epoch, Seq: 0})
+	assert.Equal(t, ErrTooOld, err)
+	assert.NotEqual(t, start.Epoch, pos.Epoch)
+	assert.Equal(t, uint64(1), pos.Seq)
+}
@@ -2,7 +2,10 @@ package realtime
 
 import (
 	"encoding/json"
+	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
@@ -11,6 +14,71 @@ import (
 
 const eventsRedisKey = "realtime:events"
 
+// initStreamLua creates the stream of the events of an instance, with a new
+// epoch, if it doesn't exist. The sequence, the epoch and the replay buffer
+// are always reset together, so that the sequence numbers of an epoch are
+// never reused in another one.
+//
+// KEYS[1] is the sequence key, KEYS[2] the epoch key, and KEYS[3] the replay
+// buffer key. ARGV[1] is the new epoch and ARGV[2] the TTL in seconds.
+const initStreamLua = `
+local epoch = redis.call('GET', KEYS[2])
+if not epoch or redis.call('EXISTS', KEYS[1]) == 0 then
+  epoch = ARGV[1]
+  redis.call('SET', KEYS[1], 0, 'EX', ARGV[2])
+  redis.call('SET', KEYS[2], epoch, 'EX', ARGV[2])
+  redis.call('DEL', KEYS[3])
+end
+`
+
+// publishScript gives a sequence number to an event, adds it to the replay
+// buffer of the instance, and publishes it. It is done in a lua script to
+// ensure that the events are published in the order of their sequence
+// numbers, even with several stacks.
+//
+// The keys and the first arguments are the same as for initStreamLua. ARGV[3]
+// is the doctype, ARGV[4] the JSON of the event without its opening brace,
+// ARGV[5] the size of the replay buffer, and ARGV[6] the channel for the
+// events. It returns the epoch and the sequence number of the event.
+var publishScript = redis.NewScript(initStreamLua + `
+local seq = redis.call('INCR', KEYS[1])
+local msg = ARGV[3] .. ',{"epoch":"' .. epoch .. '","seq":' .. seq .. ',' .. ARGV[4]
+redis.call('ZADD', KEYS[3], seq, msg)
+redis.call('ZREMRANGEBYRANK', KEYS[3], 0, -(tonumber(ARGV[5]) + 1))
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+redis.call('EXPIRE', KEYS[2], ARGV[2])
+redis.call('EXPIRE', KEYS[3], ARGV[2])
+redis.call('PUBLISH', ARGV[6], msg)
+return {epoch, seq}
+`)
+
+// replayScript returns the epoch, the last sequence number, and the events of
+// the replay buffer after the sequence number given in ARGV[3]. The keys and
+// the first arguments are the same as for initStreamLua.
+var replayScript = redis.NewScript(initStreamLua + `
+local seq = redis.call('GET', KEYS[1])
+local msgs = redis.call('ZRANGEBYSCORE', KEYS[3], '(' .. ARGV[3], '+inf')
+return {epoch, seq, msgs}
+`)
+
+// The keys for an instance use a hash tag, so that they are on the same node
+// with redis cluster.
+func seqRedisKey(db prefixer.Prefixer) string {
+	return "realtime:{" + db.DBPrefix() + "}:seq"
+}
+
+func epochRedisKey(db prefixer.Prefixer) string {
+	return "realtime:{" + db.DBPrefix() + "}:epoch"
+}
+
+func replayRedisKey(db prefixer.Prefixer) string {
+	return "realtime:{" + db.DBPrefix() + "}:replay"
+}
+
+func streamRedisKeys(db prefixer.Prefixer) []string {
+	return []string{seqRedisKey(db), epochRedisKey(db), replayRedisKey(db)}
+}
+
 type redisHub struct {
 	c     redis.UniversalClient
 	mem   *memHub
@@ -64,6 +132,8 @@ type jsonEvent struct {
 	Verb   string
 	Doc    *JSONDoc
 	Old    *JSONDoc
+	Epoch  string
+	Seq    uint64
 }
 
 func (j *jsonEvent) UnmarshalJSON(buf []byte) error {
@@ -80,58 +150,151 @@ func (j *jsonEvent) UnmarshalJSON(buf []byte) error {
 	if old, ok := m["old"].(map[string]interface{}); ok {
 		j.Old = toJSONDoc(old)
 	}
+	j.Epoch, _ = m["epoch"].(string)
+	if seq, ok := m["seq"].(float64); ok {
+		j.Seq = uint64(seq)
+	}
 	return nil
 }
 
+// parseMessage parses a message from redis, in the doctype,json format.
+func parseMessage(payload string) (*Event, error) {
+	parts := strings.SplitN(payload, ",", 2)
+	if len(parts) < 2 {
+		return nil, errors.New("Invalid payload: " + payload)
+	}
+	doctype := parts[0]
+	je := jsonEvent{}
+	if err := json.Unmarshal([]byte(parts[1]), &je); err != nil {
+		return nil, err
+	}
+	if je.Doc == nil {
+		return nil, errors.New("Invalid payload: " + payload)
+	}
+	je.Doc.Type = doctype
+	db := prefixer.NewPrefixer(je.Domain, je.Prefix)
+	e := newEvent(db, je.Verb, je.Doc, nil)
+	if je.Old != nil {
+		je.Old.Type = doctype
+		e.OldDoc = je.Old
+	}
+	e.Epoch = je.Epoch
+	e.Seq = je.Seq
+	return e, nil
+}
+
 func (h *redisHub) start() {
 	sub := h.c.Subscribe(eventsRedisKey)
 	log := logger.WithNamespace("realtime-redis")
 	for msg := range sub.Channel() {
-		je := jsonEvent{}
-		parts := strings.SplitN(msg.Payload, ",", 2)
-		if len(parts) < 2 {
-			log.Warnf("Invalid payload: %s", msg.Payload)
-			continue
-		}
-		doctype := parts[0]
-		buf := []byte(parts[1])
-		if err := json.Unmarshal(buf, &je); err != nil {
+		e, err := parseMessage(msg.Payload)
+		if err != nil {
 			log.Warnf("Error on start: %s", err)
 			continue
 		}
-		if je.Doc != nil {
-			je.Doc.Type = doctype
-		}
-		if je.Old != nil {
-			je.Old.Type = doctype
-		}
-		db := prefixer.NewPrefixer(je.Domain, je.Prefix)
-		h.mem.Publish(db, je.Verb, je.Doc, je.Old)
+		h.mem.broadcast(e)
 	}
 }
 
 func (h *redisHub) GetTopic(db prefixer.Prefixer, doctype string) *topic {
-	return nil
+	return h.mem.GetTopic(db, doctype)
 }
 
 func (h *redisHub) Publish(db prefixer.Prefixer, verb string, doc, oldDoc Doc) {
 	e := newEvent(db, verb, doc, oldDoc)
-	h.local.broadcast <- e
+	log := logger.WithNamespace("realtime-redis")
 	buf, err := json.Marshal(e)
 	if err != nil {
-		log := logger.WithNamespace("realtime-redis")
 		log.Warnf("Error on publish: %s", err)
+		h.local.broadcast <- e
+		return
+	}
+	if IsInternal(e.Doc.DocType()) {
+		msg := e.Doc.DocType() + "," + string(buf)
+		if err := h.c.Publish(eventsRedisKey, msg).Err(); err != nil {
+			log.Warnf("Error on publish: %s", err)
+		}
+		h.local.broadcast <- e
 		return
 	}
-	h.c.Publish(eventsRedisKey, e.Doc.DocType()+","+string(buf))
+	ttl := int(replayTTL / time.Second)
+	res, err := publishScript.Run(h.c, streamRedisKeys(db), newEpoch(), ttl,
+		e.Doc.DocType(), string(buf[1:]), replaySize, eventsRedisKey).Result()
+	if err == nil {
+		var pos Position
+		if pos, err = parseScriptPosition(res); err == nil {
+			e.Epoch, e.Seq = pos.Epoch, pos.Seq
+		}
+	}
+	if err != nil {
+		log.Warnf("Error on publish: %s", err)
+	}
+	h.local.broadcast <- e
+}
+
+func (h *redisHub) Replay(db prefixer.Prefixer, since Position) ([]*Event, Position, error) {
+	ttl := int(replayTTL / time.Second)
+	res, err := replayScript.Run(h.c, streamRedisKeys(db), newEpoch(), ttl,
+		strconv.FormatUint(since.Seq, 10)).Result()
+	if err != nil {
+		return nil, Position{}, err
+	}
+	last, err := parseScriptPosition(res)
+	if err != nil {
+		return nil, Position{}, err
+	}
+	var msgs []interface{}
+	if list, ok := res.([]interface{}); ok && len(list) > 2 {
+		msgs, _ = list[2].([]interface{})
+	}
+	events := make([]*Event, 0, len(msgs))
+	for _, msg := range msgs {
+		payload, ok := msg.(string)
+		if !ok {
+			return nil, Position{}, errors.New("Invalid replay buffer")
+		}
+		e, err := parseMessage(payload)
+		if err != nil {
+			return nil, Position{}, err
+		}
+		events = append(events, e)
+	}
+	return eventsSince(events, last, since)
+}
+
+// parseScriptPosition parses the epoch and the sequence number returned as
+// the first two elements of the result of a lua script.
+func parseScriptPosition(res interface{}) (Position, error) {
+	list, ok := res.([]interface{})
+	if !ok || len(list) < 2 {
+		return Position{}, errors.New("Invalid result from redis")
+	}
+	epoch, ok := list[0].(string)
+	if !ok {
+		return Position{}, errors.New("Invalid result from redis")
+	}
+	var seq uint64
+	switch v := list[1].(type) {
+	case int64:
+		seq = uint64(v)
+	case string:
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return Position{}, err
+		}
+		seq = n
+	default:
+		return Position{}, errors.New("Invalid result from redis")
+	}
+	return Position{Epoch: epoch, Seq: seq}, nil
 }
 
 func (h *redisHub) Subscriber(db prefixer.Prefixer) *DynamicSubscriber {
-	return h.mem.Subscriber(db)
+	return newDynamicSubscriber(h, db)
 }
 
 func (h *redisHub) SubscribeLocalAll() *DynamicSubscriber {
 	ds := newDynamicSubscriber(nil, globalPrefixer)
-	ds.addTopic(h.local, "")
+	ds.addTopic(h.local, &toWatch{sub: &ds.Channel, doctype: "*"})
 	return ds
 }
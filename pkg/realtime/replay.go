@@ -0,0 +1,166 @@
+package realtime
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/utils"
+)
+
+const (
+	// replaySize is the maximal number of events kept by instance for
+	// replaying them to the clients that have been disconnected.
+	replaySize = 500
+
+	// replayTTL is the duration after which the replay buffer of an instance
+	// without new events is removed.
+	replayTTL = 1 * time.Hour
+
+	// epochLength is the length of the random strings used for the epochs.
+	epochLength = 8
+)
+
+var (
+	// ErrTooOld is used when a client asks for the events after a sequence
+	// number, but some of these events are no longer in the replay buffer.
+	ErrTooOld = errors.New("The events since this sequence number are no longer available")
+	// ErrInvalidPosition is used when a position can't be parsed.
+	ErrInvalidPosition = errors.New("The sequence number is invalid")
+)
+
+// Position is the position of an event in the stream of the events of an
+// instance. The sequence numbers are reset when the stream is recreated
+// (restart of the stack, expiration in redis, etc.), and the epoch is a random
+// string given to the stream when it is created, to tell the sequence numbers
+// of the old stream from those of the new one. It is formatted as
+// <epoch>-<seq>.
+type Position struct {
+	Epoch string
+	Seq   uint64
+}
+
+func newEpoch() string {
+	return utils.RandomString(epochLength)
+}
+
+// ParsePosition parses a position in the <epoch>-<seq> format.
+func ParsePosition(s string) (Position, error) {
+	i := strings.LastIndex(s, "-")
+	if i <= 0 {
+		return Position{}, ErrInvalidPosition
+	}
+	seq, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return Position{}, ErrInvalidPosition
+	}
+	return Position{Epoch: s[:i], Seq: seq}, nil
+}
+
+// String returns the position in the <epoch>-<seq> format, or an empty string
+// for the zero position.
+func (p Position) String() string {
+	if p.Epoch == "" {
+		return ""
+	}
+	return p.Epoch + "-" + strconv.FormatUint(p.Seq, 10)
+}
+
+// memBuffers are the replay buffers of the instances, for the in-memory hub.
+type memBuffers struct {
+	sync.Mutex
+	buffers map[string]*memBuffer
+}
+
+type memBuffer struct {
+	sync.Mutex
+	epoch     string
+	seq       uint64
+	events    []*Event // the last events, in the order of their seq
+	updatedAt int64    // unix timestamp of the last event, in nanoseconds
+}
+
+func newMemBuffers() *memBuffers {
+	return &memBuffers{buffers: make(map[string]*memBuffer)}
+}
+
+// get returns the replay buffer for the given instance, and creates it if it
+// doesn't exist.
+func (b *memBuffers) get(key string) *memBuffer {
+	b.Lock()
+	defer b.Unlock()
+	buf, ok := b.buffers[key]
+	if !ok {
+		b.purge(time.Now())
+		buf = &memBuffer{epoch: newEpoch(), updatedAt: time.Now().UnixNano()}
+		b.buffers[key] = buf
+	}
+	return buf
+}
+
+// push gives a sequence number to the event, and adds it to the replay buffer
+// of its instance. The broadcast function is called while the lock of the
+// buffer is held.
+func (b *memBuffers) push(e *Event, broadcast func(e *Event)) {
+	buf := b.get(e.DBPrefix())
+	buf.Lock()
+	defer buf.Unlock()
+	buf.seq++
+	atomic.StoreInt64(&buf.updatedAt, time.Now().UnixNano())
+	e.Epoch = buf.epoch
+	e.Seq = buf.seq
+	buf.events = append(buf.events, e)
+	if len(buf.events) > replaySize {
+		buf.events = buf.events[len(buf.events)-replaySize:]
+	}
+	broadcast(e)
+}
+
+// purge removes the buffers of the instances without events since replayTTL.
+func (b *memBuffers) purge(now time.Time) {
+	for key, buf := range b.buffers {
+		updatedAt := time.Unix(0, atomic.LoadInt64(&buf.updatedAt))
+		if now.Sub(updatedAt) > replayTTL {
+			delete(b.buffers, key)
+		}
+	}
+}
+
+// replay returns the events after the since position. The replay buffer is
+// created if it doesn't exist, to give an epoch to the stream of the instance.
+func (b *memBuffers) replay(db prefixer.Prefixer, since Position) ([]*Event, Position, error) {
+	buf := b.get(db.DBPrefix())
+	buf.Lock()
+	defer buf.Unlock()
+	return eventsSince(buf.events, Position{Epoch: buf.epoch, Seq: buf.seq}, since)
+}
+
+// eventsSince returns the events with a position after since, or ErrTooOld if
+// some of those events are missing. The events must be in the order of their
+// sequence numbers, and last is the position of the last event published for
+// the instance.
+func eventsSince(events []*Event, last, since Position) ([]*Event, Position, error) {
+	// The sequence numbers of another epoch can't be compared with those of
+	// the current stream
+	if since.Epoch != last.Epoch {
+		return nil, last, ErrTooOld
+	}
+	if since.Seq == last.Seq {
+		return nil, last, nil
+	}
+	if since.Seq > last.Seq || len(events) == 0 || events[0].Seq > since.Seq+1 {
+		return nil, last, ErrTooOld
+	}
+	for i, e := range events {
+		if e.Seq > since.Seq {
+			list := make([]*Event, len(events)-i)
+			copy(list, events[i:])
+			return list, last, nil
+		}
+	}
+	return nil, last, nil
+}
@@ -0,0 +1,402 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Selector is a compiled Mango-like selector, used to filter the events sent
+// to a subscriber. It supports the same syntax as the selectors of the
+// _find requests of CouchDB:
+//
+//   - implicit equality: {"account": "123"}
+//   - nested fields: {"metadata.type": "invoice"} or {"metadata": {"type": "invoice"}}
+//   - operators: $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $regex,
+//     $size, $all and $elemMatch
+//   - combinations: $and, $or, $nor and $not
+type Selector struct {
+	raw  map[string]interface{}
+	root matcher
+}
+
+// NewSelector compiles a selector. It returns an error if the selector uses
+// an unknown operator or has an invalid argument.
+func NewSelector(raw map[string]interface{}) (*Selector, error) {
+	if len(raw) == 0 {
+		return nil, invalidSelector("empty selector")
+	}
+	root, err := compileSelector(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{raw: raw, root: root}, nil
+}
+
+// MarshalJSON returns the selector as it was given to NewSelector.
+func (s *Selector) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.raw)
+}
+
+// Match returns true if the document is matched by the selector. The values
+// of the document must be the ones from a JSON decoding (float64 for the
+// numbers, []interface{} for the arrays, etc.).
+func (s *Selector) Match(doc map[string]interface{}) bool {
+	return s.root.match(doc, true)
+}
+
+// MatchDoc is the same as Match, but for a Doc of the realtime hub.
+func (s *Selector) MatchDoc(doc Doc) bool {
+	m := docToMap(doc)
+	return m != nil && s.Match(m)
+}
+
+func invalidSelector(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid selector: "+format, args...)
+}
+
+// docToMap returns the fields of the document, as a map.
+func docToMap(doc Doc) map[string]interface{} {
+	if doc == nil {
+		return nil
+	}
+	if j, ok := doc.(*JSONDoc); ok {
+		if j == nil {
+			return nil
+		}
+		return j.M
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// A matcher checks a value. The present parameter is false when the value is
+// for a field that is missing in the document.
+type matcher interface {
+	match(value interface{}, present bool) bool
+}
+
+type matchFunc func(value interface{}, present bool) bool
+
+func (f matchFunc) match(value interface{}, present bool) bool { return f(value, present) }
+
+type andMatcher []matcher
+
+func (a andMatcher) match(value interface{}, present bool) bool {
+	for _, m := range a {
+		if !m.match(value, present) {
+			return false
+		}
+	}
+	return true
+}
+
+type orMatcher []matcher
+
+func (o orMatcher) match(value interface{}, present bool) bool {
+	for _, m := range o {
+		if m.match(value, present) {
+			return true
+		}
+	}
+	return false
+}
+
+type notMatcher struct{ m matcher }
+
+func (n notMatcher) match(value interface{}, present bool) bool {
+	return !n.m.match(value, present)
+}
+
+// fieldMatcher applies a matcher on the value of a field of an object.
+type fieldMatcher struct {
+	path []string
+	m    matcher
+}
+
+func (f fieldMatcher) match(value interface{}, present bool) bool {
+	for _, key := range f.path {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return f.m.match(nil, false)
+		}
+		value, present = obj[key]
+	}
+	return f.m.match(value, present)
+}
+
+func compileSelector(raw map[string]interface{}) (matcher, error) {
+	all := make(andMatcher, 0, len(raw))
+	for key, arg := range raw {
+		var m matcher
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			m, err = compileCombination(key, arg)
+		case "$not":
+			sub, ok := arg.(map[string]interface{})
+			if !ok {
+				return nil, invalidSelector("$not expects an object")
+			}
+			m, err = compileSelector(sub)
+			m = notMatcher{m}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, invalidSelector("unknown operator %s", key)
+			}
+			m, err = compileCondition(arg)
+			m = fieldMatcher{path: strings.Split(key, "."), m: m}
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, m)
+	}
+	return all, nil
+}
+
+func compileCombination(op string, arg interface{}) (matcher, error) {
+	list, ok := arg.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, invalidSelector("%s expects a non-empty array", op)
+	}
+	matchers := make([]matcher, len(list))
+	for i, item := range list {
+		sub, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, invalidSelector("%s expects an array of objects", op)
+		}
+		m, err := compileSelector(sub)
+		if err != nil {
+			return nil, err
+		}
+		matchers[i] = m
+	}
+	switch op {
+	case "$and":
+		return andMatcher(matchers), nil
+	case "$or":
+		return orMatcher(matchers), nil
+	default: // $nor
+		return notMatcher{orMatcher(matchers)}, nil
+	}
+}
+
+// compileCondition compiles the condition for a field: an object with
+// operators, a sub-selector, or a value for an implicit equality.
+func compileCondition(arg interface{}) (matcher, error) {
+	obj, ok := arg.(map[string]interface{})
+	if !ok || len(obj) == 0 {
+		return eqMatcher(arg), nil
+	}
+	hasOperators := false
+	for key := range obj {
+		if strings.HasPrefix(key, "$") {
+			hasOperators = true
+			break
+		}
+	}
+	if !hasOperators {
+		return compileSelector(obj)
+	}
+	all := make(andMatcher, 0, len(obj))
+	for op, opArg := range obj {
+		if !strings.HasPrefix(op, "$") {
+			return nil, invalidSelector("cannot mix operators and fields")
+		}
+		m, err := compileOperator(op, opArg)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, m)
+	}
+	return all, nil
+}
+
+func compileOperator(op string, arg interface{}) (matcher, error) {
+	switch op {
+	case "$eq":
+		return eqMatcher(arg), nil
+	case "$ne":
+		eq := eqMatcher(arg)
+		return matchFunc(func(v interface{}, present bool) bool {
+			return present && !eq.match(v, present)
+		}), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return compareMatcher(op, arg)
+	case "$in", "$nin":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalidSelector("%s expects an array", op)
+		}
+		in := matchFunc(func(v interface{}, present bool) bool {
+			if !present {
+				return false
+			}
+			for _, item := range list {
+				if equalValues(v, item) {
+					return true
+				}
+			}
+			return false
+		})
+		if op == "$in" {
+			return in, nil
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			return present && !in.match(v, present)
+		}), nil
+	case "$exists":
+		exists, ok := arg.(bool)
+		if !ok {
+			return nil, invalidSelector("$exists expects a boolean")
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			return present == exists
+		}), nil
+	case "$regex":
+		pattern, ok := arg.(string)
+		if !ok {
+			return nil, invalidSelector("$regex expects a string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, invalidSelector("%s", err)
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		}), nil
+	case "$size":
+		size, ok := arg.(float64)
+		if !ok {
+			return nil, invalidSelector("$size expects a number")
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			list, ok := v.([]interface{})
+			return ok && float64(len(list)) == size
+		}), nil
+	case "$all":
+		wanted, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalidSelector("$all expects an array")
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			list, ok := v.([]interface{})
+			if !ok {
+				return false
+			}
+			for _, w := range wanted {
+				found := false
+				for _, item := range list {
+					if equalValues(item, w) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return false
+				}
+			}
+			return true
+		}), nil
+	case "$elemMatch":
+		m, err := compileCondition(arg)
+		if err != nil {
+			return nil, err
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			list, ok := v.([]interface{})
+			if !ok {
+				return false
+			}
+			for _, item := range list {
+				if m.match(item, true) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	case "$not":
+		m, err := compileCondition(arg)
+		if err != nil {
+			return nil, err
+		}
+		return matchFunc(func(v interface{}, present bool) bool {
+			return present && !m.match(v, present)
+		}), nil
+	}
+	return nil, invalidSelector("unknown operator %s", op)
+}
+
+func eqMatcher(arg interface{}) matcher {
+	return matchFunc(func(v interface{}, present bool) bool {
+		return present && equalValues(v, arg)
+	})
+}
+
+func compareMatcher(op string, arg interface{}) (matcher, error) {
+	switch arg.(type) {
+	case float64, string:
+	default:
+		return nil, invalidSelector("%s expects a number or a string", op)
+	}
+	return matchFunc(func(v interface{}, present bool) bool {
+		if !present {
+			return false
+		}
+		cmp, ok := compareValues(v, arg)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "$gt":
+			return cmp > 0
+		case "$gte":
+			return cmp >= 0
+		case "$lt":
+			return cmp < 0
+		default: // $lte
+			return cmp <= 0
+		}
+	}), nil
+}
+
+// compareValues compares two numbers or two strings. The boolean is false if
+// the values cannot be compared.
+func compareValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+func equalValues(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseJSON(t *testing.T, s string) map[string]interface{} {
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(s), &m))
+	return m
+}
+
+func TestSelectorMatch(t *testing.T) {
+	doc := parseJSON(t, `{
+		"_id": "op-1",
+		"account": "123",
+		"amount": -42.5,
+		"label": "Grocery store",
+		"tags": ["food", "weekly"],
+		"metadata": {"version": 2, "source": "bank"},
+		"splits": [{"amount": -20}, {"amount": -22.5}]
+	}`)
+
+	cases := []struct {
+		selector string
+		match    bool
+	}{
+		{`{"account": "123"}`, true},
+		{`{"account": "456"}`, false},
+		{`{"account": "123", "amount": {"$lt": 0}}`, true},
+		{`{"account": "123", "amount": {"$gt": 0}}`, false},
+		{`{"amount": {"$gte": -42.5, "$lte": -42.5}}`, true},
+		{`{"metadata.source": "bank"}`, true},
+		{`{"metadata": {"version": {"$gt": 1}}}`, true},
+		{`{"metadata.missing": {"$exists": false}}`, true},
+		{`{"metadata.source": {"$exists": false}}`, false},
+		{`{"account": {"$ne": "456"}}`, true},
+		{`{"missing": {"$ne": "456"}}`, false},
+		{`{"account": {"$in": ["123", "456"]}}`, true},
+		{`{"account": {"$nin": ["123", "456"]}}`, false},
+		{`{"label": {"$regex": "^Groc"}}`, true},
+		{`{"tags": {"$all": ["weekly", "food"]}}`, true},
+		{`{"tags": {"$size": 3}}`, false},
+		{`{"tags": {"$elemMatch": {"$eq": "food"}}}`, true},
+		{`{"splits": {"$elemMatch": {"amount": {"$lt": -21}}}}`, true},
+		{`{"$or": [{"account": "456"}, {"label": "Grocery store"}]}`, true},
+		{`{"$and": [{"account": "123"}, {"label": "Bakery"}]}`, false},
+		{`{"$nor": [{"account": "456"}, {"label": "Bakery"}]}`, true},
+		{`{"$not": {"account": "123"}}`, false},
+		{`{"amount": {"$not": {"$gt": 0}}}`, true},
+	}
+	for _, c := range cases {
+		sel, err := NewSelector(parseJSON(t, c.selector))
+		require.NoError(t, err, c.selector)
+		assert.Equal(t, c.match, sel.Match(doc), c.selector)
+	}
+}
+
+func TestInvalidSelector(t *testing.T) {
+	for _, s := range []string{
+		`{}`,
+		`{"$where": "true"}`,
+		`{"amount": {"$between": [1, 2]}}`,
+		`{"amount": {"$gt": 1, "currency": "EUR"}}`,
+		`{"$or": {"account": "123"}}`,
+		`{"label": {"$regex": "("}}`,
+		`{"label": {"$exists": "yes"}}`,
+		`{"amount": {"$gt": [1]}}`,
+	} {
+		_, err := NewSelector(parseJSON(t, s))
+		assert.Error(t, err, s)
+	}
+}
@@ -29,6 +29,7 @@ type Version struct {
 	Size      string          `json:"size"`
 	Manifest  json.RawMessage `json:"manifest"`
 	TarPrefix string          `json:"tar_prefix"`
+	Signature string          `json:"signature,omitempty"`
 }
 
 // A MaintenanceOptions defines options about a maintenance
@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	// ErrUnsigned is used when a version has no signature.
+	ErrUnsigned = errors.New("registry: the version is not signed")
+	// ErrBadSignature is used when the signature of a version has not been
+	// made with one of the trusted keys.
+	ErrBadSignature = errors.New("registry: the signature of the version is invalid")
+)
+
+// SignedMessage returns the message that the publisher signs for a version:
+// the slug, the version and the sha256 of the tarball, separated by newlines.
+func (v *Version) SignedMessage() []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s", v.Slug, v.Version, v.Sha256))
+}
+
+// VerifySignature checks that the version has an Ed25519 signature, encoded
+// in base64, made with one of the given public keys.
+func (v *Version) VerifySignature(keys []ed25519.PublicKey) error {
+	if v.Signature == "" {
+		return ErrUnsigned
+	}
+	sig, err := base64.StdEncoding.DecodeString(v.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	msg := v.SignedMessage()
+	for _, key := range keys {
+		if ed25519.Verify(key, msg, sig) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// ParsePublicKey decodes a base64 encoded Ed25519 public key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("registry: invalid size for a public key: %d", len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	key, err := ParsePublicKey(encoded)
+	assert.NoError(t, err)
+	_, err = ParsePublicKey("Zm9v")
+	assert.Error(t, err)
+
+	v := &Version{
+		Slug:    "drive",
+		Version: "1.0.0",
+		Sha256:  "0b3fd7d1d5e1b7e8a4c7e1e1a1f9e8f5b6c5c1e1f0b2a8f9f7c6d5b4a3c2d1e0",
+	}
+	assert.Equal(t, ErrUnsigned, v.VerifySignature([]ed25519.PublicKey{key}))
+
+	sig := ed25519.Sign(priv, v.SignedMessage())
+	v.Signature = base64.StdEncoding.EncodeToString(sig)
+	assert.NoError(t, v.VerifySignature([]ed25519.PublicKey{other, key}))
+	assert.Equal(t, ErrBadSignature, v.VerifySignature([]ed25519.PublicKey{other}))
+
+	v.Version = "1.0.1"
+	assert.Equal(t, ErrBadSignature, v.VerifySignature([]ed25519.PublicKey{key}))
+
+	v.Signature = "not base64"
+	assert.Equal(t, ErrBadSignature, v.VerifySignature([]ed25519.PublicKey{key}))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -210,3 +211,46 @@ func DurationFuzzing(d time.Duration, variation float64) time.Duration {
 	}
 	return time.Duration(float64(d) * (1.0 + variation*(2.0*rand.Float64()-1.0)))
 }
+
+// ClientIP returns the IP address of the client that has made the request.
+// The X-Forwarded-For header is used only when the request comes from one of
+// the trusted proxies: it is read from right to left, and the first address
+// that is not a trusted proxy is the client.
+func ClientIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !isTrustedProxy(remote, trustedProxies) {
+		return remote
+	}
+	forwardedFor := req.Header[http.CanonicalHeaderKey("X-Forwarded-For")]
+	ip := remote
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		hops := strings.Split(forwardedFor[i], ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop := strings.TrimSpace(hops[j])
+			if net.ParseIP(hop) == nil {
+				return ip
+			}
+			ip = hop
+			if !isTrustedProxy(hop, trustedProxies) {
+				return ip
+			}
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"testing"
@@ -105,3 +107,31 @@ func TestAbsPath(t *testing.T) {
 	quux := AbsPath("////qux//quux/../quux")
 	assert.Equal(t, "/qux/quux", quux)
 }
+
+func TestClientIP(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{loopback, private}
+
+	req := &http.Request{RemoteAddr: "203.0.113.7:4242", Header: http.Header{}}
+	assert.Equal(t, "203.0.113.7", ClientIP(req, trusted))
+	// The header is ignored when the request doesn't come from a trusted proxy
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "203.0.113.7", ClientIP(req, trusted))
+
+	req = &http.Request{RemoteAddr: "127.0.0.1:4242", Header: http.Header{}}
+	assert.Equal(t, "127.0.0.1", ClientIP(req, trusted))
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "198.51.100.1", ClientIP(req, trusted))
+	// A forged value on the left is not used
+	req.Header.Set("X-Forwarded-For", "192.0.2.66, 198.51.100.1, 10.0.0.3")
+	assert.Equal(t, "198.51.100.1", ClientIP(req, trusted))
+	req.Header.Set("X-Forwarded-For", "10.0.0.4, 10.0.0.3")
+	assert.Equal(t, "10.0.0.4", ClientIP(req, trusted))
+	req.Header.Set("X-Forwarded-For", "garbage, 10.0.0.3")
+	assert.Equal(t, "10.0.0.3", ClientIP(req, trusted))
+
+	req = &http.Request{RemoteAddr: "127.0.0.1:4242", Header: http.Header{}}
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "127.0.0.1", ClientIP(req, nil))
+}
@@ -0,0 +1,283 @@
+// Package wasm runs WebAssembly modules in-process, with a pure-Go engine, for
+// the konnectors and services that are compiled to WebAssembly.
+//
+// The modules are executed like a command: their _start function is called.
+// They can use WASI for the environment variables, the clock, the random
+// numbers, and stdin/stdout/stderr, but they have no access to the file
+// system. They can also import the functions of the "cozy" host module:
+//
+//	get(name_ptr, name_len, buf_ptr, buf_len i32) i32
+//	log(ptr, len i32)
+//
+// get copies the value of a parameter in the buffer (url, credentials, fields,
+// parameters, etc.) and returns its length, or -1 if the parameter is not
+// defined. Nothing is copied if the buffer is too small, and the module can
+// retry with a buffer of the returned length. log sends a message in the same
+// JSON format as the lines written on stdout.
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	// hostModule is the name of the module with the host API.
+	hostModule = "cozy"
+
+	// envPrefix is the prefix of the environment variables that can be read
+	// with the get function of the host API.
+	envPrefix = "COZY_"
+
+	// pageSize is the size of a page of WebAssembly memory.
+	pageSize = 64 * 1024
+
+	// maxLineSize is the maximal length of a line written on stdout.
+	maxLineSize = 64 * 1024
+)
+
+// ErrInvalidModule is used when the file is not a valid WebAssembly module.
+var ErrInvalidModule = errors.New("Invalid WebAssembly module")
+
+// ExitError is used when the module has exited with a non-zero status code.
+type ExitError struct {
+	Code uint32
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Options are the parameters for the execution of a module.
+type Options struct {
+	// Name is the name of the program, given as first argument.
+	Name string
+	// Env is the list of environment variables, in the KEY=value format.
+	Env []string
+	// Output is called for each line written on stdout, and for each call to
+	// the log function of the host API.
+	Output func(line []byte)
+	// Stdin is where the module reads its stdin. If it is an io.Closer, it
+	// is closed when the execution is stopped, to unblock a pending read.
+	Stdin io.Reader
+	// Stderr is where the module writes on stderr.
+	Stderr io.Writer
+	// MemoryLimit is the maximal size of the memory, in bytes (0 for the
+	// default limit of 4GB).
+	MemoryLimit int64
+	// TimeLimit is the maximal duration of the execution (0 for no other limit
+	// than the deadline of the context).
+	TimeLimit time.Duration
+}
+
+// Run compiles and executes the WebAssembly module. It returns an ExitError
+// if the module exits with a non-zero status, and the error of the context if
+// the execution has been stopped because of its deadline or the time limit.
+func Run(ctx context.Context, module []byte, opts *Options) error {
+	if opts.TimeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.TimeLimit)
+		defer cancel()
+	}
+	if closer, ok := opts.Stdin.(io.Closer); ok {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = closer.Close()
+			case <-done:
+			}
+		}()
+	}
+
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if opts.MemoryLimit > 0 {
+		pages := opts.MemoryLimit / pageSize
+		if pages < 1 {
+			pages = 1
+		}
+		config = config.WithMemoryLimitPages(uint32(pages))
+	}
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+	defer r.Close(context.Background())
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return err
+	}
+	h := newHost(opts)
+	if err := h.instantiate(ctx, r); err != nil {
+		return err
+	}
+
+	compiled, err := r.CompileModule(ctx, module)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrInvalidModule, err)
+	}
+
+	stdout := &lineWriter{output: h.output}
+	var stdin io.Reader = bytes.NewReader(nil)
+	if opts.Stdin != nil {
+		stdin = opts.Stdin
+	}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	conf := wazero.NewModuleConfig().
+		WithArgs(opts.Name).
+		WithStdout(stdout).
+		WithStdin(stdin).
+		WithStderr(stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	for _, kv := range opts.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			conf = conf.WithEnv(parts[0], parts[1])
+		}
+	}
+
+	mod, err := r.InstantiateModule(ctx, compiled, conf)
+	stdout.flush()
+	if mod != nil {
+		_ = mod.Close(context.Background())
+	}
+	return exitError(ctx, err)
+}
+
+func exitError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if exit, ok := err.(*sys.ExitError); ok {
+		switch exit.ExitCode() {
+		case 0:
+			return nil
+		case sys.ExitCodeDeadlineExceeded:
+			return context.DeadlineExceeded
+		case sys.ExitCodeContextCanceled:
+			return context.Canceled
+		}
+		return &ExitError{Code: exit.ExitCode()}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// host implements the functions of the host API.
+type host struct {
+	params map[string]string
+	output func(line []byte)
+}
+
+func newHost(opts *Options) *host {
+	params := make(map[string]string)
+	for _, kv := range opts.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], envPrefix) {
+			name := strings.ToLower(strings.TrimPrefix(parts[0], envPrefix))
+			params[name] = parts[1]
+		}
+	}
+	output := opts.Output
+	if output == nil {
+		output = func(line []byte) {}
+	}
+	return &host{params: params, output: output}
+}
+
+func (h *host) instantiate(ctx context.Context, r wazero.Runtime) error {
+	_, err := r.NewHostModuleBuilder(hostModule).
+		NewFunctionBuilder().WithFunc(h.get).Export("get").
+		NewFunctionBuilder().WithFunc(h.log).Export("log").
+		Instantiate(ctx)
+	return err
+}
+
+func (h *host) get(ctx context.Context, m api.Module, namePtr, nameLen, bufPtr, bufLen uint32) int32 {
+	name, ok := m.Memory().Read(namePtr, nameLen)
+	if !ok {
+		return -1
+	}
+	value, ok := h.params[string(name)]
+	if !ok {
+		return -1
+	}
+	if uint32(len(value)) <= bufLen {
+		if !m.Memory().Write(bufPtr, []byte(value)) {
+			return -1
+		}
+	}
+	return int32(len(value))
+}
+
+func (h *host) log(ctx context.Context, m api.Module, ptr, length uint32) {
+	msg, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return
+	}
+	line := make([]byte, len(msg))
+	copy(line, msg)
+	h.output(line)
+}
+
+// lineWriter calls the output function for each line written on stdout.
+// The lines that are too long are discarded.
+type lineWriter struct {
+	output  func(line []byte)
+	buf     bytes.Buffer
+	discard bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.append(p)
+			break
+		}
+		w.append(p[:i])
+		w.flush()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (w *lineWriter) append(p []byte) {
+	if w.discard {
+		return
+	}
+	if w.buf.Len()+len(p) > maxLineSize {
+		w.buf.Reset()
+		w.discard = true
+		return
+	}
+	w.buf.Write(p)
+}
+
+func (w *lineWriter) flush() {
+	if !w.discard && w.buf.Len() > 0 {
+		line := make([]byte, w.buf.Len())
+		copy(line, w.buf.Bytes())
+		w.output(line)
+	}
+	w.buf.Reset()
+	w.discard = false
+}
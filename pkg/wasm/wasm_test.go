@@ -0,0 +1,161 @@
+package wasm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The modules used in the tests are encoded by hand, to avoid a dependency on
+// a WebAssembly toolchain.
+
+func leb(n int64) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && b&0x40 == 0) || (n == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func vec(items ...[]byte) []byte {
+	out := leb(int64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func name(s string) []byte {
+	return append(leb(int64(len(s))), s...)
+}
+
+func section(id byte, content []byte) []byte {
+	out := append([]byte{id}, leb(int64(len(content)))...)
+	return append(out, content...)
+}
+
+func i32(n int64) []byte {
+	return append([]byte{0x41}, leb(n)...)
+}
+
+func call(idx int64) []byte {
+	return append([]byte{0x10}, leb(idx)...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// module builds a module with the imports of the host API and WASI, a memory
+// of one page, and a _start function with the given locals and body.
+func module(locals []byte, body []byte, data ...[]byte) []byte {
+	const i32t = 0x7f
+	types := vec(
+		[]byte{0x60, 4, i32t, i32t, i32t, i32t, 1, i32t}, // get, fd_write
+		[]byte{0x60, 2, i32t, i32t, 0},                   // log
+		[]byte{0x60, 1, i32t, 0},                         // proc_exit
+		[]byte{0x60, 0, 0},                               // _start
+	)
+	imports := vec(
+		concat(name("cozy"), name("get"), []byte{0x00, 0}),
+		concat(name("cozy"), name("log"), []byte{0x00, 1}),
+		concat(name("wasi_snapshot_preview1"), name("fd_write"), []byte{0x00, 0}),
+		concat(name("wasi_snapshot_preview1"), name("proc_exit"), []byte{0x00, 2}),
+	)
+	exports := vec(
+		concat(name("_start"), []byte{0x00, 4}),
+		concat(name("memory"), []byte{0x02, 0}),
+	)
+	code := concat(locals, body, []byte{0x0b})
+	m := concat(
+		[]byte{0x00, 'a', 's', 'm', 1, 0, 0, 0},
+		section(1, types),
+		section(2, imports),
+		section(3, vec([]byte{3})),
+		section(5, vec([]byte{0x00, 1})),
+		section(7, exports),
+		section(10, vec(append(leb(int64(len(code))), code...))),
+	)
+	if len(data) > 0 {
+		m = append(m, section(11, vec(data...))...)
+	}
+	return m
+}
+
+func segment(offset int64, content []byte) []byte {
+	return concat([]byte{0x00}, i32(offset), []byte{0x0b}, name(string(content)))
+}
+
+func TestHostAPI(t *testing.T) {
+	body := concat(
+		// log(400, get("url", 400, 64))
+		i32(0), i32(3), i32(400), i32(64), call(0), []byte{0x21, 0},
+		i32(400), []byte{0x20, 0}, call(1),
+		// log(`{"type":"debug"}`)
+		i32(16), i32(16), call(1),
+		// fd_write(stdout, iovec at 200, 1, nwritten at 208)
+		i32(1), i32(200), i32(1), i32(208), call(2), []byte{0x1a},
+		// proc_exit(3)
+		i32(3), call(3),
+	)
+	mod := module([]byte{1, 1, 0x7f}, body,
+		segment(0, []byte("url")),
+		segment(16, []byte(`{"type":"debug"}`)),
+		segment(200, []byte{0x2c, 0x01, 0, 0, 12, 0, 0, 0}),
+		segment(300, []byte("hello\nworld\n")),
+	)
+
+	var lines []string
+	opts := &Options{
+		Name: "index.wasm",
+		Env:  []string{"COZY_URL=http://alice.cozy.localhost:8080/", "PATH=/bin"},
+		Output: func(line []byte) {
+			lines = append(lines, string(line))
+		},
+	}
+	err := Run(context.Background(), mod, opts)
+	require.Error(t, err)
+	exit, ok := err.(*ExitError)
+	require.True(t, ok)
+	assert.EqualValues(t, 3, exit.Code)
+	assert.Equal(t, []string{
+		"http://alice.cozy.localhost:8080/",
+		`{"type":"debug"}`,
+		"hello",
+		"world",
+	}, lines)
+}
+
+func TestTimeLimit(t *testing.T) {
+	// loop br 0 end
+	mod := module([]byte{0}, []byte{0x03, 0x40, 0x0c, 0, 0x0b})
+	opts := &Options{TimeLimit: 100 * time.Millisecond}
+	start := time.Now()
+	err := Run(context.Background(), mod, opts)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// if memory.grow(100) == -1 { unreachable }
+	body := concat(i32(100), []byte{0x40, 0}, i32(-1), []byte{0x46, 0x04, 0x40, 0x00, 0x0b})
+	mod := module([]byte{0}, body)
+	assert.NoError(t, Run(context.Background(), mod, &Options{}))
+	assert.Error(t, Run(context.Background(), mod, &Options{MemoryLimit: 1 << 20}))
+}
+
+func TestInvalidModule(t *testing.T) {
+	err := Run(context.Background(), []byte("not a wasm module"), &Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is used when the endpoint of a subscription resolves to
+// an address that is not reachable on the internet (loopback, private
+// network, etc.).
+var ErrForbiddenAddress = errors.New("Forbidden address for a push service")
+
+// pushServices is the list of the domains used by the push services of the
+// browsers for the endpoints of their subscriptions.
+var pushServices = []string{
+	"fcm.googleapis.com",
+	"android.googleapis.com",
+	"updates.push.services.mozilla.com",
+	"push.services.mozilla.com",
+	"push.apple.com",
+	"notify.windows.com",
+}
+
+// IsPushService returns true if the host (without port) is a known push
+// service, or one of its subdomains.
+func IsPushService(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, service := range pushServices {
+		if host == service || strings.HasSuffix(host, "."+service) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewClient returns an HTTP client for sending the notifications to the push
+// services. It does not follow the redirections, and it refuses to connect
+// to the loopback, private, and link-local addresses.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   controlAddress,
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// controlAddress is called with the resolved address, just before the
+// connection, so that a DNS record pointing to an internal address cannot be
+// used to reach it.
+func controlAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+var privateNetworks = []string{
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, cidr := range privateNetworks {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
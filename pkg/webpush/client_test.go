@@ -0,0 +1,58 @@
+package webpush
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPushService(t *testing.T) {
+	assert.True(t, IsPushService("fcm.googleapis.com"))
+	assert.True(t, IsPushService("updates.push.services.mozilla.com"))
+	assert.True(t, IsPushService("web.push.apple.com"))
+	assert.True(t, IsPushService("wns2-par02p.notify.windows.com"))
+	assert.True(t, IsPushService("FCM.googleapis.com."))
+
+	assert.False(t, IsPushService("localhost"))
+	assert.False(t, IsPushService("127.0.0.1"))
+	assert.False(t, IsPushService("push.example.net"))
+	assert.False(t, IsPushService("evilpush.apple.com.example.net"))
+	assert.False(t, IsPushService("notpush.apple.com"))
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1", "0.0.0.0", "10.1.2.3",
+		"172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1",
+		"fd00::1", "fe80::1"} {
+		assert.False(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "142.250.74.42", "2a00:1450:4007:80e::200a"} {
+		assert.True(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestClientRefusesLocalAddresses(t *testing.T) {
+	calls := 0
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer stub.Close()
+
+	client := NewClient(time.Second)
+	_, err := client.Post(stub.URL, "application/octet-stream", strings.NewReader("foo"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrForbiddenAddress.Error())
+	assert.Equal(t, 0, calls)
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	client := NewClient(time.Second)
+	req, _ := http.NewRequest(http.MethodPost, "https://fcm.googleapis.com/", nil)
+	assert.Equal(t, http.ErrUseLastResponse, client.CheckRedirect(req, nil))
+}
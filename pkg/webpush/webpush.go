@@ -0,0 +1,292 @@
+// Package webpush implements the Web Push protocol, to send notifications to
+// the browsers. The payloads are encrypted as described in RFC 8291, and the
+// application server is identified with VAPID (RFC 8292).
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+const (
+	// recordSize is the size of the record for the aes128gcm content coding.
+	// A single record is used for the payload.
+	recordSize = 4096
+
+	// headerSize is the size of the header of the encrypted content: salt,
+	// record size, key id length, and the public key of the server.
+	headerSize = 16 + 4 + 1 + 65
+
+	// MaxPayloadSize is the maximal size of a payload, as the push services
+	// are only required to accept a body of 4096 bytes.
+	MaxPayloadSize = recordSize - headerSize - 16 - 1
+
+	// vapidExpiration is the validity of the JWT sent to the push service.
+	vapidExpiration = 12 * time.Hour
+)
+
+var (
+	// ErrInvalidSubscription is used when the keys of a subscription cannot
+	// be used for encrypting a payload.
+	ErrInvalidSubscription = errors.New("Invalid subscription for web push")
+	// ErrPayloadTooLarge is used when the payload is larger than
+	// MaxPayloadSize.
+	ErrPayloadTooLarge = errors.New("Payload too large for web push")
+	// ErrSubscriptionGone is used when the push service has responded that
+	// the subscription has expired or has been revoked.
+	ErrSubscriptionGone = errors.New("The web push subscription is no longer valid")
+)
+
+// Subscription is a push subscription, as given by the browser (see the
+// PushSubscription API). The keys are encoded in base64url.
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// VAPIDKeys is the key pair used to identify the application server to the
+// push services. The private key is the scalar of the P-256 key, and the
+// public key is the uncompressed point, both encoded in base64url.
+type VAPIDKeys struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+// Options are the parameters for sending a push message.
+type Options struct {
+	// VAPID is the key pair of the application server.
+	VAPID *VAPIDKeys
+	// Subject is a contact for the application server, as a mailto: or an
+	// https: URL.
+	Subject string
+	// TTL is how long the push service should keep the message if the
+	// browser is not connected.
+	TTL time.Duration
+	// Urgency is one of very-low, low, normal, or high.
+	Urgency string
+	// Topic can be used to replace a pending message with the same topic.
+	Topic string
+}
+
+// GenerateVAPIDKeys returns a new key pair for VAPID.
+func GenerateVAPIDKeys() (*VAPIDKeys, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	pub := elliptic.Marshal(elliptic.P256(), priv.X, priv.Y)
+	return &VAPIDKeys{
+		PrivateKey: base64.RawURLEncoding.EncodeToString(scalarBytes(priv.D)),
+		PublicKey:  base64.RawURLEncoding.EncodeToString(pub),
+	}, nil
+}
+
+func (k *VAPIDKeys) ecdsaKey() (*ecdsa.PrivateKey, error) {
+	d, err := decodeBase64(k.PrivateKey)
+	if err != nil || len(d) != 32 {
+		return nil, errors.New("Invalid VAPID private key")
+	}
+	curve := elliptic.P256()
+	priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d)
+	return priv, nil
+}
+
+// authorization returns the value of the Authorization header for VAPID.
+func (k *VAPIDKeys) authorization(endpoint, subject string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	priv, err := k.ecdsaKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidExpiration).Unix(),
+		"sub": subject,
+	})
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + signed + ", k=" + k.PublicKey, nil
+}
+
+// ValidateSubscription checks that the keys of the subscription are valid.
+func ValidateSubscription(sub *Subscription) error {
+	_, _, _, err := sub.keys()
+	return err
+}
+
+func (sub *Subscription) keys() (uaPublic []byte, x, y *big.Int, err error) {
+	uaPublic, err = decodeBase64(sub.P256dh)
+	if err != nil {
+		return nil, nil, nil, ErrInvalidSubscription
+	}
+	x, y = elliptic.Unmarshal(elliptic.P256(), uaPublic)
+	if x == nil {
+		return nil, nil, nil, ErrInvalidSubscription
+	}
+	auth, err := decodeBase64(sub.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, nil, ErrInvalidSubscription
+	}
+	return uaPublic, x, y, nil
+}
+
+// Encrypt encrypts the payload for the subscription, with the aes128gcm
+// content coding.
+func Encrypt(sub *Subscription, payload []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	asPrivate, _, _, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(sub, payload, salt, asPrivate)
+}
+
+func encrypt(sub *Subscription, payload, salt, asPrivate []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	uaPublic, x, y, err := sub.keys()
+	if err != nil {
+		return nil, err
+	}
+	auth, _ := decodeBase64(sub.Auth)
+
+	curve := elliptic.P256()
+	asX, asY := curve.ScalarBaseMult(asPrivate)
+	asPublic := elliptic.Marshal(curve, asX, asY)
+	sx, _ := curve.ScalarMult(x, y, asPrivate)
+	ecdhSecret := scalarBytes(sx)
+
+	// The input keying material is derived from the shared secret and the
+	// authentication secret of the browser.
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ecdhSecret, auth, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single record is used, so the padding delimiter is 0x02
+	plaintext := make([]byte, len(payload)+1)
+	copy(plaintext, payload)
+	plaintext[len(payload)] = 2
+
+	body := make([]byte, headerSize, headerSize+len(plaintext)+gcm.Overhead())
+	copy(body, salt)
+	binary.BigEndian.PutUint32(body[16:], recordSize)
+	body[20] = byte(len(asPublic))
+	copy(body[21:], asPublic)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
+
+// Send encrypts the payload and sends it to the push service of the
+// subscription. ErrSubscriptionGone is returned if the push service has
+// responded that the subscription is no longer valid.
+func Send(client *http.Client, sub *Subscription, payload []byte, opts *Options) error {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(opts.TTL/time.Second)))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", opts.Urgency)
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+	if opts.VAPID != nil {
+		authorization, err := opts.VAPID.authorization(sub.Endpoint, opts.Subject, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case res.StatusCode/100 != 2:
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("webpush: unexpected status code %d: %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// scalarBytes returns the big-endian representation of n on 32 bytes.
+func scalarBytes(n *big.Int) []byte {
+	b := n.Bytes()
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return out
+}
+
+// decodeBase64 decodes a key encoded in base64url (or in standard base64),
+// with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "+/") {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,178 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/hkdf"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+func b64(t *testing.T, s string) []byte {
+	b, err := decodeBase64(s)
+	require.NoError(t, err)
+	return b
+}
+
+// TestEncryptRFC8291 uses the example from the appendix A of RFC 8291.
+func TestEncryptRFC8291(t *testing.T) {
+	sub := &Subscription{
+		Endpoint: "https://push.example.net/push/JzLQ3raZJfFBR0aqvOMsLrt54w4rJUsV",
+		P256dh:   "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:     "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+	salt := b64(t, "DGv6ra1nlYgDCS1FRnbzlw")
+	asPrivate := b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	payload := []byte("When I grow up, I want to be a watermelon")
+	body, err := encrypt(sub, payload, salt, asPrivate)
+	require.NoError(t, err)
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	assert.Equal(t, expected, base64.RawURLEncoding.EncodeToString(body))
+}
+
+func TestInvalidSubscription(t *testing.T) {
+	sub := &Subscription{Endpoint: "https://push.example.net/", P256dh: "foo", Auth: "BTBZMqHH6r4Tts7J_aSIgg"}
+	assert.Equal(t, ErrInvalidSubscription, ValidateSubscription(sub))
+	sub.P256dh = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	sub.Auth = "BTBZ"
+	assert.Equal(t, ErrInvalidSubscription, ValidateSubscription(sub))
+	sub.Auth = "BTBZMqHH6r4Tts7J/aSIgg=="
+	assert.NoError(t, ValidateSubscription(sub))
+
+	_, err := Encrypt(sub, make([]byte, MaxPayloadSize+1))
+	assert.Equal(t, ErrPayloadTooLarge, err)
+}
+
+// browser plays the role of the user agent: it has the keys of the
+// subscription and can decrypt the messages.
+type browser struct {
+	priv []byte
+	pub  []byte
+	auth []byte
+}
+
+func newBrowser(t *testing.T) *browser {
+	priv, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	_, err = io.ReadFull(rand.Reader, auth)
+	require.NoError(t, err)
+	return &browser{priv, elliptic.Marshal(elliptic.P256(), x, y), auth}
+}
+
+func (b *browser) subscription(endpoint string) *Subscription {
+	return &Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(b.pub),
+		Auth:     base64.RawURLEncoding.EncodeToString(b.auth),
+	}
+}
+
+func (b *browser) decrypt(t *testing.T, body []byte) []byte {
+	require.True(t, len(body) > headerSize)
+	salt := body[:16]
+	assert.EqualValues(t, recordSize, binary.BigEndian.Uint32(body[16:20]))
+	idlen := int(body[20])
+	asPublic := body[21 : 21+idlen]
+	ciphertext := body[21+idlen:]
+
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, asPublic)
+	require.NotNil(t, x)
+	sx, _ := curve.ScalarMult(x, y, b.priv)
+	keyInfo := append([]byte("WebPush: info\x00"), b.pub...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, scalarBytes(sx), b.auth, keyInfo), ikm)
+	require.NoError(t, err)
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	require.NoError(t, err)
+	nonce := make([]byte, 12)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	require.NoError(t, err)
+	require.Equal(t, byte(2), plaintext[len(plaintext)-1])
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestSendToPushService(t *testing.T) {
+	keys, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	b := newBrowser(t)
+
+	var received []byte
+	var headers http.Header
+	gone := false
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gone {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		headers = r.Header
+		received, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer stub.Close()
+
+	sub := b.subscription(stub.URL + "/push/abcdef")
+	opts := &Options{
+		VAPID:   keys,
+		Subject: "https://alice.cozy.example/",
+		TTL:     time.Hour,
+		Urgency: "high",
+		Topic:   "disk-quota",
+	}
+	err = Send(stub.Client(), sub, []byte(`{"title":"Hello"}`), opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, `{"title":"Hello"}`, string(b.decrypt(t, received)))
+	assert.Equal(t, "aes128gcm", headers.Get("Content-Encoding"))
+	assert.Equal(t, "3600", headers.Get("TTL"))
+	assert.Equal(t, "high", headers.Get("Urgency"))
+	assert.Equal(t, "disk-quota", headers.Get("Topic"))
+
+	// Check the VAPID authorization
+	authorization := headers.Get("Authorization")
+	require.True(t, strings.HasPrefix(authorization, "vapid t="))
+	parts := strings.SplitN(strings.TrimPrefix(authorization, "vapid t="), ", k=", 2)
+	require.Len(t, parts, 2)
+	assert.Equal(t, keys.PublicKey, parts[1])
+	pub := b64(t, parts[1])
+	x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+	require.NotNil(t, x)
+	token, err := jwt.Parse(parts[0], func(token *jwt.Token) (interface{}, error) {
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ES256", token.Method.Alg())
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, stub.URL, claims["aud"])
+	assert.Equal(t, "https://alice.cozy.example/", claims["sub"])
+
+	gone = true
+	err = Send(stub.Client(), sub, []byte(`{"title":"Hello"}`), opts)
+	assert.Equal(t, ErrSubscriptionGone, err)
+}
@@ -5,6 +5,7 @@ package apps
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -464,6 +465,53 @@ func listKonnectorsHandler(c echo.Context) error {
 	return jsonapi.DataList(c, http.StatusOK, objs, links)
 }
 
+// listRunsHandler returns the last executions of a konnector for an account,
+// with the health computed from them.
+func listRunsHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	slug := c.Param("slug")
+	accountID := c.Param("id")
+	if err := middlewares.AllowTypeAndID(c, permission.GET, consts.Accounts, accountID); err != nil {
+		return err
+	}
+
+	var limit int
+	if l := c.QueryParam("limit"); l != "" {
+		converted, err := strconv.Atoi(l)
+		if err != nil || converted <= 0 {
+			return jsonapi.InvalidParameter("limit", errors.New("Invalid limit"))
+		}
+		limit = converted
+	}
+	runs, err := account.ListRuns(inst, slug, accountID, limit)
+	if err != nil {
+		return err
+	}
+
+	data := make([]json.RawMessage, len(runs))
+	for i, r := range runs {
+		raw, err := jsonapi.MarshalObject(r)
+		if err != nil {
+			return jsonapi.InternalServerError(err)
+		}
+		data[i] = raw
+	}
+	doc := struct {
+		Data []json.RawMessage `json:"data"`
+		Meta struct {
+			Count  int                 `json:"count"`
+			Health *account.RunsHealth `json:"health"`
+		} `json:"meta"`
+	}{Data: data}
+	doc.Meta.Count = len(runs)
+	doc.Meta.Health = account.ComputeHealth(runs)
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, jsonapi.ContentType)
+	resp.WriteHeader(http.StatusOK)
+	return json.NewEncoder(resp).Encode(doc)
+}
+
 func generateLinksList(c echo.Context, next string, limit int, nextID string) *jsonapi.LinksList {
 	links := &jsonapi.LinksList{}
 	if next != "" { // Do not generate the next URL if there are no next konnectors
@@ -532,6 +580,7 @@ func WebappsRoutes(router *echo.Group) {
 	router.DELETE("/:slug", deleteHandler(consts.WebappType))
 	router.GET("/:slug/icon", iconHandler(consts.WebappType))
 	router.GET("/:slug/icon/:version", iconHandler(consts.WebappType))
+	router.Any("/:slug/functions/*", callFunction)
 }
 
 // KonnectorRoutes sets the routing for the konnectors service
@@ -543,6 +592,7 @@ func KonnectorRoutes(router *echo.Group) {
 	router.DELETE("/:slug", deleteHandler(consts.KonnectorType))
 	router.GET("/:slug/icon", iconHandler(consts.KonnectorType))
 	router.GET("/:slug/icon/:version", iconHandler(consts.KonnectorType))
+	router.GET("/:slug/accounts/:id/runs", listRunsHandler)
 }
 
 func wrapAppsError(err error) error {
@@ -565,6 +615,8 @@ func wrapAppsError(err error) error {
 		return jsonapi.BadRequest(err)
 	case apps.ErrLinkedAppExists:
 		return jsonapi.BadRequest(err)
+	case apps.ErrMissingSignature, apps.ErrBadSignature:
+		return jsonapi.Forbidden(err)
 	}
 	if _, ok := err.(*url.Error); ok {
 		return jsonapi.InvalidParameter("Source", err)
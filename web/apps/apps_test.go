@@ -24,6 +24,7 @@ import (
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/intent"
 	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/session"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/assets"
@@ -94,6 +95,16 @@ func installMiniApp() error {
 				Public: true,
 			},
 		},
+		Services: apps.Services{
+			"hooks": &apps.Service{
+				Type: "node",
+				File: "/services/hooks.js",
+				Functions: []*apps.Function{
+					{Path: "/private"},
+					{Path: "/public", Public: true},
+				},
+			},
+		},
 	}
 
 	err := couchdb.CreateNamedDoc(testInstance, manifest)
@@ -531,6 +542,58 @@ func TestUninstallAppWithoutLinkedClient(t *testing.T) {
 	assert.Nil(t, errc)
 }
 
+func doCallFunction(path, appToken string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", ts.URL+"/apps/mini/functions"+path, strings.NewReader("hello"))
+	if err != nil {
+		return nil, err
+	}
+	if appToken != "" {
+		req.Header.Add("Authorization", "Bearer "+appToken)
+	}
+	req.Host = testInstance.Domain
+	return http.DefaultClient.Do(req)
+}
+
+func TestCallFunction(t *testing.T) {
+	_, err := permission.CreateWebappSet(testInstance, slug, permission.Set{}, "1.0.0")
+	assert.NoError(t, err)
+	defer func() { _ = permission.DestroyWebapp(testInstance, slug) }()
+	_, err = permission.CreateWebappSet(testInstance, "other", permission.Set{}, "1.0.0")
+	assert.NoError(t, err)
+	defer func() { _ = permission.DestroyWebapp(testInstance, "other") }()
+	appToken := testInstance.BuildAppToken(slug, "")
+	otherToken := testInstance.BuildAppToken("other", "")
+
+	// No function matches the path
+	res, err := doCallFunction("/unknown", appToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+	res, err = doCallFunction("/privateer", appToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	// A function that is not public needs a token of the application
+	res, err = doCallFunction("/private", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+	res, err = doCallFunction("/private/foo", otherToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, res.StatusCode)
+	res, err = doCallFunction("/private/foo", token)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, res.StatusCode)
+
+	// The number of concurrent requests for an application is limited
+	release := webApps.FillFunctionSlots(testInstance.Domain, slug)
+	res, err = doCallFunction("/private/foo", appToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 429, res.StatusCode)
+	res, err = doCallFunction("/public", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 429, res.StatusCode)
+	release()
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	config.GetConfig().Assets = "../../assets"
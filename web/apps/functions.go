@@ -0,0 +1,251 @@
+package apps
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// functionTimeout is the maximal duration of the execution of a service
+	// for an HTTP request.
+	functionTimeout = 30 * time.Second
+
+	// functionMaxBodySize is the maximal size of the body of a request sent
+	// to a function, as it is given to the service in an env variable.
+	functionMaxBodySize = 64 * 1024
+
+	// maxConcurrentFunctions is the maximal number of requests that a stack
+	// handles at the same time for the functions of an application.
+	maxConcurrentFunctions = 10
+)
+
+// ErrFunctionNotFound is used when no function of the application matches
+// the path of the request.
+var ErrFunctionNotFound = errors.New("Function not found")
+
+// These headers of the request are not given to the service, to avoid
+// leaking the credentials of the user.
+var functionHiddenRequestHeaders = []string{
+	echo.HeaderAuthorization,
+	echo.HeaderCookie,
+}
+
+// These headers of the response are controlled by the stack.
+var functionForbiddenResponseHeaders = []string{
+	echo.HeaderContentLength,
+	echo.HeaderContentSecurityPolicy,
+	echo.HeaderSetCookie,
+	echo.HeaderStrictTransportSecurity,
+	"Connection",
+	"Transfer-Encoding",
+	"Access-Control-",
+}
+
+var functionCalls = struct {
+	sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+func acquireFunctionSlot(key string) bool {
+	functionCalls.Lock()
+	defer functionCalls.Unlock()
+	if functionCalls.counts[key] >= maxConcurrentFunctions {
+		return false
+	}
+	functionCalls.counts[key]++
+	return true
+}
+
+func releaseFunctionSlot(key string) {
+	functionCalls.Lock()
+	defer functionCalls.Unlock()
+	functionCalls.counts[key]--
+	if functionCalls.counts[key] <= 0 {
+		delete(functionCalls.counts, key)
+	}
+}
+
+// callFunction turns the HTTP request into a job for the service that
+// implements the function, and streams its response.
+func callFunction(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	slug := c.Param("slug")
+	man, err := app.GetWebappBySlug(inst, slug)
+	if err != nil {
+		return wrapAppsError(err)
+	}
+	if man.State() != app.Ready {
+		return jsonapi.NotFound(ErrFunctionNotFound)
+	}
+	name, fn := man.FindFunction(c.Param("*"))
+	if fn == nil {
+		return jsonapi.NotFound(ErrFunctionNotFound)
+	}
+	if !fn.Public {
+		if err := checkAppToken(c, slug); err != nil {
+			return err
+		}
+	}
+
+	key := inst.Domain + "/" + slug
+	if !acquireFunctionSlot(key) {
+		return echo.NewHTTPError(http.StatusTooManyRequests)
+	}
+	defer releaseFunctionSlot(key)
+
+	req := c.Request()
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, functionMaxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > functionMaxBodySize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+	}
+	fnReq := &job.FunctionRequest{
+		ID:      utils.RandomString(32),
+		Method:  req.Method,
+		Path:    "/" + strings.TrimPrefix(c.Param("*"), "/"),
+		Query:   req.URL.RawQuery,
+		Headers: functionRequestHeaders(req.Header),
+		Body:    body,
+	}
+
+	responses, stop, err := job.WatchFunctionResponses(inst, fnReq.ID)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	if err := pushFunctionJob(inst, slug, name, fnReq); err != nil {
+		return err
+	}
+	return streamFunctionResponse(c, responses)
+}
+
+// checkAppToken returns an error if the request has not been made with a
+// token of the application.
+func checkAppToken(c echo.Context, slug string) error {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	if pdoc.Type != permission.TypeWebapp || pdoc.SourceID != consts.Apps+"/"+slug {
+		return middlewares.ErrForbidden
+	}
+	return nil
+}
+
+func pushFunctionJob(inst *instance.Instance, slug, name string, fnReq *job.FunctionRequest) error {
+	msg, err := job.NewMessage(map[string]interface{}{
+		"slug":     slug,
+		"name":     name,
+		"function": fnReq,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: "service",
+		Message:    msg,
+		Options: &job.JobOptions{
+			MaxExecCount: 1,
+			Timeout:      functionTimeout,
+		},
+	})
+	return err
+}
+
+func streamFunctionResponse(c echo.Context, responses <-chan *job.FunctionResponse) error {
+	w := c.Response()
+	// A little gap is added to the timeout of the job, for the time spent
+	// in the queue
+	timeout := time.After(functionTimeout + 10*time.Second)
+	started := false
+	for {
+		select {
+		case res, ok := <-responses:
+			if !ok {
+				return nil
+			}
+			if !started {
+				if res.End {
+					return echo.NewHTTPError(http.StatusBadGateway, "The service has not responded")
+				}
+				status := res.Status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				for k, v := range res.Headers {
+					if isAllowedFunctionHeader(k) {
+						w.Header().Set(k, v)
+					}
+				}
+				// The response is served on the domain of the stack: it must
+				// not be able to run scripts with this origin.
+				w.Header().Set(echo.HeaderContentSecurityPolicy, "sandbox")
+				w.Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+				w.WriteHeader(status)
+				started = true
+			}
+			if len(res.Body) > 0 {
+				if _, err := w.Write(res.Body); err != nil {
+					return nil
+				}
+				w.Flush()
+			}
+			if res.End {
+				return nil
+			}
+		case <-timeout:
+			if !started {
+				return echo.NewHTTPError(http.StatusGatewayTimeout)
+			}
+			return nil
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+func functionRequestHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for k, v := range header {
+		if len(v) == 0 {
+			continue
+		}
+		hidden := false
+		for _, h := range functionHiddenRequestHeaders {
+			if strings.EqualFold(k, h) {
+				hidden = true
+			}
+		}
+		if !hidden {
+			headers[k] = v[0]
+		}
+	}
+	return headers
+}
+
+func isAllowedFunctionHeader(header string) bool {
+	header = http.CanonicalHeaderKey(header)
+	for _, h := range functionForbiddenResponseHeaders {
+		if strings.HasPrefix(header, h) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package apps
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// FillFunctionSlots takes all the slots for the functions of the given
+// application, and returns a function to release them.
+func FillFunctionSlots(domain, slug string) func() {
+	key := domain + "/" + slug
+	functionCalls.Lock()
+	functionCalls.counts[key] = maxConcurrentFunctions
+	functionCalls.Unlock()
+	return func() {
+		functionCalls.Lock()
+		delete(functionCalls.counts, key)
+		functionCalls.Unlock()
+	}
+}
+
+func TestFunctionRequestHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer secret")
+	header.Set("Cookie", "cozysessid=secret")
+	header.Set("Content-Type", "application/json")
+	header.Add("X-Foo", "bar")
+	header.Add("X-Foo", "baz")
+	header["authorization"] = []string{"Bearer lowercase"}
+	header["X-Empty"] = []string{}
+
+	headers := functionRequestHeaders(header)
+	assert.Equal(t, map[string]string{
+		"Content-Type": "application/json",
+		"X-Foo":        "bar",
+	}, headers)
+}
+
+func TestIsAllowedFunctionHeader(t *testing.T) {
+	assert.True(t, isAllowedFunctionHeader("Content-Type"))
+	assert.True(t, isAllowedFunctionHeader("cache-control"))
+	assert.True(t, isAllowedFunctionHeader("X-Foo"))
+
+	assert.False(t, isAllowedFunctionHeader("Content-Length"))
+	assert.False(t, isAllowedFunctionHeader("content-security-policy"))
+	assert.False(t, isAllowedFunctionHeader("Set-Cookie"))
+	assert.False(t, isAllowedFunctionHeader("strict-transport-security"))
+	assert.False(t, isAllowedFunctionHeader("Connection"))
+	assert.False(t, isAllowedFunctionHeader("Transfer-Encoding"))
+	assert.False(t, isAllowedFunctionHeader("Access-Control-Allow-Origin"))
+	assert.False(t, isAllowedFunctionHeader("access-control-allow-credentials"))
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/cozy/cozy-stack/model/app"
@@ -128,6 +129,39 @@ func handleIntent(c echo.Context, i *instance.Instance, slug, intentID string) {
 	middlewares.AppendCSPRule(c, "frame-ancestors", from)
 }
 
+// addAppCSP extends the CSP with the extra sources declared in the manifest
+// of the webapp, except the domains denied in the context of the instance.
+func addAppCSP(c echo.Context, i *instance.Instance, webapp *app.WebappManifest) {
+	if len(webapp.CSP) == 0 {
+		return
+	}
+	var denied []string
+	if ctxSettings, ok := i.SettingsContext(); ok {
+		list, _ := ctxSettings["csp_denied_sources"].([]interface{})
+		for _, d := range list {
+			if domain, ok := d.(string); ok {
+				denied = append(denied, domain)
+			}
+		}
+	}
+	sources := webapp.CSP.Sources(denied)
+	directives := make([]string, 0, len(sources))
+	for directive := range sources {
+		directives = append(directives, directive)
+	}
+	// The default-src directive is extended first, so that the directives
+	// created from it also have the extra default sources of the webapp.
+	sort.Slice(directives, func(i, j int) bool {
+		if directives[i] == "default-src" || directives[j] == "default-src" {
+			return directives[i] == "default-src"
+		}
+		return directives[i] < directives[j]
+	})
+	for _, directive := range directives {
+		middlewares.ExtendCSPDirective(c, directive, sources[directive]...)
+	}
+}
+
 // ServeAppFile will serve the requested file using the specified application
 // manifest and appfs.FileServer context.
 //
@@ -145,6 +179,7 @@ func ServeAppFile(c echo.Context, i *instance.Instance, fs appfs.FileServer, web
 	if file == "" {
 		file = route.Index
 	}
+	addAppCSP(c, i, webapp)
 
 	session, isLoggedIn := middlewares.GetSession(c)
 	filepath := path.Join("/", route.Folder, file)
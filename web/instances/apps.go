@@ -0,0 +1,45 @@
+package instances
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/labstack/echo/v4"
+)
+
+func rollbackAppHandler(appType consts.AppType) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		inst, err := lifecycle.GetInstance(c.Param("domain"))
+		if err != nil {
+			return wrapError(err)
+		}
+		man, err := app.GetBySlug(inst, c.Param("slug"), appType)
+		if err != nil {
+			return wrapError(err)
+		}
+		man, err = app.Rollback(inst, man, c.QueryParam("Version"))
+		if err != nil {
+			return wrapError(err)
+		}
+		return c.JSON(http.StatusOK, man)
+	}
+}
+
+func pinAppHandler(appType consts.AppType, pinned bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		inst, err := lifecycle.GetInstance(c.Param("domain"))
+		if err != nil {
+			return wrapError(err)
+		}
+		man, err := app.GetBySlug(inst, c.Param("slug"), appType)
+		if err != nil {
+			return wrapError(err)
+		}
+		if err := app.SetPinned(inst, man, pinned); err != nil {
+			return wrapError(err)
+		}
+		return c.JSON(http.StatusOK, man)
+	}
+}
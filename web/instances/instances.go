@@ -436,6 +436,10 @@ func wrapError(err error) error {
 		return jsonapi.BadRequest(err)
 	case instance.ErrBadTOSVersion:
 		return jsonapi.BadRequest(err)
+	case app.ErrNotFound, app.ErrVersionNotFound:
+		return jsonapi.NotFound(err)
+	case app.ErrBadState:
+		return jsonapi.Conflict(err)
 	}
 	return err
 }
@@ -476,6 +480,7 @@ func Routes(router *echo.Group) {
 	router.GET("/:domain/prefix", showPrefix)
 	router.GET("/:domain/swift-prefix", getSwiftBucketName)
 	router.POST("/:domain/auth-mode", setAuthMode)
+	router.POST("/:domain/permissions/check", checkPermissions)
 
 	// Config
 	router.POST("/redis", rebuildRedis)
@@ -485,6 +490,14 @@ func Routes(router *echo.Group) {
 	router.GET("/contexts", lsContexts)
 	router.GET("/with-app-version/:slug/:version", appVersion)
 
+	// Versions of the applications
+	router.POST("/:domain/apps/:slug/rollback", rollbackAppHandler(consts.WebappType))
+	router.PUT("/:domain/apps/:slug/pin", pinAppHandler(consts.WebappType, true))
+	router.DELETE("/:domain/apps/:slug/pin", pinAppHandler(consts.WebappType, false))
+	router.POST("/:domain/konnectors/:slug/rollback", rollbackAppHandler(consts.KonnectorType))
+	router.PUT("/:domain/konnectors/:slug/pin", pinAppHandler(consts.KonnectorType, true))
+	router.DELETE("/:domain/konnectors/:slug/pin", pinAppHandler(consts.KonnectorType, false))
+
 	// Fixers
 	router.POST("/:domain/fixers/content-mismatch", contentMismatchFixer)
 	router.POST("/:domain/fixers/orphan-account", orphanAccountFixer)
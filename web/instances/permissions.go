@@ -0,0 +1,35 @@
+package instances
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/permissions"
+	"github.com/labstack/echo/v4"
+)
+
+// checkPermissions explains why a permission document or a token gives
+// access, or not, to a document or a file.
+func checkPermissions(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	var req permissions.CheckRequest
+	if err = json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return jsonapi.BadJSON()
+	}
+	pdoc, err := permissions.FindPermissionForCheck(c, inst, &req)
+	if err != nil {
+		return err
+	}
+	res, err := permissions.Check(inst, pdoc, &req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
@@ -2,6 +2,7 @@ package jobs
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -32,6 +33,7 @@ import (
 	_ "github.com/cozy/cozy-stack/worker/migrations"
 	_ "github.com/cozy/cozy-stack/worker/move"
 	_ "github.com/cozy/cozy-stack/worker/notes"
+	_ "github.com/cozy/cozy-stack/worker/notifications"
 	_ "github.com/cozy/cozy-stack/worker/push"
 	_ "github.com/cozy/cozy-stack/worker/share"
 	_ "github.com/cozy/cozy-stack/worker/thumbnail"
@@ -462,6 +464,31 @@ func getJob(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusOK, apiJob{j}, nil)
 }
 
+// sendJobInput sends the input of the user to a running job, like a 2FA code
+// asked by a konnector. The attributes of the JSON-API document are the
+// input.
+func sendJobInput(c echo.Context) error {
+	instance := middlewares.GetInstance(c)
+	j, err := job.Get(instance, c.Param("job-id"))
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.POST, j); err != nil {
+		return err
+	}
+	var input json.RawMessage
+	if _, err := jsonapi.Bind(c.Request().Body, &input); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if len(input) == 0 {
+		return jsonapi.BadRequest(errors.New("The input is missing"))
+	}
+	if err := job.SendInput(instance, j, input); err != nil {
+		return wrapJobsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func cleanJobs(c echo.Context) error {
 	instance := middlewares.GetInstance(c)
 	if err := middlewares.AllowWholeType(c, permission.POST, consts.Jobs); err != nil {
@@ -611,6 +638,7 @@ func Routes(router *echo.Group) {
 	router.POST("/clean", cleanJobs)
 	router.DELETE("/purge", purgeJobs)
 	router.GET("/:job-id", getJob)
+	router.POST("/:job-id/input", sendJobInput)
 }
 
 func wrapJobsError(err error) error {
@@ -621,6 +649,8 @@ func wrapJobsError(err error) error {
 		return jsonapi.NotFound(err)
 	case job.ErrUnknownTrigger:
 		return jsonapi.InvalidAttribute("Type", err)
+	case job.ErrJobNotRunning:
+		return jsonapi.Conflict(err)
 	case limits.ErrRateLimitReached,
 		limits.ErrRateLimitExceeded:
 		return jsonapi.BadRequest(err)
@@ -0,0 +1,283 @@
+// Package localregistry exposes the registry served by the stack: the
+// querying API on its own domain, and the publication API on the admin
+// server.
+package localregistry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/localregistry"
+	"github.com/cozy/cozy-stack/web/errors"
+	"github.com/labstack/echo/v4"
+)
+
+const defaultLimit = 100
+
+// IsRegistryHost returns true if the given host is the domain of the local
+// registry.
+func IsRegistryHost(host string) bool {
+	h := config.GetConfig().LocalRegistry.Host
+	return h != "" && h == host
+}
+
+var server struct {
+	once sync.Once
+	e    *echo.Echo
+}
+
+// Serve handles the requests made on the domain of the local registry.
+func Serve(c echo.Context) error {
+	server.once.Do(func() {
+		e := echo.New()
+		e.HideBanner = true
+		e.HidePort = true
+		e.HTTPErrorHandler = errors.ErrorHandler
+		e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if c.Request().Method != http.MethodGet && c.Request().Method != http.MethodHead {
+					return jsonapi.MethodNotAllowed(c.Request().Method)
+				}
+				return next(c)
+			}
+		})
+		QueryRoutes(e.Group("/registry"))
+		server.e = e
+	})
+	server.e.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func listApps(c echo.Context) error {
+	apps, err := localregistry.ListApplications()
+	if err != nil {
+		return wrapError(err)
+	}
+
+	filtered := apps[:0]
+	for _, app := range apps {
+		if t := c.QueryParam("filter[type]"); t != "" && t != app.Type {
+			continue
+		}
+		if e := c.QueryParam("filter[editor]"); e != "" && e != app.Editor {
+			continue
+		}
+		filtered = append(filtered, app)
+	}
+	apps = filtered
+
+	sortBy := c.QueryParam("sort")
+	reverse := len(sortBy) > 0 && sortBy[0] == '-'
+	if reverse {
+		sortBy = sortBy[1:]
+	}
+	sort.SliceStable(apps, func(i, j int) bool {
+		a, b := apps[i], apps[j]
+		if reverse {
+			a, b = b, a
+		}
+		switch sortBy {
+		case "type":
+			return a.Type < b.Type
+		case "editor":
+			return a.Editor < b.Editor
+		}
+		return a.Slug < b.Slug
+	})
+
+	cursor, _ := strconv.Atoi(c.QueryParam("cursor"))
+	if cursor < 0 || cursor > len(apps) {
+		cursor = len(apps)
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	end := cursor + limit
+	nextCursor := strconv.Itoa(end)
+	if end >= len(apps) {
+		end = len(apps)
+		nextCursor = ""
+	}
+	apps = apps[cursor:end]
+
+	versionsChannel := c.QueryParam("versionsChannel")
+	latestChannel := c.QueryParam("latestChannelVersion")
+	for _, app := range apps {
+		filterVersions(app, versionsChannel)
+		if latestChannel != "" {
+			app.LatestVersion, err = localregistry.GetLatestVersion(app.Slug, latestChannel)
+			if err != nil && err != localregistry.ErrVersionNotFound {
+				return wrapError(err)
+			}
+		}
+		setTarballURL(c, app.LatestVersion)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"data": apps,
+		"meta": echo.Map{
+			"count":       len(apps),
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// filterVersions removes the versions of the channels less stable than the
+// given one.
+func filterVersions(app *localregistry.Application, channel string) {
+	switch channel {
+	case "stable":
+		app.Versions["beta"] = []string{}
+		app.Versions["dev"] = []string{}
+	case "beta":
+		app.Versions["dev"] = []string{}
+	}
+}
+
+func listMaintenance(c echo.Context) error {
+	apps, err := localregistry.ListApplications()
+	if err != nil {
+		return wrapError(err)
+	}
+	list := make([]*localregistry.Application, 0)
+	for _, app := range apps {
+		if app.MaintenanceActivated {
+			setTarballURL(c, app.LatestVersion)
+			list = append(list, app)
+		}
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+func getApp(c echo.Context) error {
+	app, err := localregistry.GetApplication(c.Param("app"))
+	if err != nil {
+		return wrapError(err)
+	}
+	setTarballURL(c, app.LatestVersion)
+	return c.JSON(http.StatusOK, app)
+}
+
+func getVersion(c echo.Context) error {
+	v, err := localregistry.GetVersion(c.Param("app"), c.Param("version"))
+	if err != nil {
+		return wrapError(err)
+	}
+	setTarballURL(c, v)
+	return c.JSON(http.StatusOK, v)
+}
+
+func getLatestVersion(c echo.Context) error {
+	// The parameter is named version to share the node of the router with
+	// the other routes, but it is the channel here.
+	v, err := localregistry.GetLatestVersion(c.Param("app"), c.Param("version"))
+	if err != nil {
+		return wrapError(err)
+	}
+	setTarballURL(c, v)
+	return c.JSON(http.StatusOK, v)
+}
+
+func getTarball(c echo.Context) error {
+	f, err := localregistry.OpenTarball(c.Param("app"), c.Param("version"))
+	if err != nil {
+		return wrapError(err)
+	}
+	defer f.Close()
+	c.Response().Header().Set("Cache-Control", "max-age=31536000, immutable")
+	return c.Stream(http.StatusOK, "application/octet-stream", f)
+}
+
+// setTarballURL fills the URL of the version with the address of the tarball
+// on the domain used for the request.
+func setTarballURL(c echo.Context, v *localregistry.Version) {
+	if v == nil {
+		return
+	}
+	u := url.URL{
+		Scheme: c.Scheme(),
+		Host:   c.Request().Host,
+		Path:   "/registry/" + v.Slug + "/" + v.Version.Version + "/tarball",
+	}
+	v.URL = u.String()
+}
+
+func publishVersion(c echo.Context) error {
+	v, err := localregistry.Publish(c.Param("app"), c.Request().Body, &localregistry.PublishOptions{
+		Version:   c.QueryParam("Version"),
+		Signature: c.QueryParam("Signature"),
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusCreated, v)
+}
+
+func activateMaintenance(c echo.Context) error {
+	var options *json.RawMessage
+	if c.Request().ContentLength != 0 {
+		var raw json.RawMessage
+		if err := json.NewDecoder(c.Request().Body).Decode(&raw); err != nil {
+			return jsonapi.BadJSON()
+		}
+		options = &raw
+	}
+	if err := localregistry.ActivateMaintenance(c.Param("app"), options); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func deactivateMaintenance(c echo.Context) error {
+	if err := localregistry.DeactivateMaintenance(c.Param("app")); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func wrapError(err error) error {
+	switch err {
+	case localregistry.ErrDisabled:
+		return jsonapi.NewError(http.StatusServiceUnavailable, err.Error())
+	case localregistry.ErrAppNotFound, localregistry.ErrVersionNotFound:
+		return jsonapi.NotFound(err)
+	case localregistry.ErrVersionExists:
+		return jsonapi.Conflict(err)
+	case localregistry.ErrInvalidSlug:
+		return jsonapi.InvalidParameter("slug", err)
+	case localregistry.ErrInvalidVersion:
+		return jsonapi.InvalidParameter("version", err)
+	case localregistry.ErrInvalidChannel:
+		return jsonapi.InvalidParameter("channel", err)
+	case localregistry.ErrInvalidTarball, localregistry.ErrNoManifest, localregistry.ErrBadManifest:
+		return jsonapi.BadRequest(err)
+	}
+	return err
+}
+
+// QueryRoutes sets the routing for querying the registry, like the other
+// registries.
+func QueryRoutes(router *echo.Group) {
+	router.GET("", listApps)
+	router.GET("/", listApps)
+	router.GET("/maintenance", listMaintenance)
+	router.GET("/:app", getApp)
+	router.GET("/:app/", getApp)
+	router.GET("/:app/:version", getVersion)
+	router.GET("/:app/:version/latest", getLatestVersion)
+	router.GET("/:app/:version/tarball", getTarball)
+}
+
+// AdminRoutes sets the routing for publishing on the registry.
+func AdminRoutes(router *echo.Group) {
+	router.POST("/:app", publishVersion)
+	router.PUT("/:app/maintenance", activateMaintenance)
+	router.DELETE("/:app/maintenance", deactivateMaintenance)
+}
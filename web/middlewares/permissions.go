@@ -4,9 +4,11 @@ import (
 	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
@@ -15,6 +17,7 @@ import (
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/crypto"
@@ -105,6 +108,23 @@ func GetForOauth(instance *instance.Instance, claims *permission.Claims, c inter
 		}
 	}
 
+	// The read-once rules already read by the client are marked as such
+	if client, ok := c.(*oauth.Client); ok && len(client.ConsumedScopes) > 0 {
+		for i, r := range set {
+			if r.Conditions == nil || !r.Conditions.ReadOnce {
+				continue
+			}
+			scope, err := r.MarshalScopeString()
+			if err != nil {
+				continue
+			}
+			if at, ok := client.ConsumedScopeAt(scope); ok {
+				set[i].Conditions = r.Conditions.Clone()
+				set[i].Conditions.ReadAt = &at
+			}
+		}
+	}
+
 	pdoc := &permission.Permission{
 		Type:        permission.TypeOauth,
 		Permissions: set,
@@ -223,6 +243,29 @@ func ParseJWT(c echo.Context, instance *instance.Instance, token string) (*permi
 			}
 		}
 
+		// The same goes for the guests, and a read-only guest can only read
+		// the shared documents
+		if pdoc.Type == permission.TypeShareGuest {
+			sharingID := strings.Split(pdoc.SourceID, "/")
+			sharingDoc, err := sharing.FindSharing(instance, sharingID[1])
+			if err != nil {
+				return nil, err
+			}
+
+			member, err := sharingDoc.FindGuestBySharecode(instance, token)
+			if err != nil {
+				return nil, permission.ErrInvalidToken
+			}
+
+			if member.ReadOnly {
+				pdoc = pdoc.Clone().(*permission.Permission)
+				getVerb := permission.Verbs(permission.GET)
+				for i := range pdoc.Permissions {
+					pdoc.Permissions[i].Verbs = getVerb
+				}
+			}
+		}
+
 		return pdoc, nil
 
 	default:
@@ -254,10 +297,64 @@ func GetPermission(c echo.Context) (*permission.Permission, error) {
 		return nil, err
 	}
 
+	pdoc = RestrictByConditions(c, pdoc)
 	c.Set(contextPermissionDoc, pdoc)
 	return pdoc, nil
 }
 
+// RestrictByConditions returns the permission document with only the rules
+// that can be used for the current request: the rules with a time window or
+// some IP ranges not matching the request, and the read-once rules that have
+// already been read, are removed. The read-once rules that are kept will be
+// marked as read by the first permission check that uses them.
+func RestrictByConditions(c echo.Context, pdoc *permission.Permission) *permission.Permission {
+	if !pdoc.Permissions.HasConditions() {
+		return pdoc
+	}
+	now := time.Now()
+	ip := net.ParseIP(config.ClientIP(c.Request()))
+
+	restricted := pdoc.Clone().(*permission.Permission)
+	restricted.Permissions = restricted.Permissions[:0]
+	for _, r := range pdoc.Permissions {
+		if r.Conditions != nil {
+			if !r.Conditions.Fulfilled(now, ip) || r.Conditions.Consumed() {
+				continue
+			}
+			if r.Conditions.ReadOnce {
+				rule := r
+				rule.Conditions = r.Conditions.Clone()
+				rule.Conditions.OnRead(func() error {
+					err := consumeReadOnce(c, pdoc, rule)
+					if err != nil {
+						GetInstance(c).Logger().WithField("nspace", "permissions").
+							Warnf("Cannot consume the read-once rule %s: %s", rule.Title, err)
+					}
+					return err
+				})
+				r = rule
+			}
+		}
+		restricted.Permissions = append(restricted.Permissions, r)
+	}
+	return restricted
+}
+
+func consumeReadOnce(c echo.Context, pdoc *permission.Permission, r permission.Rule) error {
+	inst := GetInstance(c)
+	if pdoc.ID() != "" {
+		return permission.ConsumeReadOnce(inst, pdoc.ID(), r.Title)
+	}
+	if client, ok := pdoc.Client.(*oauth.Client); ok {
+		scope, err := r.MarshalScopeString()
+		if err != nil {
+			return err
+		}
+		return client.ConsumeScope(inst, scope)
+	}
+	return nil
+}
+
 // AllowWholeType validates that the context permission set can use a verb on
 // the whold doctype
 func AllowWholeType(c echo.Context, v permission.Verb, doctype string) error {
@@ -315,8 +412,8 @@ func AllowVFS(c echo.Context, v permission.Verb, o vfs.Fetcher) error {
 	if err != nil {
 		return err
 	}
-	err = vfs.Allows(instance.VFS(), pdoc.Permissions, v, o)
-	if err != nil {
+	fs := instance.VFS()
+	if err := vfs.Allows(fs, pdoc.Permissions, v, o); err != nil {
 		return ErrForbidden
 	}
 	return nil
@@ -277,3 +277,50 @@ func appendCSPRule(currentRules, ruleType string, appendedValues ...string) (new
 	}
 	return
 }
+
+// ExtendCSPDirective adds sources to a directive of the CSP header. If the
+// directive is not in the header, it is created with the sources of the
+// default-src directive, as they would no longer apply to this directive. If
+// there is no default-src directive either, nothing is restricted for this
+// directive and the header is left unchanged. The default-src directive
+// should be extended before the other directives.
+func ExtendCSPDirective(c echo.Context, directive string, sources ...string) {
+	h := c.Response().Header()
+	currentRules := h.Get(echo.HeaderContentSecurityPolicy)
+	if currentRules == "" || len(sources) == 0 {
+		return
+	}
+	h.Set(echo.HeaderContentSecurityPolicy, extendCSPDirective(currentRules, directive, sources...))
+}
+
+func extendCSPDirective(currentRules, directive string, sources ...string) string {
+	rules := strings.Split(currentRules, ";")
+	var defaults []string
+	for i, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case directive:
+			rules[i] = strings.Join(append(fields, sources...), " ")
+			return strings.Join(rules, ";")
+		case "default-src":
+			defaults = fields[1:]
+		}
+	}
+	if defaults == nil {
+		return currentRules
+	}
+	fields := []string{directive}
+	for _, src := range defaults {
+		if src != "'none'" {
+			fields = append(fields, src)
+		}
+	}
+	fields = append(fields, sources...)
+	if !strings.HasSuffix(strings.TrimSpace(currentRules), ";") {
+		currentRules += ";"
+	}
+	return currentRules + strings.Join(fields, " ") + ";"
+}
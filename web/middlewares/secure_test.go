@@ -83,3 +83,20 @@ func TestAppendCSPRule(t *testing.T) {
 	r = appendCSPRule("script '*'; toto;", "frame-ancestors", "new-rule")
 	assert.Equal(t, "script '*'; toto;frame-ancestors new-rule;", r)
 }
+
+func TestExtendCSPDirective(t *testing.T) {
+	r := extendCSPDirective("default-src 'self';img-src data:;", "img-src", "https://tiles.example.org/")
+	assert.Equal(t, "default-src 'self';img-src data: https://tiles.example.org/;", r)
+
+	r = extendCSPDirective("default-src 'self' https://cozy.local;img-src data:;", "connect-src", "https://api.example.org/")
+	assert.Equal(t, "default-src 'self' https://cozy.local;img-src data:;connect-src 'self' https://cozy.local https://api.example.org/;", r)
+
+	r = extendCSPDirective("img-src data:;", "connect-src", "https://api.example.org/")
+	assert.Equal(t, "img-src data:;", r)
+
+	r = extendCSPDirective("default-src 'none';img-src data:", "font-src", "https://fonts.example.org/")
+	assert.Equal(t, "default-src 'none';img-src data:;font-src https://fonts.example.org/;", r)
+
+	r = extendCSPDirective("Default-Src 'self';", "default-src", "https://api.example.org/")
+	assert.Equal(t, "Default-Src 'self' https://api.example.org/;", r)
+}
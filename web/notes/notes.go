@@ -4,18 +4,26 @@
 package notes
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/utils"
 	"github.com/cozy/cozy-stack/web/files"
 	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/cozy/cozy-stack/worker/thumbnail"
 	"github.com/labstack/echo/v4"
 )
 
@@ -41,6 +49,58 @@ func CreateNote(c echo.Context) error {
 	return files.FileData(c, http.StatusCreated, file, false, nil)
 }
 
+// ImportNote is the API handler for POST /notes/import. It creates a note
+// from a Markdown or HTML content given in the body of the request.
+func ImportNote(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.Files); err != nil {
+		return err
+	}
+
+	format := note.FormatFromMime(c.Request().Header.Get(echo.HeaderContentType), c.QueryParam("Name"))
+	if format == "" {
+		return wrapError(note.ErrUnsupportedFormat)
+	}
+
+	doc := &note.Document{
+		Title:     c.QueryParam("Title"),
+		DirID:     c.QueryParam("DirID"),
+		CreatedBy: getCreatedBy(c),
+	}
+	inst := middlewares.GetInstance(c)
+	file, err := note.Import(inst, doc, format, c.Request().Body)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return files.FileData(c, http.StatusCreated, file, false, nil)
+}
+
+// ConvertFile is the API handler for POST /notes/:id/convert. It converts a
+// Markdown or HTML file to a note. The file keeps its identifier, its
+// directory and its references.
+func ConvertFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	doc := &note.Document{Title: c.QueryParam("Title")}
+	if file, err = note.ImportFile(inst, file, doc); err != nil {
+		return wrapError(err)
+	}
+
+	return files.FileData(c, http.StatusOK, file, false, nil)
+}
+
 // ListNotes is the API handler for GET /notes. It returns the list of the
 // notes.
 func ListNotes(c echo.Context) error {
@@ -93,6 +153,113 @@ func GetNote(c echo.Context) error {
 	return files.FileData(c, http.StatusOK, file, false, nil)
 }
 
+// ExportNote is the API handler for GET /notes/:id/export?format=xxx. It
+// renders the note as an HTML page, a PDF document, or a DOCX document, with
+// the theme of the instance.
+func ExportNote(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = note.ExportHTML
+	}
+	mime := note.ExportMime(format)
+	if mime == "" {
+		return jsonapi.InvalidParameter("format", note.ErrUnsupportedFormat)
+	}
+
+	var buf bytes.Buffer
+	if err := note.Export(inst, file, format, &buf); err != nil {
+		return wrapError(err)
+	}
+
+	filename := note.ExportFilename(file, format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, vfs.ContentDisposition("attachment", filename))
+	return c.Blob(http.StatusOK, mime, buf.Bytes())
+}
+
+// UploadImage is the API handler for POST /notes/:id/images. It stores an
+// image that can be embedded in the note.
+func UploadImage(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	img, err := note.UploadImage(inst, file, c.QueryParam("Name"), contentType, c.Request().Body)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusCreated, img, nil)
+}
+
+// GetImage is the API handler for GET /notes/:id/images/:name/:format. It
+// serves an image of the note, in its original size or resized by the
+// thumbnail pipeline.
+func GetImage(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	format := c.Param("format")
+	if format != "original" && !utils.IsInArray(format, thumbnail.FormatsNames) {
+		return jsonapi.InvalidParameter("format", errors.New("Invalid format"))
+	}
+
+	name := c.Param("name")
+	img, err := note.GetImage(inst, file.ID(), name)
+	if err == os.ErrNotExist {
+		// The image may have been added to a shared note by another member
+		img, err = sharing.FetchNoteImage(inst, file.ID(), name)
+	}
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if format != "original" {
+		fs := lifecycle.ThumbsFS(inst)
+		if err := fs.ServeThumbContent(c.Response(), c.Request(), img, format); err == nil {
+			return nil
+		}
+		// The thumbnail may have not been generated yet, or the image format
+		// is not supported by the thumbnail pipeline
+	}
+	// The images that are not raster images (like SVG) can embed scripts:
+	// they are sandboxed if opened directly
+	header := c.Response().Header()
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	disposition := "inline"
+	if !note.IsRasterImage(img.Mime) {
+		header.Set(echo.HeaderContentSecurityPolicy, "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+		disposition = "attachment"
+	}
+	return vfs.ServeFileContent(inst.VFS(), img, nil, "", disposition, c.Request(), c.Response())
+}
+
 // GetSteps is the API handler for GET /notes/:id/steps?Version=xxx. It returns
 // the steps since the given version. If the version is too old, and the steps
 // are no longer available, it returns a 412 response with the whole document
@@ -245,16 +412,320 @@ func ForceNoteSync(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// ListSnapshots is the API handler for GET /notes/:id/history. It returns the
+// snapshots of the note, from the most recent to the oldest.
+func ListSnapshots(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	snapshots, err := note.ListSnapshots(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(snapshots))
+	for i, snapshot := range snapshots {
+		objs[i] = snapshot
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// CreateSnapshot is the API handler for POST /notes/:id/history. It takes a
+// named snapshot of the current version of the note.
+func CreateSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	attrs := note.Event{}
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+
+	name, _ := attrs["name"].(string)
+	snapshot, err := note.CreateSnapshot(inst, file, name)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusCreated, snapshot, nil)
+}
+
+// GetSnapshot is the API handler for GET /notes/:id/history/:snapshot-id. It
+// returns the snapshot with its content.
+func GetSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	snapshot, err := note.GetSnapshot(inst, file, c.Param("snapshot-id"))
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusOK, snapshot, nil)
+}
+
+// DiffSnapshots is the API handler for GET /notes/:id/history/diff. It
+// returns the changes between two versions of the note.
+func DiffSnapshots(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	from := c.QueryParam("from")
+	if from == "" {
+		return jsonapi.InvalidParameter("from", errors.New("Missing snapshot"))
+	}
+	to := c.QueryParam("to")
+	if to == "" {
+		to = note.CurrentVersion
+	}
+	diff, err := note.DiffSnapshots(inst, file, from, to)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusOK, diff, nil)
+}
+
+// RestoreSnapshot is the API handler for POST
+// /notes/:id/history/:snapshot-id/restore. It replaces the content of the
+// note with the content of the snapshot.
+func RestoreSnapshot(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PATCH, file); err != nil {
+		return err
+	}
+
+	sessID := c.QueryParam("SessionID")
+	file, err = note.RestoreSnapshot(inst, file, c.Param("snapshot-id"), sessID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return files.FileData(c, http.StatusOK, file, false, nil)
+}
+
+// ListComments is the API handler for GET /notes/:id/comments. It returns the
+// comments on the note, sorted by creation date.
+func ListComments(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.GET, file); err != nil {
+		return err
+	}
+
+	comments, err := note.ListComments(inst, file)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	objs := make([]jsonapi.Object, len(comments))
+	for i, comment := range comments {
+		objs[i] = comment
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// CreateThread is the API handler for POST /notes/:id/comments. It starts a
+// new thread of comments on a range of the note.
+func CreateThread(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	comment := &note.Comment{}
+	if _, err := jsonapi.Bind(c.Request().Body, comment); err != nil {
+		return err
+	}
+	comment.Author = commentAuthor(c, inst)
+	if comment, err = note.CreateThread(inst, file, comment); err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusCreated, comment, nil)
+}
+
+// ReplyToThread is the API handler for POST
+// /notes/:id/comments/:comment-id/replies. It adds a comment to a thread.
+func ReplyToThread(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	comment := &note.Comment{}
+	if _, err := jsonapi.Bind(c.Request().Body, comment); err != nil {
+		return err
+	}
+	comment.Author = commentAuthor(c, inst)
+	if comment, err = note.Reply(inst, file, c.Param("comment-id"), comment); err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusCreated, comment, nil)
+}
+
+// UpdateComment is the API handler for PATCH /notes/:id/comments/:comment-id.
+// It changes the body and the mentions of a comment.
+func UpdateComment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	patch := &note.Comment{}
+	if _, err := jsonapi.Bind(c.Request().Body, patch); err != nil {
+		return err
+	}
+	comment, err := note.UpdateComment(inst, file, c.Param("comment-id"), patch)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusOK, comment, nil)
+}
+
+// ResolveThread is the API handler for POST
+// /notes/:id/comments/:comment-id/resolve. It marks a thread as resolved.
+func ResolveThread(c echo.Context) error {
+	return setThreadState(c, note.CommentResolved)
+}
+
+// ReopenThread is the API handler for POST
+// /notes/:id/comments/:comment-id/reopen. It reopens a resolved thread.
+func ReopenThread(c echo.Context) error {
+	return setThreadState(c, note.CommentOpen)
+}
+
+func setThreadState(c echo.Context, state string) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	thread, err := note.SetThreadState(inst, file, c.Param("comment-id"), state)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	return jsonapi.Data(c, http.StatusOK, thread, nil)
+}
+
+// DeleteComment is the API handler for DELETE
+// /notes/:id/comments/:comment-id. It removes a comment, or a whole thread.
+func DeleteComment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fileID := c.Param("id")
+	file, err := inst.VFS().FileByID(fileID)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	if err := middlewares.AllowVFS(c, permission.PUT, file); err != nil {
+		return err
+	}
+
+	if err := note.DeleteComment(inst, file, c.Param("comment-id")); err != nil {
+		return wrapError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 // Routes sets the routing for the collaborative edition of notes.
 func Routes(router *echo.Group) {
 	router.POST("", CreateNote)
+	router.POST("/import", ImportNote)
 	router.GET("", ListNotes)
 	router.GET("/:id", GetNote)
 	router.GET("/:id/steps", GetSteps)
+	router.GET("/:id/export", ExportNote)
+	router.POST("/:id/images", UploadImage)
+	router.GET("/:id/images/:name/:format", GetImage)
 	router.PATCH("/:id", PatchNote)
 	router.PUT("/:id/title", ChangeTitle)
 	router.PUT("/:id/telepointer", PutTelepointer)
 	router.POST("/:id/sync", ForceNoteSync)
+	router.POST("/:id/convert", ConvertFile)
+	router.GET("/:id/history", ListSnapshots)
+	router.POST("/:id/history", CreateSnapshot)
+	router.GET("/:id/history/diff", DiffSnapshots)
+	router.GET("/:id/history/:snapshot-id", GetSnapshot)
+	router.POST("/:id/history/:snapshot-id/restore", RestoreSnapshot)
+	router.GET("/:id/comments", ListComments)
+	router.POST("/:id/comments", CreateThread)
+	router.POST("/:id/comments/:comment-id/replies", ReplyToThread)
+	router.PATCH("/:id/comments/:comment-id", UpdateComment)
+	router.POST("/:id/comments/:comment-id/resolve", ResolveThread)
+	router.POST("/:id/comments/:comment-id/reopen", ReopenThread)
+	router.DELETE("/:id/comments/:comment-id", DeleteComment)
 }
 
 func wrapError(err error) *jsonapi.Error {
@@ -265,8 +736,24 @@ func wrapError(err error) *jsonapi.Error {
 		return jsonapi.NotFound(err)
 	case note.ErrNoSteps, note.ErrInvalidSteps:
 		return jsonapi.BadRequest(err)
-	case note.ErrCannotApply:
+	case note.ErrCannotApply, note.ErrAlreadyANote:
 		return jsonapi.Conflict(err)
+	case note.ErrUnsupportedFormat:
+		return jsonapi.Errorf(http.StatusUnsupportedMediaType, "%s", err)
+	case note.ErrInvalidContent, note.ErrInvalidImage:
+		return jsonapi.BadRequest(err)
+	case sharing.ErrImageNotFound, note.ErrSnapshotNotFound, note.ErrCommentNotFound:
+		return jsonapi.NotFound(err)
+	case note.ErrEmptyComment:
+		return jsonapi.InvalidAttribute("body", err)
+	case note.ErrInvalidAnchor:
+		return jsonapi.InvalidAttribute("anchor", err)
+	case note.ErrInvalidCommentState:
+		return jsonapi.InvalidAttribute("state", err)
+	case note.ErrTooOld:
+		return jsonapi.PreconditionFailed("anchor", err)
+	case note.ErrMissingSnapshotName:
+		return jsonapi.InvalidAttribute("name", err)
 	case note.ErrInvalidSchema:
 		return jsonapi.InvalidAttribute("id", err)
 	case os.ErrNotExist, vfs.ErrParentDoesNotExist, vfs.ErrParentInTrash:
@@ -286,3 +773,33 @@ func getCreatedBy(c echo.Context) string {
 	}
 	return ""
 }
+
+// commentAuthor returns the name of the author of a comment, from the
+// permission used for the request: the name of the member for a sharing, and
+// the public name of the owner of the instance otherwise.
+func commentAuthor(c echo.Context, inst *instance.Instance) string {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return ""
+	}
+	switch pdoc.Type {
+	case permission.TypeSharePreview, permission.TypeShareGuest:
+		parts := strings.SplitN(pdoc.SourceID, "/", 2)
+		if len(parts) != 2 {
+			return ""
+		}
+		s, err := sharing.FindSharing(inst, parts[1])
+		if err != nil {
+			return ""
+		}
+		member, err := s.FindMemberBySharecode(inst, middlewares.GetRequestToken(c))
+		if err != nil {
+			return ""
+		}
+		return member.PrimaryName()
+	case permission.TypeShareByLink:
+		return inst.Translate("Notes Comment Anonymous Author")
+	}
+	name, _ := inst.PublicName()
+	return name
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -11,11 +13,14 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/realtime"
 	"github.com/cozy/cozy-stack/tests/testutils"
 	"github.com/cozy/cozy-stack/web/errors"
@@ -443,6 +448,277 @@ func TestNoteMarkdown(t *testing.T) {
 	assert.Equal(t, "Hello world", string(buf))
 }
 
+func TestExportNote(t *testing.T) {
+	req, _ := http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/export?format=html", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/html"))
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Disposition"), "attachment"))
+	assert.Contains(t, res.Header.Get("Content-Disposition"), ".html")
+	buf, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), "<p>Hello world</p>")
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/export?format=pdf", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "application/pdf", res.Header.Get("Content-Type"))
+	buf, err = ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf, []byte("%PDF-")))
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/export?format=odt", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+}
+
+func TestNoteImages(t *testing.T) {
+	var square bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	assert.NoError(t, png.Encode(&square, img))
+
+	req, _ := http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/images?Name=square.png", bytes.NewReader(square.Bytes()))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "image/png")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	assert.Equal(t, "io.cozy.notes.images", data["type"])
+	attrs, _ := data["attributes"].(map[string]interface{})
+	assert.Equal(t, "image/png", attrs["mime"])
+	assert.EqualValues(t, 8, attrs["width"])
+	assert.EqualValues(t, 8, attrs["height"])
+	name, _ := attrs["name"].(string)
+	assert.True(t, strings.HasSuffix(name, ".png"))
+	assert.Equal(t, "images/"+name, attrs["src"])
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/images/"+name+"/original", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+	buf, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, square.Bytes(), buf)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/images/"+name+"/huge", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/images/unknown.png/original", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/images?Name=fake.png", strings.NewReader("not an image"))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "image/png")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+}
+
+func TestNoteHistory(t *testing.T) {
+	body := `{"data": {"type": "io.cozy.notes.snapshots", "attributes": {"name": "First draft"}}}`
+	req, _ := http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/history", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	assert.Equal(t, "io.cozy.notes.snapshots", data["type"])
+	snapshotID, _ := data["id"].(string)
+	assert.True(t, strings.HasPrefix(snapshotID, noteID+"-"))
+	attrs, _ := data["attributes"].(map[string]interface{})
+	assert.Equal(t, "named", attrs["kind"])
+	assert.Equal(t, "First draft", attrs["name"])
+
+	body = `{"data": {"type": "io.cozy.notes.snapshots", "attributes": {"name": ""}}}`
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/history", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 422, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/history", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var list map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&list)
+	assert.NoError(t, err)
+	items, _ := list["data"].([]interface{})
+	assert.NotEmpty(t, items)
+	first, _ := items[0].(map[string]interface{})
+	attrs, _ = first["attributes"].(map[string]interface{})
+	assert.NotContains(t, attrs, "content")
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/history/"+snapshotID, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ = result["data"].(map[string]interface{})
+	attrs, _ = data["attributes"].(map[string]interface{})
+	assert.Contains(t, attrs, "content")
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/history/diff?from="+snapshotID, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ = result["data"].(map[string]interface{})
+	assert.Equal(t, "io.cozy.notes.diffs", data["type"])
+	attrs, _ = data["attributes"].(map[string]interface{})
+	assert.Equal(t, "current", attrs["to"])
+	changes, _ := attrs["changes"].([]interface{})
+	for _, change := range changes {
+		c, _ := change.(map[string]interface{})
+		assert.Equal(t, "equal", c["op"])
+	}
+
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/history/"+snapshotID+"/restore", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/history/unknown/restore", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestNoteComments(t *testing.T) {
+	req, _ := http.NewRequest("GET", ts.URL+"/notes/"+noteID, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	attrs, _ := data["attributes"].(map[string]interface{})
+	meta, _ := attrs["metadata"].(map[string]interface{})
+	version, _ := meta["version"].(float64)
+
+	body := fmt.Sprintf(`{"data": {"type": "io.cozy.notes.comments", "attributes": {
+		"author": "Bob",
+		"body": "Is it the right word?",
+		"anchor": {"from": 1, "to": 1, "version": %d}
+	}}}`, int64(version))
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/comments", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ = result["data"].(map[string]interface{})
+	assert.Equal(t, "io.cozy.notes.comments", data["type"])
+	threadID, _ := data["id"].(string)
+	attrs, _ = data["attributes"].(map[string]interface{})
+	assert.Equal(t, "open", attrs["state"])
+	assert.Equal(t, "Is it the right word?", attrs["body"])
+	// The author is set by the server, not by the client
+	publicName, _ := inst.PublicName()
+	assert.Equal(t, publicName, attrs["author"])
+
+	body = `{"data": {"type": "io.cozy.notes.comments", "attributes": {"body": "  "}}}`
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/comments/"+threadID+"/replies", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 422, res.StatusCode)
+
+	body = `{"data": {"type": "io.cozy.notes.comments", "attributes": {"author": "Alice", "body": "Yes"}}}`
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/comments/"+threadID+"/replies", strings.NewReader(body))
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ = result["data"].(map[string]interface{})
+	replyID, _ := data["id"].(string)
+	attrs, _ = data["attributes"].(map[string]interface{})
+	assert.Equal(t, threadID, attrs["thread_id"])
+
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/comments/"+threadID+"/resolve", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ = result["data"].(map[string]interface{})
+	attrs, _ = data["attributes"].(map[string]interface{})
+	assert.Equal(t, "resolved", attrs["state"])
+	assert.NotEmpty(t, attrs["resolved_at"])
+
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+noteID+"/comments/"+replyID+"/reopen", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/comments", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var list map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&list)
+	assert.NoError(t, err)
+	items, _ := list["data"].([]interface{})
+	assert.Len(t, items, 2)
+
+	req, _ = http.NewRequest("DELETE", ts.URL+"/notes/"+noteID+"/comments/"+threadID, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/notes/"+noteID+"/comments", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	err = json.NewDecoder(res.Body).Decode(&list)
+	assert.NoError(t, err)
+	items, _ = list["data"].([]interface{})
+	assert.Len(t, items, 0)
+}
+
 func TestNoteRealtime(t *testing.T) {
 	u := strings.Replace(ts.URL+"/realtime/", "http", "ws", 1)
 	c, _, err := websocket.DefaultDialer.Dial(u, nil)
@@ -574,6 +850,120 @@ func TestNoteRealtime(t *testing.T) {
 	assert.EqualValues(t, file.Metadata["version"], v5)
 }
 
+func TestImportNote(t *testing.T) {
+	body := "# Imported\n\nSome *markdown* with a [link](https://cozy.io/).\n"
+	req, _ := http.NewRequest("POST", ts.URL+"/notes/import?Title=Imported", strings.NewReader(body))
+	req.Header.Add("Content-Type", "text/markdown")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	attrs := data["attributes"].(map[string]interface{})
+	assert.Equal(t, "Imported.cozy-note", attrs["name"])
+	assert.Equal(t, "text/vnd.cozy.note+markdown", attrs["mime"])
+	meta, _ := attrs["metadata"].(map[string]interface{})
+	assert.Equal(t, "Imported", meta["title"])
+	assert.NotNil(t, meta["schema"])
+	assert.NotNil(t, meta["content"])
+
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/import", strings.NewReader(body))
+	req.Header.Add("Content-Type", "application/pdf")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 415, res.StatusCode)
+}
+
+func TestDeleteNoteSnapshots(t *testing.T) {
+	body := "# Deleted\n\nThis note will be deleted.\n"
+	req, _ := http.NewRequest("POST", ts.URL+"/notes/import?Title=Deleted", strings.NewReader(body))
+	req.Header.Add("Content-Type", "text/markdown")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	fileID, _ := data["id"].(string)
+
+	file, err := inst.VFS().FileByID(fileID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	snapshot, err := note.CreateSnapshot(inst, file, "Before deletion")
+	if !assert.NoError(t, err) {
+		return
+	}
+	version, _ := file.Metadata["version"].(float64)
+	comment, err := note.CreateThread(inst, file, &note.Comment{
+		Body:   "Why?",
+		Anchor: &note.Anchor{From: 1, To: 2, Version: int64(version)},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, inst.VFS().DestroyFile(file))
+
+	var doc note.Snapshot
+	err = couchdb.GetDoc(inst, consts.NotesSnapshots, snapshot.ID(), &doc)
+	assert.True(t, couchdb.IsNotFoundError(err))
+	var deleted note.Comment
+	err = couchdb.GetDoc(inst, consts.NotesComments, comment.ID(), &deleted)
+	assert.True(t, couchdb.IsNotFoundError(err))
+}
+
+func TestConvertFile(t *testing.T) {
+	fs := inst.VFS()
+	content := []byte("<h1>Old page</h1><p>From <strong>HTML</strong></p>")
+	doc, err := vfs.NewFileDoc("page.html", consts.RootDirID, int64(len(content)), nil,
+		"text/html", "text", time.Now(), false, false, nil)
+	assert.NoError(t, err)
+	doc.AddReferencedBy(couchdb.DocReference{Type: "io.cozy.albums", ID: "album"})
+	file, err := fs.CreateFile(doc, nil)
+	assert.NoError(t, err)
+	_, err = file.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	req, _ := http.NewRequest("POST", ts.URL+"/notes/"+doc.ID()+"/convert", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	assert.Equal(t, doc.ID(), data["id"])
+	attrs := data["attributes"].(map[string]interface{})
+	assert.Equal(t, "page.cozy-note", attrs["name"])
+	assert.Equal(t, consts.RootDirID, attrs["dir_id"])
+	assert.Equal(t, "text/vnd.cozy.note+markdown", attrs["mime"])
+
+	converted, err := fs.FileByID(doc.ID())
+	assert.NoError(t, err)
+	assert.Len(t, converted.ReferencedBy, 1)
+	f, err := fs.OpenFile(converted)
+	assert.NoError(t, err)
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "# Old page\n\nFrom **HTML**", string(buf))
+
+	// A note can't be converted again
+	req, _ = http.NewRequest("POST", ts.URL+"/notes/"+doc.ID()+"/convert", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 409, res.StatusCode)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
@@ -7,6 +7,7 @@ import (
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/notification"
 	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -49,6 +50,39 @@ func createHandler(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusCreated, &apiNotif{n}, nil)
 }
 
+type apiCategory struct {
+	c *center.Category
+}
+
+func (c *apiCategory) ID() string                             { return c.c.Key }
+func (c *apiCategory) Rev() string                            { return "" }
+func (c *apiCategory) DocType() string                        { return consts.NotificationsCategories }
+func (c *apiCategory) Clone() couchdb.Doc                     { return c }
+func (c *apiCategory) SetID(_ string)                         {}
+func (c *apiCategory) SetRev(_ string)                        {}
+func (c *apiCategory) Relationships() jsonapi.RelationshipMap { return nil }
+func (c *apiCategory) Included() []jsonapi.Object             { return nil }
+func (c *apiCategory) Links() *jsonapi.LinksList              { return nil }
+func (c *apiCategory) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.c)
+}
+
+func listCategories(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+	categories, err := center.ListCategories(inst)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	objs := make([]jsonapi.Object, len(categories))
+	for i, category := range categories {
+		objs[i] = &apiCategory{category}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
 func wrapErrors(err error) error {
 	if err == nil {
 		return nil
@@ -67,4 +101,5 @@ func wrapErrors(err error) error {
 // Routes sets the routing for the notification service.
 func Routes(router *echo.Group) {
 	router.POST("", createHandler)
+	router.GET("/categories", listCategories)
 }
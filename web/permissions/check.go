@@ -0,0 +1,146 @@
+package permissions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// CheckRequest is the body of a request to simulate a permission check. The
+// permission set is given by the identifier of a permission document, or by
+// a token. The target is a document (doctype and identifier), or a file or
+// directory given by its path.
+type CheckRequest struct {
+	PermissionID string `json:"permission_id,omitempty"`
+	Token        string `json:"token,omitempty"`
+	Verb         string `json:"verb"`
+	DocType      string `json:"type"`
+	ID           string `json:"id,omitempty"`
+	Path         string `json:"path,omitempty"`
+}
+
+// CheckResult is the response of a simulation of a permission check.
+type CheckResult struct {
+	PermissionID   string `json:"permission_id,omitempty"`
+	PermissionType string `json:"permission_type"`
+	SourceID       string `json:"source_id,omitempty"`
+	*permission.Explanation
+}
+
+// FindPermissionForCheck returns the permission document to use for a check:
+// the one with the given identifier, or the one for the given token.
+func FindPermissionForCheck(c echo.Context, inst *instance.Instance, req *CheckRequest) (*permission.Permission, error) {
+	if req.PermissionID != "" {
+		return permission.GetByID(inst, req.PermissionID)
+	}
+	if req.Token == "" {
+		return nil, jsonapi.BadRequest(errors.New("A permission_id or a token is required"))
+	}
+	// ParseJWT puts the claims of the token in the echo context, but we don't
+	// want to replace the claims of the current request.
+	claims := c.Get("claims")
+	defer c.Set("claims", claims)
+	return middlewares.ParseJWT(c, inst, req.Token)
+}
+
+// Check simulates a permission check, and returns the details of which rule
+// has granted the access, or why every rule has rejected it.
+func Check(inst *instance.Instance, pdoc *permission.Permission, req *CheckRequest) (*CheckResult, error) {
+	verb := permission.Verb(req.Verb)
+	if !permission.ALL.Contains(verb) {
+		return nil, jsonapi.InvalidParameter("verb", errors.New("Invalid verb"))
+	}
+	if req.DocType == "" && req.Path != "" {
+		req.DocType = consts.Files
+	}
+	if req.DocType == "" {
+		return nil, jsonapi.InvalidParameter("type", errors.New("The doctype is missing"))
+	}
+
+	res := &CheckResult{
+		PermissionID:   pdoc.ID(),
+		PermissionType: pdoc.Type,
+		SourceID:       pdoc.SourceID,
+	}
+
+	if req.DocType == consts.Files && (req.ID != "" || req.Path != "") {
+		fs := inst.VFS()
+		var dir *vfs.DirDoc
+		var file *vfs.FileDoc
+		var err error
+		if req.Path != "" {
+			dir, file, err = fs.DirOrFileByPath(req.Path)
+		} else {
+			dir, file, err = fs.DirOrFileByID(req.ID)
+		}
+		if err != nil {
+			return nil, jsonapi.NotFound(err)
+		}
+		var fd vfs.Fetcher = file
+		if dir != nil {
+			fd = dir
+		}
+		if res.Explanation, err = vfs.ExplainAllows(fs, pdoc.Permissions, verb, fd); err != nil {
+			return nil, err
+		}
+	} else if req.ID != "" {
+		doc := &couchdb.JSONDoc{}
+		if err := couchdb.GetDoc(inst, req.DocType, req.ID, doc); err == nil {
+			doc.Type = req.DocType
+			res.Explanation = pdoc.Permissions.Explain(verb, doc)
+		} else {
+			res.Explanation = pdoc.Permissions.ExplainID(verb, req.DocType, req.ID)
+		}
+	} else {
+		res.Explanation = pdoc.Permissions.ExplainID(verb, req.DocType, "")
+	}
+
+	if pdoc.Expired() {
+		res.Allowed = false
+		res.MatchedRule = nil
+		res.Reason = "the permission has expired"
+	}
+	return res, nil
+}
+
+// checkPermissions is the handler for POST /permissions/check. Without a
+// permission_id or token in the body, the permissions of the request are
+// checked. Else, the request must have the permission to read the
+// permissions documents.
+func checkPermissions(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	current, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+
+	var req CheckRequest
+	if err = json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return jsonapi.BadJSON()
+	}
+
+	pdoc := current
+	if req.PermissionID != "" || req.Token != "" {
+		if err = middlewares.AllowWholeType(c, permission.GET, consts.Permissions); err != nil {
+			return err
+		}
+		if pdoc, err = FindPermissionForCheck(c, inst, &req); err != nil {
+			return err
+		}
+	}
+
+	res, err := Check(inst, pdoc, &req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
@@ -287,6 +287,9 @@ func patchPermission(getPerms getPermsFunc, paramName string) echo.HandlerFunc {
 		}
 
 		if patchSet {
+			if err = patch.Permissions.ValidateConditions(); err != nil {
+				return err
+			}
 			for _, r := range patch.Permissions {
 				if r.Type == "" {
 					toPatch.RemoveRule(r)
@@ -363,6 +366,7 @@ func Routes(router *echo.Group) {
 	router.POST("", createPermission)
 	router.GET("/self", displayPermissions)
 	router.POST("/exists", listPermissions)
+	router.POST("/check", checkPermissions)
 	router.PATCH("/:permdocid", patchPermission(permission.GetByID, "permdocid"))
 	router.DELETE("/:permdocid", revokePermission)
 
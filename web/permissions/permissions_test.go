@@ -573,6 +573,26 @@ func doRequest(method, url, tok, body string) (map[string]interface{}, error) {
 	return out, nil
 }
 
+func TestCheckPermissions(t *testing.T) {
+	out, err := doRequest("POST", ts.URL+"/permissions/check", token,
+		`{"verb": "PUT", "type": "io.cozy.contacts"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, true, out["allowed"])
+	assert.EqualValues(t, 0, out["matched_rule"])
+
+	out, err = doRequest("POST", ts.URL+"/permissions/check", token,
+		`{"verb": "PUT", "type": "io.cozy.files"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, false, out["allowed"])
+	assert.NotEmpty(t, out["reason"])
+	rules := out["rules"].([]interface{})
+	assert.Len(t, rules, 3)
+
+	_, err = doRequest("POST", ts.URL+"/permissions/check", token,
+		`{"permission_id": "foo", "verb": "GET", "type": "io.cozy.files"}`)
+	assert.Error(t, err)
+}
+
 func TestGetPermissionsWithShortCode(t *testing.T) {
 	id, _, _ := createTestSubPermissions(token, "daniel")
 	perm, _ := permission.GetByID(testInstance, id)
@@ -2,9 +2,12 @@ package realtime
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -31,7 +34,20 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer
-	maxMessageSize = 1024
+	maxMessageSize = 4096
+
+	// Maximum number of events sent before a replay that are remembered, to
+	// avoid sending them twice
+	maxSentBeforeReplay = 1000
+)
+
+// Events sent by the server at the end of a replay
+const (
+	// EventReplayed is sent after the missed events have been replayed
+	EventReplayed = "REPLAYED"
+	// EventResync is sent when the missed events can no longer be replayed,
+	// and the client should fetch its data again
+	EventResync = "RESYNC"
 )
 
 var upgrader = websocket.Upgrader{
@@ -45,8 +61,10 @@ var upgrader = websocket.Upgrader{
 type command struct {
 	Method  string `json:"method"`
 	Payload struct {
-		Type string `json:"type"`
-		ID   string `json:"id"`
+		Type     string                 `json:"type"`
+		ID       string                 `json:"id"`
+		Selector map[string]interface{} `json:"selector,omitempty"`
+		Since    string                 `json:"since,omitempty"`
 	} `json:"payload"`
 }
 
@@ -54,6 +72,7 @@ type wsResponsePayload struct {
 	Type string      `json:"type"`
 	ID   string      `json:"id"`
 	Doc  interface{} `json:"doc,omitempty"`
+	Seq  string      `json:"seq,omitempty"`
 }
 
 type wsResponse struct {
@@ -61,6 +80,27 @@ type wsResponse struct {
 	Payload wsResponsePayload `json:"payload"`
 }
 
+type wsSeqPayload struct {
+	Seq string `json:"seq"`
+}
+
+type wsSeqResponse struct {
+	Event   string       `json:"event"`
+	Payload wsSeqPayload `json:"payload"`
+}
+
+func newResponse(e *realtime.Event) *wsResponse {
+	return &wsResponse{
+		Event: e.Verb,
+		Payload: wsResponsePayload{
+			Type: e.Doc.DocType(),
+			ID:   e.Doc.ID(),
+			Doc:  e.Doc,
+			Seq:  e.Position().String(),
+		},
+	}
+}
+
 type wsErrorPayload struct {
 	Status string      `json:"status"`
 	Code   string      `json:"code"`
@@ -121,6 +161,87 @@ func missingType(cmd *command) *wsError {
 	}
 }
 
+func replayFailed(cmd *command) *wsError {
+	return &wsError{
+		Event: "error",
+		Payload: wsErrorPayload{
+			Status: "500 Internal Server Error",
+			Code:   "internal server error",
+			Title:  "The events cannot be replayed",
+			Source: cmd,
+		},
+	}
+}
+
+func invalidSince(cmd *command) *wsError {
+	return &wsError{
+		Event: "error",
+		Payload: wsErrorPayload{
+			Status: "400 Bad Request",
+			Code:   "bad request",
+			Title:  realtime.ErrInvalidPosition.Error(),
+			Source: cmd,
+		},
+	}
+}
+
+func badSelector(cmd *command, err error) *wsError {
+	return &wsError{
+		Event: "error",
+		Payload: wsErrorPayload{
+			Status: "400 Bad Request",
+			Code:   "bad request",
+			Title:  err.Error(),
+			Source: cmd,
+		},
+	}
+}
+
+// filters is used to check the permissions on each event for the doctypes
+// where the subscriber has subscribed with a selector, without having a
+// permission on the whole doctype.
+type filters struct {
+	sync.RWMutex
+	perms      permission.Set
+	restricted map[string]bool
+}
+
+func (f *filters) restrict(perms permission.Set, doctype string) {
+	f.Lock()
+	defer f.Unlock()
+	f.perms = perms
+	f.restricted[doctype] = true
+}
+
+func (f *filters) allowed(e *realtime.Event) bool {
+	f.RLock()
+	defer f.RUnlock()
+	if !f.restricted[e.Doc.DocType()] {
+		return true
+	}
+	if doc := toFetcher(e.Doc); doc != nil && f.perms.Allow(permission.GET, doc) {
+		return true
+	}
+	if e.OldDoc != nil {
+		if old := toFetcher(e.OldDoc); old != nil && f.perms.Allow(permission.GET, old) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFetcher(doc realtime.Doc) permission.Fetcher {
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	fetcher := &couchdb.JSONDoc{Type: doc.DocType()}
+	if err := json.Unmarshal(buf, &fetcher.M); err != nil || fetcher.M == nil {
+		return nil
+	}
+	return fetcher
+}
+
 func sendErr(ctx context.Context, errc chan *wsError, e *wsError) {
 	select {
 	case errc <- e:
@@ -129,7 +250,8 @@ func sendErr(ctx context.Context, errc chan *wsError, e *wsError) {
 }
 
 func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *websocket.Conn,
-	ds *realtime.DynamicSubscriber, errc chan *wsError, withAuthentication bool) {
+	ds *realtime.DynamicSubscriber, f *filters, errc chan *wsError, replayc chan *command,
+	withAuthentication bool) {
 	defer close(errc)
 
 	var err error
@@ -154,6 +276,7 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 			sendErr(ctx, errc, unauthorized(auth))
 			return
 		}
+		pdoc = middlewares.RestrictByConditions(c, pdoc)
 	}
 
 	for {
@@ -168,6 +291,16 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 			break
 		}
 
+		if strings.ToUpper(cmd.Method) == "REPLAY" {
+			// The replay is done by the goroutine that sends the events, to
+			// avoid sending them twice
+			select {
+			case replayc <- cmd:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
 		if strings.ToUpper(cmd.Method) != "SUBSCRIBE" {
 			sendErr(ctx, errc, unknownMethod(cmd.Method, cmd))
 			continue
@@ -176,19 +309,30 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 			sendErr(ctx, errc, missingType(cmd))
 			continue
 		}
-		permType := cmd.Payload.Type
-		// XXX: thumbnails is a synthetic doctype, listening to its events
-		// requires a permissions on io.cozy.files. Same for note events.
-		if permType == consts.Thumbnails || permType == consts.NotesEvents {
-			permType = consts.Files
+		var selector *realtime.Selector
+		if cmd.Payload.Selector != nil {
+			if cmd.Payload.ID != "" {
+				sendErr(ctx, errc, badSelector(cmd, errors.New("Invalid selector: it cannot be used with an id")))
+				continue
+			}
+			if selector, err = realtime.NewSelector(cmd.Payload.Selector); err != nil {
+				sendErr(ctx, errc, badSelector(cmd, err))
+				continue
+			}
+		}
+		if realtime.IsInternal(cmd.Payload.Type) {
+			sendErr(ctx, errc, forbidden(cmd))
+			continue
 		}
+		permType, synthetic := permDoctype(cmd.Payload.Type)
 		// XXX: no permissions are required for io.cozy.sharings.initial-sync
 		if withAuthentication && cmd.Payload.Type != consts.SharingsInitialSync {
-			var authorized bool
-			if cmd.Payload.ID == "" {
-				authorized = pdoc.Permissions.AllowWholeType(permission.GET, permType)
-			} else {
-				authorized = pdoc.Permissions.AllowID(permission.GET, permType, cmd.Payload.ID)
+			authorized := allowSubscription(pdoc.Permissions, cmd.Payload.Type, cmd.Payload.ID)
+			if !authorized && selector != nil && !synthetic && hasSomePermission(pdoc.Permissions, permType) {
+				// The permissions will be checked on each event, as the
+				// application can access only some documents of this doctype
+				f.restrict(pdoc.Permissions, cmd.Payload.Type)
+				authorized = true
 			}
 			if !authorized {
 				sendErr(ctx, errc, forbidden(cmd))
@@ -196,7 +340,9 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 			}
 		}
 
-		if cmd.Payload.ID == "" {
+		if selector != nil {
+			err = ds.SubscribeSelector(cmd.Payload.Type, selector)
+		} else if cmd.Payload.ID == "" {
 			err = ds.Subscribe(cmd.Payload.Type)
 		} else {
 			err = ds.Watch(cmd.Payload.Type, cmd.Payload.ID)
@@ -210,6 +356,39 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 	}
 }
 
+// permDoctype returns the doctype to use for checking the permissions of a
+// subscription, and true if the doctype is a synthetic one.
+func permDoctype(doctype string) (string, bool) {
+	// XXX: thumbnails is a synthetic doctype, listening to its events
+	// requires a permissions on io.cozy.files. Same for note events.
+	if doctype == consts.Thumbnails || doctype == consts.NotesEvents {
+		return consts.Files, true
+	}
+	return doctype, false
+}
+
+// allowSubscription returns true if the permissions allow to subscribe to the
+// events of the given doctype, or of the document if id is not empty.
+func allowSubscription(perms permission.Set, doctype, id string) bool {
+	// XXX: no permissions are required for io.cozy.sharings.initial-sync
+	if doctype == consts.SharingsInitialSync {
+		return true
+	}
+	permType, _ := permDoctype(doctype)
+	if id != "" {
+		return perms.AllowID(permission.GET, permType, id)
+	}
+	return perms.AllowWholeType(permission.GET, permType)
+}
+
+// hasSomePermission returns true if the set has a rule for reading some
+// documents of the given doctype.
+func hasSomePermission(perms permission.Set, doctype string) bool {
+	return perms.Some(func(r permission.Rule) bool {
+		return r.Type == doctype && r.Verbs.Contains(permission.GET)
+	})
+}
+
 // Ws is the API handler for realtime via a websocket connection.
 func Ws(c echo.Context) error {
 	var db prefixer.Prefixer
@@ -243,11 +422,20 @@ func Ws(c echo.Context) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	errc := make(chan *wsError)
-	go readPump(ctx, c, inst, ws, ds, errc, withAuthentication)
+	replayc := make(chan *command)
+	f := &filters{restricted: make(map[string]bool)}
+	go readPump(ctx, c, inst, ws, ds, f, errc, replayc, withAuthentication)
 
 	ticker := time.NewTicker(pingPeriod)
 	defer ticker.Stop()
 
+	// The events of the same epoch with a sequence number lower than replayed
+	// have already been sent by the replay. And the positions of the events
+	// sent before the replay are kept, to avoid sending them again in the
+	// replay.
+	var replayed realtime.Position
+	sentBeforeReplay := make(map[realtime.Position]bool)
+
 	for {
 		select {
 		case e, ok := <-errc:
@@ -260,21 +448,72 @@ func Ws(c echo.Context) error {
 			if err := ws.WriteJSON(e); err != nil {
 				return nil
 			}
-		case e := <-ds.Channel:
+		case cmd := <-replayc:
 			if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return err
 			}
-			res := wsResponse{
-				Event: e.Verb,
-				Payload: wsResponsePayload{
-					Type: e.Doc.DocType(),
-					ID:   e.Doc.ID(),
-					Doc:  e.Doc,
-				},
+			// Without since, the client gets the position of the last event
+			// with a RESYNC event
+			var since realtime.Position
+			if cmd.Payload.Since != "" {
+				pos, err := realtime.ParsePosition(cmd.Payload.Since)
+				if err != nil {
+					if err := ws.WriteJSON(invalidSince(cmd)); err != nil {
+						return nil
+					}
+					continue
+				}
+				since = pos
+			}
+			events, last, err := ds.Replay(since)
+			if err == realtime.ErrTooOld {
+				replayed = last
+				res := wsSeqResponse{Event: EventResync, Payload: wsSeqPayload{Seq: last.String()}}
+				if err := ws.WriteJSON(res); err != nil {
+					return nil
+				}
+				continue
+			}
+			if err != nil {
+				logger.
+					WithDomain(ds.DomainName()).
+					WithField("nspace", "realtime").
+					Warnf("Cannot replay: %s", err)
+				if err := ws.WriteJSON(replayFailed(cmd)); err != nil {
+					return nil
+				}
+				continue
+			}
+			for _, e := range events {
+				if sentBeforeReplay[e.Position()] || !f.allowed(e) {
+					continue
+				}
+				if err := ws.WriteJSON(newResponse(e)); err != nil {
+					return nil
+				}
 			}
+			replayed = last
+			sentBeforeReplay = nil
+			res := wsSeqResponse{Event: EventReplayed, Payload: wsSeqPayload{Seq: last.String()}}
 			if err := ws.WriteJSON(res); err != nil {
 				return nil
 			}
+		case e := <-ds.Channel:
+			if alreadyReplayed(e, replayed) {
+				continue
+			}
+			if !f.allowed(e) {
+				continue
+			}
+			if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+				return err
+			}
+			if err := ws.WriteJSON(newResponse(e)); err != nil {
+				return nil
+			}
+			if sentBeforeReplay != nil && len(sentBeforeReplay) < maxSentBeforeReplay {
+				sentBeforeReplay[e.Position()] = true
+			}
 		case <-ticker.C:
 			if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return err
@@ -286,6 +525,148 @@ func Ws(c echo.Context) error {
 	}
 }
 
+// Events is the API handler for GET /realtime/events: it sends the realtime
+// events as Server-Sent Events. The doctypes are given in the type query
+// parameters, optionally followed by a slash and the id of a document. The
+// missed events can be replayed with the since query parameter, or the
+// Last-Event-ID header.
+func Events(c echo.Context) error {
+	var db prefixer.Prefixer
+	var perms permission.Set
+	inst, withAuthentication := middlewares.GetInstanceSafe(c)
+	if withAuthentication {
+		db = inst
+		pdoc, err := middlewares.GetPermission(c)
+		if err != nil {
+			return err
+		}
+		perms = pdoc.Permissions
+	} else {
+		db = prefixer.GlobalPrefixer
+	}
+
+	types := c.QueryParams()["type"]
+	if len(types) == 0 {
+		return jsonapi.BadRequest(errors.New("The type parameter is mandatory"))
+	}
+	since := c.QueryParam("since")
+	if since == "" {
+		since = c.Request().Header.Get("Last-Event-ID")
+	}
+	var sincePos realtime.Position
+	if since != "" {
+		var err error
+		if sincePos, err = realtime.ParsePosition(since); err != nil {
+			return jsonapi.InvalidParameter("since", err)
+		}
+	}
+
+	ds := realtime.GetHub().Subscriber(db)
+	defer ds.Close()
+	for _, typ := range types {
+		parts := strings.SplitN(typ, "/", 2)
+		doctype, id := parts[0], ""
+		if len(parts) == 2 {
+			id = parts[1]
+		}
+		if realtime.IsInternal(doctype) {
+			return middlewares.ErrForbidden
+		}
+		if withAuthentication && !allowSubscription(perms, doctype, id) {
+			return middlewares.ErrForbidden
+		}
+		var err error
+		if id == "" {
+			err = ds.Subscribe(doctype)
+		} else {
+			err = ds.Watch(doctype, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, typeTextEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	var replayed realtime.Position
+	if since != "" {
+		events, last, err := ds.Replay(sincePos)
+		replayed = last
+		switch err {
+		case nil:
+			for _, e := range events {
+				if err := writeEvent(w, e); err != nil {
+					return nil
+				}
+			}
+			err = writeSSE(w, EventReplayed, "", wsSeqPayload{Seq: last.String()})
+		case realtime.ErrTooOld:
+			err = writeSSE(w, EventResync, "", wsSeqPayload{Seq: last.String()})
+		default:
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	done := c.Request().Context().Done()
+	for {
+		select {
+		case <-done:
+			return nil
+		case e := <-ds.Channel:
+			if alreadyReplayed(e, replayed) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\r\n\r\n")); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+const typeTextEventStream = "text/event-stream"
+
+// alreadyReplayed returns true if the event has been sent by a replay that
+// has ended at the replayed position.
+func alreadyReplayed(e *realtime.Event, replayed realtime.Position) bool {
+	return e.Seq != 0 && e.Epoch == replayed.Epoch && e.Seq <= replayed.Seq
+}
+
+func writeEvent(w *echo.Response, e *realtime.Event) error {
+	return writeSSE(w, e.Verb, e.Position().String(), newResponse(e).Payload)
+}
+
+func writeSSE(w *echo.Response, event, id string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var msg string
+	if id != "" {
+		msg = fmt.Sprintf("id: %s\r\n", id)
+	}
+	msg += fmt.Sprintf("event: %s\r\ndata: %s\r\n\r\n", event, buf)
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
 // Notify is the API handler for POST /realtime/:doctype/:id: this route can be
 // used to send documents in the real-time without having to persist them in
 // CouchDB.
@@ -315,5 +696,6 @@ func Notify(c echo.Context) error {
 // Routes set the routing for the realtime service
 func Routes(router *echo.Group) {
 	router.GET("/", Ws)
+	router.GET("/events", Events)
 	router.POST("/:doctype/:id", Notify)
 }
@@ -1,6 +1,7 @@
 package realtime
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"net/http"
@@ -168,6 +169,59 @@ func TestWSSuccess(t *testing.T) {
 	assert.Equal(t, "bar-one", payload["id"])
 }
 
+func TestWSSelector(t *testing.T) {
+	u := strings.Replace(ts.URL+"/realtime/", "http", "ws", 1)
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ws.Close()
+
+	auth := fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, token)
+	err = ws.WriteMessage(websocket.TextMessage, []byte(auth))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	msg := `{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos", "selector": { "$bad": true } }}`
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var res map[string]interface{}
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", res["event"])
+	payload := res["payload"].(map[string]interface{})
+	assert.Equal(t, "400 Bad Request", payload["status"])
+
+	msg = `{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos", "selector": { "account": "123" } }}`
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h := realtime.GetHub()
+	time.Sleep(30 * time.Millisecond)
+
+	h.Publish(inst, realtime.EventCreate, &realtime.JSONDoc{
+		Type: "io.cozy.foos",
+		M:    map[string]interface{}{"_id": "foo-456", "account": "456"},
+	}, nil)
+	// No event
+
+	h.Publish(inst, realtime.EventCreate, &realtime.JSONDoc{
+		Type: "io.cozy.foos",
+		M:    map[string]interface{}{"_id": "foo-123", "account": "123"},
+	}, nil)
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATED", res["event"])
+	payload = res["payload"].(map[string]interface{})
+	assert.Equal(t, "io.cozy.foos", payload["type"])
+	assert.Equal(t, "foo-123", payload["id"])
+}
+
 func TestWSNotify(t *testing.T) {
 	u := strings.Replace(ts.URL+"/realtime/", "http", "ws", 1)
 	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
@@ -212,6 +266,188 @@ func TestWSNotify(t *testing.T) {
 	assert.Equal(t, "world", doc["hello"])
 }
 
+func TestWSReplay(t *testing.T) {
+	h := realtime.GetHub()
+	h.Publish(inst, realtime.EventCreate, &testDoc{
+		doctype: "io.cozy.foos",
+		id:      "replay-one",
+	}, nil)
+	_, since, _ := h.Replay(inst, realtime.Position{})
+	if !assert.NotEmpty(t, since.Epoch) {
+		return
+	}
+	at := func(seq uint64) string {
+		return realtime.Position{Epoch: since.Epoch, Seq: seq}.String()
+	}
+
+	// Events published while the client is disconnected
+	h.Publish(inst, realtime.EventCreate, &testDoc{
+		doctype: "io.cozy.foos",
+		id:      "replay-two",
+	}, nil)
+	h.Publish(inst, realtime.EventCreate, &testDoc{
+		doctype: "io.cozy.bazs",
+		id:      "not-subscribed",
+	}, nil)
+
+	u := strings.Replace(ts.URL+"/realtime/", "http", "ws", 1)
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ws.Close()
+
+	auth := fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, token)
+	err = ws.WriteMessage(websocket.TextMessage, []byte(auth))
+	assert.NoError(t, err)
+	msg := `{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos" }}`
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	assert.NoError(t, err)
+	msg = fmt.Sprintf(`{"method": "REPLAY", "payload": { "since": "%s" }}`, since)
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	assert.NoError(t, err)
+
+	var res map[string]interface{}
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "CREATED", res["event"])
+	payload := res["payload"].(map[string]interface{})
+	assert.Equal(t, "replay-two", payload["id"])
+	assert.Equal(t, at(since.Seq+1), payload["seq"])
+
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "REPLAYED", res["event"])
+	payload = res["payload"].(map[string]interface{})
+	assert.Equal(t, at(since.Seq+2), payload["seq"])
+
+	msg = fmt.Sprintf(`{"method": "REPLAY", "payload": { "since": "%s" }}`, at(since.Seq+1000))
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	assert.NoError(t, err)
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "RESYNC", res["event"])
+	payload = res["payload"].(map[string]interface{})
+	assert.Equal(t, at(since.Seq+2), payload["seq"])
+
+	// The sequence numbers of another epoch can't be replayed
+	other := realtime.Position{Epoch: "another", Seq: since.Seq}
+	msg = fmt.Sprintf(`{"method": "REPLAY", "payload": { "since": "%s" }}`, other)
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	assert.NoError(t, err)
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "RESYNC", res["event"])
+	payload = res["payload"].(map[string]interface{})
+	assert.Equal(t, at(since.Seq+2), payload["seq"])
+
+	msg = `{"method": "REPLAY", "payload": { "since": "42" }}`
+	err = ws.WriteMessage(websocket.TextMessage, []byte(msg))
+	assert.NoError(t, err)
+	err = ws.ReadJSON(&res)
+	assert.NoError(t, err)
+	assert.Equal(t, "error", res["event"])
+	payload = res["payload"].(map[string]interface{})
+	assert.Equal(t, "400 Bad Request", payload["status"])
+}
+
+func TestSSE(t *testing.T) {
+	h := realtime.GetHub()
+	h.Publish(inst, realtime.EventCreate, &testDoc{
+		doctype: "io.cozy.foos",
+		id:      "sse-one",
+	}, nil)
+	_, since, _ := h.Replay(inst, realtime.Position{})
+	if !assert.NotEmpty(t, since.Epoch) {
+		return
+	}
+	at := func(seq uint64) string {
+		return realtime.Position{Epoch: since.Epoch, Seq: seq}.String()
+	}
+	h.Publish(inst, realtime.EventUpdate, &testDoc{
+		doctype: "io.cozy.foos",
+		id:      "sse-one",
+	}, nil)
+
+	req, _ := http.NewRequest("GET", ts.URL+"/realtime/events?type=io.cozy.foos", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Last-Event-ID", since.String())
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(res.Body)
+	readEvent := func() []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return lines
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				return lines
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	lines := readEvent()
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "id: "+at(since.Seq+1), lines[0])
+		assert.Equal(t, "event: UPDATED", lines[1])
+		assert.Contains(t, lines[2], `"id":"sse-one"`)
+	}
+	lines = readEvent()
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "event: REPLAYED", lines[0])
+		assert.Equal(t, fmt.Sprintf(`data: {"seq":"%s"}`, at(since.Seq+1)), lines[1])
+	}
+
+	h.Publish(inst, realtime.EventDelete, &testDoc{
+		doctype: "io.cozy.foos",
+		id:      "sse-one",
+	}, nil)
+	lines = readEvent()
+	if assert.Len(t, lines, 3) {
+		assert.Equal(t, "id: "+at(since.Seq+2), lines[0])
+		assert.Equal(t, "event: DELETED", lines[1])
+	}
+
+	// The sequence numbers of another epoch can't be replayed
+	other := realtime.Position{Epoch: "another", Seq: since.Seq}
+	req, _ = http.NewRequest("GET", ts.URL+"/realtime/events?type=io.cozy.foos&since="+other.String(), nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res3, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		reader = bufio.NewReader(res3.Body)
+		lines = readEvent()
+		if assert.Len(t, lines, 2) {
+			assert.Equal(t, "event: RESYNC", lines[0])
+			assert.Equal(t, fmt.Sprintf(`data: {"seq":"%s"}`, at(since.Seq+2)), lines[1])
+		}
+		res3.Body.Close()
+	}
+
+	req, _ = http.NewRequest("GET", ts.URL+"/realtime/events?type=io.cozy.foos&since=42", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res4, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res4.StatusCode)
+	res4.Body.Close()
+
+	req, _ = http.NewRequest("GET", ts.URL+"/realtime/events?type=io.cozy.files", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res2, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res2.StatusCode)
+	res2.Body.Close()
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
@@ -3,6 +3,7 @@ package remote
 import (
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/remote"
+	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
@@ -21,11 +22,7 @@ func remoteGet(c echo.Context) error {
 	if remote.Verb != "GET" {
 		return jsonapi.MethodNotAllowed("GET")
 	}
-	err = remote.ProxyTo(doctype, instance, c.Response(), c.Request())
-	if err != nil {
-		return wrapRemoteErr(err)
-	}
-	return nil
+	return proxy(c, remote, doctype)
 }
 
 func remotePost(c echo.Context) error {
@@ -41,7 +38,28 @@ func remotePost(c echo.Context) error {
 	if remote.Verb != "POST" {
 		return jsonapi.MethodNotAllowed("POST")
 	}
-	err = remote.ProxyTo(doctype, instance, c.Response(), c.Request())
+	return proxy(c, remote, doctype)
+}
+
+// proxy checks that the client can use the account of the request, if any,
+// and then calls the remote website.
+func proxy(c echo.Context, r *remote.Remote, doctype string) error {
+	instance := middlewares.GetInstance(c)
+	vars, err := r.ExtractVariables(c.Request())
+	if err != nil {
+		return wrapRemoteErr(err)
+	}
+	if r.NeedsAccount() {
+		accountID := vars[remote.AccountVariable]
+		if accountID == "" {
+			return wrapRemoteErr(remote.ErrMissingVar)
+		}
+		err = middlewares.AllowTypeAndID(c, permission.GET, consts.Accounts, accountID)
+		if err != nil {
+			return err
+		}
+	}
+	err = r.ProxyTo(doctype, instance, c.Response(), vars)
 	if err != nil {
 		return wrapRemoteErr(err)
 	}
@@ -80,6 +98,16 @@ func wrapRemoteErr(err error) error {
 		return jsonapi.BadGateway(err)
 	case remote.ErrRemoteAssetNotFound:
 		return jsonapi.NotFound(err)
+	case remote.ErrReservedVariable:
+		return jsonapi.BadRequest(err)
+	case remote.ErrMissingSecret:
+		return jsonapi.BadRequest(err)
+	case remote.ErrInvalidAccount:
+		return jsonapi.BadRequest(err)
+	case remote.ErrAccountRefresh:
+		return jsonapi.BadGateway(err)
+	case remote.ErrInvalidResponse:
+		return jsonapi.BadGateway(err)
 	}
 	return err
 }
@@ -23,6 +23,7 @@ import (
 	"github.com/cozy/cozy-stack/web/instances"
 	"github.com/cozy/cozy-stack/web/intents"
 	"github.com/cozy/cozy-stack/web/jobs"
+	"github.com/cozy/cozy-stack/web/localregistry"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/cozy/cozy-stack/web/move"
 	"github.com/cozy/cozy-stack/web/notes"
@@ -249,6 +250,7 @@ func SetupAdminRoutes(router *echo.Echo) error {
 	metrics.Routes(router.Group("/metrics", mws...))
 	realtime.Routes(router.Group("/realtime", mws...))
 	swift.Routes(router.Group("/swift", mws...))
+	localregistry.AdminRoutes(router.Group("/registry", mws...))
 
 	setupRecover(router)
 
@@ -281,7 +283,8 @@ func CreateSubdomainProxy(router *echo.Echo, appsHandler echo.HandlerFunc) (*ech
 }
 
 // firstRouting receives the requests and use the domain to decide if we should
-// use the API router, serve an app, or use delegated authentication.
+// use the API router, serve an app, use delegated authentication, or serve
+// the local registry.
 func firstRouting(router *echo.Echo, appsHandler echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		host := c.Request().Host
@@ -289,6 +292,10 @@ func firstRouting(router *echo.Echo, appsHandler echo.HandlerFunc) echo.HandlerF
 			return oidc.LoginDomainHandler(c, contextName)
 		}
 
+		if localregistry.IsRegistryHost(host) {
+			return localregistry.Serve(c)
+		}
+
 		if parent, slug, _ := middlewares.SplitHost(host); slug != "" {
 			if i, err := lifecycle.GetInstance(parent); err == nil {
 				c.Set("instance", i.WithContextualDomain(parent))
@@ -0,0 +1,86 @@
+package settings
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiNotificationsPreferences struct {
+	*notification.Preferences
+}
+
+func (p *apiNotificationsPreferences) Relationships() jsonapi.RelationshipMap { return nil }
+func (p *apiNotificationsPreferences) Included() []jsonapi.Object             { return nil }
+func (p *apiNotificationsPreferences) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/settings/notifications"}
+}
+func (p *apiNotificationsPreferences) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Preferences)
+}
+
+func getNotificationsPreferences(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.GET, prefs); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiNotificationsPreferences{prefs}, nil)
+}
+
+func updateNotificationsPreferences(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	prefs := &notification.Preferences{}
+	obj, err := jsonapi.Bind(c.Request().Body, prefs)
+	if err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.PUT, prefs); err != nil {
+		return err
+	}
+
+	current, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	prefs.SetRev(current.Rev())
+	if obj.Meta.Rev != "" {
+		prefs.SetRev(obj.Meta.Rev)
+	}
+	if err := prefs.Save(inst); err != nil {
+		return wrapNotificationsError(err)
+	}
+	if prefs.Digest != current.Digest {
+		if err := center.UpdateDigest(inst, prefs); err != nil {
+			return err
+		}
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiNotificationsPreferences{prefs}, nil)
+}
+
+func wrapNotificationsError(err error) error {
+	switch err {
+	case notification.ErrInvalidChannel:
+		return jsonapi.InvalidAttribute("channels", err)
+	case notification.ErrInvalidDoNotDisturb:
+		return jsonapi.InvalidAttribute("do_not_disturb", err)
+	case notification.ErrInvalidDigest:
+		return jsonapi.InvalidAttribute("digest", err)
+	}
+	if couchdb.IsConflictError(err) {
+		return jsonapi.Conflict(err)
+	}
+	return err
+}
+
+var _ jsonapi.Object = &apiNotificationsPreferences{}
@@ -93,6 +93,13 @@ func Routes(router *echo.Group) {
 
 	router.GET("/flags", getFlags)
 
+	router.GET("/notifications", getNotificationsPreferences)
+	router.PUT("/notifications", updateNotificationsPreferences)
+	router.GET("/notifications/vapid", getVAPIDKey)
+	router.GET("/notifications/webpush", listWebPushSubscriptions)
+	router.POST("/notifications/webpush", subscribeWebPush)
+	router.DELETE("/notifications/webpush/:id", unsubscribeWebPush)
+
 	router.GET("/sessions", getSessions)
 
 	router.GET("/clients", listClients)
@@ -855,6 +855,137 @@ func TestFeatureFlags(t *testing.T) {
 	assert.Equal(t, "context", attrs2["ratio_1"])
 }
 
+func TestNotificationsPreferences(t *testing.T) {
+	req, _ := http.NewRequest("GET", ts.URL+"/settings/notifications", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	assert.Equal(t, "io.cozy.settings", data["type"])
+	assert.Equal(t, "io.cozy.settings.notifications", data["id"])
+
+	body := `{
+		"data": {
+			"type": "io.cozy.settings",
+			"id": "io.cozy.settings.notifications",
+			"attributes": {
+				"categories": {
+					"stack/disk-quota": { "channels": ["sms"] }
+				}
+			}
+		}
+	}`
+	req, _ = http.NewRequest("PUT", ts.URL+"/settings/notifications", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 422, res.StatusCode)
+
+	body = `{
+		"data": {
+			"type": "io.cozy.settings",
+			"id": "io.cozy.settings.notifications",
+			"attributes": {
+				"categories": {
+					"stack/disk-quota": { "channels": ["mail"] },
+					"banks/balance-lower": { "muted": true }
+				},
+				"do_not_disturb": { "hours": "22:00-07:00", "timezone": "Europe/Paris" }
+			}
+		}
+	}`
+	req, _ = http.NewRequest("PUT", ts.URL+"/settings/notifications", bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/vnd.api+json")
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+
+	req, _ = http.NewRequest("GET", ts.URL+"/settings/notifications", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	result = nil
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ = result["data"].(map[string]interface{})
+	attrs, _ := data["attributes"].(map[string]interface{})
+	categories, _ := attrs["categories"].(map[string]interface{})
+	balance, _ := categories["banks/balance-lower"].(map[string]interface{})
+	assert.Equal(t, true, balance["muted"])
+	dnd, _ := attrs["do_not_disturb"].(map[string]interface{})
+	assert.Equal(t, "22:00-07:00", dnd["hours"])
+	assert.Equal(t, "Europe/Paris", dnd["timezone"])
+}
+
+func TestWebPushSubscriptions(t *testing.T) {
+	req, _ := http.NewRequest("GET", ts.URL+"/settings/notifications/vapid", nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	assert.NoError(t, err)
+	data, _ := result["data"].(map[string]interface{})
+	attrs, _ := data["attributes"].(map[string]interface{})
+	assert.NotEmpty(t, attrs["public_key"])
+
+	subscribe := func(endpoint, p256dh string) int {
+		body := fmt.Sprintf(`{
+			"data": {
+				"type": "io.cozy.notifications.webpush_subscriptions",
+				"attributes": {
+					"endpoint": %q,
+					"keys": { "p256dh": %q, "auth": "BTBZMqHH6r4Tts7J_aSIgg" }
+				}
+			}
+		}`, endpoint, p256dh)
+		req, _ := http.NewRequest("POST", ts.URL+"/settings/notifications/webpush", bytes.NewBufferString(body))
+		req.Header.Add("Content-Type", "application/vnd.api+json")
+		req.Header.Add("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+	list := func() []interface{} {
+		req, _ := http.NewRequest("GET", ts.URL+"/settings/notifications/webpush", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		var result map[string]interface{}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		assert.NoError(t, err)
+		data, _ := result["data"].([]interface{})
+		return data
+	}
+
+	p256dh := "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	assert.Equal(t, 422, subscribe("https://push.example.net/push/abc", "foo"))
+	assert.Equal(t, 422, subscribe("ftp://push.example.net/push/abc", p256dh))
+	assert.Equal(t, 201, subscribe("https://push.example.net/push/abc", p256dh))
+	assert.Equal(t, 201, subscribe("https://push.example.net/push/abc", p256dh))
+	subs := list()
+	assert.Len(t, subs, 1)
+
+	sub, _ := subs[0].(map[string]interface{})
+	id, _ := sub["id"].(string)
+	req, _ = http.NewRequest("DELETE", ts.URL+"/settings/notifications/webpush/"+id, nil)
+	req.Header.Add("Authorization", "Bearer "+token)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Len(t, list(), 0)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	testutils.NeedCouchdb()
@@ -865,7 +996,7 @@ func TestMain(m *testing.M) {
 		Email:       "alice@example.com",
 		ContextName: "test-context",
 	})
-	scope := consts.Settings + " " + consts.OAuthClients
+	scope := consts.Settings + " " + consts.OAuthClients + " " + consts.WebPushSubscriptions
 	_, token = setup.GetTestClient(scope)
 
 	ts = setup.GetTestServer("/settings", Routes)
@@ -0,0 +1,123 @@
+package settings
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiVAPIDKey struct {
+	DocID     string `json:"_id,omitempty"`
+	PublicKey string `json:"public_key"`
+}
+
+func (k *apiVAPIDKey) ID() string                             { return k.DocID }
+func (k *apiVAPIDKey) Rev() string                            { return "" }
+func (k *apiVAPIDKey) DocType() string                        { return consts.Settings }
+func (k *apiVAPIDKey) Clone() couchdb.Doc                     { cloned := *k; return &cloned }
+func (k *apiVAPIDKey) SetID(id string)                        { k.DocID = id }
+func (k *apiVAPIDKey) SetRev(rev string)                      {}
+func (k *apiVAPIDKey) Relationships() jsonapi.RelationshipMap { return nil }
+func (k *apiVAPIDKey) Included() []jsonapi.Object             { return nil }
+func (k *apiVAPIDKey) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/settings/notifications/vapid"}
+}
+
+type apiWebPushSubscription struct {
+	*notification.WebPushSubscription
+}
+
+func (s *apiWebPushSubscription) Relationships() jsonapi.RelationshipMap { return nil }
+func (s *apiWebPushSubscription) Included() []jsonapi.Object             { return nil }
+func (s *apiWebPushSubscription) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/settings/notifications/webpush/" + s.ID()}
+}
+func (s *apiWebPushSubscription) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.WebPushSubscription)
+}
+
+// getVAPIDKey returns the public key that the browsers must use as the
+// applicationServerKey when subscribing to the push notifications.
+func getVAPIDKey(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.WebPushSubscriptions); err != nil {
+		return err
+	}
+	keys, err := notification.GetVAPIDKeys()
+	if err != nil {
+		return err
+	}
+	doc := &apiVAPIDKey{DocID: consts.VAPIDSettingsID, PublicKey: keys.PublicKey}
+	return jsonapi.Data(c, http.StatusOK, doc, nil)
+}
+
+func listWebPushSubscriptions(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.WebPushSubscriptions); err != nil {
+		return err
+	}
+	subs, err := notification.ListWebPushSubscriptions(inst)
+	if err != nil {
+		return err
+	}
+	objs := make([]jsonapi.Object, len(subs))
+	for i, sub := range subs {
+		objs[i] = &apiWebPushSubscription{sub}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+func subscribeWebPush(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sub := &notification.WebPushSubscription{}
+	if _, err := jsonapi.Bind(c.Request().Body, sub); err != nil {
+		return err
+	}
+	if err := middlewares.Allow(c, permission.POST, sub); err != nil {
+		return err
+	}
+	if err := sub.Subscribe(inst); err != nil {
+		return wrapWebPushError(err)
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiWebPushSubscription{sub}, nil)
+}
+
+func unsubscribeWebPush(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sub, err := notification.FindWebPushSubscription(inst, c.Param("id"))
+	if err != nil {
+		return wrapWebPushError(err)
+	}
+	if err := middlewares.Allow(c, permission.DELETE, sub); err != nil {
+		return err
+	}
+	if err := sub.Unsubscribe(inst); err != nil {
+		return wrapWebPushError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func wrapWebPushError(err error) error {
+	switch err {
+	case notification.ErrInvalidEndpoint:
+		return jsonapi.InvalidAttribute("endpoint", err)
+	case webpush.ErrInvalidSubscription:
+		return jsonapi.InvalidAttribute("keys", err)
+	case notification.ErrTooManySubscriptions:
+		return jsonapi.BadRequest(err)
+	}
+	if couchdb.IsNotFoundError(err) {
+		return jsonapi.NotFound(err)
+	}
+	return err
+}
+
+var _ jsonapi.Object = &apiWebPushSubscription{}
+var _ jsonapi.Object = &apiVAPIDKey{}
@@ -0,0 +1,87 @@
+package sharings
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// GetActivity returns the changes made by the members on the shared
+// documents. It can be called by the owner, by an application on a recipient
+// (and the request is forwarded to the owner), or by the cozy of a recipient.
+func GetActivity(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	member, err := requestMember(c, s)
+	if err == nil {
+		requestPerm, errp := middlewares.GetPermission(c)
+		if errp != nil {
+			return errp
+		}
+		if !requestPerm.Permissions.AllowID("GET", consts.Sharings, sharingID) {
+			return echo.NewHTTPError(http.StatusForbidden)
+		}
+	} else if err = checkGetPermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+
+	bookmark := c.QueryParam("page[cursor]")
+	activities, bookmark, err := s.ListActivity(inst, member, bookmark)
+	if err != nil {
+		return wrapErrors(err)
+	}
+
+	var links jsonapi.LinksList
+	if bookmark != "" {
+		links.Next = "/sharings/" + sharingID + "/activity?page[cursor]=" + bookmark
+	}
+	objs := make([]jsonapi.Object, len(activities))
+	for i, a := range activities {
+		objs[i] = a
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, &links)
+}
+
+// EnableActivityDigest is used to receive every day a notification with a
+// summary of the activity on the sharing
+func EnableActivityDigest(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	if err = s.EnableActivityDigest(inst); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DisableActivityDigest is used to stop the daily notification about the
+// activity on the sharing
+func DisableActivityDigest(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkGetPermissions(c, s); err != nil {
+		return wrapErrors(err)
+	}
+	if err = s.DisableActivityDigest(inst); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,372 @@
+package sharings
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/model/vfs"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// guestItem is a file or directory displayed on the page for the guests
+type guestItem struct {
+	ID    string
+	Name  string
+	IsDir bool
+	Size  int64
+}
+
+func renderGuestPage(c echo.Context, inst *instance.Instance, code int, s *sharing.Sharing, values echo.Map) error {
+	publicName, _ := inst.PublicName()
+	data := echo.Map{
+		"Title":       inst.TemplateTitle(),
+		"CozyUI":      middlewares.CozyUI(inst),
+		"ThemeCSS":    middlewares.ThemeCSS(inst),
+		"Domain":      inst.ContextualDomain(),
+		"ContextName": inst.ContextName,
+		"Locale":      inst.Locale,
+		"Favicon":     middlewares.Favicon(inst),
+		"PublicName":  publicName,
+		"SharingID":   s.SID,
+		"Description": s.Description,
+	}
+	for k, v := range values {
+		data[k] = v
+	}
+	return c.Render(code, "sharing_guest.html", data)
+}
+
+func renderGuestError(c echo.Context, inst *instance.Instance) error {
+	return c.Render(http.StatusBadRequest, "error.html", echo.Map{
+		"Title":       inst.TemplateTitle(),
+		"ThemeCSS":    middlewares.ThemeCSS(inst),
+		"CozyUI":      middlewares.CozyUI(inst),
+		"Domain":      inst.ContextualDomain(),
+		"ContextName": inst.ContextName,
+		"Error":       "Error Invalid sharing",
+		"Favicon":     middlewares.Favicon(inst),
+	})
+}
+
+// findGuestByState returns the sharing and the guest for the state given in
+// the link of the invitation mail.
+func findGuestByState(c echo.Context) (*sharing.Sharing, *sharing.Member, error) {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := s.FindGuestByState(c.FormValue("state"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, m, nil
+}
+
+// guestCookieName is the name of the cookie where the sharecode of a guest is
+// kept after they have checked their identity.
+const guestCookieName = "cozy_guest"
+
+// guestCSRF protects the upload form of the guests, as the sharecode is sent
+// in a cookie. The requests with the sharecode in the Authorization header
+// don't need it.
+var guestCSRF = middlewares.CSRFWithConfig(middlewares.CSRFConfig{
+	Skipper: func(c echo.Context) bool {
+		return c.Request().Header.Get(echo.HeaderAuthorization) != ""
+	},
+	TokenLookup:    "form:csrf_token",
+	CookieName:     "_csrf_guest",
+	CookiePath:     "/sharings/",
+	CookieMaxAge:   3600, // 1 hour
+	CookieHTTPOnly: true,
+	CookieSecure:   !build.IsDevRelease(),
+})
+
+func guestCookiePath(sharingID string) string {
+	return "/sharings/" + sharingID + "/guest"
+}
+
+// setGuestCookie keeps the sharecode of the guest in a cookie, so that it is
+// not put in the URLs of the pages for the guest.
+func setGuestCookie(c echo.Context, sharingID, sharecode string) {
+	c.SetCookie(&http.Cookie{
+		Name:     guestCookieName,
+		Value:    sharecode,
+		Path:     guestCookiePath(sharingID),
+		Secure:   !build.IsDevRelease(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// guestSharecode returns the sharecode of the guest, from the Authorization
+// header, or else from the cookie.
+func guestSharecode(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if cookie, err := c.Cookie(guestCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// findGuestBySharecode returns the sharing, the guest and their permissions
+// for the sharecode given after the guest has checked their identity.
+func findGuestBySharecode(c echo.Context) (*sharing.Sharing, *sharing.Member, *permission.Permission, error) {
+	inst := middlewares.GetInstance(c)
+	s, err := sharing.FindSharing(inst, c.Param("sharing-id"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sharecode := guestSharecode(c)
+	if sharecode == "" {
+		return nil, nil, nil, permission.ErrInvalidToken
+	}
+	pdoc, err := middlewares.ParseJWT(c, inst, sharecode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if pdoc.Type != permission.TypeShareGuest || pdoc.SourceID != consts.Sharings+"/"+s.SID {
+		return nil, nil, nil, permission.ErrInvalidToken
+	}
+	m, err := s.FindGuestBySharecode(inst, sharecode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return s, m, pdoc, nil
+}
+
+// GetGuest displays the page where a guest, ie a member of the sharing
+// without a cozy, can ask for a code to access the shared documents.
+func GetGuest(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, m, err := findGuestByState(c)
+	if err != nil {
+		return renderGuestError(c, inst)
+	}
+	return renderGuestPage(c, inst, http.StatusOK, s, echo.Map{
+		"Step":          "start",
+		"State":         c.FormValue("state"),
+		"RecipientName": m.PrimaryName(),
+	})
+}
+
+// SendGuestCode sends a code by mail to the guest, and displays the form
+// where they can type it.
+func SendGuestCode(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, m, err := findGuestByState(c)
+	if err != nil {
+		return renderGuestError(c, inst)
+	}
+	if err = s.SendGuestCode(inst, m); err != nil {
+		return wrapErrors(err)
+	}
+	return renderGuestPage(c, inst, http.StatusOK, s, echo.Map{
+		"Step":  "code",
+		"State": c.FormValue("state"),
+		"Email": m.Email,
+	})
+}
+
+// VerifyGuestCode checks the code typed by the guest, and redirects them to
+// the page with the shared documents if it is valid.
+//
+// Note: we don't have an anti-CSRF system, we rely on state being secret.
+func VerifyGuestCode(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, m, err := findGuestByState(c)
+	if err != nil {
+		return renderGuestError(c, inst)
+	}
+	sharecode, err := s.ValidateGuestCode(inst, m, c.FormValue("code"))
+	if err == sharing.ErrInvalidGuestCode {
+		return renderGuestPage(c, inst, http.StatusForbidden, s, echo.Map{
+			"Step":      "code",
+			"State":     c.FormValue("state"),
+			"Email":     m.Email,
+			"CodeError": true,
+		})
+	}
+	if err != nil {
+		return wrapErrors(err)
+	}
+	setGuestCookie(c, s.SID, sharecode)
+	return c.Redirect(http.StatusSeeOther, "/sharings/"+s.SID+"/guest/view")
+}
+
+// GetGuestView displays the shared files and directories to a guest. Without
+// the dir parameter, it is the list of the files and directories that are
+// the roots of the sharing.
+func GetGuestView(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	s, m, pdoc, err := findGuestBySharecode(c)
+	if err != nil {
+		return renderGuestError(c, inst)
+	}
+	fs := inst.VFS()
+
+	var items []guestItem
+	var parentID string
+	canUpload := false
+	dirID := c.QueryParam("dir")
+	if dirID == "" {
+		for _, rule := range s.Rules {
+			if !rule.FilesByID() {
+				continue
+			}
+			for _, id := range rule.Values {
+				dir, file, err := fs.DirOrFileByID(id)
+				if err != nil {
+					continue
+				}
+				items = append(items, toGuestItem(dir, file))
+			}
+		}
+	} else {
+		dir, err := fs.DirByID(dirID)
+		if err != nil {
+			return renderGuestError(c, inst)
+		}
+		if err = vfs.Allows(fs, pdoc.Permissions, permission.GET, dir); err != nil {
+			return renderGuestError(c, inst)
+		}
+		if parent, err := dir.Parent(fs); err == nil &&
+			vfs.Allows(fs, pdoc.Permissions, permission.GET, parent) == nil {
+			parentID = parent.ID()
+		}
+		canUpload = vfs.Allows(fs, pdoc.Permissions, permission.POST, dir) == nil
+		iter := fs.DirIterator(dir, nil)
+		for {
+			d, f, err := iter.Next()
+			if err == vfs.ErrIteratorDone {
+				break
+			}
+			if err != nil {
+				return wrapErrors(err)
+			}
+			items = append(items, toGuestItem(d, f))
+		}
+	}
+
+	state := ""
+	if creds := s.FindCredentials(m); creds != nil {
+		state = creds.State
+	}
+	csrf, _ := c.Get("csrf").(string)
+
+	return renderGuestPage(c, inst, http.StatusOK, s, echo.Map{
+		"Step":          "view",
+		"State":         state,
+		"CSRF":          csrf,
+		"RecipientName": m.PrimaryName(),
+		"DirID":         dirID,
+		"ParentID":      parentID,
+		"Items":         items,
+		"CanUpload":     canUpload,
+	})
+}
+
+func toGuestItem(dir *vfs.DirDoc, file *vfs.FileDoc) guestItem {
+	if dir != nil {
+		return guestItem{ID: dir.ID(), Name: dir.DocName, IsDir: true}
+	}
+	return guestItem{ID: file.ID(), Name: file.DocName, Size: file.ByteSize}
+}
+
+// GetGuestFile sends the content of a shared file to a guest.
+func GetGuestFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	_, _, pdoc, err := findGuestBySharecode(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	fs := inst.VFS()
+	doc, err := fs.FileByID(c.Param("file-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = vfs.Allows(fs, pdoc.Permissions, permission.GET, doc); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	return vfs.ServeFileContent(fs, doc, nil, "", "attachment", c.Request(), c.Response())
+}
+
+// UploadGuestFile is used by a guest to upload a file in a shared directory.
+// If a file with the same name already exists, its content is replaced.
+func UploadGuestFile(c echo.Context) (err error) {
+	inst := middlewares.GetInstance(c)
+	s, _, pdoc, err := findGuestBySharecode(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	fs := inst.VFS()
+	dir, err := fs.DirByID(c.Param("dir-id"))
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = vfs.Allows(fs, pdoc.Permissions, permission.POST, dir); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err)
+	}
+	name := path.Base(header.Filename)
+	content, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	olddoc, err := fs.FileByPath(path.Join(dir.Fullpath, name))
+	if err != nil {
+		olddoc = nil
+	} else if err = vfs.Allows(fs, pdoc.Permissions, permission.PUT, olddoc); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+
+	mime, class := vfs.ExtractMimeAndClassFromFilename(name)
+	newdoc, err := vfs.NewFileDoc(name, dir.ID(), header.Size, nil, mime, class,
+		time.Now(), false, false, nil)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if olddoc != nil {
+		newdoc.SetID(olddoc.ID())
+		newdoc.CreatedAt = olddoc.CreatedAt
+		newdoc.ReferencedBy = olddoc.ReferencedBy
+		newdoc.Tags = olddoc.Tags
+	}
+
+	file, err := fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if _, err = io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return wrapErrors(err)
+	}
+	if err = file.Close(); err != nil {
+		return wrapErrors(err)
+	}
+
+	u := url.URL{
+		Path:     "/sharings/" + s.SID + "/guest/view",
+		RawQuery: url.Values{"dir": {dir.ID()}}.Encode(),
+	}
+	return c.Redirect(http.StatusSeeOther, u.String())
+}
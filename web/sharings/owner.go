@@ -0,0 +1,98 @@
+package sharings
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// TransferOwnership is used by the owner to give the ownership of the sharing
+// to a recipient
+func TransferOwnership(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	_, err = checkCreatePermissions(c, s)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		return jsonapi.InvalidParameter("index", err)
+	}
+	if index == 0 || index >= len(s.Members) {
+		return jsonapi.InvalidParameter("index", errors.New("Invalid index"))
+	}
+	leave := c.QueryParam("leave") == "true"
+	if err = s.TransferOwnership(inst, index, leave); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TakeOwnership is used to inform a recipient that it is the new owner of
+// the sharing, and to give it the credentials toward the other members
+func TakeOwnership(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkRequestFromOwner(c, s); err != nil {
+		return err
+	}
+	var ownership sharing.APIOwnership
+	if _, err = jsonapi.Bind(c.Request().Body, &ownership); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err = s.TakeOwnership(inst, &ownership); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ChangeOwner is used to inform a recipient that the sharing has a new
+// owner, and to give it the credentials for contacting this new owner
+func ChangeOwner(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		return wrapErrors(err)
+	}
+	if err = checkRequestFromOwner(c, s); err != nil {
+		return err
+	}
+	var ownership sharing.APIOwnership
+	if _, err = jsonapi.Bind(c.Request().Body, &ownership); err != nil {
+		return jsonapi.BadJSON()
+	}
+	if err = s.ChangeOwner(inst, &ownership); err != nil {
+		return wrapErrors(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkRequestFromOwner returns an error if the request has not been made
+// with the credentials given by this recipient to the owner of the sharing.
+func checkRequestFromOwner(c echo.Context, s *sharing.Sharing) error {
+	requestPerm, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+	if s.Owner || len(s.Credentials) != 1 ||
+		s.Credentials[0].InboundClientID == "" ||
+		requestPerm.SourceID != s.Credentials[0].InboundClientID {
+		return echo.NewHTTPError(http.StatusForbidden)
+	}
+	return nil
+}
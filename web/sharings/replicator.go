@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/cozy/cozy-stack/model/sharing"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/web/middlewares"
@@ -55,7 +56,8 @@ func BulkDocs(c echo.Context) error {
 		inst.Logger().WithField("nspace", "replicator").Infof("No bulk docs")
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
-	err = s.ApplyBulkDocs(inst, docs)
+	member, _ := requestMember(c, s)
+	err = s.ApplyBulkDocs(inst, docs, member)
 	if err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on apply: %s", err)
 		return wrapErrors(err)
@@ -85,6 +87,29 @@ func GetFolder(c echo.Context) error {
 	return c.JSON(http.StatusOK, folder)
 }
 
+// GetNoteImage serves an image of a shared note to another member of the
+// sharing
+func GetNoteImage(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	sharingID := c.Param("sharing-id")
+	s, err := sharing.FindSharing(inst, sharingID)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Sharing was not found: %s", err)
+		return wrapErrors(err)
+	}
+	member, err := requestMember(c, s)
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Member was not found: %s", err)
+		return wrapErrors(err)
+	}
+	img, err := s.GetNoteImage(inst, member, c.Param("id"), c.Param("name"))
+	if err != nil {
+		inst.Logger().WithField("nspace", "replicator").Infof("Image was not found: %s", err)
+		return wrapErrors(err)
+	}
+	return vfs.ServeFileContent(inst.VFS(), img, nil, "", "inline", c.Request(), c.Response())
+}
+
 // SyncFile will try to synchronize a file from just its metadata. If it's not
 // possible, it will respond with a key that allow to send the content to
 // finish the synchronization.
@@ -105,7 +130,8 @@ func SyncFile(c echo.Context) error {
 		err = errors.New("The identifiers in the URL and in the doc are not the same")
 		return jsonapi.InvalidAttribute("id", err)
 	}
-	key, err := s.SyncFile(inst, fileDoc)
+	member, _ := requestMember(c, s)
+	key, err := s.SyncFile(inst, fileDoc, member)
 	if err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on sync file: %s", err)
 		return wrapErrors(err)
@@ -125,7 +151,8 @@ func FileHandler(c echo.Context) error {
 		inst.Logger().WithField("nspace", "replicator").Infof("Sharing was not found: %s", err)
 		return wrapErrors(err)
 	}
-	if err := s.HandleFileUpload(inst, c.Param("id"), c.Request().Body); err != nil {
+	member, _ := requestMember(c, s)
+	if err := s.HandleFileUpload(inst, c.Param("id"), c.Request().Body, member); err != nil {
 		inst.Logger().WithField("nspace", "replicator").Infof("Error on file upload: %s", err)
 		return wrapErrors(err)
 	}
@@ -152,6 +179,7 @@ func replicatorRoutes(router *echo.Group) {
 	group.POST("/:sharing-id/_revs_diff", RevsDiff, checkSharingWritePermissions)
 	group.POST("/:sharing-id/_bulk_docs", BulkDocs, checkSharingWritePermissions)
 	group.GET("/:sharing-id/io.cozy.files/:id", GetFolder, checkSharingReadPermissions)
+	group.GET("/:sharing-id/notes/:id/images/:name", GetNoteImage, checkSharingReadPermissions)
 	group.PUT("/:sharing-id/io.cozy.files/:id/metadata", SyncFile, checkSharingWritePermissions)
 	group.PUT("/:sharing-id/io.cozy.files/:id", FileHandler, checkSharingWritePermissions)
 	group.DELETE("/:sharing-id/initial", EndInitial, checkSharingWritePermissions)
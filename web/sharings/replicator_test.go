@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/cozy/cozy-stack/client/auth"
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/vfs"
@@ -415,3 +419,45 @@ func TestGetFolder(t *testing.T) {
 	assert.NotEmpty(t, attrs["created_at"])
 	assert.NotEmpty(t, attrs["updated_at"])
 }
+
+func TestFetchNoteImage(t *testing.T) {
+	assert.NotEmpty(t, fileSharingID)
+	assert.NotEmpty(t, fileAccessToken)
+
+	// The image is on the instance of the owner of the sharing
+	ownerNoteID := uuidv4()
+	_, err := createSharedDoc(replInstance, consts.Files+"/"+ownerNoteID, fileSharingID)
+	assert.NoError(t, err)
+	var square bytes.Buffer
+	assert.NoError(t, png.Encode(&square, image.NewRGBA(image.Rect(0, 0, 8, 8))))
+	_, err = note.SaveImage(replInstance, ownerNoteID, "square.png", "image/png", bytes.NewReader(square.Bytes()))
+	assert.NoError(t, err)
+
+	// The note is shared with another instance, where its identifier is XORed
+	memberNoteID := sharing.XorID(ownerNoteID, xorKey)
+	s := &sharing.Sharing{
+		SID:    fileSharingID,
+		Active: true,
+		Members: []sharing.Member{
+			{Status: sharing.MemberStatusOwner, Instance: tsR.URL},
+			{Status: sharing.MemberStatusReady, Instance: "https://j.example.net/"},
+		},
+		Credentials: []sharing.Credentials{{
+			XorKey:      xorKey,
+			AccessToken: &auth.AccessToken{AccessToken: fileAccessToken},
+		}},
+	}
+	assert.NoError(t, couchdb.CreateNamedDocWithDB(bobInstance, s))
+	_, err = createSharedDoc(bobInstance, consts.Files+"/"+memberNoteID, fileSharingID)
+	assert.NoError(t, err)
+
+	img, err := sharing.FetchNoteImage(bobInstance, memberNoteID, "square.png")
+	assert.NoError(t, err)
+	if assert.NotNil(t, img) {
+		assert.Equal(t, "image/png", img.Mime)
+		assert.Equal(t, int64(square.Len()), img.ByteSize)
+	}
+	local, err := note.GetImage(bobInstance, memberNoteID, "square.png")
+	assert.NoError(t, err)
+	assert.Equal(t, img.ID(), local.ID())
+}
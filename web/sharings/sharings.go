@@ -64,6 +64,30 @@ func CreateSharing(c echo.Context) error {
 		}
 	}
 
+	if rel, ok := obj.GetRelationship("guests"); ok {
+		if data, ok := rel.Data.([]interface{}); ok {
+			for _, ref := range data {
+				if id, ok := ref.(map[string]interface{})["id"].(string); ok {
+					if err = s.AddGuest(inst, id, false); err != nil {
+						return wrapErrors(err)
+					}
+				}
+			}
+		}
+	}
+
+	if rel, ok := obj.GetRelationship("read_only_guests"); ok {
+		if data, ok := rel.Data.([]interface{}); ok {
+			for _, ref := range data {
+				if id, ok := ref.(map[string]interface{})["id"].(string); ok {
+					if err = s.AddGuest(inst, id, true); err != nil {
+						return wrapErrors(err)
+					}
+				}
+			}
+		}
+	}
+
 	codes, err := s.Create(inst)
 	if err != nil {
 		return wrapErrors(err)
@@ -237,6 +261,19 @@ func addRecipientsToSharing(inst *instance.Instance, s *sharing.Sharing, rel *js
 	return err
 }
 
+func addGuestsToSharing(inst *instance.Instance, s *sharing.Sharing, rel *jsonapi.Relationship, readOnly bool) error {
+	if data, ok := rel.Data.([]interface{}); ok {
+		ids := make(map[string]bool)
+		for _, ref := range data {
+			if id, ok := ref.(map[string]interface{})["id"].(string); ok {
+				ids[id] = readOnly
+			}
+		}
+		return s.AddGuests(inst, ids)
+	}
+	return nil
+}
+
 // AddRecipients is used to add a member to a sharing
 func AddRecipients(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
@@ -263,6 +300,16 @@ func AddRecipients(c echo.Context) error {
 			return wrapErrors(err)
 		}
 	}
+	if rel, ok := obj.GetRelationship("guests"); ok {
+		if err = addGuestsToSharing(inst, s, rel, false); err != nil {
+			return wrapErrors(err)
+		}
+	}
+	if rel, ok := obj.GetRelationship("read_only_guests"); ok {
+		if err = addGuestsToSharing(inst, s, rel, true); err != nil {
+			return wrapErrors(err)
+		}
+	}
 	return jsonapiSharingWithDocs(c, s)
 }
 
@@ -503,11 +550,29 @@ func Routes(router *echo.Group) {
 	router.DELETE("/:sharing-id/recipients/self", RevokeRecipientBySelf)                                     // On the recipient
 	router.DELETE("/:sharing-id/answer", RevocationOwnerNotif, checkSharingWritePermissions)                 // On the sharer
 
+	// Transferring the ownership
+	router.POST("/:sharing-id/recipients/:index/owner", TransferOwnership)                         // On the sharer
+	router.POST("/:sharing-id/recipients/self/owner", TakeOwnership, checkSharingWritePermissions) // On the new owner
+	router.PUT("/:sharing-id/owner", ChangeOwner, checkSharingWritePermissions)                    // On the other recipients
+
+	// Activity of the members on the shared documents
+	router.GET("/:sharing-id/activity", GetActivity)
+	router.POST("/:sharing-id/activity/digest", EnableActivityDigest)
+	router.DELETE("/:sharing-id/activity/digest", DisableActivityDigest)
+
 	// Delegated routes for open sharing
 	router.POST("/:sharing-id/recipients/delegated", AddRecipientsDelegated, checkSharingWritePermissions)
 
 	router.GET("/doctype/:doctype", GetSharingsInfoByDocType)
 
+	// Pages for the guests, ie the recipients without a cozy
+	router.GET("/:sharing-id/guest", GetGuest)
+	router.POST("/:sharing-id/guest/code", SendGuestCode)
+	router.POST("/:sharing-id/guest/verify", VerifyGuestCode)
+	router.GET("/:sharing-id/guest/view", GetGuestView, guestCSRF)
+	router.GET("/:sharing-id/guest/files/:file-id", GetGuestFile)
+	router.POST("/:sharing-id/guest/files/:dir-id", UploadGuestFile, guestCSRF)
+
 	// Register the URL of their Cozy for recipients
 	router.GET("/:sharing-id/discovery", GetDiscovery)
 	router.POST("/:sharing-id/discovery", PostDiscovery)
@@ -619,12 +684,14 @@ func wrapErrors(err error) error {
 		return jsonapi.InternalServerError(err)
 	case sharing.ErrMissingFileMetadata:
 		return jsonapi.NotFound(err)
-	case sharing.ErrFolderNotFound:
+	case sharing.ErrFolderNotFound, sharing.ErrImageNotFound:
 		return jsonapi.NotFound(err)
 	case sharing.ErrSafety:
 		return jsonapi.BadRequest(err)
 	case sharing.ErrAlreadyAccepted:
 		return jsonapi.Conflict(err)
+	case sharing.ErrInvalidGuestCode:
+		return jsonapi.Forbidden(err)
 	case vfs.ErrInvalidHash:
 		return jsonapi.InvalidParameter("md5sum", err)
 	case vfs.ErrContentLengthMismatch:
@@ -1073,6 +1073,178 @@ func TestRevocationFromRecipient(t *testing.T) {
 	assertLastRecipientIsRevoked(t, s, sharedRefs)
 }
 
+// createSharingForTransfer creates a sharing from Alice to Bob, on both
+// instances, with the credentials that are exchanged when Bob accepts it.
+func createSharingForTransfer(t *testing.T, values []string) (*sharing.Sharing, *sharing.Sharing) {
+	onAlice := createSharing(t, aliceInstance, values)
+	onAlice.Members[1].Status = sharing.MemberStatusReady
+	xorKey := sharing.MakeXorKey()
+
+	// Bob's client on Alice's instance
+	cliA, err := sharing.CreateOAuthClient(aliceInstance, &onAlice.Members[1])
+	assert.NoError(t, err)
+	tokenA, err := sharing.CreateAccessToken(aliceInstance, cliA, onAlice.SID, permission.ALL)
+	assert.NoError(t, err)
+
+	// Alice's client on Bob's instance
+	alice := onAlice.Members[0]
+	alice.Instance = tsA.URL
+	cliB, err := sharing.CreateOAuthClient(bobInstance, &alice)
+	assert.NoError(t, err)
+	tokenB, err := sharing.CreateAccessToken(bobInstance, cliB, onAlice.SID, permission.ALL)
+	assert.NoError(t, err)
+
+	onAlice.Credentials[0] = sharing.Credentials{
+		Client:          sharing.ConvertOAuthClient(cliB),
+		AccessToken:     tokenB,
+		InboundClientID: cliA.ClientID,
+		XorKey:          xorKey,
+	}
+	assert.NoError(t, couchdb.UpdateDoc(aliceInstance, onAlice))
+
+	onBob := &sharing.Sharing{
+		SID:    onAlice.SID,
+		Active: true,
+		Rules:  onAlice.Rules,
+		Members: []sharing.Member{
+			onAlice.Members[0],
+			{
+				Status:   sharing.MemberStatusReady,
+				Email:    onAlice.Members[1].Email,
+				Instance: tsB.URL,
+			},
+		},
+		Credentials: []sharing.Credentials{{
+			Client:          sharing.ConvertOAuthClient(cliA),
+			AccessToken:     tokenA,
+			InboundClientID: cliB.ClientID,
+			XorKey:          xorKey,
+		}},
+	}
+	assert.NoError(t, couchdb.CreateNamedDocWithDB(bobInstance, onBob))
+	return onAlice, onBob
+}
+
+func TestTransferOwnership(t *testing.T) {
+	onAlice, onBob := createSharingForTransfer(t, []string{"transfer1"})
+	aliceClientID := onAlice.Credentials[0].InboundClientID
+	bobClientID := onBob.Credentials[0].InboundClientID
+
+	req, err := http.NewRequest(http.MethodPost, tsA.URL+"/sharings/"+onAlice.SID+"/recipients/1/owner", nil)
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+aliceAppToken)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+
+	// Bob is the new owner
+	var s sharing.Sharing
+	assert.NoError(t, couchdb.GetDoc(bobInstance, consts.Sharings, onBob.SID, &s))
+	assert.True(t, s.Owner)
+	if assert.Len(t, s.Members, 2) {
+		assert.Equal(t, sharing.MemberStatusOwner, s.Members[0].Status)
+		assert.Equal(t, "bob@example.net", s.Members[0].Email)
+		assert.Equal(t, bobInstance.PageURL("", nil), s.Members[0].Instance)
+		assert.Equal(t, sharing.MemberStatusReady, s.Members[1].Status)
+		assert.Equal(t, "alice@example.net", s.Members[1].Email)
+	}
+	if assert.Len(t, s.Credentials, 1) {
+		assert.Equal(t, bobClientID, s.Credentials[0].InboundClientID)
+		assert.NotNil(t, s.Credentials[0].AccessToken)
+	}
+	assert.NotEmpty(t, s.Triggers.TrackID)
+
+	// And Alice is now a recipient
+	s = sharing.Sharing{}
+	assert.NoError(t, couchdb.GetDoc(aliceInstance, consts.Sharings, onAlice.SID, &s))
+	assert.False(t, s.Owner)
+	if assert.Len(t, s.Members, 2) {
+		assert.Equal(t, "bob@example.net", s.Members[0].Email)
+		assert.Equal(t, tsB.URL, s.Members[0].Instance)
+		assert.Equal(t, "alice@example.net", s.Members[1].Email)
+	}
+	if assert.Len(t, s.Credentials, 1) {
+		assert.Equal(t, aliceClientID, s.Credentials[0].InboundClientID)
+	}
+}
+
+func TestTransferOwnershipRollback(t *testing.T) {
+	onAlice, onBob := createSharingForTransfer(t, []string{"transfer2"})
+
+	// Bob doesn't know the same members, and refuses the ownership
+	onBob.Members = append(onBob.Members, sharing.Member{
+		Status: sharing.MemberStatusReady,
+		Email:  "eve@example.net",
+	})
+	assert.NoError(t, couchdb.UpdateDoc(bobInstance, onBob))
+
+	req, err := http.NewRequest(http.MethodPost, tsA.URL+"/sharings/"+onAlice.SID+"/recipients/1/owner", nil)
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+aliceAppToken)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 204, res.StatusCode)
+
+	// Alice is still the owner
+	var s sharing.Sharing
+	assert.NoError(t, couchdb.GetDoc(aliceInstance, consts.Sharings, onAlice.SID, &s))
+	assert.True(t, s.Owner)
+	if assert.Len(t, s.Members, 2) {
+		assert.Equal(t, "alice@example.net", s.Members[0].Email)
+		assert.Equal(t, tsB.URL, s.Members[1].Instance)
+	}
+	if assert.Len(t, s.Credentials, 1) {
+		assert.Equal(t, onAlice.Credentials[0].InboundClientID, s.Credentials[0].InboundClientID)
+	}
+
+	// And Bob is still a recipient
+	s = sharing.Sharing{}
+	assert.NoError(t, couchdb.GetDoc(bobInstance, consts.Sharings, onBob.SID, &s))
+	assert.False(t, s.Owner)
+	assert.Len(t, s.Members, 3)
+}
+
+func TestChangeOwnerOnlyFromOwner(t *testing.T) {
+	onAlice, onBob := createSharingForTransfer(t, []string{"transfer3"})
+
+	// Eve has a token for the sharing on Bob's instance, but she is not the
+	// owner
+	eve := sharing.Member{Email: "eve@example.net", Instance: "https://eve.example.net"}
+	cli, err := sharing.CreateOAuthClient(bobInstance, &eve)
+	assert.NoError(t, err)
+	token, err := sharing.CreateAccessToken(bobInstance, cli, onBob.SID, permission.ALL)
+	assert.NoError(t, err)
+
+	body := `{"data": {"type": "io.cozy.sharings.ownership", "attributes": {
+		"members": [
+			{"status": "owner", "email": "eve@example.net", "instance": "https://eve.example.net"},
+			{"status": "ready", "email": "alice@example.net"}
+		],
+		"credentials": [{}]
+	}}}`
+	req, err := http.NewRequest(http.MethodPut, tsB.URL+"/sharings/"+onBob.SID+"/owner", strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderContentType, "application/vnd.api+json")
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+token.AccessToken)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, res.StatusCode)
+
+	// Even with the credentials of the owner, the list of members must be
+	// the same, with only the owner swapped
+	req, err = http.NewRequest(http.MethodPut, tsB.URL+"/sharings/"+onBob.SID+"/owner", strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Add(echo.HeaderContentType, "application/vnd.api+json")
+	req.Header.Add(echo.HeaderAuthorization, "Bearer "+onAlice.Credentials[0].AccessToken.AccessToken)
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, res.StatusCode)
+
+	var s sharing.Sharing
+	assert.NoError(t, couchdb.GetDoc(bobInstance, consts.Sharings, onBob.SID, &s))
+	assert.Equal(t, "alice@example.net", s.Members[0].Email)
+}
+
 func TestMain(m *testing.M) {
 	config.UseTestFile()
 	config.GetConfig().Assets = "../../assets"
@@ -41,6 +41,7 @@ var (
 		"passphrase_renew.html",
 		"passphrase_onboarding.html",
 		"sharing_discovery.html",
+		"sharing_guest.html",
 		"instance_blocked.html",
 	}
 )
@@ -3,9 +3,13 @@ package archive
 import (
 	"archive/zip"
 	"io"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/vfs"
 )
 
@@ -13,6 +17,13 @@ type zipMessage struct {
 	Files    map[string]string `json:"files"` // path -> fileID
 	DirID    string            `json:"dir_id"`
 	Filename string            `json:"filename"`
+
+	// NotesDirID is the identifier of a directory where all the notes (even in
+	// sub-directories) are added to the archive.
+	NotesDirID string `json:"notes_dir_id,omitempty"`
+	// NotesFormat is the format (html, pdf, docx or md) used for the notes. If
+	// empty, the notes are put in the archive as is.
+	NotesFormat string `json:"notes_format,omitempty"`
 }
 
 // WorkerZip is a worker that creates zip archives.
@@ -21,11 +32,57 @@ func WorkerZip(ctx *job.WorkerContext) error {
 	if err := ctx.UnmarshalMessage(msg); err != nil {
 		return err
 	}
-	fs := ctx.Instance.VFS()
-	return createZip(fs, msg.Files, msg.DirID, msg.Filename)
+	if msg.NotesFormat != "" && note.ExportMime(msg.NotesFormat) == "" {
+		return note.ErrUnsupportedFormat
+	}
+	if msg.Files == nil {
+		msg.Files = make(map[string]string)
+	}
+	if msg.NotesDirID != "" {
+		if err := addNotesFromDir(ctx.Instance, msg.Files, msg.NotesDirID); err != nil {
+			return err
+		}
+	}
+	var notes *notesExport
+	if msg.NotesFormat != "" {
+		notes = &notesExport{inst: ctx.Instance, format: msg.NotesFormat}
+	}
+	return createZipWithNotes(ctx.Instance.VFS(), msg.Files, msg.DirID, msg.Filename, notes)
+}
+
+// notesExport is used to export the notes in another format when they are
+// added to a zip.
+type notesExport struct {
+	inst   *instance.Instance
+	format string
+}
+
+// addNotesFromDir adds the notes inside the given directory to the files,
+// with their path relative to this directory.
+func addNotesFromDir(inst *instance.Instance, files map[string]string, dirID string) error {
+	fs := inst.VFS()
+	root, err := fs.DirByID(dirID)
+	if err != nil {
+		return err
+	}
+	prefix := path.Dir(root.Fullpath)
+	return vfs.Walk(fs, root.Fullpath, func(name string, dir *vfs.DirDoc, file *vfs.FileDoc, err error) error {
+		if err != nil {
+			return err
+		}
+		if file != nil && note.IsNote(file) {
+			rel := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+			files[rel] = file.ID()
+		}
+		return nil
+	})
 }
 
 func createZip(fs vfs.VFS, files map[string]string, dirID, filename string) error {
+	return createZipWithNotes(fs, files, dirID, filename, nil)
+}
+
+func createZipWithNotes(fs vfs.VFS, files map[string]string, dirID, filename string, notes *notesExport) error {
 	now := time.Now()
 	zipDoc, err := vfs.NewFileDoc(filename, dirID, -1, nil, "application/zip", "zip", now, false, false, nil)
 	if err != nil {
@@ -39,7 +96,7 @@ func createZip(fs vfs.VFS, files map[string]string, dirID, filename string) erro
 	}
 	w := zip.NewWriter(z)
 	for filePath, fileID := range files {
-		err = addFileToZip(fs, w, fileID, filePath)
+		err = addFileToZip(fs, w, fileID, filePath, notes)
 		if err != nil {
 			break
 		}
@@ -55,11 +112,14 @@ func createZip(fs vfs.VFS, files map[string]string, dirID, filename string) erro
 	return zerr
 }
 
-func addFileToZip(fs vfs.VFS, w *zip.Writer, fileID, filePath string) error {
+func addFileToZip(fs vfs.VFS, w *zip.Writer, fileID, filePath string, notes *notesExport) error {
 	file, err := fs.FileByID(fileID)
 	if err != nil {
 		return err
 	}
+	if notes != nil && note.IsNote(file) {
+		return addNoteToZip(w, file, filePath, notes)
+	}
 	fr, err := fs.OpenFile(file)
 	if err != nil {
 		return err
@@ -82,3 +142,53 @@ func addFileToZip(fs vfs.VFS, w *zip.Writer, fileID, filePath string) error {
 	_, err = io.Copy(f, fr)
 	return err
 }
+
+// addNoteToZip exports the note in the given format and adds it to the zip,
+// with the extension of the format.
+func addNoteToZip(w *zip.Writer, file *vfs.FileDoc, filePath string, notes *notesExport) error {
+	if notes.format == note.ExportMarkdown {
+		return addMarkdownNoteToZip(w, file, filePath, notes)
+	}
+	filePath = path.Join(path.Dir(filePath), note.ExportFilename(file, notes.format))
+	f, err := createInZip(w, filePath, file.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return note.Export(notes.inst, file, notes.format, f)
+}
+
+// addMarkdownNoteToZip adds the markdown for the note to the zip, and its
+// images in an images/ directory next to it.
+func addMarkdownNoteToZip(w *zip.Writer, file *vfs.FileDoc, filePath string, notes *notesExport) error {
+	md, images, err := note.MarkdownFiles(notes.inst, file)
+	if err != nil {
+		return err
+	}
+	dir := path.Dir(filePath)
+	name := strings.TrimSuffix(file.DocName, ".cozy-note") + ".md"
+	f, err := createInZip(w, path.Join(dir, name), file.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(md); err != nil {
+		return err
+	}
+	fs := notes.inst.VFS()
+	for _, img := range images {
+		imgPath := path.Join(dir, note.ImageSrcPrefix+img.DocName)
+		if err := addFileToZip(fs, w, img.ID(), imgPath, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createInZip(w *zip.Writer, filePath string, modified time.Time) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:     filePath,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	header.SetMode(0640)
+	return w.CreateHeader(header)
+}
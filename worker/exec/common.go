@@ -50,6 +50,7 @@ func init() {
 
 type execWorker interface {
 	Slug() string
+	IsWasm() bool
 	PrepareWorkDir(ctx *job.WorkerContext, i *instance.Instance) (workDir string, err error)
 	PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instance) (cmd string, env []string, err error)
 	ScanOutput(ctx *job.WorkerContext, i *instance.Instance, line []byte) error
@@ -78,6 +79,28 @@ func worker(ctx *job.WorkerContext) (err error) {
 		return err
 	}
 
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
+		var result string
+		if err != nil {
+			result = metrics.WorkerExecResultErrored
+		} else {
+			result = metrics.WorkerExecResultSuccess
+		}
+		metrics.WorkersKonnectorsExecDurations.
+			WithLabelValues(worker.Slug(), result).
+			Observe(v)
+	}))
+	defer timer.ObserveDuration()
+
+	if worker.IsWasm() {
+		err = runWasm(ctx, worker, workDir, env)
+		return err
+	}
+	err = runCmd(ctx, worker, workDir, cmdStr, env)
+	return err
+}
+
+func runCmd(ctx *job.WorkerContext, worker execWorker, workDir, cmdStr string, env []string) (err error) {
 	var stderrBuf bytes.Buffer
 	cmd := CreateCmd(cmdStr, workDir)
 	cmd.Env = env
@@ -98,23 +121,22 @@ func worker(ctx *job.WorkerContext) (err error) {
 	if err != nil {
 		return err
 	}
+	if iw, ok := worker.(interactiveWorker); ok {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		session, err := startInputSession(ctx, stdin, time.Time{})
+		if err != nil {
+			return err
+		}
+		defer session.close()
+		iw.SetInputSession(session)
+	}
 	scanBuf := make([]byte, 16*1024)
 	scanOut := bufio.NewScanner(cmdOut)
 	scanOut.Buffer(scanBuf, 64*1024)
 
-	timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		var result string
-		if err != nil {
-			result = metrics.WorkerExecResultErrored
-		} else {
-			result = metrics.WorkerExecResultSuccess
-		}
-		metrics.WorkersKonnectorsExecDurations.
-			WithLabelValues(worker.Slug(), result).
-			Observe(v)
-	}))
-	defer timer.ObserveDuration()
-
 	if err = cmd.Start(); err != nil {
 		return wrapErr(ctx, err)
 	}
@@ -0,0 +1,139 @@
+package exec
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+const (
+	// defaultInputTimeout is how long the stack waits for the input of the
+	// user when the konnector has not given a timeout.
+	defaultInputTimeout = 5 * time.Minute
+
+	// inputTimeLimitGap is kept between the expiration of an input request
+	// and the time limit of the job, to let the konnector handle the timeout.
+	inputTimeLimitGap = 10 * time.Second
+)
+
+// inputAnswer is the line written on the stdin of the konnector when the user
+// has answered, or when the request has expired.
+type inputAnswer struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// interactiveWorker is implemented by the workers whose process can ask for
+// an input of the user during its execution.
+type interactiveWorker interface {
+	SetInputSession(s *inputSession)
+}
+
+// inputSession forwards the inputs of the user sent to a job to the stdin of
+// its process. Only one request can be pending at a time, and the inputs sent
+// when the process has not asked for one are discarded.
+type inputSession struct {
+	mu       sync.Mutex
+	ctx      *job.WorkerContext
+	stdin    io.WriteCloser
+	deadline time.Time
+	waiting  bool
+	timer    *time.Timer
+	stop     func()
+}
+
+// startInputSession starts to watch the inputs for the job. The deadline is
+// the time limit of the process, or zero if there is none.
+func startInputSession(ctx *job.WorkerContext, stdin io.WriteCloser, deadline time.Time) (*inputSession, error) {
+	inputs, stop, err := job.WatchInputs(ctx.Instance, ctx.JobID())
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	s := &inputSession{
+		ctx:      ctx,
+		stdin:    stdin,
+		deadline: deadline,
+		stop:     stop,
+	}
+	go func() {
+		for data := range inputs {
+			s.answer(data)
+		}
+	}()
+	return s, nil
+}
+
+// request marks the session as waiting for an input, and returns when the
+// request will expire. The timeout is capped by the time limit of the job.
+func (s *inputSession) request(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		timeout = defaultInputTimeout
+	}
+	expiresAt := time.Now().Add(timeout)
+	if !s.deadline.IsZero() {
+		if limit := s.deadline.Add(-inputTimeLimitGap); limit.Before(expiresAt) {
+			expiresAt = limit
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.waiting = true
+	s.timer = time.AfterFunc(time.Until(expiresAt), s.expire)
+	return expiresAt
+}
+
+func (s *inputSession) answer(data json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.waiting {
+		s.ctx.Logger().Warn("Input received for a job that is not waiting for it")
+		return
+	}
+	s.waiting = false
+	s.timer.Stop()
+	s.write(&inputAnswer{Data: data})
+}
+
+func (s *inputSession) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.waiting {
+		return
+	}
+	s.waiting = false
+	s.write(&inputAnswer{Error: "timeout"})
+}
+
+func (s *inputSession) write(answer *inputAnswer) {
+	line, err := json.Marshal(answer)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	if _, err := s.stdin.Write(line); err != nil {
+		s.ctx.Logger().Warnf("Cannot write the input on stdin: %s", err)
+	}
+}
+
+// close stops watching the inputs, and closes the stdin of the process.
+func (s *inputSession) close() {
+	s.stop()
+	// stdin is closed before taking the lock to unblock a pending write
+	_ = s.stdin.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waiting = false
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
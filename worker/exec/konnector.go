@@ -10,12 +10,15 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/account"
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/appfs"
@@ -35,9 +38,11 @@ const (
 )
 
 type konnectorWorker struct {
-	slug string
-	msg  *KonnectorMessage
-	man  *app.KonnManifest
+	slug   string
+	msg    *KonnectorMessage
+	man    *app.KonnManifest
+	inputs *inputSession
+	run    *runRecorder
 
 	err     error
 	lastErr error
@@ -49,6 +54,9 @@ const (
 	konnectorMsgTypeWarning  = "warning"
 	konnectorMsgTypeError    = "error"
 	konnectorMsgTypeCritical = "critical"
+	// konnectorMsgTypeUserInput is used by the konnector to ask the user for
+	// an input, like a 2FA code. The answer is written on its stdin.
+	konnectorMsgTypeUserInput = "user_input"
 )
 
 // KonnectorMessage is the message structure sent to the konnector worker.
@@ -186,6 +194,10 @@ func (w *konnectorWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Ins
 		if couchdb.IsNotFoundError(err) {
 			return "", job.ErrBadTrigger{Err: err}
 		}
+		// The retries are part of the same execution of the konnector
+		if w.run == nil {
+			w.run = startRunRecorder(i, w.man, msg.Account)
+		}
 	}
 
 	man := w.man
@@ -458,6 +470,10 @@ func (w *konnectorWorker) Slug() string {
 	return w.slug
 }
 
+func (w *konnectorWorker) IsWasm() bool {
+	return w.man.Language == wasmLanguage
+}
+
 func (w *konnectorWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instance) (cmd string, env []string, err error) {
 	var parameters interface{} = w.man.Parameters
 
@@ -509,13 +525,19 @@ func (w *konnectorWorker) Logger(ctx *job.WorkerContext) *logrus.Entry {
 
 func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instance, line []byte) error {
 	var msg struct {
-		Type    string `json:"type"`
-		Message string `json:"message"`
-		NoRetry bool   `json:"no_retry"`
+		Type    string          `json:"type"`
+		Message string          `json:"message"`
+		NoRetry bool            `json:"no_retry"`
+		Schema  json.RawMessage `json:"schema"`
+		Timeout int             `json:"timeout"`
 	}
 	if err := json.Unmarshal(line, &msg); err != nil {
 		return fmt.Errorf("Could not parse stdout as JSON: %q", string(line))
 	}
+	event := map[string]interface{}{
+		"type":    msg.Type,
+		"message": msg.Message,
+	}
 
 	log := w.Logger(ctx)
 	switch msg.Type {
@@ -535,18 +557,52 @@ func (w *konnectorWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instanc
 			ctx.SetNoRetry()
 		}
 		log.Error(msg.Message)
+	case konnectorMsgTypeUserInput:
+		if w.inputs == nil {
+			return errors.New("The konnector cannot ask for an input")
+		}
+		expiresAt := w.inputs.request(time.Duration(msg.Timeout) * time.Second)
+		event["job_id"] = ctx.JobID()
+		event["slug"] = w.slug
+		event["schema"] = msg.Schema
+		event["expires_at"] = expiresAt
+		w.notifyUserInput(ctx, i, msg.Message)
 	}
 
 	realtime.GetHub().Publish(i,
 		realtime.EventCreate,
-		&couchdb.JSONDoc{Type: consts.JobEvents, M: map[string]interface{}{
-			"type":    msg.Type,
-			"message": msg.Message,
-		}},
+		&couchdb.JSONDoc{Type: consts.JobEvents, M: event},
 		nil)
 	return nil
 }
 
+// SetInputSession is used to implement the interactiveWorker interface.
+func (w *konnectorWorker) SetInputSession(s *inputSession) {
+	w.inputs = s
+}
+
+// notifyUserInput sends a notification to the user, as they may not have the
+// Home opened when the konnector asks for an input.
+func (w *konnectorWorker) notifyUserInput(ctx *job.WorkerContext, i *instance.Instance, message string) {
+	name := w.man.Name
+	if name == "" {
+		name = w.slug
+	}
+	n := &notification.Notification{
+		Title:    i.Translate("Notifications Konnector Input Title", name),
+		Message:  message,
+		Priority: "high",
+		Data: map[string]interface{}{
+			"job_id": ctx.JobID(),
+			"slug":   w.slug,
+		},
+		PreferredChannels: []string{notification.ChannelMobile},
+	}
+	if err := center.PushStack(i.Domain, center.NotificationKonnectorInput, n); err != nil {
+		w.Logger(ctx).Warnf("Cannot send the notification for the input: %s", err)
+	}
+}
+
 func (w *konnectorWorker) Error(i *instance.Instance, err error) error {
 	if w.err != nil {
 		return w.err
@@ -570,5 +626,8 @@ func (w *konnectorWorker) Commit(ctx *job.WorkerContext, errjob error) error {
 	} else {
 		log.Infof("Konnector failure: %s", errjob)
 	}
+	if w.run != nil {
+		w.run.stop(ctx, w, errjob)
+	}
 	return nil
 }
@@ -0,0 +1,167 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/account"
+	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/metrics"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+)
+
+const (
+	runErrorTimeout  = "timeout"
+	runErrorInternal = "internal"
+)
+
+// runRecorder follows an execution of a konnector for an account. It counts
+// the files and documents created by the konnector during the execution, with
+// the realtime events, and records the execution in the history of the
+// account when it ends.
+type runRecorder struct {
+	startedAt time.Time
+	accountID string
+	sub       *realtime.DynamicSubscriber
+	done      chan struct{}
+	files     int
+	docs      int
+}
+
+// startRunRecorder subscribes to the creation of the files and of the
+// documents of the doctypes in the permissions of the konnector, that have
+// the konnector in their cozyMetadata.createdByApp.
+func startRunRecorder(inst *instance.Instance, man *app.KonnManifest, accountID string) *runRecorder {
+	r := &runRecorder{
+		startedAt: time.Now(),
+		accountID: accountID,
+		sub:       realtime.GetHub().Subscriber(inst),
+		done:      make(chan struct{}),
+	}
+	slug := man.Slug()
+	if sel, err := realtime.NewSelector(map[string]interface{}{
+		"type":                      consts.FileType,
+		"cozyMetadata.createdByApp": slug,
+	}); err == nil {
+		_ = r.sub.SubscribeSelector(consts.Files, sel)
+	}
+	if sel, err := realtime.NewSelector(map[string]interface{}{
+		"cozyMetadata.createdByApp": slug,
+	}); err == nil {
+		seen := map[string]bool{consts.Files: true}
+		for _, rule := range man.Permissions() {
+			doctype := rule.Type
+			if seen[doctype] || strings.Contains(doctype, "*") || realtime.IsInternal(doctype) {
+				continue
+			}
+			seen[doctype] = true
+			_ = r.sub.SubscribeSelector(doctype, sel)
+		}
+	}
+	go r.count()
+	return r
+}
+
+func (r *runRecorder) count() {
+	defer close(r.done)
+	for e := range r.sub.Channel {
+		if e.Verb != realtime.EventCreate || !r.isForAccount(e.Doc) {
+			continue
+		}
+		if e.Doc.DocType() == consts.Files {
+			r.files++
+		} else {
+			r.docs++
+		}
+	}
+}
+
+// isForAccount returns false if the document has been created for another
+// account, by another execution of the same konnector.
+func (r *runRecorder) isForAccount(doc realtime.Doc) bool {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return true
+	}
+	var md struct {
+		CozyMetadata struct {
+			SourceAccount string `json:"sourceAccount"`
+		} `json:"cozyMetadata"`
+	}
+	if err := json.Unmarshal(raw, &md); err != nil {
+		return true
+	}
+	account := md.CozyMetadata.SourceAccount
+	return account == "" || account == r.accountID
+}
+
+// stop ends the counting, and records the execution.
+func (r *runRecorder) stop(ctx *job.WorkerContext, w *konnectorWorker, errjob error) {
+	_ = r.sub.Close()
+	<-r.done
+
+	finishedAt := time.Now()
+	run := &account.Run{
+		Konnector:        w.slug,
+		Account:          w.msg.Account,
+		JobID:            ctx.ID(),
+		Manual:           ctx.Manual(),
+		StartedAt:        r.startedAt,
+		FinishedAt:       finishedAt,
+		Duration:         finishedAt.Sub(r.startedAt).Seconds(),
+		State:            account.RunDone,
+		FilesCreated:     r.files,
+		DocumentsCreated: r.docs,
+	}
+	if triggerID, ok := ctx.TriggerID(); ok {
+		run.TriggerID = triggerID
+	}
+	if w.man != nil {
+		run.Version = w.man.Version()
+	}
+	if errjob != nil {
+		run.State = account.RunErrored
+		run.Error = errjob.Error()
+		switch {
+		case errjob == context.DeadlineExceeded:
+			run.ErrorType = runErrorTimeout
+		case w.err != nil:
+			run.ErrorType = konnectorMsgTypeCritical
+		case w.lastErr != nil:
+			run.ErrorType = konnectorMsgTypeError
+		default:
+			run.ErrorType = runErrorInternal
+		}
+	}
+
+	result := metrics.WorkerExecResultSuccess
+	if errjob != nil {
+		result = metrics.WorkerExecResultErrored
+	}
+	metrics.WorkersKonnectorsRuns.
+		WithLabelValues(run.Konnector, result, run.ErrorType, strconv.FormatBool(run.Manual)).
+		Inc()
+	metrics.WorkersKonnectorsCreated.WithLabelValues(run.Konnector, "file").Add(float64(run.FilesCreated))
+	metrics.WorkersKonnectorsCreated.WithLabelValues(run.Konnector, "document").Add(float64(run.DocumentsCreated))
+
+	log := w.Logger(ctx)
+	if err := account.RecordRun(ctx.Instance, run); err != nil {
+		log.Warnf("Cannot record the execution: %s", err)
+		return
+	}
+	runs, err := account.ListRuns(ctx.Instance, run.Konnector, run.Account, 0)
+	if err != nil {
+		log.Warnf("Cannot compute the health of the account: %s", err)
+		return
+	}
+	health := account.ComputeHealth(runs)
+	metrics.WorkersKonnectorsConsecutiveFailures.
+		WithLabelValues(run.Konnector).
+		Observe(float64(health.ConsecutiveFailures))
+}
@@ -1,6 +1,7 @@
 package exec
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,13 +25,22 @@ type ServiceOptions struct {
 	Name string `json:"name"`
 	File string `json:"service_file"`
 
+	// Function is the HTTP request when the service is called for one of its
+	// functions.
+	Function *job.FunctionRequest `json:"function,omitempty"`
+
 	Message *ServiceOptions `json:"message"`
 }
 
+// serviceMsgTypeResponse is used by a service to write a part of its response
+// to an HTTP request.
+const serviceMsgTypeResponse = "response"
+
 type serviceWorker struct {
-	man  *app.WebappManifest
-	slug string
-	name string
+	man      *app.WebappManifest
+	slug     string
+	name     string
+	function *job.FunctionRequest
 }
 
 func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Instance) (workDir string, err error) {
@@ -44,6 +54,7 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Insta
 
 	slug := opts.Slug
 	name := opts.Name
+	w.function = opts.Function
 
 	man, err := app.GetWebappBySlugAndUpdate(i, slug,
 		app.Copier(consts.WebappType, i), i.Registries())
@@ -122,7 +133,11 @@ func (w *serviceWorker) PrepareWorkDir(ctx *job.WorkerContext, i *instance.Insta
 	}
 	defer src.Close()
 
-	dst, err := workFS.OpenFile("index.js", os.O_CREATE|os.O_WRONLY, 0640)
+	entryPoint := "index.js"
+	if w.IsWasm() {
+		entryPoint = wasmEntryPoint
+	}
+	dst, err := workFS.OpenFile(entryPoint, os.O_CREATE|os.O_WRONLY, 0640)
 	if err != nil {
 		return
 	}
@@ -140,6 +155,10 @@ func (w *serviceWorker) Slug() string {
 	return w.slug
 }
 
+func (w *serviceWorker) IsWasm() bool {
+	return w.man.Language == wasmLanguage
+}
+
 func (w *serviceWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instance) (cmd string, env []string, err error) {
 	type serviceEvent struct {
 		Doc interface{} `json:"doc"`
@@ -154,17 +173,29 @@ func (w *serviceWorker) PrepareCmdEnv(ctx *job.WorkerContext, i *instance.Instan
 		}
 	}
 
+	language := "node" // default to node language for services
+	if w.IsWasm() {
+		language = wasmLanguage
+	}
+
 	token := i.BuildAppToken(w.man.Slug(), "")
 	cmd = config.GetConfig().Konnectors.Cmd
 	env = []string{
 		"COZY_URL=" + i.PageURL("/", nil),
 		"COZY_CREDENTIALS=" + token,
-		"COZY_LANGUAGE=node", // default to node language for services
+		"COZY_LANGUAGE=" + language,
 		"COZY_LOCALE=" + i.Locale,
 		"COZY_TIME_LIMIT=" + ctxToTimeLimit(ctx),
 		"COZY_JOB_ID=" + ctx.ID(),
 		"COZY_COUCH_DOC=" + string(marshaled),
 	}
+	if w.function != nil {
+		request, err := json.Marshal(w.function)
+		if err != nil {
+			return "", nil, err
+		}
+		env = append(env, "COZY_FUNCTION_REQUEST="+string(request))
+	}
 	return
 }
 
@@ -178,14 +209,37 @@ func (w *serviceWorker) Logger(ctx *job.WorkerContext) *logrus.Entry {
 
 func (w *serviceWorker) ScanOutput(ctx *job.WorkerContext, i *instance.Instance, line []byte) error {
 	var msg struct {
-		Type    string `json:"type"`
-		Message string `json:"message"`
+		Type    string            `json:"type"`
+		Message string            `json:"message"`
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body"`
+		// Encoding is "base64" when the body is binary data
+		Encoding string `json:"encoding"`
 	}
 	if err := json.Unmarshal(line, &msg); err != nil {
 		return fmt.Errorf("Could not parse stdout as JSON: %q", string(line))
 	}
 	log := w.Logger(ctx)
 	switch msg.Type {
+	case serviceMsgTypeResponse:
+		if w.function == nil {
+			return errors.New("The service has written a response without a request")
+		}
+		body := []byte(msg.Body)
+		if msg.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(msg.Body)
+			if err != nil {
+				return fmt.Errorf("Could not decode the body of the response: %s", err)
+			}
+			body = decoded
+		}
+		job.SendFunctionResponse(i, &job.FunctionResponse{
+			RequestID: w.function.ID,
+			Status:    msg.Status,
+			Headers:   msg.Headers,
+			Body:      body,
+		})
 	case konnectorMsgTypeDebug, konnectorMsgTypeInfo:
 		log.Debug(msg.Message)
 	case konnectorMsgTypeWarning, "warn":
@@ -203,6 +257,12 @@ func (w *serviceWorker) Error(i *instance.Instance, err error) error {
 }
 
 func (w *serviceWorker) Commit(ctx *job.WorkerContext, errjob error) error {
+	if w.function != nil && ctx.Instance != nil {
+		job.SendFunctionResponse(ctx.Instance, &job.FunctionResponse{
+			RequestID: w.function.ID,
+			End:       true,
+		})
+	}
 	log := w.Logger(ctx)
 	if errjob == nil {
 		log.Info("Service success")
@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/pkg/wasm"
+)
+
+const (
+	// wasmLanguage is the value of the language field in the manifest for
+	// the konnectors and services compiled to WebAssembly.
+	wasmLanguage = "wasm"
+
+	// wasmEntryPoint is the file executed in the working directory.
+	wasmEntryPoint = "index.wasm"
+)
+
+// runWasm executes the WebAssembly module of the konnector or service in the
+// working directory, with the in-process runtime instead of the external
+// command. The lines of logs are handled like the ones printed on stdout by
+// the command.
+func runWasm(ctx *job.WorkerContext, worker execWorker, workDir string, env []string) error {
+	file := workDir
+	if infos, err := os.Stat(workDir); err != nil {
+		return err
+	} else if infos.IsDir() {
+		file = path.Join(workDir, wasmEntryPoint)
+	}
+	module, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// set stderr writable with a bytes.Buffer limited total size of 256Ko
+	var stderrBuf bytes.Buffer
+	log := worker.Logger(ctx)
+	defer func() {
+		if stderrBuf.Len() > 0 {
+			log.Error("Stderr: ", stderrBuf.String())
+		}
+	}()
+
+	conf := config.GetConfig().Konnectors
+	var stdin io.Reader
+	if iw, ok := worker.(interactiveWorker); ok {
+		var deadline time.Time
+		if conf.WasmTimeLimit > 0 {
+			deadline = time.Now().Add(conf.WasmTimeLimit)
+		}
+		pr, pw := io.Pipe()
+		session, err := startInputSession(ctx, pw, deadline)
+		if err != nil {
+			return err
+		}
+		defer session.close()
+		iw.SetInputSession(session)
+		stdin = pr
+	}
+
+	opts := &wasm.Options{
+		Name:   wasmEntryPoint,
+		Env:    env,
+		Stdin:  stdin,
+		Stderr: utils.LimitWriterDiscard(&stderrBuf, 256*1024),
+		Output: func(line []byte) {
+			if errOut := worker.ScanOutput(ctx, ctx.Instance, line); errOut != nil {
+				log.Error(errOut)
+			}
+		},
+		MemoryLimit: conf.WasmMemoryLimit,
+		TimeLimit:   conf.WasmTimeLimit,
+	}
+	err = wasm.Run(ctx, module, opts)
+	return worker.Error(ctx.Instance, wrapErr(ctx, err))
+}
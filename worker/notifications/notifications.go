@@ -0,0 +1,28 @@
+package notifications
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   center.DigestWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      1 * time.Minute,
+		WorkerFunc:   WorkerDigest,
+	})
+}
+
+// WorkerDigest is used to send a mail with the notifications that have been
+// kept for the digest since the last one.
+func WorkerDigest(ctx *job.WorkerContext) error {
+	ctx.Instance.Logger().WithField("nspace", "notifications").
+		Debugf("Send the digest")
+	return center.SendDigest(ctx.Instance)
+}
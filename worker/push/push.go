@@ -6,19 +6,24 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"runtime"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
 	"github.com/cozy/cozy-stack/model/notification/center"
 	"github.com/cozy/cozy-stack/model/oauth"
+	build "github.com/cozy/cozy-stack/pkg/config"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/mail"
+	"github.com/cozy/cozy-stack/pkg/webpush"
 	"github.com/sirupsen/logrus"
 
 	fcm "github.com/appleboy/go-fcm"
@@ -29,11 +34,25 @@ import (
 	apns_token "github.com/sideshow/apns2/token"
 )
 
+// webPushTTL is how long the push services should keep the notifications
+// for the browsers that are not connected.
+const webPushTTL = 24 * time.Hour
+
 var (
-	fcmClient *fcm.Client
-	iosClient *apns.Client
+	fcmClient     *fcm.Client
+	iosClient     *apns.Client
+	webPushClient = newWebPushClient()
 )
 
+// newWebPushClient returns the client for the push services. The endpoints
+// can be on a local network only for the development releases.
+func newWebPushClient() *http.Client {
+	if build.IsDevRelease() {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return webpush.NewClient(10 * time.Second)
+}
+
 func init() {
 	job.AddWorker(&job.WorkerConfig{
 		WorkerType:   "push",
@@ -128,6 +147,19 @@ func Worker(ctx *job.WorkerContext) error {
 				Warnf("could not send notification on device: %s", err)
 		}
 	}
+	subs, err := notification.ListWebPushSubscriptions(ctx.Instance)
+	if err != nil {
+		ctx.Logger().Warnf("could not list the web push subscriptions: %s", err)
+	}
+	for _, sub := range subs {
+		if err := pushToWebPush(ctx, sub, &msg); err == nil {
+			sent = true
+		} else {
+			ctx.Logger().
+				WithField("subscription_id", sub.ID()).
+				Warnf("could not send notification via web push: %s", err)
+		}
+	}
 	if !sent {
 		sendFallbackMail(ctx.Instance, msg.MailFallback)
 	}
@@ -255,6 +287,96 @@ func pushToAPNS(ctx *job.WorkerContext, c *oauth.Client, msg *center.PushMessage
 	return nil
 }
 
+// webPushPayload is the JSON sent to the service worker of the browser.
+type webPushPayload struct {
+	NotificationID string                 `json:"notification_id"`
+	Source         string                 `json:"source"`
+	Title          string                 `json:"title"`
+	Body           string                 `json:"body"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+}
+
+// Web Push Protocol, with VAPID and encrypted payloads
+// https://tools.ietf.org/html/rfc8030
+func pushToWebPush(ctx *job.WorkerContext, sub *notification.WebPushSubscription, msg *center.PushMessage) error {
+	keys, err := notification.GetVAPIDKeys()
+	if err != nil {
+		return err
+	}
+
+	payload, err := makeWebPushPayload(msg)
+	if err != nil {
+		return err
+	}
+
+	opts := &webpush.Options{
+		VAPID:   keys,
+		Subject: ctx.Instance.PageURL("/", nil),
+		TTL:     webPushTTL,
+		Urgency: "normal",
+	}
+	if msg.Priority == "high" {
+		opts.Urgency = "high"
+	}
+	if msg.Collapsible {
+		opts.Topic = hex.EncodeToString(hashSource(msg.Source))
+	}
+
+	err = webpush.Send(webPushClient, sub.Subscription(), payload, opts)
+	if err == webpush.ErrSubscriptionGone {
+		if errd := sub.Unsubscribe(ctx.Instance); errd != nil {
+			ctx.Logger().Warnf("could not remove the web push subscription: %s", errd)
+		}
+	}
+	return err
+}
+
+// makeWebPushPayload serializes the message for the service worker. The
+// push services only accept small payloads: the data are dropped if they
+// make the payload too large, and then the body is truncated.
+func makeWebPushPayload(msg *center.PushMessage) ([]byte, error) {
+	p := &webPushPayload{
+		NotificationID: msg.NotificationID,
+		Source:         msg.Source,
+		Title:          msg.Title,
+		Body:           msg.Message,
+		Data:           msg.Data,
+	}
+	payload, err := json.Marshal(p)
+	if err != nil || len(payload) <= webpush.MaxPayloadSize {
+		return payload, err
+	}
+
+	p.Data = nil
+	payload, err = json.Marshal(p)
+	if err != nil || len(payload) <= webpush.MaxPayloadSize {
+		return payload, err
+	}
+
+	// The JSON encoding of a rune can take up to 6 bytes (\uXXXX)
+	for len(payload) > webpush.MaxPayloadSize && p.Body != "" {
+		excess := len(payload) - webpush.MaxPayloadSize
+		p.Body = truncateRunes(p.Body, excess/6+1)
+		payload, err = json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(payload) > webpush.MaxPayloadSize {
+		return nil, webpush.ErrPayloadTooLarge
+	}
+	return payload, nil
+}
+
+// truncateRunes removes the n last runes of s.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if n >= len(runes) {
+		return ""
+	}
+	return string(runes[:len(runes)-n])
+}
+
 func hashSource(source string) []byte {
 	h := md5.New()
 	_, _ = h.Write([]byte(source))
@@ -0,0 +1,47 @@
+package push
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeWebPushPayload(t *testing.T) {
+	msg := &center.PushMessage{
+		NotificationID: "123",
+		Source:         "cozy/cli/foo",
+		Title:          "Hello",
+		Message:        "World",
+		Data:           map[string]interface{}{"foo": "bar"},
+	}
+	payload, err := makeWebPushPayload(msg)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"notification_id":"123","source":"cozy/cli/foo","title":"Hello","body":"World","data":{"foo":"bar"}}`, string(payload))
+
+	// The data are dropped when they are too large
+	msg.Data["foo"] = strings.Repeat("x", webpush.MaxPayloadSize)
+	payload, err = makeWebPushPayload(msg)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"notification_id":"123","source":"cozy/cli/foo","title":"Hello","body":"World"}`, string(payload))
+
+	// And the body is truncated
+	msg.Message = strings.Repeat("é<", webpush.MaxPayloadSize)
+	payload, err = makeWebPushPayload(msg)
+	require.NoError(t, err)
+	assert.True(t, len(payload) <= webpush.MaxPayloadSize)
+	var p webPushPayload
+	require.NoError(t, json.Unmarshal(payload, &p))
+	assert.NotEmpty(t, p.Body)
+	assert.True(t, strings.HasPrefix(msg.Message, p.Body))
+	assert.Nil(t, p.Data)
+
+	// An error is returned if the title is too large
+	msg.Title = strings.Repeat("x", webpush.MaxPayloadSize)
+	_, err = makeWebPushPayload(msg)
+	assert.Equal(t, webpush.ErrPayloadTooLarge, err)
+}
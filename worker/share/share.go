@@ -41,6 +41,15 @@ func init() {
 		Timeout:      1 * time.Hour,
 		WorkerFunc:   WorkerUpload,
 	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "share-activity-digest",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      1 * time.Minute,
+		WorkerFunc:   WorkerActivityDigest,
+	})
 }
 
 // WorkerTrack is used to update the io.cozy.shared database when a document
@@ -95,3 +104,22 @@ func WorkerUpload(ctx *job.WorkerContext) error {
 	}
 	return s.Upload(ctx.Instance, msg.Errors)
 }
+
+// WorkerActivityDigest is used to send a daily notification with a summary of
+// the activity on a sharing
+func WorkerActivityDigest(ctx *job.WorkerContext) error {
+	var msg sharing.ActivityDigestMsg
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	ctx.Instance.Logger().WithField("nspace", "share").
+		Debugf("Activity digest %#v", msg)
+	s, err := sharing.FindSharing(ctx.Instance, msg.SharingID)
+	if err != nil {
+		return err
+	}
+	if !s.Active {
+		return s.DisableActivityDigest(ctx.Instance)
+	}
+	return s.SendActivityDigest(ctx.Instance, time.Now().Add(-24*time.Hour))
+}
@@ -256,6 +256,10 @@ func installerPush(inst *instance.Instance, insc chan *app.Installer, errc chan
 			if opts.OnlyRegistry && strings.HasPrefix(webapp.Source(), "registry://") {
 				continue
 			}
+			// The pinned applications are not updated automatically
+			if webapp.Pinned() {
+				continue
+			}
 			installer, err := createInstaller(inst, registries, webapp, opts)
 			if err != nil {
 				errc <- &updateError{
@@ -288,6 +292,10 @@ func installerPush(inst *instance.Instance, insc chan *app.Installer, errc chan
 			if opts.OnlyRegistry && strings.HasPrefix(konn.Source(), "registry://") {
 				continue
 			}
+			// The pinned applications are not updated automatically
+			if konn.Pinned() {
+				continue
+			}
 			installer, err := createInstaller(inst, registries, konn, opts)
 			if err != nil {
 				errc <- &updateError{